@@ -2,12 +2,16 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,53 +22,164 @@ type Client struct {
 	user string
 	pass string
 	http *http.Client
+	cfg  ClientConfig
+	rec  *recorder // nil unless cfg.RecordDir is set
 }
 
-func New(base, user, pass string, httpClient *http.Client) *Client {
-	return &Client{base: base, user: user, pass: pass, http: httpClient}
+// ClientConfig controls how the client retries failed Robot calls and whether it records them.
+// The zero value disables both.
+type ClientConfig struct {
+	MaxRetries       int           // retries after the first attempt
+	RetryStatusCodes []int         // response codes that are retried (e.g. 429, 503)
+	RetryWait        time.Duration // base wait, doubled after each retry (default 1s); Retry-After wins for 429
+	RecordDir        string        // when set, every response (retried ones too) is written there as a numbered Exchange file
 }
 
-func (c *Client) do(method, path string, form url.Values, oks ...int) ([]byte, error) {
-	var body io.Reader
-	if form != nil {
-		body = bytes.NewBufferString(form.Encode())
+// DefaultRetryStatusCodes are the response codes retried when none are configured
+var DefaultRetryStatusCodes = []int{429, 500, 502, 503, 504}
+
+func New(base, user, pass string, httpClient *http.Client, cfg ClientConfig) *Client {
+	if cfg.RetryWait <= 0 {
+		cfg.RetryWait = time.Second
 	}
-	log.Printf("CALLING: %s", c.base+path)
-	req, err := http.NewRequest(method, c.base+path, body)
-	if err != nil {
-		return nil, err
+	c := &Client{base: base, user: user, pass: pass, http: httpClient, cfg: cfg}
+	if cfg.RecordDir != "" {
+		c.rec = newRecorder(cfg.RecordDir)
+	}
+	return c
+}
+
+// shouldRetry reports whether a response status may be retried for the given method. POSTs
+// (orders, resets, renames) are only retried when Robot rejected them before doing any work,
+// so that a 500/502/504 never turns into a second order.
+func (c *Client) shouldRetry(method string, status int) bool {
+	listed := false
+	for _, s := range c.cfg.RetryStatusCodes {
+		if s == status {
+			listed = true
+			break
+		}
+	}
+	if !listed {
+		return false
 	}
-	req.SetBasicAuth(c.user, c.pass)
-	if form != nil {
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if method == http.MethodPost {
+		return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
 	}
+	return true
+}
 
-	resp, err := c.http.Do(req)
+// retryDelay returns how long to wait before retry number attempt (0-based)
+func (c *Client) retryDelay(resp *http.Response, attempt int) time.Duration {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if ra := resp.Header.Get("Retry-After"); ra != "" {
+			if secs, err := strconv.Atoi(ra); err == nil && secs >= 0 {
+				return time.Duration(secs) * time.Second
+			}
+			if t, err := http.ParseTime(ra); err == nil {
+				if d := time.Until(t); d > 0 {
+					return d
+				}
+				return 0
+			}
+		}
+	}
+	return c.cfg.RetryWait << attempt
+}
+
+func (c *Client) do(method, path string, form url.Values, oks ...int) ([]byte, error) {
+	body, err := c.doStream(method, path, form, oks...)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	b, err := io.ReadAll(resp.Body)
+	defer body.Close()
+	b, err := io.ReadAll(body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
+	return b, nil
+}
+
+// doStream is do without buffering a successful response: the body is handed to the caller
+// unread, so large listings can be decoded as they arrive. The caller closes it
+func (c *Client) doStream(method, path string, form url.Values, oks ...int) (io.ReadCloser, error) {
+	resp, err := c.doResponse(method, path, form, oks...)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
 
-	ok := false
-	for _, s := range oks {
-		if s == resp.StatusCode {
-			ok = true
+// doResponse is doStream returning the whole response, for callers that need the status code
+func (c *Client) doResponse(method, path string, form url.Values, oks ...int) (*http.Response, error) {
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if form != nil {
+			body = bytes.NewBufferString(form.Encode())
+		}
+		log.Printf("CALLING: %s", c.base+path)
+		req, err := http.NewRequest(method, c.base+path, body)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(c.user, c.pass)
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+
+		resp, err = c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if c.rec != nil {
+			c.rec.record(method, path, form, resp)
+		}
+
+		if attempt >= c.cfg.MaxRetries || !c.shouldRetry(method, resp.StatusCode) {
 			break
 		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		wait := c.retryDelay(resp, attempt)
+		log.Printf("Robot returned %d for %s %s, retrying in %s (attempt %d of %d)", resp.StatusCode, method, path, wait, attempt+1, c.cfg.MaxRetries)
+		time.Sleep(wait)
 	}
-	if !ok {
-		log.Printf("API request failed with status %d, body: %s", resp.StatusCode, string(b))
-		var ae apiErr
-		if err := json.Unmarshal(b, &ae); err == nil && ae.Error.Message != "" {
-			return nil, fmt.Errorf("robot: %s: %s", ae.Error.Code, ae.Error.Message)
+
+	for _, s := range oks {
+		if s == resp.StatusCode {
+			return resp, nil
 		}
-		return nil, fmt.Errorf("robot: unexpected %d: %s", resp.StatusCode, string(b))
 	}
-	return b, nil
+
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	log.Printf("API request failed with status %d, body: %s", resp.StatusCode, string(b))
+	apiError := &APIError{Status: resp.StatusCode, Body: string(b)}
+	var ae apiErr
+	if err := json.Unmarshal(b, &ae); err == nil && ae.Error.Message != "" {
+		apiError.Code = ae.Error.Code
+		apiError.Message = ae.Error.Message
+	}
+	return nil, apiError
+}
+
+// APIError is returned for any Robot response with an unexpected status code
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Body    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("robot: %s: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("robot: unexpected %d: %s", e.Status, e.Body)
 }
 
 // retryVSwitchOperation retries an operation that might fail with VSWITCH_IN_PROCESS error
@@ -136,16 +251,52 @@ func (c *Client) OrderServer(p OrderParams) (*Transaction, error) {
 	if err := json.Unmarshal(b, &env); err != nil {
 		return nil, err
 	}
+	env.Transaction.TransactionType = TransactionTypeOrder
 	return &env.Transaction, nil
 }
 
+// GetOrderTransactionList lists all standard order transactions of the account
+func (c *Client) GetOrderTransactionList() ([]Transaction, error) {
+	return c.listTransactions("/order/server/transaction", TransactionTypeOrder)
+}
+
+// listTransactions decodes a transaction listing one entry at a time; Robot doesn't paginate it
+// and accounts with a long order history get large responses. Robot answers 404 when there are none
+func (c *Client) listTransactions(path, txType string) ([]Transaction, error) {
+	body, err := c.doStream("GET", path, nil, 200)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	if tok, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("decode transaction list: %w", err)
+	} else if tok != json.Delim('[') {
+		return nil, fmt.Errorf("decode transaction list: expected an array, got %v", tok)
+	}
+	var txs []Transaction
+	for dec.More() {
+		var env transactionEnv
+		if err := dec.Decode(&env); err != nil {
+			return nil, fmt.Errorf("decode transaction list: %w", err)
+		}
+		env.Transaction.TransactionType = txType
+		txs = append(txs, env.Transaction)
+	}
+	return txs, nil
+}
+
 // --- Market/Auction Order
 
 type MarketOrderParams struct {
-	ProductID      int
-	Dist, Password *string
-	Keys, Addons   []string
-	Test           bool
+	ProductID            int
+	Dist, Lang, Password *string
+	Keys, Addons         []string
+	Test                 bool
 }
 
 func (c *Client) OrderMarketServer(p MarketOrderParams) (*Transaction, error) {
@@ -154,6 +305,9 @@ func (c *Client) OrderMarketServer(p MarketOrderParams) (*Transaction, error) {
 	if p.Dist != nil {
 		f.Set("dist", *p.Dist)
 	}
+	if p.Lang != nil {
+		f.Set("lang", *p.Lang)
+	}
 	if p.Password != nil {
 		f.Set("password", *p.Password)
 	}
@@ -175,6 +329,7 @@ func (c *Client) OrderMarketServer(p MarketOrderParams) (*Transaction, error) {
 	if err := json.Unmarshal(b, &env); err != nil {
 		return nil, err
 	}
+	env.Transaction.TransactionType = TransactionTypeMarket
 	return &env.Transaction, nil
 }
 
@@ -187,9 +342,15 @@ func (c *Client) GetMarketOrderTransaction(id string) (*Transaction, error) {
 	if err := json.Unmarshal(b, &env); err != nil {
 		return nil, err
 	}
+	env.Transaction.TransactionType = TransactionTypeMarket
 	return &env.Transaction, nil
 }
 
+// GetMarketOrderTransactionList lists all auction order transactions of the account
+func (c *Client) GetMarketOrderTransactionList() ([]Transaction, error) {
+	return c.listTransactions("/order/server_market/transaction", TransactionTypeMarket)
+}
+
 func (c *Client) ListMarketProducts() ([]Product, error) {
 	b, err := c.do("GET", "/order/server_market/product", nil, 200)
 	if err != nil {
@@ -214,6 +375,32 @@ func (c *Client) GetMarketProduct(productID string) (*Product, error) {
 	return &env.Product, nil
 }
 
+func (c *Client) GetProduct(productID string) (*Product, error) {
+	b, err := c.do("GET", "/order/server/product/"+url.PathEscape(productID), nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	var env productEnv
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	return &env.Product, nil
+}
+
+// GetProductDistributions returns the preinstall distributions and languages Robot offers for a product
+func (c *Client) GetProductDistributions(productID string, market bool) (dists, langs []string, err error) {
+	var p *Product
+	if market {
+		p, err = c.GetMarketProduct(productID)
+	} else {
+		p, err = c.GetProduct(productID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.Dist, p.Lang, nil
+}
+
 func (c *Client) GetOrderTransaction(id string) (*Transaction, error) {
 	b, err := c.do("GET", "/order/server/transaction/"+url.PathEscape(id), nil, 200)
 	if err != nil {
@@ -223,9 +410,27 @@ func (c *Client) GetOrderTransaction(id string) (*Transaction, error) {
 	if err := json.Unmarshal(b, &env); err != nil {
 		return nil, err
 	}
+	env.Transaction.TransactionType = TransactionTypeOrder
 	return &env.Transaction, nil
 }
 
+// --- Raw calls
+
+// Call sends a request Robot has no dedicated method for and returns the status code and body
+// of a successful (2xx) response. Failures are returned as *APIError like for every other call
+func (c *Client) Call(method, path string, form url.Values) (int, []byte, error) {
+	resp, err := c.doResponse(method, path, form, http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return resp.StatusCode, b, nil
+}
+
 // --- Rescue + Reset
 
 type RescueParams struct {
@@ -254,6 +459,33 @@ func (c *Client) ActivateRescue(serverNumber int, p RescueParams) (*Rescue, erro
 	return &env.Rescue, nil
 }
 
+// GetRescue returns the rescue system status of the server; Robot only returns the password
+// in the response to ActivateRescue
+func (c *Client) GetRescue(serverNumber int) (*Rescue, error) {
+	b, err := c.do("GET", fmt.Sprintf("/boot/%d/rescue", serverNumber), nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	var env rescueEnv
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	return &env.Rescue, nil
+}
+
+// GetResetOptions returns the reset types Robot offers for a server
+func (c *Client) GetResetOptions(serverNumber int) (*ResetOptions, error) {
+	b, err := c.do("GET", fmt.Sprintf("/reset/%d", serverNumber), nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	var env resetOptionsEnv
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	return &env.Reset, nil
+}
+
 func (c *Client) Reset(serverNumber int, typ string) error {
 	if typ == "" {
 		typ = "hw"
@@ -264,12 +496,32 @@ func (c *Client) Reset(serverNumber int, typ string) error {
 	return err
 }
 
+// GetCancellation returns the cancellation status of a server, including the earliest possible date
+func (c *Client) GetCancellation(serverNumber int) (*Cancellation, error) {
+	b, err := c.do("GET", fmt.Sprintf("/server/%d/cancellation", serverNumber), nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	var env cancellationEnv
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	return &env.Cancellation, nil
+}
+
+// CancelServer cancels a server on cancelDate (yyyy-mm-dd or "now"). An empty date cancels at the
+// earliest possible date, i.e. the end of the current billing period.
 func (c *Client) CancelServer(serverNumber int, cancelDate string) error {
-	f := url.Values{}
-	if cancelDate != "" {
-		f.Set("cancellation_date", cancelDate)
+	if cancelDate == "" {
+		cancellation, err := c.GetCancellation(serverNumber)
+		if err != nil {
+			return err
+		}
+		cancelDate = cancellation.EarliestCancellationDate
 	}
-	_, err := c.do("DELETE", fmt.Sprintf("/server/%d/cancellation", serverNumber), f, 200)
+	f := url.Values{}
+	f.Set("cancellation_date", cancelDate)
+	_, err := c.do("POST", fmt.Sprintf("/server/%d/cancellation", serverNumber), f, 200)
 	return err
 }
 
@@ -280,6 +532,55 @@ func (c *Client) SetServerName(serverNumber int, serverName string) error {
 	return err
 }
 
+// SetServerDescription stores a free-text note on the server. Robot may reject the comment field
+// with INVALID_INPUT on accounts where server notes are not available (see IsInvalidInput).
+func (c *Client) SetServerDescription(serverNumber int, description string) error {
+	f := url.Values{}
+	f.Set("comment", description)
+	_, err := c.do("POST", fmt.Sprintf("/server/%d", serverNumber), f, 200)
+	return err
+}
+
+// GetServer fetches a single server directly (uncached)
+func (c *Client) GetServer(serverNumber int) (*Server, error) {
+	b, err := c.do("GET", fmt.Sprintf("/server/%d", serverNumber), nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	var env serverEnv
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	server := normalizeServers([]Server{env.Server})[0]
+	return &server, nil
+}
+
+// GetServerHardware returns the hardware Robot reports for the server, from the server itself or
+// else its hardware subresource. It returns nil without an error when Robot has none
+func (c *Client) GetServerHardware(serverNumber int) (*ServerHardware, error) {
+	for _, p := range []string{fmt.Sprintf("/server/%d", serverNumber), fmt.Sprintf("/server/%d/hardware", serverNumber)} {
+		b, err := c.do("GET", p, nil, 200)
+		if IsNotFound(err) && strings.HasSuffix(p, "/hardware") {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var env serverHardwareEnv
+		if err := json.Unmarshal(b, &env); err != nil {
+			// Robot doesn't document the format; data that doesn't match is the same as none
+			continue
+		}
+		if env.Server.Hardware != nil {
+			return env.Server.Hardware, nil
+		}
+		if env.Hardware != nil {
+			return env.Hardware, nil
+		}
+	}
+	return nil, nil
+}
+
 func (c *Client) AddServerToVSwitch(vswitchID int, serverIP string) error {
 	return c.retryVSwitchOperation(func() error {
 		f := url.Values{}
@@ -289,51 +590,70 @@ func (c *Client) AddServerToVSwitch(vswitchID int, serverIP string) error {
 	}, 50, 10*time.Second) // Retry up to 50 times with 10-second delays
 }
 
-// --- VSwitch
+func (c *Client) RemoveServerFromVSwitch(vswitchID int, serverIP string) error {
+	return c.retryVSwitchOperation(func() error {
+		f := url.Values{}
+		f.Set("server[]", serverIP)
+		_, err := c.do("DELETE", fmt.Sprintf("/vswitch/%d/server", vswitchID), f, 200)
+		return err
+	}, 50, 10*time.Second) // Retry up to 50 times with 10-second delays
+}
+
+// --- SSH keys
 
-func (c *Client) CreateVSwitch(vlan int, name string) (*VSwitch, error) {
+func (c *Client) AddSSHKey(name, data string) (*SSHKey, error) {
 	f := url.Values{}
-	f.Set("vlan", fmt.Sprintf("%d", vlan))
 	f.Set("name", name)
+	f.Set("data", data)
+	b, err := c.do("POST", "/key", f, 201, 200)
+	if err != nil {
+		return nil, err
+	}
+	var env sshKeyEnv
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	return &env.Key, nil
+}
 
-	b, err := c.do("POST", "/vswitch", f, 201, 200)
+// ListSSHKeys returns the keys stored in Robot; Robot answers NOT_FOUND when there are none
+func (c *Client) ListSSHKeys() ([]SSHKey, error) {
+	b, err := c.do("GET", "/key", nil, 200)
+	if IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	var envs []sshKeyEnv
+	if err := json.Unmarshal(b, &envs); err != nil {
+		return nil, err
+	}
+	keys := make([]SSHKey, 0, len(envs))
+	for _, e := range envs {
+		keys = append(keys, e.Key)
+	}
+	return keys, nil
+}
 
-	// Debug: log the raw response
-	log.Printf("CreateVSwitch response: %s", string(b))
+func (c *Client) DeleteSSHKey(fingerprint string) error {
+	_, err := c.do("DELETE", "/key/"+url.PathEscape(fingerprint), nil, 200)
+	return err
+}
 
-	// Try to unmarshal as direct VSwitch first
-	var vswitch VSwitch
-	if err := json.Unmarshal(b, &vswitch); err == nil {
-		log.Printf("Parsed VSwitch directly: ID=%d, VLAN=%d, Name='%s'", vswitch.ID, vswitch.VLAN, vswitch.Name)
-		// If the API response doesn't include vlan/name, use the values we sent
-		if vswitch.VLAN == 0 {
-			vswitch.VLAN = vlan
-		}
-		if vswitch.Name == "" {
-			vswitch.Name = name
-		}
-		return &vswitch, nil
-	}
+// --- VSwitch
 
-	// If that fails, try the wrapped format
-	var env vswitchEnv
-	if err := json.Unmarshal(b, &env); err != nil {
-		log.Printf("Failed to unmarshal VSwitch response: %v", err)
+// CreateVSwitch creates a vSwitch; description is only sent when not nil, since Robot may not
+// know the field
+func (c *Client) CreateVSwitch(vlan int, name string, description *string) (*VSwitch, error) {
+	b, err := c.do("POST", "/vswitch", vswitchForm(vlan, name, description), 201, 200)
+	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Parsed VSwitch wrapped: ID=%d, VLAN=%d, Name='%s'", env.VSwitch.ID, env.VSwitch.VLAN, env.VSwitch.Name)
-	// If the API response doesn't include vlan/name, use the values we sent
-	if env.VSwitch.VLAN == 0 {
-		env.VSwitch.VLAN = vlan
-	}
-	if env.VSwitch.Name == "" {
-		env.VSwitch.Name = name
-	}
-	return &env.VSwitch, nil
+	// Debug: log the raw response
+	log.Printf("CreateVSwitch response: %s", string(b))
+	return decodeVSwitch(b)
 }
 
 func (c *Client) GetVSwitch(id int) (*VSwitch, error) {
@@ -344,23 +664,16 @@ func (c *Client) GetVSwitch(id int) (*VSwitch, error) {
 
 	// Debug: log the raw response
 	log.Printf("GetVSwitch response for ID %d: %s", id, string(b))
+	return decodeVSwitch(b)
+}
 
-	// Try to unmarshal as direct VSwitch first
-	var vswitch VSwitch
-	if err := json.Unmarshal(b, &vswitch); err == nil {
-		log.Printf("Parsed VSwitch directly: ID=%d, VLAN=%d, Name='%s'", vswitch.ID, vswitch.VLAN, vswitch.Name)
-		return &vswitch, nil
-	}
-
-	// If that fails, try the wrapped format
-	var env vswitchEnv
-	if err := json.Unmarshal(b, &env); err != nil {
-		log.Printf("Failed to unmarshal VSwitch response: %v", err)
+// GetVSwitchServers lists the servers attached to a vSwitch
+func (c *Client) GetVSwitchServers(id int) ([]VSwitchServer, error) {
+	vswitch, err := c.GetVSwitch(id)
+	if err != nil {
 		return nil, err
 	}
-
-	log.Printf("Parsed VSwitch wrapped: ID=%d, VLAN=%d, Name='%s'", env.VSwitch.ID, env.VSwitch.VLAN, env.VSwitch.Name)
-	return &env.VSwitch, nil
+	return vswitch.Servers, nil
 }
 
 func (c *Client) ListVSwitches() ([]VSwitch, error) {
@@ -369,6 +682,12 @@ func (c *Client) ListVSwitches() ([]VSwitch, error) {
 		return nil, err
 	}
 
+	// Robot returns a plain array; older responses wrapped it in {"vswitch": [...]}
+	var list []VSwitch
+	if err := json.Unmarshal(b, &list); err == nil {
+		return list, nil
+	}
+
 	var env vswitchListEnv
 	if err := json.Unmarshal(b, &env); err != nil {
 		return nil, err
@@ -376,56 +695,190 @@ func (c *Client) ListVSwitches() ([]VSwitch, error) {
 	return env.VSwitches, nil
 }
 
-func (c *Client) UpdateVSwitch(id int, vlan int, name string) (*VSwitch, error) {
-	f := url.Values{}
-	f.Set("vlan", fmt.Sprintf("%d", vlan))
-	f.Set("name", name)
-
-	b, err := c.do("POST", fmt.Sprintf("/vswitch/%d", id), f, 200)
+// UpdateVSwitch changes vlan and name, and description when not nil
+func (c *Client) UpdateVSwitch(id int, vlan int, name string, description *string) (*VSwitch, error) {
+	b, err := c.do("POST", fmt.Sprintf("/vswitch/%d", id), vswitchForm(vlan, name, description), 200)
 	if err != nil {
 		return nil, err
 	}
 
 	// Debug: log the raw response
 	log.Printf("UpdateVSwitch response: %s", string(b))
+	return decodeVSwitch(b)
+}
 
-	// Try to unmarshal as direct VSwitch first
-	var vswitch VSwitch
-	if err := json.Unmarshal(b, &vswitch); err == nil {
-		log.Printf("Parsed VSwitch directly: ID=%d, VLAN=%d, Name='%s'", vswitch.ID, vswitch.VLAN, vswitch.Name)
-		// If the API response doesn't include vlan/name, use the values we sent
-		if vswitch.VLAN == 0 {
-			vswitch.VLAN = vlan
+// decodeVSwitch parses a vSwitch Robot returned directly or, like older responses, wrapped in
+// {"vswitch": {...}}. Any object would decode into VSwitch, so the wrapper is looked up by key,
+// and a vSwitch without an ID or an error payload is an error instead of an empty vSwitch
+func decodeVSwitch(b []byte) (*VSwitch, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(b, &top); err != nil {
+		return nil, fmt.Errorf("unexpected vSwitch response: %w", err)
+	}
+	if _, ok := top["error"]; ok {
+		apiError := &APIError{Status: http.StatusOK, Body: string(b)}
+		var ae apiErr
+		if err := json.Unmarshal(b, &ae); err == nil {
+			if ae.Error.Status != 0 {
+				apiError.Status = ae.Error.Status
+			}
+			apiError.Code = ae.Error.Code
+			apiError.Message = ae.Error.Message
 		}
-		if vswitch.Name == "" {
-			vswitch.Name = name
+		return nil, apiError
+	}
+
+	raw := json.RawMessage(b)
+	if wrapped, ok := top["vswitch"]; ok {
+		raw = wrapped
+	}
+	var vswitch VSwitch
+	if err := json.Unmarshal(raw, &vswitch); err != nil {
+		return nil, fmt.Errorf("unexpected vSwitch response: %w", err)
+	}
+	if vswitch.ID == 0 {
+		return nil, fmt.Errorf("unexpected vSwitch response without an id: %s", string(b))
+	}
+	return &vswitch, nil
+}
+
+// vswitchForm builds the form of CreateVSwitch and UpdateVSwitch
+func vswitchForm(vlan int, name string, description *string) url.Values {
+	f := url.Values{}
+	f.Set("vlan", fmt.Sprintf("%d", vlan))
+	f.Set("name", name)
+	if description != nil {
+		f.Set("description", *description)
+	}
+	return f
+}
+
+func (c *Client) DeleteVSwitch(id int) error {
+	_, err := c.do("DELETE", fmt.Sprintf("/vswitch/%d?cancellation_date=%s", id, "now"), nil, 200)
+	return err
+}
+
+// --- Firewall
+
+// ListFirewallTemplates lists the firewall templates of the account; Robot omits their rules here
+func (c *Client) ListFirewallTemplates() ([]FirewallTemplate, error) {
+	b, err := c.do("GET", "/firewall/template", nil, 200)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
 		}
-		return &vswitch, nil
+		return nil, err
 	}
+	var envs []firewallTemplateEnv
+	if err := json.Unmarshal(b, &envs); err != nil {
+		return nil, err
+	}
+	templates := make([]FirewallTemplate, len(envs))
+	for i, e := range envs {
+		templates[i] = e.FirewallTemplate
+	}
+	return templates, nil
+}
 
-	// If that fails, try the wrapped format
-	var env vswitchEnv
-	if err := json.Unmarshal(b, &env); err != nil {
-		log.Printf("Failed to unmarshal VSwitch response: %v", err)
+func (c *Client) GetFirewallTemplate(id int) (*FirewallTemplate, error) {
+	b, err := c.do("GET", fmt.Sprintf("/firewall/template/%d", id), nil, 200)
+	if err != nil {
 		return nil, err
 	}
+	return parseFirewallTemplate(b)
+}
 
-	log.Printf("Parsed VSwitch wrapped: ID=%d, VLAN=%d, Name='%s'", env.VSwitch.ID, env.VSwitch.VLAN, env.VSwitch.Name)
-	// If the API response doesn't include vlan/name, use the values we sent
-	if env.VSwitch.VLAN == 0 {
-		env.VSwitch.VLAN = vlan
+func (c *Client) CreateFirewallTemplate(t FirewallTemplate) (*FirewallTemplate, error) {
+	b, err := c.do("POST", "/firewall/template", firewallTemplateForm(t), 201, 200)
+	if err != nil {
+		return nil, err
 	}
-	if env.VSwitch.Name == "" {
-		env.VSwitch.Name = name
+	return parseFirewallTemplate(b)
+}
+
+// UpdateFirewallTemplate replaces the settings and rules of a template; servers it was applied to
+// keep their rules until it is applied again
+func (c *Client) UpdateFirewallTemplate(id int, t FirewallTemplate) (*FirewallTemplate, error) {
+	b, err := c.do("POST", fmt.Sprintf("/firewall/template/%d", id), firewallTemplateForm(t), 200)
+	if err != nil {
+		return nil, err
 	}
-	return &env.VSwitch, nil
+	return parseFirewallTemplate(b)
 }
 
-func (c *Client) DeleteVSwitch(id int) error {
-	_, err := c.do("DELETE", fmt.Sprintf("/vswitch/%d?cancellation_date=%s", id, "now"), nil, 200)
+func (c *Client) DeleteFirewallTemplate(id int) error {
+	_, err := c.do("DELETE", fmt.Sprintf("/firewall/template/%d", id), nil, 200)
 	return err
 }
 
+// GetFirewall fetches the firewall of a server
+func (c *Client) GetFirewall(serverNumber int) (*Firewall, error) {
+	b, err := c.do("GET", fmt.Sprintf("/firewall/%d", serverNumber), nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	var env firewallEnv
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	return &env.Firewall, nil
+}
+
+// ApplyFirewallTemplate applies a template to the firewall of a server and polls until the
+// firewall leaves "in process"
+func (c *Client) ApplyFirewallTemplate(ctx context.Context, serverNumber, templateID int, opts PollOptions) (*Firewall, error) {
+	f := url.Values{}
+	f.Set("template_id", strconv.Itoa(templateID))
+	b, err := c.do("POST", fmt.Sprintf("/firewall/%d", serverNumber), f, 200, 202)
+	if err != nil {
+		return nil, err
+	}
+	var env firewallEnv
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	if env.Firewall.Status != FirewallInProcess {
+		return &env.Firewall, nil
+	}
+	return Poll(ctx, opts, func() (*Firewall, error) {
+		return c.GetFirewall(serverNumber)
+	}, func(fw *Firewall) bool {
+		return fw.Status != FirewallInProcess
+	})
+}
+
+func parseFirewallTemplate(b []byte) (*FirewallTemplate, error) {
+	var env firewallTemplateEnv
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	return &env.FirewallTemplate, nil
+}
+
+// firewallTemplateForm encodes a template the way Robot expects, with the rules as
+// rules[input][0][name]=... fields; empty rule fields are left out
+func firewallTemplateForm(t FirewallTemplate) url.Values {
+	f := url.Values{}
+	f.Set("name", t.Name)
+	f.Set("filter_ipv6", strconv.FormatBool(t.FilterIPv6))
+	f.Set("whitelist_hos", strconv.FormatBool(t.WhitelistHOS))
+	f.Set("is_default", strconv.FormatBool(t.IsDefault))
+	for direction, rules := range map[string][]FirewallRule{"input": t.Rules.Input, "output": t.Rules.Output} {
+		for i, r := range rules {
+			prefix := fmt.Sprintf("rules[%s][%d]", direction, i)
+			for _, field := range [][2]string{
+				{"name", r.Name}, {"ip_version", r.IPVersion}, {"src_ip", r.SrcIP}, {"dst_ip", r.DstIP}, {"src_port", r.SrcPort},
+				{"dst_port", r.DstPort}, {"protocol", r.Protocol}, {"tcp_flags", r.TCPFlags}, {"action", r.Action},
+			} {
+				if field[1] != "" {
+					f.Set(prefix+"["+field[0]+"]", field[1])
+				}
+			}
+		}
+	}
+	return f
+}
+
 // --- Server Management
 
 // GetAllServers fetches all servers in one API call
@@ -435,12 +888,54 @@ func (c *Client) GetAllServers() ([]Server, error) {
 		return nil, err
 	}
 
-	var resp serversResponse
-	if err := json.Unmarshal(b, &resp); err != nil {
-		return nil, err
+	return parseServerList(b)
+}
+
+// ServerFilter narrows a server list; zero fields match every server. Robot's /server endpoint
+// ignores query parameters, so filters are applied client-side.
+type ServerFilter struct {
+	ServerNumbers []int
+	ServerIP      string // the main IP or any additional IP
+	ServerName    string
+	Product       string
+	Location      string
+	Status        string
+}
+
+// Match reports whether a server passes the filter; strings compare case-insensitively
+func (f ServerFilter) Match(s Server) bool {
+	if len(f.ServerNumbers) > 0 && !slices.Contains(f.ServerNumbers, s.ServerNumber) {
+		return false
+	}
+	if f.ServerIP != "" && s.ServerIP != f.ServerIP && !slices.Contains(s.IP, f.ServerIP) {
+		return false
+	}
+	for _, c := range [][2]string{{f.ServerName, s.ServerName}, {f.Product, s.Product}, {f.Location, s.Location}, {f.Status, s.Status}} {
+		if c[0] != "" && !strings.EqualFold(c[0], c[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterServers returns the servers matching the filter, keeping their order
+func FilterServers(servers []Server, filter ServerFilter) []Server {
+	var out []Server
+	for _, s := range servers {
+		if filter.Match(s) {
+			out = append(out, s)
+		}
 	}
+	return out
+}
 
-	return resp.Server, nil
+// GetServersFiltered fetches all servers and keeps the ones matching the filter
+func (c *Client) GetServersFiltered(filter ServerFilter) ([]Server, error) {
+	servers, err := c.GetAllServers()
+	if err != nil {
+		return nil, err
+	}
+	return FilterServers(servers, filter), nil
 }
 
 // GetServerFromBulk finds a specific server from bulk data
@@ -457,9 +952,12 @@ func (c *Client) GetServerFromBulk(serverNumber int, servers []Server) (*Server,
 // --- Simple Cache Manager
 
 type CacheManager struct {
-	servers []Server
-	fetched bool
-	mutex   sync.RWMutex
+	servers        []Server
+	fetched        bool
+	stale          map[int]bool // servers modified since the fetch, re-read one by one on next access
+	refreshedAt    time.Time
+	vswitchServers map[int][]VSwitchServer
+	mutex          sync.RWMutex
 }
 
 func NewCacheManager() *CacheManager {
@@ -469,7 +967,7 @@ func NewCacheManager() *CacheManager {
 // GetServers fetches all servers once per apply, then returns cached data
 func (cm *CacheManager) GetServers(client *Client) ([]Server, error) {
 	cm.mutex.RLock()
-	if cm.fetched {
+	if cm.fetched && len(cm.stale) == 0 {
 		servers := make([]Server, len(cm.servers))
 		copy(servers, cm.servers)
 		cm.mutex.RUnlock()
@@ -482,23 +980,92 @@ func (cm *CacheManager) GetServers(client *Client) ([]Server, error) {
 	defer cm.mutex.Unlock()
 
 	// Double-check in case another goroutine already fetched
-	if cm.fetched {
-		servers := make([]Server, len(cm.servers))
-		copy(servers, cm.servers)
-		return servers, nil
+	if !cm.fetched {
+		servers, err := client.GetAllServers()
+		if err != nil {
+			return nil, err
+		}
+		cm.servers = servers
+		cm.fetched = true
+		cm.stale = nil
+		cm.refreshedAt = time.Now()
 	}
 
-	servers, err := client.GetAllServers()
-	if err != nil {
-		return nil, err
+	for serverNumber := range cm.stale {
+		server, err := client.GetServer(serverNumber)
+		if err != nil && !IsNotFound(err) {
+			return nil, err
+		}
+		cm.replaceServer(serverNumber, server)
+		delete(cm.stale, serverNumber)
+		cm.refreshedAt = time.Now()
 	}
 
-	cm.servers = servers
-	cm.fetched = true
-
+	servers := make([]Server, len(cm.servers))
+	copy(servers, cm.servers)
 	return servers, nil
 }
 
+// replaceServer swaps the cached copy of a server for server, or drops it when server is nil.
+// The caller holds the write lock.
+func (cm *CacheManager) replaceServer(serverNumber int, server *Server) {
+	for i := range cm.servers {
+		if cm.servers[i].ServerNumber != serverNumber {
+			continue
+		}
+		if server == nil {
+			cm.servers = append(cm.servers[:i], cm.servers[i+1:]...)
+		} else {
+			cm.servers[i] = *server
+		}
+		return
+	}
+	if server != nil {
+		cm.servers = append(cm.servers, *server)
+	}
+}
+
+// InvalidateServer marks a server as modified (renamed, reset, ...) so the next lookup re-reads
+// it from Robot instead of returning the cached copy
+func (cm *CacheManager) InvalidateServer(serverNumber int) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if !cm.fetched {
+		return
+	}
+	if cm.stale == nil {
+		cm.stale = make(map[int]bool)
+	}
+	cm.stale[serverNumber] = true
+}
+
+// Invalidate drops all cached data so the next lookup fetches the server list again, e.g. after
+// a server was cancelled
+func (cm *CacheManager) Invalidate() {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.servers = nil
+	cm.fetched = false
+	cm.stale = nil
+	cm.vswitchServers = nil
+}
+
+// RefreshedAt returns when the cached servers were last read from Robot; zero before the first fetch
+func (cm *CacheManager) RefreshedAt() time.Time {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.refreshedAt
+}
+
+// GetServersFiltered returns the cached servers matching the filter
+func (cm *CacheManager) GetServersFiltered(client *Client, filter ServerFilter) ([]Server, error) {
+	servers, err := cm.GetServers(client)
+	if err != nil {
+		return nil, err
+	}
+	return FilterServers(servers, filter), nil
+}
+
 // GetServer finds a specific server from cached data
 func (cm *CacheManager) GetServer(client *Client, serverNumber int) (*Server, error) {
 	servers, err := cm.GetServers(client)
@@ -509,10 +1076,102 @@ func (cm *CacheManager) GetServer(client *Client, serverNumber int) (*Server, er
 	return client.GetServerFromBulk(serverNumber, servers)
 }
 
+// GetVSwitchServers fetches the members of a vSwitch once per apply, then returns cached data
+func (cm *CacheManager) GetVSwitchServers(client *Client, vswitchID int) ([]VSwitchServer, error) {
+	cm.mutex.RLock()
+	members, ok := cm.vswitchServers[vswitchID]
+	cm.mutex.RUnlock()
+	if !ok {
+		var err error
+		members, err = client.GetVSwitchServers(vswitchID)
+		if err != nil {
+			return nil, err
+		}
+		cm.mutex.Lock()
+		if cm.vswitchServers == nil {
+			cm.vswitchServers = make(map[int][]VSwitchServer)
+		}
+		cm.vswitchServers[vswitchID] = members
+		cm.mutex.Unlock()
+	}
+
+	out := make([]VSwitchServer, len(members))
+	copy(out, members)
+	return out, nil
+}
+
+// InvalidateVSwitch drops the cached members of a vSwitch after servers were added or removed
+func (cm *CacheManager) InvalidateVSwitch(vswitchID int) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	delete(cm.vswitchServers, vswitchID)
+}
+
 func IsNotFound(err error) bool {
 	if err == nil {
 		return false
 	}
+	var ae *APIError
+	if errors.As(err, &ae) && ae.Status == http.StatusNotFound {
+		return true
+	}
 	s := strings.ToLower(err.Error())
 	return strings.Contains(s, "404") || strings.Contains(s, "not found")
 }
+
+// IsNotAllowed reports whether Robot refused the call because the webservice user lacks the
+// permission. Robot also answers 403 with other codes, so the status only counts when the
+// response carried no code
+func IsNotAllowed(err error) bool {
+	var ae *APIError
+	if !errors.As(err, &ae) || IsRateLimited(err) {
+		return false
+	}
+	if ae.Code != "" {
+		return strings.EqualFold(ae.Code, "NOT_ALLOWED")
+	}
+	return ae.Status == http.StatusForbidden
+}
+
+// rateLimitCodes are the error codes Robot answers with once too many requests were made
+var rateLimitCodes = []string{"RATE_LIMIT_EXCEEDED", "TOO_MANY_REQUESTS"}
+
+// IsRateLimited reports whether Robot refused the call because of its request rate limit
+// (429, or a rate limit code on a 403 or 409); the same call succeeds once the limit resets
+func IsRateLimited(err error) bool {
+	var ae *APIError
+	if !errors.As(err, &ae) {
+		return false
+	}
+	if ae.Status == http.StatusTooManyRequests {
+		return true
+	}
+	for _, code := range rateLimitCodes {
+		if strings.EqualFold(ae.Code, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsInvalidInput reports whether Robot rejected the request parameters
+func IsInvalidInput(err error) bool {
+	var ae *APIError
+	if !errors.As(err, &ae) {
+		return false
+	}
+	return strings.EqualFold(ae.Code, "INVALID_INPUT")
+}
+
+// IsConflict reports whether Robot refused the call because it conflicts with another operation
+// on the same object, e.g. a rescue activation while another one is in progress
+func IsConflict(err error) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.Status == http.StatusConflict
+}
+
+// IsUnauthorized reports whether Robot rejected the webservice credentials
+func IsUnauthorized(err error) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.Status == http.StatusUnauthorized
+}
@@ -1,14 +1,22 @@
 package client_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/mokto/terraform-provider-hrobot/internal/client"
+	"github.com/mokto/terraform-provider-hrobot/internal/client/clienttest"
 )
 
 func newMockServer(t *testing.T) (*httptest.Server, *client.Client) {
@@ -49,8 +57,12 @@ func newMockServer(t *testing.T) (*httptest.Server, *client.Client) {
 		_ = json.NewEncoder(w).Encode(resp)
 	})
 
-	// POST /boot/424242/rescue
+	// GET, POST /boot/424242/rescue
 	mux.HandleFunc("/boot/424242/rescue", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"rescue":{"server_ip":"192.0.2.10","active":true,"password":null}}`))
+			return
+		}
 		_ = r.ParseForm()
 		if r.Form.Get("os") == "" {
 			http.Error(w, `{"error":{"status":400,"code":"bad_request","message":"os required"}}`, 400)
@@ -66,8 +78,12 @@ func newMockServer(t *testing.T) (*httptest.Server, *client.Client) {
 		_ = json.NewEncoder(w).Encode(resp)
 	})
 
-	// POST /reset/424242
+	// GET, POST /reset/424242
 	mux.HandleFunc("/reset/424242", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"reset":{"server_ip":"192.0.2.10","server_number":424242,"type":["sw","man"],"operating_status":"not supported"}}`))
+			return
+		}
 		_ = r.ParseForm()
 		if r.Form.Get("type") == "" {
 			http.Error(w, `{"error":{"status":400,"code":"bad_request","message":"type required"}}`, 400)
@@ -80,7 +96,7 @@ func newMockServer(t *testing.T) (*httptest.Server, *client.Client) {
 	ts := httptest.NewServer(mux)
 
 	base, _ := url.Parse(ts.URL)
-	cl := client.New(base.String(), "user", "pass", &http.Client{Timeout: 5 * time.Second})
+	cl := client.New(base.String(), "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
 	return ts, cl
 }
 
@@ -116,7 +132,1060 @@ func TestActivateRescueAndReset(t *testing.T) {
 	if !res.Active || res.ServerIP != "192.0.2.10" {
 		t.Fatalf("unexpected rescue: %+v", res)
 	}
+	status, err := cl.GetRescue(424242)
+	if err != nil {
+		t.Fatalf("GetRescue error: %v", err)
+	}
+	if !status.Active || status.Password != "" {
+		t.Fatalf("unexpected rescue status: %+v", status)
+	}
+	options, err := cl.GetResetOptions(424242)
+	if err != nil {
+		t.Fatalf("GetResetOptions error: %v", err)
+	}
+	if len(options.Type) != 2 || options.Type[0] != "sw" || options.Type[1] != "man" {
+		t.Fatalf("unexpected reset options: %+v", options)
+	}
 	if err := cl.Reset(424242, "hw"); err != nil {
 		t.Fatalf("Reset error: %v", err)
 	}
 }
+
+func TestMarketOrderDistAndProductDistributions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/order/server_market/transaction", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.Form.Get("dist") != "Rescue system" || r.Form.Get("lang") != "en" {
+			http.Error(w, `{"error":{"status":400,"code":"INVALID_INPUT","message":"dist/lang missing"}}`, 400)
+			return
+		}
+		w.WriteHeader(201)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"transaction": map[string]any{"id": "B20150121-344957-251478", "status": "in process"},
+		})
+	})
+	mux.HandleFunc("/order/server_market/transaction/B20150121-344957-251478", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("expected GET, got %s", r.Method)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"transaction": map[string]any{
+				"id":            "B20150121-344957-251478",
+				"status":        "ready",
+				"server_number": 107239,
+				"server_ip":     "192.0.2.20",
+				"product":       map[string]any{"id": 2783507, "name": "SB48"},
+			},
+		})
+	})
+	mux.HandleFunc("/order/server_market/transaction/B-unknown", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"status":404,"code":"TRANSACTION_NOT_FOUND","message":"transaction not found"}}`))
+	})
+	mux.HandleFunc("/order/server_market/product/2783507", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"product": map[string]any{
+				"id":   2783507,
+				"name": "SB48",
+				"dist": []string{"Rescue system", "Debian 12 base"},
+				"lang": []string{"en"},
+			},
+		})
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+
+	dist, lang := "Rescue system", "en"
+	tx, err := cl.OrderMarketServer(client.MarketOrderParams{ProductID: 2783507, Dist: &dist, Lang: &lang})
+	if err != nil {
+		t.Fatalf("OrderMarketServer error: %v", err)
+	}
+	if tx.ID != "B20150121-344957-251478" {
+		t.Fatalf("unexpected txn id: %s", tx.ID)
+	}
+
+	tx, err = cl.GetMarketOrderTransaction("B20150121-344957-251478")
+	if err != nil {
+		t.Fatalf("GetMarketOrderTransaction error: %v", err)
+	}
+	if tx.Status != "ready" || tx.ServerNumber == nil || *tx.ServerNumber != 107239 || tx.ServerIP != "192.0.2.20" || tx.ProductID != 2783507 {
+		t.Fatalf("unexpected market transaction: %+v", tx)
+	}
+	if _, err := cl.GetMarketOrderTransaction("B-unknown"); !client.IsNotFound(err) {
+		t.Fatalf("expected not found for unknown transaction, got %v", err)
+	}
+
+	dists, langs, err := cl.GetProductDistributions("2783507", true)
+	if err != nil {
+		t.Fatalf("GetProductDistributions error: %v", err)
+	}
+	if len(dists) != 2 || dists[1] != "Debian 12 base" || len(langs) != 1 || langs[0] != "en" {
+		t.Fatalf("unexpected distributions: %v / %v", dists, langs)
+	}
+}
+
+func TestGetAllServersParsesFixture(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/servers.json")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	legacy := `{"server":[{"server_number":321,"server_name":"server1","server_ip":"123.123.123.123","dc":"NBG1-DC1"}]}`
+
+	for name, body := range map[string]string{"wrapped": string(fixture), "legacy": legacy} {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(body))
+			}))
+			defer ts.Close()
+			cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+
+			servers, err := cl.GetAllServers()
+			if err != nil {
+				t.Fatalf("GetAllServers error: %v", err)
+			}
+			s := servers[0]
+			if s.ServerNumber != 321 || s.ServerIP != "123.123.123.123" || s.DC != "NBG1-DC1" || s.Location != "NBG1" {
+				t.Fatalf("unexpected server: %+v", s)
+			}
+			if name == "legacy" {
+				return
+			}
+			if len(servers) != 2 {
+				t.Fatalf("expected 2 servers, got %d", len(servers))
+			}
+			if s.Traffic != "5 TB" || s.PaidUntil != "2010-09-02" || s.LinkedStoragebox != nil {
+				t.Fatalf("unexpected server: %+v", s)
+			}
+			if len(s.Subnet) != 1 || s.Subnet[0].IP != "2a01:4f8:111:4221::" || s.Subnet[0].Mask != "64" {
+				t.Fatalf("unexpected subnets: %+v", s.Subnet)
+			}
+			s2 := servers[1]
+			if !s2.Flatrate || !s2.Cancelled || len(s2.IP) != 2 || s2.LinkedStoragebox == nil || *s2.LinkedStoragebox != 12345 {
+				t.Fatalf("unexpected server: %+v", s2)
+			}
+		})
+	}
+}
+
+func newServerListMock(tb testing.TB, count int, calls *int64) *httptest.Server {
+	tb.Helper()
+	list := make([]map[string]any, count)
+	for i := range list {
+		list[i] = map[string]any{"server": map[string]any{
+			"server_number": i + 1,
+			"server_name":   fmt.Sprintf("server-%d", i+1),
+			"server_ip":     fmt.Sprintf("10.%d.%d.%d", (i>>16)&0xff, (i>>8)&0xff, i&0xff),
+			"status":        "ready",
+		}}
+	}
+	body, err := json.Marshal(list)
+	if err != nil {
+		tb.Fatalf("marshal servers: %v", err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(calls, 1)
+		_, _ = w.Write(body)
+	}))
+}
+
+func BenchmarkCacheManager_GetServers(b *testing.B) {
+	var calls int64
+	ts := newServerListMock(b, 500, &calls)
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+	cm := client.NewCacheManager()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			servers, err := cm.GetServers(cl)
+			if err != nil {
+				b.Errorf("GetServers error: %v", err)
+				return
+			}
+			if len(servers) != 500 {
+				b.Errorf("expected 500 servers, got %d", len(servers))
+				return
+			}
+		}
+	})
+	b.StopTimer()
+
+	if got := atomic.LoadInt64(&calls); got != 1 {
+		b.Fatalf("expected exactly 1 API call, got %d", got)
+	}
+}
+
+func BenchmarkCacheManager_GetServer(b *testing.B) {
+	for _, count := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("servers=%d", count), func(b *testing.B) {
+			var calls int64
+			ts := newServerListMock(b, count, &calls)
+			defer ts.Close()
+			cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+			cm := client.NewCacheManager()
+			if _, err := cm.GetServers(cl); err != nil {
+				b.Fatalf("GetServers error: %v", err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Look up the last server so every iteration pays for a full scan
+				if _, err := cm.GetServer(cl, count); err != nil {
+					b.Fatalf("GetServer error: %v", err)
+				}
+			}
+			b.StopTimer()
+
+			if got := atomic.LoadInt64(&calls); got != 1 {
+				b.Fatalf("expected exactly 1 API call, got %d", got)
+			}
+		})
+	}
+}
+
+func TestAPIErrorPredicates(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/order/server/transaction":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"status":403,"code":"NOT_ALLOWED","message":"ordering not allowed"}}`))
+		case "/server/111":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"status":403,"code":"SERVER_CANCELLED","message":"server is cancelled"}}`))
+		case "/server/222":
+			w.WriteHeader(http.StatusForbidden)
+		case "/boot/424242/rescue":
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error":{"status":409,"code":"BOOT_ALREADY_ENABLED","message":"A boot option is already active"}}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"status":401,"code":"UNAUTHORIZED","message":"Unable to authenticate"}}`))
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+
+	_, err := cl.OrderServer(client.OrderParams{ProductID: "EX101"})
+	if !client.IsNotAllowed(err) || client.IsUnauthorized(err) || client.IsNotFound(err) {
+		t.Fatalf("expected not allowed error, got %v", err)
+	}
+	if err.Error() != "robot: NOT_ALLOWED: ordering not allowed" {
+		t.Fatalf("unexpected error text: %v", err)
+	}
+
+	// Other 403 codes aren't a missing permission; a 403 without a code is
+	if _, err := cl.GetServer(111); err == nil || client.IsNotAllowed(err) {
+		t.Fatalf("expected a 403 with another code not to be not allowed, got %v", err)
+	}
+	if _, err := cl.GetServer(222); !client.IsNotAllowed(err) {
+		t.Fatalf("expected a 403 without a code to be not allowed, got %v", err)
+	}
+
+	_, err = cl.GetAllServers()
+	if !client.IsUnauthorized(err) || client.IsNotAllowed(err) || client.IsConflict(err) {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+
+	_, err = cl.ActivateRescue(424242, client.RescueParams{})
+	if !client.IsConflict(err) || client.IsNotAllowed(err) || client.IsRateLimited(err) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+}
+
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestPollBacksOffUntilDone(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	attempts := 0
+	status, err := client.Poll(context.Background(), client.PollOptions{
+		Interval:    time.Second,
+		MaxInterval: 5 * time.Second,
+		Clock:       clock,
+	}, func() (string, error) {
+		attempts++
+		if attempts == 6 {
+			return "ready", nil
+		}
+		return "in process", nil
+	}, func(s string) bool { return s == "ready" })
+	if err != nil {
+		t.Fatalf("Poll error: %v", err)
+	}
+	if status != "ready" || attempts != 6 {
+		t.Fatalf("unexpected result %q after %d attempts", status, attempts)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	if fmt.Sprint(clock.waits) != fmt.Sprint(want) {
+		t.Fatalf("unexpected waits: %v, want %v", clock.waits, want)
+	}
+}
+
+func TestPollStopsAtMaxElapsed(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	attempts := 0
+	status, err := client.Poll(context.Background(), client.PollOptions{
+		Interval:   4 * time.Second,
+		MaxElapsed: 10 * time.Second,
+		Clock:      clock,
+	}, func() (string, error) {
+		attempts++
+		return "in process", nil
+	}, func(s string) bool { return s == "ready" })
+	if !errors.Is(err, client.ErrPollTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if status != "in process" {
+		t.Fatalf("expected last value to be returned, got %q", status)
+	}
+	// 4s, then the remaining 6s are capped to the deadline, then one final attempt
+	want := []time.Duration{4 * time.Second, 6 * time.Second}
+	if fmt.Sprint(clock.waits) != fmt.Sprint(want) || attempts != 3 {
+		t.Fatalf("unexpected waits %v after %d attempts", clock.waits, attempts)
+	}
+}
+
+func TestPollStopsOnFetchErrorAndCancel(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	boom := errors.New("boom")
+	_, err := client.Poll(context.Background(), client.PollOptions{Clock: clock}, func() (int, error) {
+		return 0, boom
+	}, func(int) bool { return false })
+	if !errors.Is(err, boom) || len(clock.waits) != 0 {
+		t.Fatalf("expected immediate fetch error, got %v after %d waits", err, len(clock.waits))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.Poll(ctx, client.PollOptions{Interval: time.Hour}, func() (int, error) {
+		return 0, nil
+	}, func(int) bool { return false })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancellation, got %v", err)
+	}
+}
+
+func TestServerDescription(t *testing.T) {
+	var gotComment string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/server/111" && r.Method == http.MethodPost:
+			_ = r.ParseForm()
+			gotComment = r.PostForm.Get("comment")
+			_, _ = w.Write([]byte(`{"server":{"server_number":111,"comment":"` + gotComment + `"}}`))
+		case r.URL.Path == "/server/111" && r.Method == http.MethodGet:
+			_, _ = w.Write([]byte(`{"server":{"server_number":111,"dc":"FSN1-DC14","comment":"k3s worker"}}`))
+		case r.URL.Path == "/server/222":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"status":400,"code":"INVALID_INPUT","message":"invalid input","invalid":["comment"]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+
+	if err := cl.SetServerDescription(111, "k3s worker"); err != nil {
+		t.Fatalf("SetServerDescription: %v", err)
+	}
+	if gotComment != "k3s worker" {
+		t.Fatalf("expected comment form field, got %q", gotComment)
+	}
+
+	s, err := cl.GetServer(111)
+	if err != nil {
+		t.Fatalf("GetServer: %v", err)
+	}
+	if s.Comment != "k3s worker" || s.Location != "FSN1" {
+		t.Fatalf("unexpected server: %+v", s)
+	}
+
+	err = cl.SetServerDescription(222, "x")
+	if !client.IsInvalidInput(err) || client.IsNotAllowed(err) {
+		t.Fatalf("expected invalid input error, got %v", err)
+	}
+}
+
+func TestSSHKeyLifecycle(t *testing.T) {
+	var deleted string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/key":
+			_ = r.ParseForm()
+			if r.PostForm.Get("name") != "ephemeral" || r.PostForm.Get("data") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"key":{"name":"ephemeral","fingerprint":"56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10","type":"ED25519","size":256}}`))
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/key/"):
+			deleted = strings.TrimPrefix(r.URL.Path, "/key/")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+
+	key, err := cl.AddSSHKey("ephemeral", "ssh-ed25519 AAAA")
+	if err != nil {
+		t.Fatalf("AddSSHKey: %v", err)
+	}
+	if key.Fingerprint != "56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10" || key.Type != "ED25519" {
+		t.Fatalf("unexpected key: %+v", key)
+	}
+	if err := cl.DeleteSSHKey(key.Fingerprint); err != nil {
+		t.Fatalf("DeleteSSHKey: %v", err)
+	}
+	if deleted != key.Fingerprint {
+		t.Fatalf("expected delete of %s, got %s", key.Fingerprint, deleted)
+	}
+}
+
+func TestRetriesConfiguredStatusCodes(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"server":{"server_number":1,"server_name":"a"}}]`))
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{
+		MaxRetries:       3,
+		RetryStatusCodes: client.DefaultRetryStatusCodes,
+		RetryWait:        time.Millisecond,
+	})
+
+	servers, err := cl.GetAllServers()
+	if err != nil {
+		t.Fatalf("GetAllServers: %v", err)
+	}
+	if len(servers) != 1 || servers[0].ServerName != "a" {
+		t.Fatalf("unexpected servers: %+v", servers)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+}
+
+func TestRetryHonorsRetryAfterAndSkipsUnsafePosts(t *testing.T) {
+	var calls int32
+	var gap time.Duration
+	var last time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		now := time.Now()
+		if !last.IsZero() {
+			gap = now.Sub(last)
+		}
+		last = now
+		switch {
+		case r.URL.Path == "/server" && n == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == "/server":
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{
+		MaxRetries:       3,
+		RetryStatusCodes: client.DefaultRetryStatusCodes,
+		RetryWait:        time.Millisecond,
+	})
+
+	if _, err := cl.GetAllServers(); err != nil {
+		t.Fatalf("GetAllServers: %v", err)
+	}
+	if gap < 900*time.Millisecond {
+		t.Fatalf("expected Retry-After to delay the retry by ~1s, got %s", gap)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	if _, err := cl.OrderServer(client.OrderParams{ProductID: "EX101"}); err == nil {
+		t.Fatalf("expected order to fail")
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected a 502 on POST not to be retried, got %d attempts", got)
+	}
+}
+
+func TestCancelServerDefaultsToEarliestDate(t *testing.T) {
+	var method, date string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`{"cancellation":{"server_number":111,"earliest_cancellation_date":"2026-11-30","cancelled":false,"cancellation_date":null}}`))
+		default:
+			_ = r.ParseForm()
+			method, date = r.Method, r.PostForm.Get("cancellation_date")
+			_, _ = w.Write([]byte(`{"cancellation":{"server_number":111,"cancelled":true,"cancellation_date":"` + date + `"}}`))
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+
+	if err := cl.CancelServer(111, ""); err != nil {
+		t.Fatalf("CancelServer: %v", err)
+	}
+	if method != http.MethodPost || date != "2026-11-30" {
+		t.Fatalf("expected POST with earliest date, got %s %q", method, date)
+	}
+
+	if err := cl.CancelServer(111, "now"); err != nil {
+		t.Fatalf("CancelServer: %v", err)
+	}
+	if date != "now" {
+		t.Fatalf("expected explicit date to be passed through, got %q", date)
+	}
+}
+
+func TestListSSHKeys(t *testing.T) {
+	keys := `[{"key":{"name":"laptop","fingerprint":"56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10","type":"ED25519","size":256}}]`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/key" {
+			http.NotFound(w, r)
+			return
+		}
+		if keys == "" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"status":404,"code":"NOT_FOUND","message":"No keys found"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(keys))
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+
+	list, err := cl.ListSSHKeys()
+	if err != nil {
+		t.Fatalf("ListSSHKeys: %v", err)
+	}
+	if len(list) != 1 || list[0].Name != "laptop" || list[0].Fingerprint != "56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10" {
+		t.Fatalf("unexpected keys: %+v", list)
+	}
+
+	// An account without keys is not an error
+	keys = ""
+	list, err = cl.ListSSHKeys()
+	if err != nil || len(list) != 0 {
+		t.Fatalf("expected no keys, got %+v, %v", list, err)
+	}
+}
+
+func TestCacheManagerInvalidateServer(t *testing.T) {
+	var listCalls, getCalls int32
+	name := "before"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/server":
+			atomic.AddInt32(&listCalls, 1)
+			_, _ = w.Write([]byte(`[{"server":{"server_number":1,"server_name":"` + name + `"}},{"server":{"server_number":2,"server_name":"other"}}]`))
+		case "/server/1":
+			atomic.AddInt32(&getCalls, 1)
+			_, _ = w.Write([]byte(`{"server":{"server_number":1,"server_name":"` + name + `"}}`))
+		case "/server/2":
+			atomic.AddInt32(&getCalls, 1)
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"status":404,"code":"SERVER_NOT_FOUND","message":"server not found"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+	cm := client.NewCacheManager()
+
+	s, err := cm.GetServer(cl, 1)
+	if err != nil || s.ServerName != "before" {
+		t.Fatalf("GetServer: %+v, %v", s, err)
+	}
+	name = "after"
+	if s, _ = cm.GetServer(cl, 1); s.ServerName != "before" {
+		t.Fatalf("expected cached name before invalidation, got %q", s.ServerName)
+	}
+
+	cm.InvalidateServer(1)
+	s, err = cm.GetServer(cl, 1)
+	if err != nil || s.ServerName != "after" {
+		t.Fatalf("expected fresh server after invalidation: %+v, %v", s, err)
+	}
+	if _, err = cm.GetServer(cl, 1); err != nil {
+		t.Fatalf("GetServer: %v", err)
+	}
+	if l, g := atomic.LoadInt32(&listCalls), atomic.LoadInt32(&getCalls); l != 1 || g != 1 {
+		t.Fatalf("expected 1 list and 1 single fetch, got %d and %d", l, g)
+	}
+
+	// A server that is gone after invalidation is dropped from the cache
+	cm.InvalidateServer(2)
+	servers, err := cm.GetServers(cl)
+	if err != nil || len(servers) != 1 || servers[0].ServerNumber != 1 {
+		t.Fatalf("expected server 2 to be dropped: %+v, %v", servers, err)
+	}
+}
+
+func TestServersFiltered(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"server":{"server_number":1,"server_name":"web-1","server_ip":"1.1.1.1","ip":["1.1.1.1","1.1.1.2"],"product":"EX101","dc":"FSN1-DC1","status":"ready"}},
+			{"server":{"server_number":2,"server_name":"db-1","server_ip":"2.2.2.2","product":"AX41","dc":"HEL1-DC2","status":"in process"}}
+		]`))
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", ts.Client(), client.ClientConfig{})
+
+	cases := []struct {
+		filter client.ServerFilter
+		want   []int
+	}{
+		{client.ServerFilter{}, []int{1, 2}},
+		{client.ServerFilter{ServerNumbers: []int{2, 3}}, []int{2}},
+		{client.ServerFilter{ServerIP: "1.1.1.2"}, []int{1}},
+		{client.ServerFilter{Product: "ex101", Status: "READY"}, []int{1}},
+		{client.ServerFilter{ServerName: "db-1", Status: "ready"}, nil},
+	}
+	for _, c := range cases {
+		servers, err := cl.GetServersFiltered(c.filter)
+		if err != nil {
+			t.Fatalf("GetServersFiltered(%+v): %v", c.filter, err)
+		}
+		var got []int
+		for _, s := range servers {
+			got = append(got, s.ServerNumber)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("GetServersFiltered(%+v) = %v, want %v", c.filter, got, c.want)
+		}
+	}
+
+	cm := client.NewCacheManager()
+	if !cm.RefreshedAt().IsZero() {
+		t.Fatal("expected no refresh time before the first fetch")
+	}
+	servers, err := cm.GetServersFiltered(cl, client.ServerFilter{ServerNumbers: []int{1}})
+	if err != nil || len(servers) != 1 || cm.RefreshedAt().IsZero() {
+		t.Fatalf("cached filter: %+v, %v, refreshed %v", servers, err, cm.RefreshedAt())
+	}
+}
+
+func TestCacheManagerInvalidateDuringReads(t *testing.T) {
+	var listCalls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/server":
+			atomic.AddInt32(&listCalls, 1)
+			_, _ = w.Write([]byte(`[{"server":{"server_number":1,"server_name":"a"}},{"server":{"server_number":2,"server_name":"b"}}]`))
+		case "/server/1":
+			_, _ = w.Write([]byte(`{"server":{"server_number":1,"server_name":"a"}}`))
+		case "/vswitch/7":
+			_, _ = w.Write([]byte(`{"id":7,"server":[{"server_ip":"1.1.1.1","server_number":1,"status":"ready"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", ts.Client(), client.ClientConfig{})
+	cm := client.NewCacheManager()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 50; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			if s, err := cm.GetServer(cl, 1); err != nil || s.ServerName != "a" {
+				errs <- fmt.Errorf("GetServer: %+v, %v", s, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := cm.GetVSwitchServers(cl, 7); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			cm.Invalidate()
+		}()
+		go func() {
+			defer wg.Done()
+			cm.InvalidateServer(1)
+			_ = cm.RefreshedAt()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	calls := atomic.LoadInt32(&listCalls)
+	cm.Invalidate()
+	if servers, err := cm.GetServers(cl); err != nil || len(servers) != 2 {
+		t.Fatalf("GetServers after Invalidate: %+v, %v", servers, err)
+	}
+	if atomic.LoadInt32(&listCalls) != calls+1 {
+		t.Fatal("expected Invalidate to force a fresh server list")
+	}
+}
+
+func TestTransactionDateAndProductEcho(t *testing.T) {
+	cases := []struct {
+		name    string
+		json    string
+		product string
+		date    string
+	}{
+		{"object with string id", `{"id":"t1","date":"2024-03-01T10:20:30+01:00","product":{"id":"EX44","name":"EX44"}}`, "EX44", "2024-03-01T09:20:30Z"},
+		{"bare market id", `{"id":"t2","date":"2024-03-01 10:20:30","product":1234567}`, "1234567", "2024-03-01T10:20:30Z"},
+		{"object with numeric id", `{"id":"t3","date":"2024-03-01","product":{"id":98765}}`, "98765", "2024-03-01T00:00:00Z"},
+		{"missing date and product", `{"id":"t4"}`, "", ""},
+		{"empty date", `{"id":"t5","date":"","product":"EX101"}`, "EX101", ""},
+		{"garbage date", `{"id":"t6","date":"soon"}`, "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var tx client.Transaction
+			if err := json.Unmarshal([]byte(tc.json), &tx); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			// The provider caches transactions as JSON; the echo has to survive the round trip
+			b, err := json.Marshal(&tx)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var cached client.Transaction
+			if err := json.Unmarshal(b, &cached); err != nil {
+				t.Fatalf("unmarshal cached: %v", err)
+			}
+			for _, got := range []client.Transaction{tx, cached} {
+				if got.OrderedProduct != tc.product {
+					t.Errorf("OrderedProduct = %q, want %q", got.OrderedProduct, tc.product)
+				}
+				ts, ok := got.CreatedAt()
+				if ok != (tc.date != "") || (ok && ts.UTC().Format(time.RFC3339) != tc.date) {
+					t.Errorf("CreatedAt = %v, %v, want %q", ts, ok, tc.date)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderTransactionLists(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/order/server/transaction":
+			_, _ = w.Write([]byte(`[
+				{"transaction":{"id":"B1","date":"2024-01-01T10:00:00+01:00","status":"ready","server_number":1,"server_ip":"192.0.2.1","product":{"id":"EX44"}}},
+				{"transaction":{"id":"B2","status":"in process","server_number":null,"server_ip":null,"product":{"id":"AX42"}}}
+			]`))
+		case "/order/server_market/transaction":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"status":404,"code":"NOT_FOUND","message":"No transactions found"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+
+	txs, err := cl.GetOrderTransactionList()
+	if err != nil {
+		t.Fatalf("GetOrderTransactionList: %v", err)
+	}
+	if len(txs) != 2 || txs[0].ID != "B1" || *txs[0].ServerNumber != 1 || txs[0].OrderedProduct != "EX44" || txs[1].ServerNumber != nil || txs[1].Status != "in process" || txs[0].TransactionType != client.TransactionTypeOrder {
+		t.Fatalf("unexpected transactions: %+v", txs)
+	}
+
+	market, err := cl.GetMarketOrderTransactionList()
+	if err != nil || len(market) != 0 {
+		t.Fatalf("expected no market transactions, got %+v, %v", market, err)
+	}
+}
+
+func TestFirewallTemplates(t *testing.T) {
+	var form url.Values
+	var firewallReads int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/firewall/template":
+			form = r.PostForm
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"firewall_template":{"id":7,"name":"base","filter_ipv6":false,"whitelist_hos":true,"is_default":false,
+				"rules":{"input":[{"ip_version":"ipv4","name":"ssh","dst_ip":null,"src_ip":null,"dst_port":"22","src_port":null,"protocol":"tcp","tcp_flags":null,"action":"accept"}],"output":[]}}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/firewall/template":
+			_, _ = w.Write([]byte(`[{"firewall_template":{"id":7,"name":"base","filter_ipv6":false,"whitelist_hos":true,"is_default":true}}]`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/firewall/template/7":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPost && r.URL.Path == "/firewall/111":
+			form = r.PostForm
+			_, _ = w.Write([]byte(`{"firewall":{"server_ip":"1.2.3.4","server_number":111,"status":"in process","rules":{"input":[],"output":[]}}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/firewall/111":
+			status := "in process"
+			if atomic.AddInt32(&firewallReads, 1) >= 2 {
+				status = "active"
+			}
+			_, _ = w.Write([]byte(`{"firewall":{"server_ip":"1.2.3.4","server_number":111,"status":"` + status + `","rules":{"input":[],"output":[]}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", ts.Client(), client.ClientConfig{})
+
+	tmpl, err := cl.CreateFirewallTemplate(client.FirewallTemplate{
+		Name:         "base",
+		WhitelistHOS: true,
+		Rules: client.FirewallRules{Input: []client.FirewallRule{
+			{Name: "ssh", IPVersion: "ipv4", DstPort: "22", Protocol: "tcp", Action: "accept"},
+			{Name: "drop", Action: "discard"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("CreateFirewallTemplate: %v", err)
+	}
+	if tmpl.ID != 7 || len(tmpl.Rules.Input) != 1 || tmpl.Rules.Input[0].DstPort != "22" || tmpl.Rules.Input[0].SrcIP != "" {
+		t.Fatalf("unexpected template: %+v", tmpl)
+	}
+	for key, want := range map[string]string{
+		"name": "base", "whitelist_hos": "true", "filter_ipv6": "false",
+		"rules[input][0][dst_port]": "22", "rules[input][0][action]": "accept", "rules[input][1][action]": "discard",
+	} {
+		if got := form.Get(key); got != want {
+			t.Errorf("form %s = %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := form["rules[input][1][protocol]"]; ok {
+		t.Error("expected empty rule fields to be left out")
+	}
+
+	list, err := cl.ListFirewallTemplates()
+	if err != nil || len(list) != 1 || !list[0].IsDefault {
+		t.Fatalf("ListFirewallTemplates: %+v, %v", list, err)
+	}
+	if err := cl.DeleteFirewallTemplate(7); err != nil {
+		t.Fatalf("DeleteFirewallTemplate: %v", err)
+	}
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	fw, err := cl.ApplyFirewallTemplate(context.Background(), 111, 7, client.PollOptions{Interval: time.Second, MaxElapsed: time.Minute, Clock: clock})
+	if err != nil {
+		t.Fatalf("ApplyFirewallTemplate: %v", err)
+	}
+	if form.Get("template_id") != "7" || fw.Status != "active" || atomic.LoadInt32(&firewallReads) != 2 {
+		t.Fatalf("expected template 7 to be applied after polling, got %+v after %d reads", fw, firewallReads)
+	}
+}
+
+func TestGetServerHardware(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/server/1":
+			_, _ = w.Write([]byte(`{"server":{"server_number":1,"hardware":{"cpu":"AMD Ryzen 9 5950X","memory_gb":128,
+				"drives":[{"model":"SAMSUNG MZQL23T8HCLS","serial":"S64HNE0R100001","size":3840755982336}]}}}`))
+		case "/server/2", "/server/3":
+			_, _ = w.Write([]byte(`{"server":{"server_number":2}}`))
+		case "/server/2/hardware":
+			_, _ = w.Write([]byte(`{"hardware":{"drives":[{"model":"TOSHIBA MG08ACA16TE","serial":"X0F0A0AAFVGG","size":16000900661248}]}}`))
+		case "/server/4":
+			// Undocumented formats count as no data
+			_, _ = w.Write([]byte(`{"server":{"server_number":4,"hardware":"n/a"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"status":404,"code":"NOT_FOUND","message":"Not found"}}`))
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", ts.Client(), client.ClientConfig{})
+
+	hw, err := cl.GetServerHardware(1)
+	if err != nil || hw == nil || hw.CPU != "AMD Ryzen 9 5950X" || hw.MemoryGB != 128 || len(hw.Drives) != 1 || hw.Drives[0].Serial != "S64HNE0R100001" || hw.Drives[0].SizeBytes != 3840755982336 {
+		t.Fatalf("unexpected hardware from the server: %+v, %v", hw, err)
+	}
+	hw, err = cl.GetServerHardware(2)
+	if err != nil || hw == nil || len(hw.Drives) != 1 || hw.Drives[0].Model != "TOSHIBA MG08ACA16TE" {
+		t.Fatalf("unexpected hardware from the subresource: %+v, %v", hw, err)
+	}
+	for _, n := range []int{3, 4} {
+		if hw, err := cl.GetServerHardware(n); err != nil || hw != nil {
+			t.Fatalf("server %d: expected no hardware, got %+v, %v", n, hw, err)
+		}
+	}
+	if _, err := cl.GetServerHardware(5); !client.IsNotFound(err) {
+		t.Fatalf("expected an unknown server to fail, got %v", err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/traffic":
+			_, _ = w.Write([]byte(`{"traffic":{"type":"month"}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/wol/321" && r.Form.Get("note") == "hi":
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"wol":{"server_number":321}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"status":404,"code":"NOT_FOUND","message":"Not found"}}`))
+		}
+	}))
+	defer ts.Close()
+	cl := client.New(ts.URL, "user", "pass", &http.Client{Timeout: 5 * time.Second}, client.ClientConfig{})
+
+	status, body, err := cl.Call(http.MethodGet, "/traffic", nil)
+	if err != nil || status != http.StatusOK || string(body) != `{"traffic":{"type":"month"}}` {
+		t.Fatalf("unexpected GET result: %d %s %v", status, body, err)
+	}
+	status, _, err = cl.Call(http.MethodPost, "/wol/321", url.Values{"note": {"hi"}})
+	if err != nil || status != http.StatusAccepted {
+		t.Fatalf("unexpected POST result: %d %v", status, err)
+	}
+	if _, _, err := cl.Call(http.MethodGet, "/nope", nil); !client.IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
+
+func TestVSwitchRecordedResponses(t *testing.T) {
+	// direct: Robot returns the vSwitch itself and knows description
+	cl, replay, err := clienttest.NewClient("testdata/vswitch/direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	description := "k3s nodes"
+	created, err := cl.CreateVSwitch(4000, "internal", &description)
+	if err != nil || created.ID != 7 || created.Description == nil || *created.Description != "k3s nodes" {
+		t.Fatalf("CreateVSwitch: %+v, %v", created, err)
+	}
+	got, err := cl.GetVSwitch(7)
+	if err != nil || got.Name != "internal" || got.Description != nil || len(got.Servers) != 1 || got.Servers[0].ServerNumber != 321 {
+		t.Fatalf("GetVSwitch: %+v, %v", got, err)
+	}
+	// Without a description none is sent, which the recorded form checks
+	updated, err := cl.UpdateVSwitch(7, 4001, "renamed", nil)
+	if err != nil || updated.VLAN != 4001 || updated.Name != "renamed" {
+		t.Fatalf("UpdateVSwitch: %+v, %v", updated, err)
+	}
+	empty := ""
+	if cleared, err := cl.UpdateVSwitch(7, 4001, "renamed", &empty); err != nil || cleared.Description == nil || *cleared.Description != "" {
+		t.Fatalf("clearing the description: %+v, %v", cleared, err)
+	}
+	if unused := replay.Unused(); len(unused) != 0 {
+		t.Errorf("unused exchanges: %v", unused)
+	}
+
+	// wrapped: older responses wrap the vSwitch in {"vswitch": ...}
+	cl, replay, err = clienttest.NewClient("testdata/vswitch/wrapped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created, err = cl.CreateVSwitch(4000, "internal", nil); err != nil || created.ID != 8 || created.VLAN != 4000 || created.Name != "internal" {
+		t.Fatalf("wrapped CreateVSwitch: %+v, %v", created, err)
+	}
+	if got, err = cl.GetVSwitch(8); err != nil || got.ID != 8 || got.Name != "internal" {
+		t.Fatalf("wrapped GetVSwitch: %+v, %v", got, err)
+	}
+	if updated, err = cl.UpdateVSwitch(8, 4000, "renamed", nil); err != nil || updated.ID != 8 || updated.Name != "renamed" {
+		t.Fatalf("wrapped UpdateVSwitch: %+v, %v", updated, err)
+	}
+	if unused := replay.Unused(); len(unused) != 0 {
+		t.Errorf("unused exchanges: %v", unused)
+	}
+}
+
+func TestVSwitchRecordedErrors(t *testing.T) {
+	cl, replay, err := clienttest.NewClient("testdata/vswitch/errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.GetVSwitch(9); !client.IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	// An error payload is an error even with status 200
+	var ae *client.APIError
+	if _, err := cl.UpdateVSwitch(9, 4000, "internal", nil); !errors.As(err, &ae) || ae.Code != "VSWITCH_IN_PROCESS" || ae.Status != 409 {
+		t.Errorf("expected VSWITCH_IN_PROCESS, got %v", err)
+	}
+	if v, err := cl.GetVSwitch(9); err == nil || !strings.Contains(err.Error(), "without an id") {
+		t.Errorf("expected an error for a vSwitch without id, got %+v, %v", v, err)
+	}
+	if v, err := cl.GetVSwitch(9); err == nil {
+		t.Errorf("expected an error for a non-object response, got %+v", v)
+	}
+	if unused := replay.Unused(); len(unused) != 0 {
+		t.Errorf("unused exchanges: %v", unused)
+	}
+}
+
+func TestRecordAPIDir(t *testing.T) {
+	ts, _ := newMockServer(t)
+	defer ts.Close()
+	dir := t.TempDir()
+	cl := client.New(ts.URL, "user", "secret-pass", ts.Client(), client.ClientConfig{RecordDir: dir})
+
+	if _, _, err := cl.Call(http.MethodPost, "/boot/424242/rescue", url.Values{"os": {"linux"}, "password": {"hunter2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cl.Call(http.MethodGet, "/boot/424242/rescue", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 2 || files[0].Name() != "0001-POST-boot-424242-rescue.json" || files[1].Name() != "0002-GET-boot-424242-rescue.json" {
+		t.Fatalf("unexpected recordings: %v", files)
+	}
+	for _, f := range files {
+		b, _ := os.ReadFile(dir + "/" + f.Name())
+		for _, secret := range []string{"hunter2", "secret-pass", `"secret"`} {
+			if strings.Contains(string(b), secret) {
+				t.Errorf("%s contains %q:\n%s", f.Name(), secret, b)
+			}
+		}
+	}
+
+	// A second run continues the numbering instead of overwriting
+	cl = client.New(ts.URL, "user", "secret-pass", ts.Client(), client.ClientConfig{RecordDir: dir})
+	if _, _, err := cl.Call(http.MethodGet, "/boot/424242/rescue", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir + "/0003-GET-boot-424242-rescue.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recordings replay; the redacted password only has to be sent
+	replayed, replay, err := clienttest.NewClient(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body, err := replayed.Call(http.MethodPost, "/boot/424242/rescue", url.Values{"os": {"linux"}, "password": {"other"}})
+	if err != nil || status != http.StatusOK || !strings.Contains(string(body), client.Redacted) {
+		t.Fatalf("replayed POST: %d %s %v", status, body, err)
+	}
+	if _, _, err := replayed.Call(http.MethodPost, "/boot/424242/rescue", url.Values{"os": {"linux"}}); err == nil {
+		t.Error("expected an error once the recorded exchange is used up")
+	}
+	if unused := replay.Unused(); len(unused) != 2 {
+		t.Errorf("unused exchanges: %v", unused)
+	}
+}
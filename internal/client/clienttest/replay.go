@@ -0,0 +1,136 @@
+// Package clienttest serves Robot exchanges recorded with record_api_dir, so client tests can run
+// against the responses Robot actually sent
+package clienttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+// BaseURL is the base URL to give client.New with a replay client
+const BaseURL = "http://robot.replay"
+
+// ReplayTransport answers each request with the first unused recorded exchange of the same method
+// and path. A recorded form must match the request form, except for redacted fields
+type ReplayTransport struct {
+	mu        sync.Mutex
+	exchanges []client.Exchange
+	used      []bool
+}
+
+// NewReplayTransport loads the exchanges recorded in dir, in file name order
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	t := &ReplayTransport{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var e client.Exchange
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		t.exchanges = append(t.exchanges, e)
+	}
+	if len(t.exchanges) == 0 {
+		return nil, fmt.Errorf("no recorded exchanges in %s", dir)
+	}
+	t.used = make([]bool, len(t.exchanges))
+	return t, nil
+}
+
+// NewClient returns a client that replays the exchanges recorded in dir
+func NewClient(dir string) (*client.Client, *ReplayTransport, error) {
+	t, err := NewReplayTransport(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.New(BaseURL, "user", "pass", &http.Client{Transport: t}, client.ClientConfig{}), t, nil
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	var form url.Values
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if form, err = url.ParseQuery(string(b)); err != nil {
+			return nil, err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, e := range t.exchanges {
+		if t.used[i] || e.Method != req.Method || e.Path != path {
+			continue
+		}
+		if e.Form != nil && !formMatches(e.Form, form) {
+			return nil, fmt.Errorf("clienttest: %s %s sent form %v, recorded %v", req.Method, path, form, e.Form)
+		}
+		t.used[i] = true
+		return &http.Response{
+			StatusCode: e.Status,
+			Status:     fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(e.Body)),
+			Request:    req,
+		}, nil
+	}
+	return nil, fmt.Errorf("clienttest: no recorded exchange left for %s %s", req.Method, path)
+}
+
+// Unused returns the recorded exchanges no request asked for, as "METHOD path"
+func (t *ReplayTransport) Unused() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []string
+	for i, e := range t.exchanges {
+		if !t.used[i] {
+			out = append(out, e.Method+" "+e.Path)
+		}
+	}
+	return out
+}
+
+// formMatches compares a sent form with a recorded one; redacted fields only need to be present
+func formMatches(recorded map[string][]string, sent url.Values) bool {
+	if len(recorded) != len(sent) {
+		return false
+	}
+	for k, vs := range recorded {
+		got, ok := sent[k]
+		if !ok {
+			return false
+		}
+		if len(vs) == 1 && vs[0] == client.Redacted {
+			continue
+		}
+		if !reflect.DeepEqual(vs, got) {
+			return false
+		}
+	}
+	return true
+}
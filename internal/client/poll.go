@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrPollTimeout is returned by Poll when MaxElapsed passes before the predicate is satisfied
+var ErrPollTimeout = errors.New("timed out waiting for condition")
+
+// Clock abstracts time so polling can be tested without real sleeps
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// PollOptions controls how often Poll calls the endpoint and for how long
+type PollOptions struct {
+	Interval    time.Duration // wait after the first attempt, doubled after each further attempt
+	MaxInterval time.Duration // upper bound for the wait between attempts (0 = no bound)
+	MaxElapsed  time.Duration // give up after this long (0 = until ctx is done)
+	Jitter      float64       // fraction of each wait that is randomised, 0..1
+	Clock       Clock         // defaults to the wall clock
+}
+
+// Poll calls fetch until done reports true for its result, backing off exponentially between
+// attempts. A fetch error ends polling immediately. On timeout the last fetched value is
+// returned together with an error wrapping ErrPollTimeout.
+func Poll[T any](ctx context.Context, opts PollOptions, fetch func() (T, error), done func(T) bool) (T, error) {
+	clock := opts.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	start := clock.Now()
+	for attempt := 1; ; attempt++ {
+		v, err := fetch()
+		if err != nil {
+			return v, err
+		}
+		if done(v) {
+			return v, nil
+		}
+
+		wait := interval
+		if opts.Jitter > 0 {
+			wait += time.Duration(opts.Jitter * float64(wait) * (rand.Float64()*2 - 1))
+		}
+		if opts.MaxElapsed > 0 {
+			remaining := opts.MaxElapsed - clock.Now().Sub(start)
+			if remaining <= 0 {
+				return v, fmt.Errorf("%w after %d attempts (%s)", ErrPollTimeout, attempt, opts.MaxElapsed)
+			}
+			if wait > remaining {
+				wait = remaining
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return v, ctx.Err()
+		case <-clock.After(wait):
+		}
+
+		interval *= 2
+		if opts.MaxInterval > 0 && interval > opts.MaxInterval {
+			interval = opts.MaxInterval
+		}
+	}
+}
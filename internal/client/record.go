@@ -0,0 +1,161 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Exchange is one recorded Robot request and its response, as written by the record_api_dir
+// mode and served by clienttest.ReplayTransport. Path is relative to the base URL and includes
+// the query string
+type Exchange struct {
+	Method string              `json:"method"`
+	Path   string              `json:"path"`
+	Form   map[string][]string `json:"form,omitempty"`
+	Status int                 `json:"status"`
+	Body   string              `json:"body"`
+}
+
+// Redacted replaces the values of sensitive form fields and response keys
+const Redacted = "REDACTED"
+
+// sensitiveField reports whether a form field or JSON key holds a secret that must not end up
+// in a recording
+func sensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"password", "secret", "token"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactForm returns form with the values of sensitive fields replaced
+func redactForm(form url.Values) map[string][]string {
+	if form == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(form))
+	for k, vs := range form {
+		if sensitiveField(k) {
+			vs = []string{Redacted}
+		}
+		out[k] = vs
+	}
+	return out
+}
+
+// redactBody replaces the values of sensitive keys anywhere in a JSON body, such as the root
+// password of an activated rescue system. Bodies that aren't JSON are kept as they are
+func redactBody(b []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return string(b)
+	}
+	if !redactValue(v) {
+		return string(b)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return string(b)
+	}
+	return string(out)
+}
+
+// redactValue redacts v in place and reports whether anything was replaced
+func redactValue(v interface{}) bool {
+	changed := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if _, ok := e.(string); ok && sensitiveField(k) {
+				t[k] = Redacted
+				changed = true
+			} else if redactValue(e) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, e := range t {
+			if redactValue(e) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// recorder writes exchanges to dir as 0001-GET-vswitch-42.json and so on, continuing after the
+// files of earlier runs
+type recorder struct {
+	dir  string
+	mu   sync.Mutex
+	next int
+}
+
+var (
+	recordingNumber = regexp.MustCompile(`^(\d+)-.*\.json$`)
+	unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9]+`)
+)
+
+func newRecorder(dir string) *recorder {
+	r := &recorder{dir: dir, next: 1}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if m := recordingNumber.FindStringSubmatch(e.Name()); m != nil {
+			if n, err := strconv.Atoi(m[1]); err == nil && n >= r.next {
+				r.next = n + 1
+			}
+		}
+	}
+	return r
+}
+
+// record writes the final response of a call and gives resp a fresh copy of its body. Failures
+// are logged: a recording must never fail the call it records
+func (r *recorder) record(method, path string, form url.Values, resp *http.Response) {
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		log.Printf("record_api_dir: reading the response of %s %s: %v", method, path, err)
+		return
+	}
+	out, err := json.MarshalIndent(Exchange{
+		Method: method,
+		Path:   path,
+		Form:   redactForm(form),
+		Status: resp.StatusCode,
+		Body:   redactBody(b),
+	}, "", "  ")
+	if err != nil {
+		log.Printf("record_api_dir: encoding %s %s: %v", method, path, err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	slug := strings.Trim(unsafeFileChars.ReplaceAllString(strings.SplitN(path, "?", 2)[0], "-"), "-")
+	name := filepath.Join(r.dir, fmt.Sprintf("%04d-%s-%s.json", r.next, method, slug))
+	if err := os.MkdirAll(r.dir, 0o700); err != nil {
+		log.Printf("record_api_dir: %v", err)
+		return
+	}
+	if err := os.WriteFile(name, append(out, '\n'), 0o600); err != nil {
+		log.Printf("record_api_dir: %v", err)
+		return
+	}
+	r.next++
+}
@@ -2,6 +2,10 @@ package client
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Product struct {
@@ -10,6 +14,8 @@ type Product struct {
 	Description []string `json:"description"`
 	Traffic     string   `json:"traffic"`
 	Location    []string `json:"location"`
+	Dist        []string `json:"dist"`
+	Lang        []string `json:"lang"`
 }
 
 // UnmarshalJSON custom unmarshaling for Product to handle location as either string or []string
@@ -54,12 +60,42 @@ func (p *Product) UnmarshalJSON(data []byte) error {
 type Transaction struct {
 	ID           string   `json:"id"`
 	Date         string   `json:"date"`
-	Status       string   `json:"status"` // "in process" | "ready" | "cancelled"
+	Status       string   `json:"status"` // "in process" | "ready" | "cancelled"; only the last two are final
 	ServerNumber *int     `json:"server_number"`
 	ServerIP     string   `json:"server_ip"`
 	Product      *Product `json:"-"` // Handle with custom unmarshaling
 	ProductID    int      `json:"-"` // Store product ID when it's an integer
 	Addons       []string `json:"addons,omitempty"`
+	// DC is the datacenter (e.g. FSN1-DC14) once Robot has assigned the server
+	DC string `json:"dc,omitempty"`
+	// OrderedProduct is the product id Robot echoed, whether it came as a bare id or as the
+	// product object; unlike Product it survives the provider's transaction cache
+	OrderedProduct string `json:"ordered_product,omitempty"`
+	// TransactionType tells standard orders from auction orders, whose ids come from separate
+	// sequences; set by the client, not sent by Robot
+	TransactionType string `json:"transaction_type,omitempty"`
+}
+
+const (
+	TransactionTypeOrder  = "order"
+	TransactionTypeMarket = "market"
+)
+
+// transactionDateLayouts are the formats Robot has used for the transaction date
+var transactionDateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// CreatedAt parses the transaction date; false when Robot left it out or sent something unparsable
+func (t *Transaction) CreatedAt() (time.Time, bool) {
+	date := strings.TrimSpace(t.Date)
+	if date == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range transactionDateLayouts {
+		if ts, err := time.Parse(layout, date); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
 }
 
 // UnmarshalJSON custom unmarshaling for Transaction to handle product as either string or object
@@ -82,9 +118,13 @@ func (t *Transaction) UnmarshalJSON(data []byte) error {
 		// JSON numbers are unmarshaled as float64
 		t.ProductID = int(v)
 		t.Product = nil
+		t.OrderedProduct = strconv.Itoa(t.ProductID)
 	case int:
 		t.ProductID = v
 		t.Product = nil
+		t.OrderedProduct = strconv.Itoa(v)
+	case string:
+		t.OrderedProduct = v
 	case map[string]interface{}:
 		// Convert back to JSON and unmarshal as Product
 		productJSON, err := json.Marshal(v)
@@ -95,6 +135,13 @@ func (t *Transaction) UnmarshalJSON(data []byte) error {
 				t.ProductID = product.ID
 			}
 		}
+		// Standard products have string ids (e.g. "EX44"), which Product.ID can't hold
+		switch id := v["id"].(type) {
+		case float64:
+			t.OrderedProduct = strconv.Itoa(int(id))
+		case string:
+			t.OrderedProduct = id
+		}
 	case nil:
 		t.Product = nil
 		t.ProductID = 0
@@ -129,34 +176,226 @@ type rescueEnv struct {
 	Rescue Rescue `json:"rescue"`
 }
 
+// ResetOptions are the reset types Robot offers for a server: sw, hw, man, power, power_long.
+// Older auction servers without IPMI lack hw
+type ResetOptions struct {
+	ServerNumber    int      `json:"server_number"`
+	Type            []string `json:"type"`
+	OperatingStatus string   `json:"operating_status"`
+}
+
+type resetOptionsEnv struct {
+	Reset ResetOptions `json:"reset"`
+}
+
+type Cancellation struct {
+	ServerNumber             int    `json:"server_number"`
+	EarliestCancellationDate string `json:"earliest_cancellation_date"`
+	Cancelled                bool   `json:"cancelled"`
+	CancellationDate         string `json:"cancellation_date"`
+}
+type cancellationEnv struct {
+	Cancellation Cancellation `json:"cancellation"`
+}
+
+type SSHKey struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	Type        string `json:"type"`
+	Size        int    `json:"size"`
+	Data        string `json:"data"`
+}
+type sshKeyEnv struct {
+	Key SSHKey `json:"key"`
+}
+
 type VSwitch struct {
-	ID   int    `json:"id"`
-	VLAN int    `json:"vlan"`
-	Name string `json:"name"`
+	ID        int             `json:"id"`
+	VLAN      int             `json:"vlan"`
+	Name      string          `json:"name"`
+	Cancelled bool            `json:"cancelled"`
+	Servers   []VSwitchServer `json:"server"`
+	// Description is nil when Robot doesn't return the field
+	Description *string `json:"description,omitempty"`
+}
+
+// VSwitchServer is a member of a vSwitch as listed by GET /vswitch/{id}
+type VSwitchServer struct {
+	ServerIP     string `json:"server_ip"`
+	ServerNumber int    `json:"server_number"`
+	Status       string `json:"status"` // "ready" | "in process" | "failed"
 }
 
 type vswitchEnv struct {
 	VSwitch VSwitch `json:"vswitch"`
 }
 
+// FirewallRule is one rule of a server firewall or firewall template; empty fields match anything
+type FirewallRule struct {
+	Name      string `json:"name"`
+	IPVersion string `json:"ip_version"` // "ipv4" | "ipv6", empty for both
+	SrcIP     string `json:"src_ip"`
+	DstIP     string `json:"dst_ip"`
+	SrcPort   string `json:"src_port"`
+	DstPort   string `json:"dst_port"`
+	Protocol  string `json:"protocol"`
+	TCPFlags  string `json:"tcp_flags"`
+	Action    string `json:"action"` // "accept" | "discard"
+}
+
+type FirewallRules struct {
+	Input  []FirewallRule `json:"input"`
+	Output []FirewallRule `json:"output"`
+}
+
+// FirewallTemplate is a reusable firewall rule set (GET /firewall/template/{id})
+type FirewallTemplate struct {
+	ID           int           `json:"id"`
+	Name         string        `json:"name"`
+	FilterIPv6   bool          `json:"filter_ipv6"`
+	WhitelistHOS bool          `json:"whitelist_hos"` // allow Hetzner services (DHCP, DNS, ...) regardless of the rules
+	IsDefault    bool          `json:"is_default"`
+	Rules        FirewallRules `json:"rules"`
+}
+
+type firewallTemplateEnv struct {
+	FirewallTemplate FirewallTemplate `json:"firewall_template"`
+}
+
+// Firewall is the firewall of a server (GET /firewall/{server-number})
+type Firewall struct {
+	ServerIP     string        `json:"server_ip"`
+	ServerNumber int           `json:"server_number"`
+	Status       string        `json:"status"` // "active" | "disabled" | "in process"
+	FilterIPv6   bool          `json:"filter_ipv6"`
+	WhitelistHOS bool          `json:"whitelist_hos"`
+	Port         string        `json:"port"` // "main" | "kvm"
+	Rules        FirewallRules `json:"rules"`
+}
+
+// FirewallInProcess is the status of a firewall while Robot applies a change
+const FirewallInProcess = "in process"
+
+type firewallEnv struct {
+	Firewall Firewall `json:"firewall"`
+}
+
 type vswitchListEnv struct {
 	VSwitches []VSwitch `json:"vswitch"`
 }
 
 type Server struct {
-	ServerNumber int    `json:"server_number"`
-	ServerName   string `json:"server_name"`
-	ServerIP     string `json:"server_ip"`
-	Status       string `json:"status"`
-	Product      string `json:"product"`
-	Location     string `json:"location"`
-	// Add other fields as needed based on Hetzner API response
+	ServerNumber     int      `json:"server_number"`
+	ServerName       string   `json:"server_name"`
+	ServerIP         string   `json:"server_ip"`
+	Status           string   `json:"status"`
+	Product          string   `json:"product"`
+	Location         string   `json:"location"`
+	DC               string   `json:"dc"`
+	Traffic          string   `json:"traffic"`
+	Flatrate         bool     `json:"flatrate"`
+	Cancelled        bool     `json:"cancelled"`
+	PaidUntil        string   `json:"paid_until"`
+	IP               []string `json:"ip"`
+	Subnet           []Subnet `json:"subnet"`
+	LinkedStoragebox *int     `json:"linked_storagebox"`
+	Comment          string   `json:"comment"`
+	Locked           bool     `json:"locked"` // set while Robot has locked the server (abuse, unpaid invoices)
+}
+
+// ServerStatusLocked is the status Robot reports instead of ready for a locked server
+const ServerStatusLocked = "locked"
+
+// LockReason reports whether the server is locked, and what Robot says about it. Robot refuses
+// resets and rescue activation for locked servers.
+func (s Server) LockReason() (string, bool) {
+	if !s.Locked && !strings.EqualFold(s.Status, ServerStatusLocked) {
+		return "", false
+	}
+	reason := fmt.Sprintf("Robot reports status %q", s.Status)
+	if s.PaidUntil != "" {
+		reason += fmt.Sprintf(", paid until %s", s.PaidUntil)
+	}
+	return reason, true
+}
+
+type Subnet struct {
+	IP   string `json:"ip"`
+	Mask string `json:"mask"`
+}
+
+type serverEnv struct {
+	Server Server `json:"server"`
+}
+
+// ServerHardware is the hardware Robot reports for a server. Robot only has it for some servers
+// and accounts, either in the server itself or in its hardware subresource
+type ServerHardware struct {
+	CPU      string          `json:"cpu"`
+	MemoryGB int             `json:"memory_gb"`
+	Drives   []HardwareDrive `json:"drives"`
+}
+
+// HardwareDrive is a drive of ServerHardware; unlike the device name, the serial stays the same
+// across reinstalls
+type HardwareDrive struct {
+	Model     string `json:"model"`
+	Serial    string `json:"serial"`
+	SizeBytes int64  `json:"size"`
+}
+
+type serverHardwareEnv struct {
+	Server struct {
+		Hardware *ServerHardware `json:"hardware"`
+	} `json:"server"`
+	Hardware *ServerHardware `json:"hardware"`
 }
 
 type serversResponse struct {
 	Server []Server `json:"server"`
 }
 
+// parseServerList decodes the /server response. Robot returns a top-level array with
+// each entry wrapped in a "server" key, older responses used {"server": [...]}.
+func parseServerList(b []byte) ([]Server, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		var resp serversResponse
+		if err2 := json.Unmarshal(b, &resp); err2 != nil {
+			return nil, err
+		}
+		return normalizeServers(resp.Server), nil
+	}
+
+	servers := make([]Server, 0, len(raw))
+	for _, item := range raw {
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(item, &probe); err != nil {
+			return nil, err
+		}
+		var server Server
+		if wrapped, ok := probe["server"]; ok {
+			if err := json.Unmarshal(wrapped, &server); err != nil {
+				return nil, err
+			}
+		} else if err := json.Unmarshal(item, &server); err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return normalizeServers(servers), nil
+}
+
+// normalizeServers derives the location (e.g. FSN1) from the datacenter (e.g. FSN1-DC14) when Robot omits it
+func normalizeServers(servers []Server) []Server {
+	for i := range servers {
+		if servers[i].Location == "" && servers[i].DC != "" {
+			servers[i].Location = strings.SplitN(servers[i].DC, "-", 2)[0]
+		}
+	}
+	return servers
+}
+
 type apiErr struct {
 	Error struct {
 		Status  int    `json:"status"`
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -13,49 +14,127 @@ import (
 )
 
 type Conn struct {
-	Host                  string
-	User                  string
-	Timeout               time.Duration
+	Host string
+	User string
+	// DialTimeout bounds establishing the connection, including the handshake
+	DialTimeout time.Duration
+	// SessionTimeout bounds each command Run executes on the connection; zero means no limit
+	SessionTimeout        time.Duration
 	Auth                  Auth
 	InsecureIgnoreHostKey bool
 }
 
 type Auth struct {
-	pass     string
-	useAgent bool
+	pass        string
+	useAgent    bool
+	agentSocket string // empty: SSH_AUTH_SOCK
+	privateKey  []byte
 }
 
 func AuthPassword(p string) Auth { return Auth{pass: p} }
-func AuthFromAgent() Auth        { return Auth{useAgent: true} }
 
-type Handle struct{ c *ssh.Client }
+// AuthFromAgent logs in with the keys of the SSH agent listening on sock, or on SSH_AUTH_SOCK
+// when sock is empty
+func AuthFromAgent(sock string) Auth      { return Auth{useAgent: true, agentSocket: sock} }
+func AuthPrivateKey(pemBytes []byte) Auth { return Auth{privateKey: pemBytes} }
 
-func Connect(c Conn) (*Handle, func(), error) {
-	var methods []ssh.AuthMethod
-	if c.Auth.useAgent {
-		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
-			if conn, err := net.Dial("unix", sock); err == nil {
-				methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
-			}
+// agentSocket returns sock, falling back to SSH_AUTH_SOCK
+func agentSocket(sock string) string {
+	if sock != "" {
+		return sock
+	}
+	return os.Getenv("SSH_AUTH_SOCK")
+}
+
+// Method names the single way a connection authenticates: "agent", "private_key" or "password"
+func (a Auth) Method() string {
+	switch {
+	case a.useAgent:
+		return "agent"
+	case len(a.privateKey) > 0:
+		return "private_key"
+	case a.pass != "":
+		return "password"
+	}
+	return "none"
+}
+
+// Fingerprints returns the MD5 fingerprints of the keys this auth logs in with: the agent's keys or
+// the private key's public half. Password auth has none
+func (a Auth) Fingerprints() ([]string, error) {
+	switch a.Method() {
+	case "agent":
+		return AgentFingerprints(a.agentSocket)
+	case "private_key":
+		signer, err := ssh.ParsePrivateKey(a.privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
 		}
+		return []string{ssh.FingerprintLegacyMD5(signer.PublicKey())}, nil
 	}
-	if c.Auth.pass != "" {
-		methods = append(methods, ssh.Password(c.Auth.pass))
+	return nil, nil
+}
+
+// CheckAgent reports why agent auth on sock (empty: SSH_AUTH_SOCK) cannot work, so it can fail
+// before any server is touched
+func CheckAgent(sock string) error {
+	sock = agentSocket(sock)
+	if sock == "" {
+		return fmt.Errorf("SSH_AUTH_SOCK is not set; start ssh-agent and add your key, set ssh_agent_socket, or configure private_key or password auth")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return fmt.Errorf("cannot reach the SSH agent at %s: %w", sock, err)
+	}
+	return conn.Close()
+}
+
+type Handle struct {
+	c              *ssh.Client
+	sessionTimeout time.Duration
+}
+
+// Connect logs in with exactly the method of c.Auth, so a failure names the method that failed
+func Connect(c Conn) (*Handle, func(), error) {
+	var method ssh.AuthMethod
+	switch c.Auth.Method() {
+	case "agent":
+		if err := CheckAgent(c.Auth.agentSocket); err != nil {
+			return nil, nil, fmt.Errorf("ssh agent auth: %w", err)
+		}
+		conn, err := net.Dial("unix", agentSocket(c.Auth.agentSocket))
+		if err != nil {
+			return nil, nil, fmt.Errorf("ssh agent auth: %w", err)
+		}
+		defer conn.Close()
+		method = ssh.PublicKeysCallback(agent.NewClient(conn).Signers)
+	case "private_key":
+		signer, err := ssh.ParsePrivateKey(c.Auth.privateKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ssh private_key auth: %w", err)
+		}
+		method = ssh.PublicKeys(signer)
+	case "password":
+		method = ssh.Password(c.Auth.pass)
+	default:
+		return nil, nil, fmt.Errorf("ssh: no authentication method configured")
 	}
 	cfg := &ssh.ClientConfig{
 		User:            c.User,
-		Auth:            methods,
-		Timeout:         c.Timeout,
+		Auth:            []ssh.AuthMethod{method},
+		Timeout:         c.DialTimeout,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 	client, err := ssh.Dial("tcp", net.JoinHostPort(c.Host, "22"), cfg)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("ssh %s@%s with %s auth: %w", c.User, c.Host, c.Auth.Method(), err)
 	}
-	h := &Handle{c: client}
+	h := &Handle{c: client, sessionTimeout: c.SessionTimeout}
 	return h, func() { _ = client.Close() }, nil
 }
 
+// Run executes cmd in a new session. A command still running after the session timeout of the
+// connection has its session closed and fails
 func Run(h *Handle, cmd string) (string, error) {
 	sess, err := h.c.NewSession()
 	if err != nil {
@@ -65,7 +144,19 @@ func Run(h *Handle, cmd string) (string, error) {
 	var out, errb bytes.Buffer
 	sess.Stdout = &out
 	sess.Stderr = &errb
+	// x/crypto/ssh sessions have no deadline of their own, so closing the session stands in for one
+	var timedOut atomic.Bool
+	if h.sessionTimeout > 0 {
+		timer := time.AfterFunc(h.sessionTimeout, func() {
+			timedOut.Store(true)
+			_ = sess.Close()
+		})
+		defer timer.Stop()
+	}
 	if err := sess.Run(cmd); err != nil {
+		if timedOut.Load() {
+			return out.String(), fmt.Errorf("command did not finish within %s: %w", h.sessionTimeout, err)
+		}
 		if errb.Len() > 0 {
 			return out.String(), fmt.Errorf("%v: %s", err, errb.String())
 		}
@@ -88,3 +179,26 @@ func Upload(h *Handle, dst string, data []byte, _mode uint32) error {
 	_, err = f.Write(data)
 	return err
 }
+
+// AgentFingerprints returns the MD5 fingerprints (aa:bb:..., as Robot shows them) of the keys
+// loaded in the SSH agent on sock (empty: SSH_AUTH_SOCK); none when no agent is running
+func AgentFingerprints(sock string) ([]string, error) {
+	sock = agentSocket(sock)
+	if sock == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, err
+	}
+	fps := make([]string, len(keys))
+	for i, k := range keys {
+		fps[i] = ssh.FingerprintLegacyMD5(k)
+	}
+	return fps, nil
+}
@@ -0,0 +1,104 @@
+// Package tfutil holds helpers shared by the provider's resources
+package tfutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+// ElementsAs converts a list attribute to a slice, appending conversion errors to diags. Null
+// and unknown lists yield nil.
+func ElementsAs[T any](ctx context.Context, diags *diag.Diagnostics, l types.List) []T {
+	if l.IsNull() || l.IsUnknown() {
+		return nil
+	}
+	var out []T
+	diags.Append(l.ElementsAs(ctx, &out, false)...)
+	return out
+}
+
+// ElementsAsStrings converts a list of strings attribute to a []string
+func ElementsAsStrings(ctx context.Context, diags *diag.Diagnostics, l types.List) []string {
+	return ElementsAs[string](ctx, diags, l)
+}
+
+// Backoff bounds of WaitForPort; variables so tests don't have to wait
+var (
+	waitPortMinDelay = 2 * time.Second
+	waitPortMaxDelay = 15 * time.Second
+)
+
+// WaitForPort polls addr (host:port) until it accepts TCP connections. The delay backs off from
+// 2s to 15s with jitter, changes like "connection refused -> accepting" are logged, and the
+// timeout error lists the last distinct failures: refusals mean the host is up but the daemon
+// isn't, unreachable or timeouts mean the host itself is down.
+func WaitForPort(ctx context.Context, addr string, timeout time.Duration) error {
+	state := ""
+	var recent []string
+	dial := func() (error, error) {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		next := "accepting"
+		if err != nil {
+			next = dialErrorKind(err)
+			recent = appendDistinct(recent, err.Error(), 3)
+		} else {
+			_ = conn.Close()
+		}
+		if next != state {
+			if state != "" {
+				tflog.Info(ctx, fmt.Sprintf("%s: %s -> %s", addr, state, next), map[string]interface{}{"addr": addr, "from": state, "to": next})
+			}
+			state = next
+		}
+		// The dial error is the polled value, not a failure of the poll itself
+		return err, nil
+	}
+
+	opts := client.PollOptions{Interval: waitPortMinDelay, MaxInterval: waitPortMaxDelay, MaxElapsed: timeout, Jitter: 0.5}
+	_, err := client.Poll(ctx, opts, dial, func(err error) bool { return err == nil })
+	if errors.Is(err, client.ErrPollTimeout) {
+		return fmt.Errorf("timeout after %s waiting for %s (%s); last errors: %s", timeout, addr, state, strings.Join(recent, "; "))
+	}
+	return err
+}
+
+// dialErrorKind classifies a failed dial for logs and timeout messages
+func dialErrorKind(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// appendDistinct appends s, dropping an earlier copy of it, and keeps the last max entries
+func appendDistinct(list []string, s string, max int) []string {
+	out := make([]string, 0, len(list)+1)
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	out = append(out, s)
+	if len(out) > max {
+		out = out[len(out)-max:]
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package tfutil
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestElementsAsStrings(t *testing.T) {
+	ctx := context.Background()
+
+	var diags diag.Diagnostics
+	if got := ElementsAsStrings(ctx, &diags, types.ListNull(types.StringType)); got != nil || diags.HasError() {
+		t.Fatalf("null list: got %v, %v", got, diags)
+	}
+	if got := ElementsAsStrings(ctx, &diags, types.ListUnknown(types.StringType)); got != nil || diags.HasError() {
+		t.Fatalf("unknown list: got %v, %v", got, diags)
+	}
+
+	l, _ := types.ListValueFrom(ctx, types.StringType, []string{"a", "b"})
+	if got := ElementsAsStrings(ctx, &diags, l); strings.Join(got, ",") != "a,b" || diags.HasError() {
+		t.Fatalf("got %v, %v", got, diags)
+	}
+
+	// Malformed lists must surface as diagnostics rather than be dropped
+	ints, _ := types.ListValueFrom(ctx, types.Int64Type, []int64{1})
+	ElementsAsStrings(ctx, &diags, ints)
+	if !diags.HasError() {
+		t.Fatal("converting a list of numbers did not report an error")
+	}
+
+	diags = nil
+	withNull, _ := types.ListValue(types.StringType, []attr.Value{types.StringValue("a"), types.StringNull()})
+	ElementsAsStrings(ctx, &diags, withNull)
+	if !diags.HasError() {
+		t.Fatal("converting a list with a null element did not report an error")
+	}
+
+	// Existing diagnostics are kept
+	diags = diag.Diagnostics{diag.NewWarningDiagnostic("earlier", "")}
+	ElementsAs[int64](ctx, &diags, ints)
+	if len(diags) != 1 || diags.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+}
+
+func TestWaitForPort(t *testing.T) {
+	minDelay, maxDelay := waitPortMinDelay, waitPortMaxDelay
+	waitPortMinDelay, waitPortMaxDelay = 10*time.Millisecond, 40*time.Millisecond
+	t.Cleanup(func() { waitPortMinDelay, waitPortMaxDelay = minDelay, maxDelay })
+	ctx := context.Background()
+
+	// A closed port on localhost refuses connections: the host is up, the daemon isn't
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	err = WaitForPort(ctx, addr, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected a timeout on a closed port")
+	}
+	if !strings.Contains(err.Error(), "(connection refused)") || !strings.Contains(err.Error(), "last errors: ") {
+		t.Fatalf("timeout does not explain the failures: %v", err)
+	}
+	if n := strings.Count(err.Error(), "connection refused"); n != 2 {
+		t.Fatalf("repeated errors are not deduplicated: %v", err)
+	}
+
+	// The port starts accepting while waiting
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if ln, err := net.Listen("tcp", addr); err == nil {
+			t.Cleanup(func() { ln.Close() })
+		}
+	}()
+	if err := WaitForPort(ctx, addr, 5*time.Second); err != nil {
+		t.Fatalf("port never accepted: %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := WaitForPort(canceled, "127.0.0.1:1", time.Minute); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestAppendDistinct(t *testing.T) {
+	var list []string
+	for _, s := range []string{"a", "b", "a", "c", "d"} {
+		list = appendDistinct(list, s, 3)
+	}
+	if strings.Join(list, ",") != "a,c,d" {
+		t.Fatalf("got %v", list)
+	}
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	ansibleStageBeforeK3S = "before_k3s"
+	ansibleStageAfterK3S  = "after_k3s"
+
+	defaultAnsiblePlaybook = "local.yml"
+
+	// ansibleVarsPath holds ansible_extra_vars on the server while ansible-pull runs, so the
+	// values never appear on a command line
+	ansibleVarsPath = "/root/.hrobot-ansible-vars.json"
+	ansibleLogPath  = "/var/log/hrobot-ansible.log"
+
+	// ansibleLogTail is how many lines of the ansible-pull output a failed apply reports
+	ansibleLogTail = 50
+)
+
+// ansibleRepo limits ansible_repo to git URLs that are safe to put in the script
+var ansibleRepo = regexp.MustCompile(`^((https?|ssh|git)://[A-Za-z0-9._~:@%/+-]+|[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[A-Za-z0-9._~/+-]+)$`)
+
+// ansiblePlaybook is a playbook path relative to the checkout
+var ansiblePlaybook = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._/-]*$`)
+
+// ansibleVarName is what ansible accepts as a variable name
+var ansibleVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// AnsibleConfig holds the settings runAnsible runs ansible-pull with, with defaults applied
+type AnsibleConfig struct {
+	Repo      string
+	Playbook  string
+	ExtraVars map[string]string
+	Stage     string // before_k3s or after_k3s
+}
+
+// ansibleConfig returns the ansible-pull settings; nil skips ansible
+func ansibleConfig(ctx context.Context, m configurationModel) *AnsibleConfig {
+	if m.AnsibleRepo.IsNull() || m.AnsibleRepo.IsUnknown() || m.AnsibleRepo.ValueString() == "" {
+		return nil
+	}
+	cfg := &AnsibleConfig{
+		Repo:     m.AnsibleRepo.ValueString(),
+		Playbook: defaultAnsiblePlaybook,
+		Stage:    ansibleStageAfterK3S,
+	}
+	if !m.AnsiblePlaybook.IsNull() && m.AnsiblePlaybook.ValueString() != "" {
+		cfg.Playbook = m.AnsiblePlaybook.ValueString()
+	}
+	if !m.AnsibleRunStage.IsNull() && m.AnsibleRunStage.ValueString() != "" {
+		cfg.Stage = m.AnsibleRunStage.ValueString()
+	}
+	if !m.AnsibleExtraVars.IsNull() && !m.AnsibleExtraVars.IsUnknown() {
+		m.AnsibleExtraVars.ElementsAs(ctx, &cfg.ExtraVars, false)
+	}
+	return cfg
+}
+
+// buildAnsibleScript installs ansible if needed and runs ansible-pull with the vars file; on
+// failure it prints the end of the log and exits non-zero. The vars file is removed either way
+func buildAnsibleScript(cfg AnsibleConfig) string {
+	var script strings.Builder
+	script.WriteString("#!/bin/bash\n")
+	fmt.Fprintf(&script, "trap 'rm -f %s' EXIT\n", ansibleVarsPath)
+	script.WriteString("if ! command -v ansible-pull >/dev/null 2>&1; then\n")
+	script.WriteString("    echo 'Installing ansible...'\n")
+	script.WriteString("    apt-get update -qq >/dev/null && DEBIAN_FRONTEND=noninteractive apt-get install -y -qq ansible git >/dev/null || { echo 'Error: failed to install ansible'; exit 1; }\n")
+	script.WriteString("fi\n")
+	fmt.Fprintf(&script, "echo 'Running ansible-pull %s from %s...'\n", cfg.Playbook, cfg.Repo)
+	fmt.Fprintf(&script, "if ! ansible-pull -U '%s' -e '@%s' '%s' >%s 2>&1; then\n", cfg.Repo, ansibleVarsPath, cfg.Playbook, ansibleLogPath)
+	fmt.Fprintf(&script, "    echo 'Error: ansible-pull failed, last %d lines of %s:'\n", ansibleLogTail, ansibleLogPath)
+	fmt.Fprintf(&script, "    tail -n %d %s\n", ansibleLogTail, ansibleLogPath)
+	script.WriteString("    exit 1\n")
+	script.WriteString("fi\n")
+	script.WriteString("echo '✓ ansible-pull completed'\n")
+	return script.String()
+}
+
+// runAnsible writes ansible_extra_vars to a root-only file and runs ansible-pull over SSH
+func runAnsible(ctx context.Context, run func(cmd string) (string, error), upload func(dst string, data []byte) error, cfg AnsibleConfig) (string, string) {
+	vars := cfg.ExtraVars
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return "encode ansible vars", err.Error()
+	}
+
+	// Create the file with mode 0600 first: the upload keeps the mode of an existing file
+	if out, err := run(fmt.Sprintf("install -m 0600 /dev/null %s", ansibleVarsPath)); err != nil {
+		return "create ansible vars file", fmt.Sprintf("%v\n%s", err, out)
+	}
+	if err := upload(ansibleVarsPath, data); err != nil {
+		_, _ = run(fmt.Sprintf("rm -f %s", ansibleVarsPath))
+		return "upload ansible vars", err.Error()
+	}
+
+	tflog.Info(ctx, "running ansible-pull", map[string]interface{}{
+		"repo":     cfg.Repo,
+		"playbook": cfg.Playbook,
+		"stage":    cfg.Stage,
+	})
+	if out, err := run(buildAnsibleScript(cfg)); err != nil {
+		return "ansible-pull failed", fmt.Sprintf("%v\n%s", err, out)
+	}
+	return "", ""
+}
+
+// validateAnsible checks the ansible attributes and that they are only set with ansible_repo
+func validateAnsible(ctx context.Context, diags *diag.Diagnostics, config configurationModel) {
+	if r := config.AnsibleRepo; !r.IsNull() && !r.IsUnknown() && !ansibleRepo.MatchString(r.ValueString()) {
+		diags.AddAttributeError(path.Root("ansible_repo"), "Invalid ansible_repo",
+			fmt.Sprintf("ansible_repo must be a git URL like https://github.com/org/repo.git or git@github.com:org/repo.git, got %q.", r.ValueString()))
+	}
+	if p := config.AnsiblePlaybook; !p.IsNull() && !p.IsUnknown() && (!ansiblePlaybook.MatchString(p.ValueString()) || strings.Contains(p.ValueString(), "..")) {
+		diags.AddAttributeError(path.Root("ansible_playbook"), "Invalid ansible_playbook",
+			fmt.Sprintf("ansible_playbook must be a path relative to the repository of letters, digits and . _ - / only, got %q.", p.ValueString()))
+	}
+	if s := config.AnsibleRunStage; !s.IsNull() && !s.IsUnknown() && s.ValueString() != ansibleStageBeforeK3S && s.ValueString() != ansibleStageAfterK3S {
+		diags.AddAttributeError(path.Root("ansible_run_stage"), "Invalid ansible_run_stage",
+			fmt.Sprintf("ansible_run_stage must be %q or %q, got %q.", ansibleStageBeforeK3S, ansibleStageAfterK3S, s.ValueString()))
+	}
+	if v := config.AnsibleExtraVars; !v.IsNull() && !v.IsUnknown() {
+		var vars map[string]types.String
+		v.ElementsAs(ctx, &vars, false)
+		for name := range vars {
+			if !ansibleVarName.MatchString(name) {
+				diags.AddAttributeError(path.Root("ansible_extra_vars"), "Invalid ansible_extra_vars",
+					fmt.Sprintf("ansible_extra_vars keys must be valid variable names (letters, digits and _, not starting with a digit), got %q.", name))
+			}
+		}
+	}
+
+	if !config.AnsibleRepo.IsNull() {
+		return
+	}
+	for _, a := range []struct {
+		name  string
+		value interface{ IsNull() bool }
+	}{
+		{"ansible_playbook", config.AnsiblePlaybook},
+		{"ansible_extra_vars", config.AnsibleExtraVars},
+		{"ansible_run_stage", config.AnsibleRunStage},
+	} {
+		if !a.value.IsNull() {
+			diags.AddAttributeError(path.Root(a.name), "Missing ansible_repo",
+				fmt.Sprintf("%s has no effect without ansible_repo.", a.name))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestAnsible(t *testing.T) {
+	ctx := context.Background()
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	validate := func(set map[string]tftypes.Value) diag.Diagnostics {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["server_number"] = tftypes.NewValue(tftypes.Number, 111)
+		vals["server_ip"] = tftypes.NewValue(tftypes.String, "1.2.3.4")
+		vals["name"] = tftypes.NewValue(tftypes.String, "web")
+		vals["install_mode"] = tftypes.NewValue(tftypes.String, installModeConfigureOnly)
+		for k, v := range set {
+			vals[k] = v
+		}
+		req := resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}
+		var resp resource.ValidateConfigResponse
+		res.ValidateConfig(ctx, req, &resp)
+		return resp.Diagnostics
+	}
+	str := func(s string) tftypes.Value { return tftypes.NewValue(tftypes.String, s) }
+	varsType := tftypes.Map{ElementType: tftypes.String}
+
+	if diags := validate(map[string]tftypes.Value{
+		"ansible_repo":       str("git@github.com:org/infra.git"),
+		"ansible_playbook":   str("playbooks/node.yml"),
+		"ansible_run_stage":  str("before_k3s"),
+		"ansible_extra_vars": tftypes.NewValue(varsType, map[string]tftypes.Value{"db_password": str("s3cr'et")}),
+	}); diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+	for name, set := range map[string]map[string]tftypes.Value{
+		"Invalid ansible_repo":       {"ansible_repo": str("https://example.com/x.git; rm -rf /")},
+		"Invalid ansible_playbook":   {"ansible_repo": str("https://example.com/x.git"), "ansible_playbook": str("../site.yml")},
+		"Invalid ansible_run_stage":  {"ansible_repo": str("https://example.com/x.git"), "ansible_run_stage": str("first")},
+		"Invalid ansible_extra_vars": {"ansible_repo": str("https://example.com/x.git"), "ansible_extra_vars": tftypes.NewValue(varsType, map[string]tftypes.Value{"1x": str("v")})},
+		"Missing ansible_repo":       {"ansible_playbook": str("site.yml")},
+	} {
+		if diags := validate(set); !diags.HasError() || diags.Errors()[0].Summary() != name {
+			t.Fatalf("expected %q, got %v", name, diags)
+		}
+	}
+
+	// Secrets go to the vars file, never into the script
+	cfg := AnsibleConfig{Repo: "https://example.com/x.git", Playbook: "site.yml", ExtraVars: map[string]string{"token": "hunter2"}, Stage: ansibleStageAfterK3S}
+	var ran []string
+	var uploaded []byte
+	run := func(cmd string) (string, error) {
+		ran = append(ran, cmd)
+		if strings.Contains(cmd, "ansible-pull -U") {
+			return "Error: ansible-pull failed\nTASK [fail] failed", errors.New("exit status 1")
+		}
+		return "", nil
+	}
+	upload := func(dst string, data []byte) error {
+		if dst != ansibleVarsPath {
+			t.Fatalf("unexpected upload to %s", dst)
+		}
+		uploaded = data
+		return nil
+	}
+	summary, detail := runAnsible(ctx, run, upload, cfg)
+	if summary != "ansible-pull failed" || !strings.Contains(detail, "TASK [fail] failed") {
+		t.Fatalf("expected the output tail in the failure, got %q: %q", summary, detail)
+	}
+	if string(uploaded) != `{"token":"hunter2"}` {
+		t.Fatalf("unexpected vars file: %s", uploaded)
+	}
+	if len(ran) != 2 || !strings.HasPrefix(ran[0], "install -m 0600 /dev/null ") {
+		t.Fatalf("vars file must be created root-only before the upload, ran %q", ran)
+	}
+	if strings.Contains(ran[1], "hunter2") || !strings.Contains(ran[1], "-e '@"+ansibleVarsPath+"'") || !strings.Contains(ran[1], "trap 'rm -f "+ansibleVarsPath+"' EXIT") {
+		t.Fatalf("unexpected script:\n%s", ran[1])
+	}
+
+	if ansibleConfig(ctx, configurationModel{AnsibleRepo: types.StringNull()}) != nil {
+		t.Fatalf("expected no ansible without ansible_repo")
+	}
+	got := ansibleConfig(ctx, configurationModel{AnsibleRepo: types.StringValue("https://example.com/x.git"), AnsibleExtraVars: types.MapNull(types.StringType)})
+	if got == nil || got.Playbook != defaultAnsiblePlaybook || got.Stage != ansibleStageAfterK3S {
+		t.Fatalf("unexpected defaults: %+v", got)
+	}
+}
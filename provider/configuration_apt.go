@@ -0,0 +1,29 @@
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// aptProxyFile is where the first-run script writes the apt proxy settings
+const aptProxyFile = "/etc/apt/apt.conf.d/01proxy"
+
+// validateAPTProxies checks apt_proxy and apt_https_proxy: http(s) URLs with a host, and nothing
+// that would end the quoted value in the apt configuration or the heredoc writing it
+func validateAPTProxies(diags *diag.Diagnostics, config configurationModel) {
+	for name, v := range map[string]types.String{"apt_proxy": config.APTProxy, "apt_https_proxy": config.APTHTTPSProxy} {
+		if v.IsNull() || v.IsUnknown() {
+			continue
+		}
+		u, err := url.Parse(v.ValueString())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(v.ValueString(), " \t\r\n\"'\\;") {
+			diags.AddAttributeError(path.Root(name), "Invalid "+name,
+				fmt.Sprintf("%s must be an http:// or https:// URL of the proxy without whitespace or quotes, e.g. http://apt-cache.internal:3142; got %q.", name, v.ValueString()))
+		}
+	}
+}
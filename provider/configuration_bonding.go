@@ -0,0 +1,185 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+const defaultBondMode = "active-backup"
+
+// bondModes are the netplan bond modes accepted by network_bonding
+var bondModes = []string{"active-backup", "802.3ad", "balance-rr", "balance-xor", "balance-tlb", "balance-alb", "broadcast"}
+
+// ifaceName matches a Linux interface name; it is also what keeps the names safe to put in the script
+var ifaceName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,15}$`)
+
+type networkBondingModel struct {
+	Mode             types.String `tfsdk:"mode"`
+	PrimaryInterface types.String `tfsdk:"primary_interface"`
+	Interfaces       types.List   `tfsdk:"interfaces"`
+}
+
+// BondTemplateData describes the public bond in the first-run script
+type BondTemplateData struct {
+	Mode       string
+	Primary    string // empty: the interface holding the public IP (active-backup, balance-tlb, balance-alb)
+	Interfaces string // space-separated, empty to auto-detect the cabled NICs
+}
+
+func networkBondingAttribute() rschema.SingleNestedAttribute {
+	return rschema.SingleNestedAttribute{
+		Optional:    true,
+		Description: "Bond the public NICs into bond0 on first run and put the VLAN interfaces on top of it. Skipped with a warning when fewer than two NICs are cabled",
+		Attributes: map[string]rschema.Attribute{
+			"mode": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Bond mode: " + strings.Join(bondModes, ", ") + " (default: active-backup)",
+			},
+			"primary_interface": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Preferred NIC for active-backup, balance-tlb and balance-alb (default: the NIC holding the public IP)",
+			},
+			"interfaces": rschema.ListAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "NICs to bond (at least two); auto-detected from the NICs with a link when unset",
+			},
+		},
+	}
+}
+
+func networkBonding(ctx context.Context, m configurationModel) *networkBondingModel {
+	if m.NetworkBonding.IsNull() || m.NetworkBonding.IsUnknown() {
+		return nil
+	}
+	var b networkBondingModel
+	m.NetworkBonding.As(ctx, &b, basetypes.ObjectAsOptions{})
+	return &b
+}
+
+// bondTemplateData returns the bond settings for the first-run script, nil without network_bonding
+func bondTemplateData(ctx context.Context, m configurationModel) *BondTemplateData {
+	b := networkBonding(ctx, m)
+	if b == nil {
+		return nil
+	}
+	data := &BondTemplateData{Mode: defaultBondMode, Primary: b.PrimaryInterface.ValueString()}
+	if !b.Mode.IsNull() && b.Mode.ValueString() != "" {
+		data.Mode = b.Mode.ValueString()
+	}
+	if !b.Interfaces.IsNull() && !b.Interfaces.IsUnknown() {
+		var ifaces []string
+		b.Interfaces.ElementsAs(ctx, &ifaces, false)
+		data.Interfaces = strings.Join(ifaces, " ")
+	}
+	return data
+}
+
+// bondModeHasPrimary reports whether the bond mode uses a primary interface
+func bondModeHasPrimary(mode string) bool {
+	return mode == "active-backup" || mode == "balance-tlb" || mode == "balance-alb"
+}
+
+// validateNetworkBonding checks network_bonding without connecting to the server
+func validateNetworkBonding(ctx context.Context, diags *diag.Diagnostics, config configurationModel) {
+	b := networkBonding(ctx, config)
+	if b == nil {
+		return
+	}
+	p := path.Root("network_bonding")
+
+	mode := defaultBondMode
+	if !b.Mode.IsNull() && !b.Mode.IsUnknown() {
+		mode = b.Mode.ValueString()
+		valid := false
+		for _, m := range bondModes {
+			valid = valid || m == mode
+		}
+		if !valid {
+			diags.AddAttributeError(p.AtName("mode"), "Invalid bond mode",
+				fmt.Sprintf("mode must be one of %s, got %q.", strings.Join(bondModes, ", "), mode))
+		}
+	}
+
+	var ifaces []string
+	if !b.Interfaces.IsNull() && !b.Interfaces.IsUnknown() {
+		b.Interfaces.ElementsAs(ctx, &ifaces, false)
+		if len(ifaces) < 2 {
+			diags.AddAttributeError(p.AtName("interfaces"), "Too few interfaces",
+				"A bond needs at least two interfaces; leave interfaces unset to auto-detect them.")
+		}
+		seen := map[string]bool{}
+		for i, iface := range ifaces {
+			if !ifaceName.MatchString(iface) {
+				diags.AddAttributeError(p.AtName("interfaces").AtListIndex(i), "Invalid interface name",
+					fmt.Sprintf("%q is not a valid network interface name.", iface))
+			}
+			if seen[iface] {
+				diags.AddAttributeError(p.AtName("interfaces").AtListIndex(i), "Duplicate interface",
+					fmt.Sprintf("%q is listed more than once.", iface))
+			}
+			seen[iface] = true
+		}
+	}
+
+	if !b.PrimaryInterface.IsNull() && !b.PrimaryInterface.IsUnknown() {
+		primary := b.PrimaryInterface.ValueString()
+		switch {
+		case !ifaceName.MatchString(primary):
+			diags.AddAttributeError(p.AtName("primary_interface"), "Invalid interface name",
+				fmt.Sprintf("%q is not a valid network interface name.", primary))
+		case !bondModeHasPrimary(mode):
+			diags.AddAttributeError(p.AtName("primary_interface"), "Unused primary_interface",
+				fmt.Sprintf("primary_interface only applies to active-backup, balance-tlb and balance-alb, not %s.", mode))
+		case len(ifaces) > 0 && !containsString(ifaces, primary):
+			diags.AddAttributeError(p.AtName("primary_interface"), "Unknown primary_interface",
+				fmt.Sprintf("%q is not one of the bonded interfaces.", primary))
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// networkInterfaceLine is printed by the first-run script with the interface the VLANs hang off
+var networkInterfaceLine = regexp.MustCompile(`(?m)^HROBOT_NETWORK_INTERFACE=(\S+)$`)
+
+// validateNetworkInterface checks network_interface is an interface name, which also keeps it
+// safe to put in the scripts, and that no bond replaces it
+func validateNetworkInterface(diags *diag.Diagnostics, config configurationModel) {
+	if config.NetworkInterface.IsNull() || config.NetworkInterface.IsUnknown() {
+		return
+	}
+	if iface := config.NetworkInterface.ValueString(); !ifaceName.MatchString(iface) {
+		diags.AddAttributeError(path.Root("network_interface"), "Invalid interface name",
+			fmt.Sprintf("network_interface must be a Linux interface name of up to 15 letters, digits, '.', '_' or '-', got %q.", iface))
+	}
+	if !config.NetworkBonding.IsNull() {
+		diags.AddAttributeError(path.Root("network_interface"), "Conflicting network settings",
+			"network_interface cannot be combined with network_bonding, which attaches the VLAN interfaces to bond0.")
+	}
+}
+
+// usedNetworkInterface returns the interface the first-run script attached the VLANs to, from its
+// log; empty when it configured no private network
+func usedNetworkInterface(log string) string {
+	matches := networkInterfaceLine.FindAllStringSubmatch(log, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[len(matches)-1][1]
+}
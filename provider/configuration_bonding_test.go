@@ -0,0 +1,225 @@
+package provider
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestNetworkBondingInFirstRunScript(t *testing.T) {
+	ctx := context.Background()
+	bondingType := map[string]attr.Type{
+		"mode":              types.StringType,
+		"primary_interface": types.StringType,
+		"interfaces":        types.ListType{ElemType: types.StringType},
+	}
+	render := func(t *testing.T, bond *BondTemplateData) string {
+		t.Helper()
+		out, err := renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{LocalIP: "10.1.0.5", ParentMTU: defaultParentMTU, Bond: bond})
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		if bash, err := exec.LookPath("bash"); err == nil {
+			cmd := exec.Command(bash, "-n")
+			cmd.Stdin = strings.NewReader(out)
+			if msg, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("rendered script is not valid bash: %v\n%s", err, msg)
+			}
+		}
+		return out
+	}
+
+	t.Run("no bonding", func(t *testing.T) {
+		if bondTemplateData(ctx, configurationModel{NetworkBonding: types.ObjectNull(bondingType)}) != nil {
+			t.Fatalf("expected no bond without network_bonding")
+		}
+		out := render(t, nil)
+		if strings.Contains(out, "bond0") {
+			t.Fatalf("script configures a bond without network_bonding")
+		}
+		if !strings.Contains(out, "  ${PARENT_KIND}:\n    ${DEFAULT_IFACE}:\n      mtu: 1500\n") || !strings.Contains(out, `PARENT_KIND="ethernets"`) {
+			t.Fatalf("expected the VLANs on the default interface")
+		}
+	})
+
+	t.Run("dual NIC", func(t *testing.T) {
+		ifaces, _ := types.ListValueFrom(ctx, types.StringType, []string{"enp1s0", "enp2s0"})
+		obj, diags := types.ObjectValueFrom(ctx, bondingType, networkBondingModel{
+			Mode:             types.StringNull(),
+			PrimaryInterface: types.StringValue("enp2s0"),
+			Interfaces:       ifaces,
+		})
+		if diags.HasError() {
+			t.Fatalf("build object: %v", diags)
+		}
+		m := configurationModel{NetworkBonding: obj}
+		validateNetworkBonding(ctx, &diags, m)
+		if diags.HasError() {
+			t.Fatalf("unexpected validation errors: %v", diags)
+		}
+
+		out := render(t, bondTemplateData(ctx, m))
+		for _, want := range []string{
+			`BOND_IFACES="enp1s0 enp2s0"`,
+			`BOND_PRIMARY="enp2s0"`,
+			`echo "        mode: active-backup"`,
+			`DEFAULT_IFACE="bond0"`,
+			`PARENT_KIND="bonds"`,
+			"skipping bonding and using $DEFAULT_IFACE",
+		} {
+			if !strings.Contains(out, want) {
+				t.Fatalf("first-run script missing %q", want)
+			}
+		}
+		if strings.Contains(out, "lacp-rate") {
+			t.Fatalf("LACP parameters set for active-backup")
+		}
+		// The VLANs hang off whatever DEFAULT_IFACE ends up being, which is bond0 once bonded
+		if !strings.Contains(out, "    ${DEFAULT_IFACE}.4001:\n      id: 4001\n      link: ${DEFAULT_IFACE}\n") {
+			t.Fatalf("private VLAN is not linked to the parent interface")
+		}
+	})
+
+	t.Run("single NIC auto-detect", func(t *testing.T) {
+		obj, diags := types.ObjectValueFrom(ctx, bondingType, networkBondingModel{
+			Mode:             types.StringValue("802.3ad"),
+			PrimaryInterface: types.StringNull(),
+			Interfaces:       types.ListNull(types.StringType),
+		})
+		if diags.HasError() {
+			t.Fatalf("build object: %v", diags)
+		}
+		out := render(t, bondTemplateData(ctx, configurationModel{NetworkBonding: obj}))
+		for _, want := range []string{
+			`BOND_IFACES=""`,
+			"/sys/class/net/$nic/carrier",
+			`if [ $# -lt 2 ]; then`,
+			"skipping bonding and using $DEFAULT_IFACE",
+			`echo "        lacp-rate: fast"`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Fatalf("first-run script missing %q", want)
+			}
+		}
+		if strings.Contains(out, `BOND_PRIMARY="$DEFAULT_IFACE"`) {
+			t.Fatalf("802.3ad must not default a primary interface")
+		}
+	})
+}
+
+func TestValidateNetworkBonding(t *testing.T) {
+	ctx := context.Background()
+	bondingType := map[string]attr.Type{
+		"mode":              types.StringType,
+		"primary_interface": types.StringType,
+		"interfaces":        types.ListType{ElemType: types.StringType},
+	}
+	tests := []struct {
+		name    string
+		mode    types.String
+		primary types.String
+		ifaces  []string
+		wantErr string
+	}{
+		{name: "unknown mode", mode: types.StringValue("lacp"), wantErr: "Invalid bond mode"},
+		{name: "one interface", ifaces: []string{"eno1"}, wantErr: "Too few interfaces"},
+		{name: "shell injection", ifaces: []string{"eno1", "eno2; reboot"}, wantErr: "Invalid interface name"},
+		{name: "duplicate", ifaces: []string{"eno1", "eno1"}, wantErr: "Duplicate interface"},
+		{name: "primary not bonded", primary: types.StringValue("eno3"), ifaces: []string{"eno1", "eno2"}, wantErr: "Unknown primary_interface"},
+		{name: "primary with lacp", mode: types.StringValue("802.3ad"), primary: types.StringValue("eno1"), wantErr: "Unused primary_interface"},
+		{name: "auto-detect", mode: types.StringValue("balance-alb"), primary: types.StringValue("eno1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Zero types.String values are null, like unset attributes
+			ifaces := types.ListNull(types.StringType)
+			if tt.ifaces != nil {
+				ifaces, _ = types.ListValueFrom(ctx, types.StringType, tt.ifaces)
+			}
+			obj, diags := types.ObjectValueFrom(ctx, bondingType, networkBondingModel{Mode: tt.mode, PrimaryInterface: tt.primary, Interfaces: ifaces})
+			validateNetworkBonding(ctx, &diags, configurationModel{NetworkBonding: obj})
+			if tt.wantErr == "" {
+				if diags.HasError() {
+					t.Fatalf("unexpected errors: %v", diags)
+				}
+				return
+			}
+			found := false
+			for _, d := range diags.Errors() {
+				found = found || d.Summary() == tt.wantErr
+			}
+			if !found {
+				t.Fatalf("expected %q, got %v", tt.wantErr, diags)
+			}
+		})
+	}
+}
+
+func TestNetworkInterface(t *testing.T) {
+	ctx := context.Background()
+	bondNull := types.ObjectNull(map[string]attr.Type{})
+	for iface, valid := range map[string]bool{
+		"enp9s0":           true,
+		"eno1-2":           true,
+		"eth0.100":         true,
+		"eth0;reboot":      false,
+		"$(reboot)":        false,
+		"":                 false,
+		"enp1s0f0np0-long": false,
+	} {
+		var diags diag.Diagnostics
+		validateNetworkInterface(&diags, configurationModel{NetworkInterface: types.StringValue(iface), NetworkBonding: bondNull})
+		if diags.HasError() == valid {
+			t.Errorf("network_interface %q: valid = %v, diags %v", iface, !diags.HasError(), diags)
+		}
+	}
+	var diags diag.Diagnostics
+	validateNetworkInterface(&diags, configurationModel{NetworkInterface: types.StringValue("enp9s0"), NetworkBonding: types.ObjectValueMust(map[string]attr.Type{}, map[string]attr.Value{})})
+	if !diags.HasError() {
+		t.Error("expected network_interface to conflict with network_bonding")
+	}
+
+	render := func(iface string) string {
+		t.Helper()
+		out, err := renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{LocalIP: "10.1.0.5", ParentMTU: defaultParentMTU, NetworkInterface: iface})
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		if bash, err := exec.LookPath("bash"); err == nil {
+			cmd := exec.Command(bash, "-n")
+			cmd.Stdin = strings.NewReader(out)
+			if msg, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("rendered script is not valid bash: %v\n%s", err, msg)
+			}
+		}
+		return out
+	}
+	out := render("enp9s0")
+	if !strings.Contains(out, `DEFAULT_IFACE="enp9s0"`) || strings.Contains(out, "ip route | grep default") {
+		t.Error("expected network_interface to replace the default route detection")
+	}
+	if out := render(""); !strings.Contains(out, "ip route | grep default") {
+		t.Error("expected the default route detection without network_interface")
+	}
+	if !strings.Contains(out, `echo "HROBOT_NETWORK_INTERFACE=$DEFAULT_IFACE"`) {
+		t.Error("expected the script to report the interface it used")
+	}
+
+	if got := usedNetworkInterface("Using default interface: eno1\nHROBOT_NETWORK_INTERFACE=eno1\nHROBOT_OUTPUT_X=1\n"); got != "eno1" {
+		t.Errorf("usedNetworkInterface = %q", got)
+	}
+	if got := usedNetworkInterface("no private network\n"); got != "" {
+		t.Errorf("usedNetworkInterface without the line = %q", got)
+	}
+
+	// The Flannel VLAN interface of K3S follows network_interface too
+	script := buildK3SScript(ctx, K3SConfig{Role: k3sRoleAgent, Token: "t", URL: "https://10.0.0.2:6443", NetworkInterface: "enp9s0"}, "10.1.0.5", "1.2.3.4")
+	if !strings.Contains(script, "DEFAULT_IFACE=\"enp9s0\"\n") || strings.Contains(script, "ip route | grep default") {
+		t.Errorf("K3S script does not use network_interface:\n%s", script)
+	}
+}
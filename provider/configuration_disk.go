@@ -0,0 +1,270 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+const (
+	defaultFilesystem = "ext4"
+	defaultRaidLevel  = 1
+	defaultBootSizeMB = 1024
+	defaultEFISizeMB  = 512
+
+	defaultImageBasePath = "/root/images"
+)
+
+var (
+	// archs are the architectures the Ubuntu images are published for
+	archs = []string{"amd64", "arm64"}
+	// filesystems are the root filesystems accepted by disk_config
+	filesystems = []string{"ext4", "xfs", "btrfs"}
+	// raidLevels are the levels installimage supports with the two disks the autosetup uses
+	raidLevels = []int64{0, 1}
+)
+
+// diskConfigMigration is appended to the descriptions of the deprecated top-level disk attributes
+const diskConfigMigration = "Deprecated: move it into disk_config (arch, raid_level, filesystem_type -> filesystem, no_uefi); moving arch with the same value does not replace the server"
+
+type diskConfigModel struct {
+	Arch       types.String `tfsdk:"arch"`
+	RaidLevel  types.Int64  `tfsdk:"raid_level"`
+	Filesystem types.String `tfsdk:"filesystem"`
+	NoUEFI     types.Bool   `tfsdk:"no_uefi"`
+	SwapSizeMB types.Int64  `tfsdk:"swap_size_mb"`
+	BootSizeMB types.Int64  `tfsdk:"boot_size_mb"`
+	EFISizeMB  types.Int64  `tfsdk:"efi_size_mb"`
+}
+
+// DiskLayout is the partitioning of the generated autosetup, with defaults applied
+type DiskLayout struct {
+	Arch       string
+	RaidLevel  int64 // only used with two disks
+	Filesystem string
+	NoUEFI     bool
+	SwapSizeMB int64 // 0: no swap partition
+	BootSizeMB int64
+	EFISizeMB  int64 // ignored with NoUEFI
+}
+
+// AutosetupImage is where installimage takes the OS image from
+type AutosetupImage struct {
+	BasePath     string // directory or http(s) URL holding the image tarballs
+	ChecksumType string // md5, sha1, sha256 or sha512; empty without image_checksum
+	Checksum     string
+}
+
+// imageChecksumLengths are the hex digest lengths of the image_checksum types
+var imageChecksumLengths = map[string]int{"md5": 32, "sha1": 40, "sha256": 64, "sha512": 128}
+
+var imageChecksumPattern = regexp.MustCompile(`^(md5|sha1|sha256|sha512):([0-9a-fA-F]+)$`)
+
+// autosetupImage returns the image location from image_base_path and image_checksum
+func autosetupImage(m configurationModel) AutosetupImage {
+	image := AutosetupImage{BasePath: defaultImageBasePath}
+	if !m.ImageBasePath.IsNull() && !m.ImageBasePath.IsUnknown() && m.ImageBasePath.ValueString() != "" {
+		image.BasePath = strings.TrimRight(m.ImageBasePath.ValueString(), "/")
+	}
+	if parts := imageChecksumPattern.FindStringSubmatch(m.ImageChecksum.ValueString()); parts != nil {
+		image.ChecksumType, image.Checksum = parts[1], strings.ToLower(parts[2])
+	}
+	return image
+}
+
+// validateImage checks image_base_path and image_checksum
+func validateImage(diags *diag.Diagnostics, config configurationModel) {
+	base := config.ImageBasePath
+	if !base.IsNull() && !base.IsUnknown() {
+		v := base.ValueString()
+		if !(strings.HasPrefix(v, "/") || strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://")) || strings.ContainsAny(v, " \t\r\n") {
+			diags.AddAttributeError(path.Root("image_base_path"), "Invalid image_base_path",
+				fmt.Sprintf("image_base_path must be an absolute path or an http:// or https:// URL without whitespace, got %q.", v))
+		}
+	}
+
+	sum := config.ImageChecksum
+	if sum.IsNull() || sum.IsUnknown() {
+		return
+	}
+	if !base.IsUnknown() && !strings.HasPrefix(base.ValueString(), "http://") && !strings.HasPrefix(base.ValueString(), "https://") {
+		diags.AddAttributeError(path.Root("image_checksum"), "Unused image_checksum",
+			"image_checksum is only checked for images downloaded over HTTP; set image_base_path to an http:// or https:// URL.")
+	}
+	parts := imageChecksumPattern.FindStringSubmatch(sum.ValueString())
+	if parts == nil || len(parts[2]) != imageChecksumLengths[parts[1]] {
+		diags.AddAttributeError(path.Root("image_checksum"), "Invalid image_checksum",
+			fmt.Sprintf("image_checksum must be <type>:<hex digest> with type md5, sha1, sha256 or sha512, e.g. sha256:%s; got %q.", strings.Repeat("0", 64), sum.ValueString()))
+	}
+}
+
+// archReplace replaces the server when the effective arch changes, so moving arch between the
+// top level and disk_config with the same value is a no-op
+var archReplace = stringplanmodifier.RequiresReplaceIf(
+	func(ctx context.Context, req planmodifier.StringRequest, resp *stringplanmodifier.RequiresReplaceIfFuncResponse) {
+		resp.RequiresReplace = effectiveArch(ctx, req.State) != effectiveArch(ctx, req.Plan)
+	},
+	"If the architecture changes, Terraform will destroy and recreate the resource.",
+	"If the architecture changes, Terraform will destroy and recreate the resource.",
+)
+
+type attributeGetter interface {
+	GetAttribute(ctx context.Context, p path.Path, target interface{}) diag.Diagnostics
+}
+
+// effectiveArch returns disk_config.arch, falling back to the deprecated top-level arch
+func effectiveArch(ctx context.Context, data attributeGetter) string {
+	var arch types.String
+	data.GetAttribute(ctx, path.Root("disk_config").AtName("arch"), &arch)
+	if arch.IsNull() || arch.IsUnknown() {
+		data.GetAttribute(ctx, path.Root("arch"), &arch)
+	}
+	return arch.ValueString()
+}
+
+// unameArchs maps the machine names uname -m prints to the arch of the OS image
+var unameArchs = map[string]string{"x86_64": "amd64", "aarch64": "arm64"}
+
+// detectArch returns the arch of the server from uname -m; run executes a command in the rescue system
+func detectArch(run func(cmd string) (string, error)) (string, error) {
+	out, err := run("uname -m")
+	if err != nil {
+		return "", err
+	}
+	machine := strings.TrimSpace(out)
+	arch, ok := unameArchs[machine]
+	if !ok {
+		return "", fmt.Errorf("no OS image for machine %q; set disk_config.arch to one of %s", machine, strings.Join(archs, ", "))
+	}
+	return arch, nil
+}
+
+func diskConfigAttribute() rschema.SingleNestedAttribute {
+	return rschema.SingleNestedAttribute{
+		Optional:    true,
+		Description: "Disk layout of the generated autosetup. Replaces the top-level arch, raid_level, filesystem_type and no_uefi, which cannot be set together with it",
+		Attributes: map[string]rschema.Attribute{
+			"arch": rschema.StringAttribute{
+				Optional:      true,
+				Description:   "Architecture for the OS image (" + strings.Join(archs, " or ") + "); detected from uname -m in the rescue system when not set. Changing it replaces the resource",
+				PlanModifiers: []planmodifier.String{archReplace},
+			},
+			"raid_level":   rschema.Int64Attribute{Optional: true, Description: "Software RAID level when two disks are used, 0 or 1 (default: 1)"},
+			"filesystem":   rschema.StringAttribute{Optional: true, Description: "Root filesystem: " + strings.Join(filesystems, ", ") + " (default: ext4)"},
+			"no_uefi":      rschema.BoolAttribute{Optional: true, Description: "If true, removes the UEFI boot partition from the disk partitioning scheme"},
+			"swap_size_mb": rschema.Int64Attribute{Optional: true, Description: "Size of an unencrypted swap partition in MiB, 0 for none (default: 0)"},
+			"boot_size_mb": rschema.Int64Attribute{Optional: true, Description: "Size of the /boot partition in MiB (default: 1024)"},
+			"efi_size_mb":  rschema.Int64Attribute{Optional: true, Description: "Size of the EFI system partition in MiB, unused with no_uefi (default: 512)"},
+		},
+	}
+}
+
+func diskConfig(ctx context.Context, m configurationModel) *diskConfigModel {
+	if m.DiskConfig.IsNull() || m.DiskConfig.IsUnknown() {
+		return nil
+	}
+	var d diskConfigModel
+	m.DiskConfig.As(ctx, &d, basetypes.ObjectAsOptions{})
+	return &d
+}
+
+// diskLayout returns the partitioning from disk_config, or from the deprecated top-level
+// attributes when disk_config is not set
+func diskLayout(ctx context.Context, m configurationModel) DiskLayout {
+	arch, raidLevel, filesystem, noUEFI := m.Arch, m.RaidLevel, m.FilesystemType, m.NoUEFI
+	layout := DiskLayout{BootSizeMB: defaultBootSizeMB, EFISizeMB: defaultEFISizeMB}
+	if d := diskConfig(ctx, m); d != nil {
+		arch, raidLevel, filesystem, noUEFI = d.Arch, d.RaidLevel, d.Filesystem, d.NoUEFI
+		layout.SwapSizeMB = int64OrDefault(d.SwapSizeMB, 0)
+		layout.BootSizeMB = int64OrDefault(d.BootSizeMB, defaultBootSizeMB)
+		layout.EFISizeMB = int64OrDefault(d.EFISizeMB, defaultEFISizeMB)
+	}
+
+	layout.Arch = arch.ValueString()
+	layout.RaidLevel = int64OrDefault(raidLevel, defaultRaidLevel)
+	layout.Filesystem = defaultFilesystem
+	if !filesystem.IsNull() && !filesystem.IsUnknown() && filesystem.ValueString() != "" {
+		layout.Filesystem = filesystem.ValueString()
+	}
+	layout.NoUEFI = !noUEFI.IsNull() && !noUEFI.IsUnknown() && noUEFI.ValueBool()
+	return layout
+}
+
+// partSize formats a partition size for installimage, in whole GiB when possible
+func partSize(mb int64) string {
+	if mb%1024 == 0 {
+		return fmt.Sprintf("%dG", mb/1024)
+	}
+	return fmt.Sprintf("%dM", mb)
+}
+
+// validateDiskConfig checks disk_config and that it is not mixed with the attributes it replaces
+func validateDiskConfig(ctx context.Context, diags *diag.Diagnostics, config configurationModel) {
+	if config.DiskConfig.IsNull() {
+		return
+	}
+	deprecated := map[string]attr.Value{
+		"arch":            config.Arch,
+		"raid_level":      config.RaidLevel,
+		"filesystem_type": config.FilesystemType,
+		"no_uefi":         config.NoUEFI,
+	}
+	for name, v := range deprecated {
+		if !v.IsNull() {
+			diags.AddAttributeError(path.Root(name), "Conflicting disk settings",
+				fmt.Sprintf("%s cannot be combined with disk_config; move it into disk_config instead.", name))
+		}
+	}
+	if config.DiskConfig.IsUnknown() {
+		return
+	}
+
+	var d diskConfigModel
+	config.DiskConfig.As(ctx, &d, basetypes.ObjectAsOptions{UnhandledUnknownAsEmpty: true})
+	p := path.Root("disk_config")
+
+	if !d.Arch.IsNull() && !d.Arch.IsUnknown() && !containsString(archs, d.Arch.ValueString()) {
+		diags.AddAttributeError(p.AtName("arch"), "Invalid arch",
+			fmt.Sprintf("arch must be one of %s, got %q.", strings.Join(archs, ", "), d.Arch.ValueString()))
+	}
+	if !d.Filesystem.IsNull() && !d.Filesystem.IsUnknown() && !containsString(filesystems, d.Filesystem.ValueString()) {
+		diags.AddAttributeError(p.AtName("filesystem"), "Invalid filesystem",
+			fmt.Sprintf("filesystem must be one of %s, got %q.", strings.Join(filesystems, ", "), d.Filesystem.ValueString()))
+	}
+	if !d.RaidLevel.IsNull() && !d.RaidLevel.IsUnknown() {
+		valid := false
+		for _, l := range raidLevels {
+			valid = valid || d.RaidLevel.ValueInt64() == l
+		}
+		if !valid {
+			diags.AddAttributeError(p.AtName("raid_level"), "Invalid raid_level",
+				fmt.Sprintf("raid_level must be 0 or 1, only two disks are used; got %d.", d.RaidLevel.ValueInt64()))
+		}
+	}
+
+	minSizes := []struct {
+		name string
+		v    types.Int64
+		min  int64
+	}{
+		{"swap_size_mb", d.SwapSizeMB, 0},
+		{"boot_size_mb", d.BootSizeMB, 256},
+		{"efi_size_mb", d.EFISizeMB, 64},
+	}
+	for _, s := range minSizes {
+		if !s.v.IsNull() && !s.v.IsUnknown() && s.v.ValueInt64() < s.min {
+			diags.AddAttributeError(p.AtName(s.name), "Invalid "+s.name,
+				fmt.Sprintf("%s must be at least %d, got %d.", s.name, s.min, s.v.ValueInt64()))
+		}
+	}
+}
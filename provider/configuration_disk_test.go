@@ -0,0 +1,237 @@
+package provider
+
+import (
+	"context"
+	"math/big"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestDiskConfigValidationAndMigration(t *testing.T) {
+	ctx := context.Background()
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	diskType := objType.AttributeTypes["disk_config"].(tftypes.Object)
+
+	// raw builds the resource object with the given top-level arch and disk_config attributes
+	raw := func(arch interface{}, disk map[string]interface{}) tftypes.Value {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["server_number"] = tftypes.NewValue(tftypes.Number, 111)
+		vals["server_ip"] = tftypes.NewValue(tftypes.String, "1.2.3.4")
+		vals["name"] = tftypes.NewValue(tftypes.String, "web")
+		vals["cryptpassword"] = tftypes.NewValue(tftypes.String, "secret")
+		vals["use_ephemeral_ssh_key"] = tftypes.NewValue(tftypes.Bool, true)
+		vals["arch"] = tftypes.NewValue(tftypes.String, arch)
+		if disk != nil {
+			diskVals := map[string]tftypes.Value{}
+			for name, typ := range diskType.AttributeTypes {
+				diskVals[name] = tftypes.NewValue(typ, disk[name])
+			}
+			vals["disk_config"] = tftypes.NewValue(diskType, diskVals)
+		}
+		return tftypes.NewValue(objType, vals)
+	}
+	validate := func(v tftypes.Value) []string {
+		var resp resource.ValidateConfigResponse
+		res.ValidateConfig(ctx, resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: v}}, &resp)
+		var summaries []string
+		for _, d := range resp.Diagnostics.Errors() {
+			summaries = append(summaries, d.Summary())
+		}
+		return summaries
+	}
+
+	tests := []struct {
+		name string
+		raw  tftypes.Value
+		want []string
+	}{
+		{name: "deprecated arch", raw: raw("amd64", nil)},
+		{name: "disk_config", raw: raw(nil, map[string]interface{}{"arch": "arm64", "filesystem": "btrfs", "swap_size_mb": big.NewFloat(0)})},
+		{name: "detected arch", raw: raw(nil, map[string]interface{}{"filesystem": "ext4"})},
+		{name: "both", raw: raw("amd64", map[string]interface{}{"arch": "amd64"}), want: []string{"Conflicting disk settings"}},
+		{name: "invalid values", raw: raw(nil, map[string]interface{}{"arch": "riscv64", "filesystem": "zfs", "raid_level": big.NewFloat(5), "boot_size_mb": big.NewFloat(100)}),
+			want: []string{"Invalid arch", "Invalid filesystem", "Invalid raid_level", "Invalid boot_size_mb"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validate(tt.raw)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Moving arch into disk_config with the same value must not replace the server
+	planModify := func(state, plan tftypes.Value, p path.Path) bool {
+		var stateValue, planValue types.String
+		st := tfsdk.State{Schema: schemaResp.Schema, Raw: state}
+		pl := tfsdk.Plan{Schema: schemaResp.Schema, Raw: plan}
+		st.GetAttribute(ctx, p, &stateValue)
+		pl.GetAttribute(ctx, p, &planValue)
+		req := planmodifier.StringRequest{Path: p, State: st, Plan: pl, StateValue: stateValue, PlanValue: planValue}
+		var resp planmodifier.StringResponse
+		archReplace.PlanModifyString(ctx, req, &resp)
+		return resp.RequiresReplace
+	}
+	oldState := raw("amd64", nil)
+	for _, p := range []path.Path{path.Root("arch"), path.Root("disk_config").AtName("arch")} {
+		if planModify(oldState, raw(nil, map[string]interface{}{"arch": "amd64"}), p) {
+			t.Errorf("%s: moving arch into disk_config replaces the resource", p)
+		}
+		if !planModify(oldState, raw(nil, map[string]interface{}{"arch": "arm64"}), p) {
+			t.Errorf("%s: changing arch while moving it does not replace the resource", p)
+		}
+	}
+}
+
+func TestAutosetupImage(t *testing.T) {
+	layout := DiskLayout{Arch: "arm64", RaidLevel: 1, Filesystem: "ext4", BootSizeMB: defaultBootSizeMB, EFISizeMB: defaultEFISizeMB}
+	m := configurationModel{
+		ImageBasePath: types.StringValue("https://mirror.example.com/images/"),
+		ImageChecksum: types.StringValue("sha256:" + strings.Repeat("AB", 32)),
+	}
+	got := buildAutosetupContent("web-abc123", "secret", layout, autosetupImage(m), "/dev/sda", "")
+	want := "IMAGE https://mirror.example.com/images/Ubuntu-2404-noble-arm64-base.tar.gz\nIMAGECHECKSUMTYPE sha256\nIMAGECHECKSUM " + strings.Repeat("ab", 32) + "\n"
+	if !strings.Contains(got, want) {
+		t.Fatalf("unexpected autosetup:\n%s", got)
+	}
+
+	tests := []struct {
+		base, checksum string
+		want           []string
+	}{
+		{base: "/mnt/nfs/images"},
+		{base: "http://10.0.0.5/images", checksum: "md5:" + strings.Repeat("0", 32)},
+		{base: "ftp://mirror/images", want: []string{"Invalid image_base_path"}},
+		{base: "images", want: []string{"Invalid image_base_path"}},
+		{base: "/root/my images", want: []string{"Invalid image_base_path"}},
+		{base: "/root/images", checksum: "sha256:" + strings.Repeat("0", 64), want: []string{"Unused image_checksum"}},
+		{base: "https://mirror/images", checksum: "sha256:abc", want: []string{"Invalid image_checksum"}},
+		{base: "https://mirror/images", checksum: strings.Repeat("0", 64), want: []string{"Invalid image_checksum"}},
+	}
+	for _, tt := range tests {
+		m := configurationModel{ImageBasePath: types.StringValue(tt.base), ImageChecksum: types.StringNull()}
+		if tt.checksum != "" {
+			m.ImageChecksum = types.StringValue(tt.checksum)
+		}
+		var diags diag.Diagnostics
+		validateImage(&diags, m)
+		var got []string
+		for _, d := range diags.Errors() {
+			got = append(got, d.Summary())
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s %s: errors = %v, want %v", tt.base, tt.checksum, got, tt.want)
+		}
+	}
+}
+
+func TestDetectArch(t *testing.T) {
+	uname := func(machine string) func(string) (string, error) {
+		return func(cmd string) (string, error) {
+			if cmd != "uname -m" {
+				t.Fatalf("unexpected command %q", cmd)
+			}
+			return machine + "\n", nil
+		}
+	}
+	for machine, want := range map[string]string{"aarch64": "arm64", "x86_64": "amd64"} {
+		got, err := detectArch(uname(machine))
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", machine, got, err, want)
+		}
+	}
+	if _, err := detectArch(uname("riscv64")); err == nil || !strings.Contains(err.Error(), "disk_config.arch") {
+		t.Errorf("expected an error for an unsupported machine, got %v", err)
+	}
+}
+
+func TestCryptPassword(t *testing.T) {
+	ctx := context.Background()
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	validate := func(password string) diag.Diagnostics {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["server_number"] = tftypes.NewValue(tftypes.Number, 111)
+		vals["name"] = tftypes.NewValue(tftypes.String, "web")
+		vals["cryptpassword"] = tftypes.NewValue(tftypes.String, password)
+		vals["use_ephemeral_ssh_key"] = tftypes.NewValue(tftypes.Bool, true)
+		var resp resource.ValidateConfigResponse
+		res.ValidateConfig(ctx, resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}, &resp)
+		return resp.Diagnostics
+	}
+
+	var printable strings.Builder
+	for c := '!'; c <= '~'; c++ {
+		printable.WriteRune(c)
+	}
+	nasty := []string{
+		printable.String(),
+		`a"b\c`,
+		`it's`,
+		`$(reboot)`,
+		"`id`;exit",
+		`\n`,
+		`%s%d{{.LocalIP}}`,
+		"SECRETPASSWORDREPLACEME",
+	}
+	for _, password := range nasty {
+		if diags := validate(password); diags.HasError() {
+			t.Fatalf("%q: unexpected errors: %v", password, diags)
+		}
+	}
+	for _, password := range []string{"", "two words", "tab\there", "new\nline", "cr\r", "nul\x00", "del\x7f", "pässword"} {
+		diags := validate(password)
+		if !diags.HasError() || diags.Errors()[0].Summary() != "Invalid cryptpassword" {
+			t.Fatalf("%q: expected Invalid cryptpassword, got %v", password, diags)
+		}
+		if strings.Contains(diags.Errors()[0].Detail(), password) && password != "" {
+			t.Fatalf("%q: the error repeats the password", password)
+		}
+	}
+
+	// Every accepted password reaches cryptsetup unchanged
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	for _, password := range nasty {
+		out, err := renderScript(postinstallTemplate, &PostInstallTemplateData{CryptPassword: password})
+		if err != nil {
+			t.Fatalf("%q: render: %v", password, err)
+		}
+		var line string
+		for _, l := range strings.Split(out, "\n") {
+			if strings.HasPrefix(l, "CRYPT_PASSWORD=") {
+				line = l
+			}
+		}
+		got, err := exec.Command(bash, "-c", line+"\nprintf '%s' \"$CRYPT_PASSWORD\"").CombinedOutput()
+		if err != nil || string(got) != password {
+			t.Fatalf("%q: the post-install script sees %q (%v)", password, got, err)
+		}
+		if autosetup := buildAutosetupContent("web", password, DiskLayout{Arch: "amd64", Filesystem: "ext4"}, AutosetupImage{BasePath: "/root/images"}, "/dev/sda", ""); !strings.Contains(autosetup, "CRYPTPASSWORD "+password+"\n") {
+			t.Fatalf("%q: autosetup does not carry the password on its own line", password)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+const (
+	k3sRoleAgent  = "agent"
+	k3sRoleServer = "server"
+)
+
+// k3sVersion matches a K3S release (e.g. v1.30.4+k3s1); it is also what keeps the version safe to put in the script
+var k3sVersion = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-rc[0-9]+)?\+k3s[0-9]+$`)
+
+// k3sReadyURL limits wait_for_k3s_ready to plain http(s) URLs, which also keeps it safe to put in the curl command
+var k3sReadyURL = regexp.MustCompile(`^https?://[A-Za-z0-9.:\[\]-]+(/[A-Za-z0-9._~/-]*)?$`)
+
+// k3sReadyPoll is how wait_for_k3s_ready polls the K3S API before the install
+var k3sReadyPoll = client.PollOptions{Interval: 10 * time.Second, MaxInterval: 30 * time.Second, MaxElapsed: 30 * time.Minute, Jitter: 0.1}
+
+// reservedLabelPrefix is the label namespace Kubernetes keeps for itself
+const reservedLabelPrefix = "kubernetes.io/"
+
+// defaultK3SCloudProvider is the kubelet --cloud-provider K3S nodes get unless cloud_provider
+// says otherwise; "external" hands node initialization to a cloud controller manager
+const defaultK3SCloudProvider = "external"
+
+// k3sCloudProviderNone is the cloud_provider value that leaves out --cloud-provider
+const k3sCloudProviderNone = "none"
+
+// k3sCloudProvider matches a cloud provider name, which is also what keeps it safe to put in the script
+var k3sCloudProvider = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// defaultTopologyLabelKey is the well-known zone label auto_topology_labels sets to the datacenter
+const defaultTopologyLabelKey = "topology.kubernetes.io/zone"
+
+// autoLabelPrefix is the namespace of the labels auto_labels derives from Robot data
+const autoLabelPrefix = "hrobot.mokto.dev/"
+
+// labelValueInvalid matches the runs of characters labelValue replaces with a dash
+var labelValueInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// nodeLabelKey matches a Kubernetes label key: an optional DNS subdomain prefix and a name
+var nodeLabelKey = regexp.MustCompile(`^([a-z0-9]([a-z0-9.-]{0,251}[a-z0-9])?/)?[A-Za-z0-9]([A-Za-z0-9._-]{0,61}[A-Za-z0-9])?$`)
+
+// k3sMigration is appended to the descriptions of the deprecated top-level K3S attributes
+const k3sMigration = "Deprecated: move it into the k3s block (k3s_token -> token, k3s_url -> url, node_labels, taints, cpu_manager)"
+
+type k3sModel struct {
+	Token      types.String `tfsdk:"token"`
+	URL        types.String `tfsdk:"url"`
+	Role       types.String `tfsdk:"role"`
+	Version    types.String `tfsdk:"version"`
+	NodeLabels types.List   `tfsdk:"node_labels"`
+	Taints     types.List   `tfsdk:"taints"`
+	CPUManager types.Bool   `tfsdk:"cpu_manager"`
+
+	NodeLabelsMap types.Map `tfsdk:"node_labels_map"`
+
+	AutoTopologyLabels types.Bool   `tfsdk:"auto_topology_labels"`
+	TopologyLabelKey   types.String `tfsdk:"topology_label_key"`
+	AutoLabels         types.Bool   `tfsdk:"auto_labels"`
+	CloudProvider      types.String `tfsdk:"cloud_provider"`
+}
+
+// K3SConfig holds the settings buildK3SScript installs K3S with, with defaults applied
+type K3SConfig struct {
+	Token      string
+	URL        string
+	Role       string // agent or server
+	Version    string // empty: the current stable release
+	NodeLabels []nodeLabelModel
+	Taints     []string
+	CPUManager bool
+	// CloudProvider is passed to the kubelet as --cloud-provider; empty leaves the flag out
+	CloudProvider string
+	// NetworkInterface is the parent of the Flannel VLAN interface (network_interface); empty
+	// detects the interface with the default route
+	NetworkInterface string
+	// VLANInterface is the Flannel interface (vlan_interface_name); empty for <parent>.4001
+	VLANInterface string
+}
+
+func nodeLabelsAttribute() rschema.ListNestedAttribute {
+	return rschema.ListNestedAttribute{
+		Optional:    true,
+		Description: "List of node labels to apply to this K3S node",
+		NestedObject: rschema.NestedAttributeObject{
+			Attributes: map[string]rschema.Attribute{
+				"name":  rschema.StringAttribute{Required: true, Description: "Label name"},
+				"value": rschema.StringAttribute{Required: true, Description: "Label value"},
+			},
+		},
+	}
+}
+
+func k3sAttribute() rschema.SingleNestedAttribute {
+	labels := nodeLabelsAttribute()
+	labels.Description += ". Deprecated: use node_labels_map"
+	labels.DeprecationMessage = "Use node_labels_map instead."
+	return rschema.SingleNestedAttribute{
+		Optional:    true,
+		Description: "Join the server to a K3S cluster after the install. K3S is not installed when neither this block nor the deprecated k3s_token/k3s_url are set",
+		Attributes: map[string]rschema.Attribute{
+			"token":       rschema.StringAttribute{Required: true, Sensitive: true, Description: "K3S token for joining the cluster"},
+			"url":         rschema.StringAttribute{Required: true, Description: "K3S server URL (e.g., https://master-ip:6443)"},
+			"role":        rschema.StringAttribute{Optional: true, Description: "Join as an agent or as an additional server (default: agent)"},
+			"version":     rschema.StringAttribute{Optional: true, Description: "K3S release to install, e.g. v1.30.4+k3s1 (default: the current stable release)"},
+			"node_labels": labels,
+			"node_labels_map": rschema.MapAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Node labels to apply to this K3S node, keyed by label name (e.g., { role = \"worker\" }); wins over node_labels on the same name",
+			},
+			"taints": rschema.ListAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "List of taints to apply to this K3S node (e.g., 'localstorage=true:NoSchedule')",
+			},
+			"cpu_manager": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Enable CPU manager with static policy and resource reservations (cpu-manager-policy=static, system-reserved=cpu=1, kube-reserved=cpu=1)",
+			},
+			"auto_topology_labels": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Label the node with its datacenter in lower case (e.g., topology.kubernetes.io/zone=fsn1-dc14), so workloads can be spread across datacenters; a label of the same name in node_labels_map wins (default: true)",
+			},
+			"topology_label_key": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Label name auto_topology_labels puts the datacenter in (default: topology.kubernetes.io/zone)",
+			},
+			"cloud_provider": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Kubelet --cloud-provider for the node; \"\" or \"none\" leaves the flag out, for clusters without a cloud controller manager (default: external)",
+			},
+			"auto_labels": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Also label the node with Robot data: hrobot.mokto.dev/server-number, hrobot.mokto.dev/product, node.kubernetes.io/instance-type (the product) and topology.kubernetes.io/region (the location); a label of the same name in node_labels_map wins (default: false)",
+			},
+		},
+	}
+}
+
+// k3sConfig returns the K3S settings from the k3s block, or from the deprecated top-level
+// attributes when the block is not set; nil skips the K3S install
+func k3sConfig(ctx context.Context, m configurationModel) *K3SConfig {
+	token, url, labels, taints, cpuManager := m.K3SToken, m.K3SURL, m.NodeLabels, m.Taints, m.CPUManager
+	labelsMap := types.MapNull(types.StringType)
+	topologyKey, autoLabels := "", false
+	cfg := &K3SConfig{Role: k3sRoleAgent, CloudProvider: defaultK3SCloudProvider}
+	if !m.K3S.IsNull() && !m.K3S.IsUnknown() {
+		var k k3sModel
+		m.K3S.As(ctx, &k, basetypes.ObjectAsOptions{})
+		token, url, labels, taints, cpuManager = k.Token, k.URL, k.NodeLabels, k.Taints, k.CPUManager
+		labelsMap = k.NodeLabelsMap
+		if !k.Role.IsNull() && k.Role.ValueString() != "" {
+			cfg.Role = k.Role.ValueString()
+		}
+		cfg.Version = k.Version.ValueString()
+		if k.AutoTopologyLabels.IsNull() || k.AutoTopologyLabels.ValueBool() {
+			topologyKey = defaultTopologyLabelKey
+			if !k.TopologyLabelKey.IsNull() && k.TopologyLabelKey.ValueString() != "" {
+				topologyKey = k.TopologyLabelKey.ValueString()
+			}
+		}
+		autoLabels = k.AutoLabels.ValueBool()
+		if !k.CloudProvider.IsNull() {
+			cfg.CloudProvider = k.CloudProvider.ValueString()
+			if cfg.CloudProvider == k3sCloudProviderNone {
+				cfg.CloudProvider = ""
+			}
+		}
+	}
+	if token.IsNull() || token.IsUnknown() || url.IsNull() || url.IsUnknown() {
+		return nil
+	}
+
+	cfg.Token = token.ValueString()
+	cfg.URL = url.ValueString()
+	if !labels.IsNull() && !labels.IsUnknown() {
+		labels.ElementsAs(ctx, &cfg.NodeLabels, false)
+	}
+	if !labelsMap.IsNull() && !labelsMap.IsUnknown() {
+		var values map[string]string
+		labelsMap.ElementsAs(ctx, &values, false)
+		cfg.NodeLabels = mergeNodeLabels(cfg.NodeLabels, values)
+	}
+	defaults := map[string]string{}
+	if autoLabels {
+		defaults = robotLabels(m)
+	}
+	if topologyKey != "" {
+		defaults[topologyKey] = labelValue(m.ServerDatacenter.ValueString())
+	}
+	cfg.NodeLabels = addDefaultLabels(cfg.NodeLabels, defaults)
+	if !taints.IsNull() && !taints.IsUnknown() {
+		var values []types.String
+		taints.ElementsAs(ctx, &values, false)
+		for _, t := range values {
+			if !t.IsNull() && !t.IsUnknown() {
+				cfg.Taints = append(cfg.Taints, t.ValueString())
+			}
+		}
+	}
+	cfg.CPUManager = !cpuManager.IsNull() && !cpuManager.IsUnknown() && cpuManager.ValueBool()
+	cfg.NetworkInterface = m.NetworkInterface.ValueString()
+	cfg.VLANInterface = m.VLANInterfaceName.ValueString()
+	return cfg
+}
+
+// mergeNodeLabels adds the labels of node_labels_map to those of node_labels, replacing the ones
+// with the same name. The list keeps its order and the map's labels follow sorted by name
+func mergeNodeLabels(list []nodeLabelModel, labels map[string]string) []nodeLabelModel {
+	merged := make([]nodeLabelModel, 0, len(list)+len(labels))
+	for _, label := range list {
+		if _, ok := labels[label.Name.ValueString()]; !ok {
+			merged = append(merged, label)
+		}
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		merged = append(merged, nodeLabelModel{Name: types.StringValue(name), Value: types.StringValue(labels[name])})
+	}
+	return merged
+}
+
+// robotLabels returns the auto_labels labels for the server; values Robot didn't report are empty
+func robotLabels(m configurationModel) map[string]string {
+	labels := map[string]string{
+		autoLabelPrefix + "product":        labelValue(m.ServerProduct.ValueString()),
+		"node.kubernetes.io/instance-type": labelValue(m.ServerProduct.ValueString()),
+		"topology.kubernetes.io/region":    labelValue(m.ServerLocation.ValueString()),
+	}
+	if !m.ServerNumber.IsNull() && !m.ServerNumber.IsUnknown() {
+		labels[autoLabelPrefix+"server-number"] = strconv.FormatInt(m.ServerNumber.ValueInt64(), 10)
+	}
+	return labels
+}
+
+// labelValue turns s into a valid label value: lower case, runs of anything but letters and
+// digits replaced by a dash, trimmed to 63 characters
+func labelValue(s string) string {
+	v := strings.Trim(labelValueInvalid.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(v) > 63 {
+		v = strings.TrimRight(v[:63], "-")
+	}
+	return v
+}
+
+// addDefaultLabels appends the labels of defaults sorted by name, skipping those with an empty
+// value and those the node already has, so configured labels win
+func addDefaultLabels(labels []nodeLabelModel, defaults map[string]string) []nodeLabelModel {
+	set := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		set[label.Name.ValueString()] = true
+	}
+	names := make([]string, 0, len(defaults))
+	for name, value := range defaults {
+		if value != "" && !set[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		labels = append(labels, nodeLabelModel{Name: types.StringValue(name), Value: types.StringValue(defaults[name])})
+	}
+	return labels
+}
+
+// validateK3S checks the k3s block and that it is not mixed with the attributes it replaces
+func validateK3S(ctx context.Context, diags *diag.Diagnostics, config configurationModel) {
+	if w := config.WaitForK3SReady; !w.IsNull() && !w.IsUnknown() {
+		if !k3sReadyURL.MatchString(w.ValueString()) {
+			diags.AddAttributeError(path.Root("wait_for_k3s_ready"), "Invalid URL",
+				fmt.Sprintf("wait_for_k3s_ready must be an http(s) URL like https://10.0.0.2:6443, got %q.", w.ValueString()))
+		} else if config.K3S.IsNull() && config.K3SToken.IsNull() {
+			diags.AddAttributeWarning(path.Root("wait_for_k3s_ready"), "K3S is not installed",
+				"wait_for_k3s_ready has no effect without the k3s block.")
+		}
+	}
+	if config.K3S.IsNull() {
+		if config.K3SToken.IsNull() != config.K3SURL.IsNull() {
+			diags.AddAttributeError(path.Root("k3s_url"), "Incomplete K3S settings",
+				"k3s_token and k3s_url must be set together; K3S is not installed when neither is set.")
+		}
+		return
+	}
+	deprecated := map[string]attr.Value{
+		"k3s_token":   config.K3SToken,
+		"k3s_url":     config.K3SURL,
+		"node_labels": config.NodeLabels,
+		"taints":      config.Taints,
+		"cpu_manager": config.CPUManager,
+	}
+	for name, v := range deprecated {
+		if !v.IsNull() {
+			diags.AddAttributeError(path.Root(name), "Conflicting K3S settings",
+				fmt.Sprintf("%s cannot be combined with the k3s block; move it into the block instead.", name))
+		}
+	}
+	if config.K3S.IsUnknown() {
+		return
+	}
+
+	var k k3sModel
+	config.K3S.As(ctx, &k, basetypes.ObjectAsOptions{UnhandledUnknownAsEmpty: true})
+	p := path.Root("k3s")
+	if !k.Role.IsNull() && !k.Role.IsUnknown() && k.Role.ValueString() != k3sRoleAgent && k.Role.ValueString() != k3sRoleServer {
+		diags.AddAttributeError(p.AtName("role"), "Invalid role",
+			fmt.Sprintf("role must be %q or %q, got %q.", k3sRoleAgent, k3sRoleServer, k.Role.ValueString()))
+	}
+	if !k.Version.IsNull() && !k.Version.IsUnknown() && !k3sVersion.MatchString(k.Version.ValueString()) {
+		diags.AddAttributeError(p.AtName("version"), "Invalid version",
+			fmt.Sprintf("version must be a K3S release like v1.30.4+k3s1, got %q.", k.Version.ValueString()))
+	}
+	for name := range k.NodeLabelsMap.Elements() {
+		if strings.HasPrefix(name, reservedLabelPrefix) {
+			diags.AddAttributeError(p.AtName("node_labels_map"), "Reserved node label",
+				fmt.Sprintf("node_labels_map cannot set %q: labels under %s are reserved for Kubernetes.", name, reservedLabelPrefix))
+		}
+	}
+	if c := k.CloudProvider; !c.IsNull() && !c.IsUnknown() && c.ValueString() != "" && !k3sCloudProvider.MatchString(c.ValueString()) {
+		diags.AddAttributeError(p.AtName("cloud_provider"), "Invalid cloud_provider",
+			fmt.Sprintf("cloud_provider must be a name like external, or \"none\" to leave it out, got %q.", c.ValueString()))
+	}
+	if key := k.TopologyLabelKey; !key.IsNull() && !key.IsUnknown() {
+		if !nodeLabelKey.MatchString(key.ValueString()) || strings.HasPrefix(key.ValueString(), reservedLabelPrefix) {
+			diags.AddAttributeError(p.AtName("topology_label_key"), "Invalid topology_label_key",
+				fmt.Sprintf("topology_label_key must be a label name like topology.kubernetes.io/zone outside %s, got %q.", reservedLabelPrefix, key.ValueString()))
+		} else if !k.AutoTopologyLabels.IsNull() && !k.AutoTopologyLabels.IsUnknown() && !k.AutoTopologyLabels.ValueBool() {
+			diags.AddAttributeWarning(p.AtName("topology_label_key"), "Topology label disabled",
+				"topology_label_key has no effect with auto_topology_labels = false.")
+		}
+	}
+}
+
+// waitForK3SReady polls url from the server (run executes a command on it over SSH) until the K3S
+// API answers. Any HTTP response counts: the API replies 401 to anonymous requests
+func waitForK3SReady(ctx context.Context, run func(cmd string) (string, error), url string, opts client.PollOptions) error {
+	cmd := fmt.Sprintf("curl -ks -o /dev/null --max-time 10 '%s'", url)
+	_, err := client.Poll(ctx, opts, func() (bool, error) {
+		_, err := run(cmd)
+		return err == nil, nil
+	}, func(ready bool) bool { return ready })
+	if err != nil {
+		return fmt.Errorf("K3S API at %s did not respond: %w", url, err)
+	}
+	return nil
+}
+
+// k3sVersionCmd prints the version line of the installed K3S binary
+const k3sVersionCmd = "k3s --version | head -1"
+
+// installedK3SVersionLine matches the first line of `k3s --version`, e.g.
+// "k3s version v1.30.2+k3s1 (aa4794bc)"
+var installedK3SVersionLine = regexp.MustCompile(`^k3s version (v[0-9]+\.[0-9]+\.[0-9]+\S*)`)
+
+// readInstalledK3SVersion returns the version K3S reports on the server (run executes a command
+// on it over SSH), which for a channel install is only known once the install script ran
+func readInstalledK3SVersion(run func(cmd string) (string, error)) (string, error) {
+	out, err := run(k3sVersionCmd)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w\n%s", k3sVersionCmd, err, out)
+	}
+	match := installedK3SVersionLine.FindStringSubmatch(strings.TrimSpace(out))
+	if match == nil {
+		return "", fmt.Errorf("%s printed %q, which is not a K3S version", k3sVersionCmd, strings.TrimSpace(out))
+	}
+	return match[1], nil
+}
+
+// warnInstalledK3SVersion warns when K3S was installed but configure could not read its version
+func warnInstalledK3SVersion(ctx context.Context, diags *diag.Diagnostics, plan configurationModel) {
+	if k3sConfig(ctx, plan) == nil || !plan.InstalledK3SVersion.IsNull() {
+		return
+	}
+	diags.AddAttributeWarning(path.Root("installed_k3s_version"), "Unknown K3S version",
+		fmt.Sprintf("K3S was installed but `%s` did not report a version, so installed_k3s_version is null. The output is in the provider log.", k3sVersionCmd))
+}
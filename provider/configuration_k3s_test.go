@@ -0,0 +1,434 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+func TestK3SConfig(t *testing.T) {
+	ctx := context.Background()
+	k3sType := k3sAttribute().GetType().(types.ObjectType)
+	labelType := k3sType.AttrTypes["node_labels"].(types.ListType).ElemType.(types.ObjectType)
+	base := configurationModel{
+		K3SToken:   types.StringNull(),
+		K3SURL:     types.StringNull(),
+		NodeLabels: types.ListNull(labelType),
+		Taints:     types.ListNull(types.StringType),
+		CPUManager: types.BoolNull(),
+		K3S:        types.ObjectNull(k3sType.AttrTypes),
+	}
+
+	t.Run("no k3s", func(t *testing.T) {
+		if cfg := k3sConfig(ctx, base); cfg != nil {
+			t.Fatalf("expected K3S to be skipped, got %+v", cfg)
+		}
+	})
+
+	t.Run("deprecated attributes", func(t *testing.T) {
+		m := base
+		m.K3SToken = types.StringValue("tok")
+		m.K3SURL = types.StringValue("https://10.0.0.2:6443")
+		m.Taints, _ = types.ListValueFrom(ctx, types.StringType, []string{"localstorage=true:NoSchedule"})
+		cfg := k3sConfig(ctx, m)
+		if cfg == nil {
+			t.Fatal("deprecated k3s_token/k3s_url no longer install K3S")
+		}
+		script := buildK3SScript(ctx, *cfg, "", "")
+		want := `echo 'Installing K3S agent...'
+curl -sfL https://get.k3s.io | K3S_URL="https://10.0.0.2:6443" K3S_TOKEN=tok \
+  sh -s - \
+  --kubelet-arg="--cloud-provider=external" \
+  --kubelet-arg=register-with-taints=localstorage=true:NoSchedule
+echo 'K3S installation completed'
+`
+		if script != want {
+			t.Fatalf("unexpected script:\n%s", script)
+		}
+	})
+
+	t.Run("k3s block", func(t *testing.T) {
+		labels, _ := types.ListValueFrom(ctx, labelType, []nodeLabelModel{{Name: types.StringValue("pool"), Value: types.StringValue("db")}})
+		k3s, d := types.ObjectValue(k3sType.AttrTypes, map[string]attr.Value{
+			"token":       types.StringValue("tok"),
+			"url":         types.StringValue("https://10.0.0.2:6443"),
+			"role":        types.StringValue(k3sRoleServer),
+			"version":     types.StringValue("v1.30.4+k3s1"),
+			"node_labels": labels,
+			"taints":      types.ListNull(types.StringType),
+			"cpu_manager": types.BoolValue(true),
+
+			"node_labels_map":      types.MapNull(types.StringType),
+			"auto_topology_labels": types.BoolNull(),
+			"topology_label_key":   types.StringNull(),
+			"auto_labels":          types.BoolNull(),
+			"cloud_provider":       types.StringNull(),
+		})
+		if d.HasError() {
+			t.Fatal(d)
+		}
+		m := base
+		m.K3S = k3s
+		cfg := k3sConfig(ctx, m)
+		if cfg == nil {
+			t.Fatal("k3s block does not install K3S")
+		}
+		script := buildK3SScript(ctx, *cfg, "10.1.0.5", "1.2.3.4")
+		for _, want := range []string{
+			`| INSTALL_K3S_VERSION="v1.30.4+k3s1" K3S_TOKEN=tok \`,
+			`sh -s - server --server "https://10.0.0.2:6443" \`,
+			"--node-ip=10.1.0.5 \\",
+			"--node-external-ip=1.2.3.4 \\",
+			"--node-label pool=db \\",
+			"--kubelet-arg=cpu-manager-policy=static \\",
+			`--flannel-iface="$VLAN_IFACE"`,
+		} {
+			if !strings.Contains(script, want) {
+				t.Errorf("script is missing %q:\n%s", want, script)
+			}
+		}
+		if strings.Contains(script, "K3S_URL") {
+			t.Errorf("server role must not set K3S_URL:\n%s", script)
+		}
+
+		var diags diag.Diagnostics
+		m.K3SToken = types.StringValue("tok")
+		validateK3S(ctx, &diags, m)
+		if !diags.HasError() || diags.Errors()[0].Summary() != "Conflicting K3S settings" {
+			t.Fatalf("expected a conflict with k3s_token, got %v", diags)
+		}
+	})
+
+	t.Run("invalid block", func(t *testing.T) {
+		k3s, _ := types.ObjectValue(k3sType.AttrTypes, map[string]attr.Value{
+			"token":       types.StringValue("tok"),
+			"url":         types.StringValue("https://10.0.0.2:6443"),
+			"role":        types.StringValue("worker"),
+			"version":     types.StringValue("latest; rm -rf /"),
+			"node_labels": types.ListNull(labelType),
+			"taints":      types.ListNull(types.StringType),
+			"cpu_manager": types.BoolNull(),
+
+			"node_labels_map":      types.MapNull(types.StringType),
+			"auto_topology_labels": types.BoolNull(),
+			"topology_label_key":   types.StringNull(),
+			"auto_labels":          types.BoolNull(),
+			"cloud_provider":       types.StringNull(),
+		})
+		m := base
+		m.K3S = k3s
+		var diags diag.Diagnostics
+		validateK3S(ctx, &diags, m)
+		if len(diags.Errors()) != 2 {
+			t.Fatalf("expected role and version errors, got %v", diags)
+		}
+	})
+
+	t.Run("node_labels_map", func(t *testing.T) {
+		labels, _ := types.ListValueFrom(ctx, labelType, []nodeLabelModel{
+			{Name: types.StringValue("role"), Value: types.StringValue("db")},
+			{Name: types.StringValue("zone"), Value: types.StringValue("fsn1")},
+		})
+		labelsMap, _ := types.MapValueFrom(ctx, types.StringType, map[string]string{"role": "worker", "pool": "general"})
+		k3s, d := types.ObjectValue(k3sType.AttrTypes, map[string]attr.Value{
+			"token":       types.StringValue("tok"),
+			"url":         types.StringValue("https://10.0.0.2:6443"),
+			"role":        types.StringNull(),
+			"version":     types.StringNull(),
+			"node_labels": labels,
+			"taints":      types.ListNull(types.StringType),
+			"cpu_manager": types.BoolNull(),
+
+			"node_labels_map":      labelsMap,
+			"auto_topology_labels": types.BoolNull(),
+			"topology_label_key":   types.StringNull(),
+			"auto_labels":          types.BoolNull(),
+			"cloud_provider":       types.StringNull(),
+		})
+		if d.HasError() {
+			t.Fatal(d)
+		}
+		m := base
+		m.K3S = k3s
+		script := buildK3SScript(ctx, *k3sConfig(ctx, m), "", "")
+		want := "  --node-label zone=fsn1 \\\n  --node-label pool=general \\\n  --node-label role=worker\n"
+		if !strings.Contains(script, want) || strings.Contains(script, "role=db") {
+			t.Fatalf("expected node_labels_map to win over node_labels, got:\n%s", script)
+		}
+
+		var diags diag.Diagnostics
+		validateK3S(ctx, &diags, m)
+		if diags.HasError() {
+			t.Fatalf("expected no errors, got %v", diags)
+		}
+
+		reserved, _ := types.MapValueFrom(ctx, types.StringType, map[string]string{"kubernetes.io/role": "worker"})
+		attrs := k3s.Attributes()
+		attrs["node_labels_map"] = reserved
+		m.K3S = types.ObjectValueMust(k3sType.AttrTypes, attrs)
+		validateK3S(ctx, &diags, m)
+		if !diags.HasError() || diags.Errors()[0].Summary() != "Reserved node label" {
+			t.Fatalf("expected kubernetes.io/ labels to be rejected, got %v", diags)
+		}
+	})
+
+	t.Run("topology labels", func(t *testing.T) {
+		block := func(auto types.Bool, key types.String, labels map[string]string) types.Object {
+			labelsMap := types.MapNull(types.StringType)
+			if labels != nil {
+				labelsMap, _ = types.MapValueFrom(ctx, types.StringType, labels)
+			}
+			return types.ObjectValueMust(k3sType.AttrTypes, map[string]attr.Value{
+				"token":       types.StringValue("tok"),
+				"url":         types.StringValue("https://10.0.0.2:6443"),
+				"role":        types.StringNull(),
+				"version":     types.StringNull(),
+				"node_labels": types.ListNull(labelType),
+				"taints":      types.ListNull(types.StringType),
+				"cpu_manager": types.BoolNull(),
+
+				"node_labels_map":      labelsMap,
+				"auto_topology_labels": auto,
+				"topology_label_key":   key,
+				"auto_labels":          types.BoolNull(),
+				"cloud_provider":       types.StringNull(),
+			})
+		}
+		m := base
+		m.ServerDatacenter = types.StringValue("FSN1-DC14")
+		cases := []struct {
+			name   string
+			k3s    types.Object
+			want   string
+			absent string
+		}{
+			{"default", block(types.BoolNull(), types.StringNull(), nil), "--node-label topology.kubernetes.io/zone=fsn1-dc14", ""},
+			{"custom key", block(types.BoolValue(true), types.StringValue("example.com/dc"), nil), "--node-label example.com/dc=fsn1-dc14", "topology.kubernetes.io/zone"},
+			{"disabled", block(types.BoolValue(false), types.StringNull(), nil), "", "topology.kubernetes.io/zone"},
+			{"overridden", block(types.BoolNull(), types.StringNull(), map[string]string{"topology.kubernetes.io/zone": "rack-7"}), "--node-label topology.kubernetes.io/zone=rack-7", "fsn1-dc14"},
+		}
+		for _, tc := range cases {
+			m.K3S = tc.k3s
+			script := buildK3SScript(ctx, *k3sConfig(ctx, m), "", "")
+			if !strings.Contains(script, tc.want) || (tc.absent != "" && strings.Contains(script, tc.absent)) {
+				t.Errorf("%s: unexpected script:\n%s", tc.name, script)
+			}
+		}
+
+		unknownDC := base
+		unknownDC.K3S = block(types.BoolNull(), types.StringNull(), nil)
+		if script := buildK3SScript(ctx, *k3sConfig(ctx, unknownDC), "", ""); strings.Contains(script, "topology.kubernetes.io/zone") {
+			t.Errorf("expected no topology label without a datacenter:\n%s", script)
+		}
+
+		var diags diag.Diagnostics
+		m.K3S = block(types.BoolNull(), types.StringValue("kubernetes.io/zone"), nil)
+		validateK3S(ctx, &diags, m)
+		if !diags.HasError() || diags.Errors()[0].Summary() != "Invalid topology_label_key" {
+			t.Fatalf("expected a reserved topology_label_key to be rejected, got %v", diags)
+		}
+	})
+
+	t.Run("auto labels", func(t *testing.T) {
+		labelsMap, _ := types.MapValueFrom(ctx, types.StringType, map[string]string{"node.kubernetes.io/instance-type": "big"})
+		m := base
+		m.ServerNumber = types.Int64Value(321)
+		m.ServerProduct = types.StringValue("Server Auction (AX41-NVMe)")
+		m.ServerLocation = types.StringValue("FSN1")
+		m.ServerDatacenter = types.StringValue("FSN1-DC14")
+		m.K3S = types.ObjectValueMust(k3sType.AttrTypes, map[string]attr.Value{
+			"token":       types.StringValue("tok"),
+			"url":         types.StringValue("https://10.0.0.2:6443"),
+			"role":        types.StringNull(),
+			"version":     types.StringNull(),
+			"node_labels": types.ListNull(labelType),
+			"taints":      types.ListNull(types.StringType),
+			"cpu_manager": types.BoolNull(),
+
+			"node_labels_map":      labelsMap,
+			"auto_topology_labels": types.BoolNull(),
+			"topology_label_key":   types.StringNull(),
+			"auto_labels":          types.BoolValue(true),
+			"cloud_provider":       types.StringNull(),
+		})
+		script := buildK3SScript(ctx, *k3sConfig(ctx, m), "", "")
+		want := "  --node-label node.kubernetes.io/instance-type=big \\\n" +
+			"  --node-label hrobot.mokto.dev/product=server-auction-ax41-nvme \\\n" +
+			"  --node-label hrobot.mokto.dev/server-number=321 \\\n" +
+			"  --node-label topology.kubernetes.io/region=fsn1 \\\n" +
+			"  --node-label topology.kubernetes.io/zone=fsn1-dc14\n"
+		if !strings.Contains(script, want) {
+			t.Fatalf("unexpected labels:\n%s", script)
+		}
+	})
+
+	t.Run("cloud provider", func(t *testing.T) {
+		block := func(cloudProvider types.String) configurationModel {
+			m := base
+			m.K3S = types.ObjectValueMust(k3sType.AttrTypes, map[string]attr.Value{
+				"token":       types.StringValue("tok"),
+				"url":         types.StringValue("https://10.0.0.2:6443"),
+				"role":        types.StringNull(),
+				"version":     types.StringNull(),
+				"node_labels": types.ListNull(labelType),
+				"taints":      types.ListNull(types.StringType),
+				"cpu_manager": types.BoolNull(),
+
+				"node_labels_map":      types.MapNull(types.StringType),
+				"auto_topology_labels": types.BoolNull(),
+				"topology_label_key":   types.StringNull(),
+				"auto_labels":          types.BoolNull(),
+				"cloud_provider":       cloudProvider,
+			})
+			return m
+		}
+		for _, tc := range []struct {
+			name          string
+			cloudProvider types.String
+			want          string
+		}{
+			{"external", types.StringNull(), `--kubelet-arg="--cloud-provider=external"`},
+			{"custom", types.StringValue("aws"), `--kubelet-arg="--cloud-provider=aws"`},
+			{"disabled", types.StringValue("none"), ""},
+			{"disabled empty", types.StringValue(""), ""},
+		} {
+			script := buildK3SScript(ctx, *k3sConfig(ctx, block(tc.cloudProvider)), "", "")
+			if tc.want == "" && strings.Contains(script, "--cloud-provider") {
+				t.Errorf("%s: expected no --cloud-provider:\n%s", tc.name, script)
+			} else if !strings.Contains(script, tc.want) {
+				t.Errorf("%s: script is missing %q:\n%s", tc.name, tc.want, script)
+			}
+		}
+
+		var diags diag.Diagnostics
+		validateK3S(ctx, &diags, block(types.StringValue("external; reboot")))
+		if !diags.HasError() || diags.Errors()[0].Summary() != "Invalid cloud_provider" {
+			t.Fatalf("expected an invalid cloud_provider error, got %v", diags)
+		}
+	})
+}
+
+func TestWaitForK3SReady(t *testing.T) {
+	ctx := context.Background()
+	opts := client.PollOptions{Interval: time.Millisecond, MaxInterval: time.Millisecond, MaxElapsed: time.Second}
+
+	var cmds []string
+	run := func(cmd string) (string, error) {
+		cmds = append(cmds, cmd)
+		if len(cmds) < 3 {
+			return "", errors.New("exit status 7")
+		}
+		return "", nil
+	}
+	if err := waitForK3SReady(ctx, run, "https://10.0.0.2:6443", opts); err != nil {
+		t.Fatalf("expected the API to become ready, got %v", err)
+	}
+	if len(cmds) != 3 || cmds[0] != "curl -ks -o /dev/null --max-time 10 'https://10.0.0.2:6443'" {
+		t.Fatalf("unexpected commands: %q", cmds)
+	}
+
+	down := func(string) (string, error) { return "", errors.New("exit status 7") }
+	opts.MaxElapsed = 20 * time.Millisecond
+	if err := waitForK3SReady(ctx, down, "https://10.0.0.2:6443", opts); !errors.Is(err, client.ErrPollTimeout) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	k3sType := k3sAttribute().GetType().(types.ObjectType)
+	validate := func(url string, withK3S bool) diag.Diagnostics {
+		m := configurationModel{
+			K3SToken:        types.StringNull(),
+			K3SURL:          types.StringNull(),
+			K3S:             types.ObjectNull(k3sType.AttrTypes),
+			WaitForK3SReady: types.StringValue(url),
+		}
+		if withK3S {
+			m.K3SToken = types.StringValue("tok")
+			m.K3SURL = types.StringValue("https://10.0.0.2:6443")
+		}
+		var diags diag.Diagnostics
+		validateK3S(ctx, &diags, m)
+		return diags
+	}
+	if diags := validate("https://10.0.0.2:6443", true); diags.HasError() || diags.WarningsCount() != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	if diags := validate("https://10.0.0.2:6443/readyz'; reboot #", true); !diags.HasError() {
+		t.Fatal("expected a URL with shell metacharacters to be rejected")
+	}
+	if diags := validate("https://10.0.0.2:6443", false); diags.WarningsCount() != 1 {
+		t.Fatalf("expected a warning without K3S, got %v", diags)
+	}
+}
+
+func TestReadInstalledK3SVersion(t *testing.T) {
+	var ran string
+	run := func(cmd string) (string, error) {
+		ran = cmd
+		return "k3s version v1.30.2+k3s1 (aa4794bc)\n", nil
+	}
+	version, err := readInstalledK3SVersion(run)
+	if err != nil || version != "v1.30.2+k3s1" {
+		t.Fatalf("expected v1.30.2+k3s1, got %q, %v", version, err)
+	}
+	if ran != k3sVersionCmd {
+		t.Fatalf("expected %q to run, got %q", k3sVersionCmd, ran)
+	}
+
+	for _, out := range []string{"", "bash: k3s: command not found", "k3s version unknown"} {
+		if _, err := readInstalledK3SVersion(func(string) (string, error) { return out, nil }); err == nil {
+			t.Fatalf("expected %q not to parse", out)
+		}
+	}
+	if _, err := readInstalledK3SVersion(func(string) (string, error) { return "", errors.New("exit status 127") }); err == nil {
+		t.Fatalf("expected a failed command to be an error")
+	}
+
+	ctx := context.Background()
+	k3sType := k3sAttribute().GetType().(types.ObjectType)
+	plan := configurationModel{
+		K3SToken:            types.StringValue("tok"),
+		K3SURL:              types.StringValue("https://10.0.0.2:6443"),
+		K3S:                 types.ObjectNull(k3sType.AttrTypes),
+		InstalledK3SVersion: types.StringNull(),
+	}
+	var diags diag.Diagnostics
+	warnInstalledK3SVersion(ctx, &diags, plan)
+	if diags.WarningsCount() != 1 {
+		t.Fatalf("expected a warning when the version could not be read, got %v", diags)
+	}
+	plan.InstalledK3SVersion = types.StringValue("v1.30.2+k3s1")
+	diags = nil
+	warnInstalledK3SVersion(ctx, &diags, plan)
+	if len(diags) != 0 {
+		t.Fatalf("expected no warning with a version, got %v", diags)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	cases := map[string]string{
+		"AX41-NVMe":                    "ax41-nvme",
+		"Server Auction (EX 101)":      "server-auction-ex-101",
+		"  SX134 / 10x16TB HDD  ":      "sx134-10x16tb-hdd",
+		"Dell PowerEdge™ R6515":        "dell-poweredge-r6515",
+		"__":                           "",
+		strings.Repeat("ab ", 30):      strings.TrimRight(strings.Repeat("ab-", 21), "-"),
+		strings.Repeat("x", 62) + "-y": strings.Repeat("x", 62),
+	}
+	for in, want := range cases {
+		got := labelValue(in)
+		if got != want {
+			t.Errorf("labelValue(%q) = %q, want %q", in, got, want)
+		}
+		if len(got) > 63 {
+			t.Errorf("labelValue(%q) is %d characters long", in, len(got))
+		}
+	}
+}
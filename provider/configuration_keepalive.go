@@ -0,0 +1,156 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+)
+
+// vlanIfaceCmd finds the private VLAN interface on an installed server by its VLAN ID, whatever
+// its name (e.g. eno1.4001, bond0.4001 or vlan_interface_name)
+const vlanIfaceCmd = `VLAN_IFACE=$(ip -o -d link show type vlan | awk '/ id 4001 / {sub(/@.*/, "", $2); sub(/:$/, "", $2); print $2; exit}')
+if [ -z "$VLAN_IFACE" ]; then
+    echo "ERROR: no VLAN 4001 interface found" >&2
+    exit 1
+fi
+`
+
+var arpKeepaliveTemplate = template.Must(template.New("arp-keepalive").Option("missingkey=error").Parse(arpKeepaliveScript))
+
+type arpKeepaliveModel struct {
+	Enabled         types.Bool   `tfsdk:"enabled"`
+	GatewayIP       types.String `tfsdk:"gateway_ip"`
+	TestIP          types.String `tfsdk:"test_ip"`
+	IntervalSeconds types.Int64  `tfsdk:"interval_seconds"`
+}
+
+// ARPKeepaliveTemplateData holds the settings of the vlan-arp-keepalive service
+type ARPKeepaliveTemplateData struct {
+	Enabled         bool
+	GatewayIP       string // kept in the ARP cache with gratuitous ARP
+	TestIP          string // pinged every 10th iteration to detect a broken neighbour entry
+	IntervalSeconds int64
+}
+
+func arpKeepaliveAttribute() rschema.SingleNestedAttribute {
+	return rschema.SingleNestedAttribute{
+		Optional:    true,
+		Description: "Settings of the service keeping the private VLAN gateway in the ARP cache. Changes are applied over SSH (with ssh_auth) without a reinstall",
+		Attributes: map[string]rschema.Attribute{
+			"enabled":          rschema.BoolAttribute{Optional: true, Description: "Install the ARP keepalive service (default: true)"},
+			"gateway_ip":       rschema.StringAttribute{Optional: true, Description: "Gateway to keep in the ARP cache (default: 10.1.0.1)"},
+			"test_ip":          rschema.StringAttribute{Optional: true, Description: "Address pinged to check connectivity over the VLAN (default: 10.0.0.2)"},
+			"interval_seconds": rschema.Int64Attribute{Optional: true, Description: "Seconds between keepalives, 1-3600 (default: 5)"},
+		},
+	}
+}
+
+// arpKeepaliveData returns the ARP keepalive settings with defaults applied
+func arpKeepaliveData(ctx context.Context, m configurationModel) ARPKeepaliveTemplateData {
+	data := ARPKeepaliveTemplateData{Enabled: true, GatewayIP: "10.1.0.1", TestIP: "10.0.0.2", IntervalSeconds: 5}
+	if m.ARPKeepalive.IsNull() || m.ARPKeepalive.IsUnknown() {
+		return data
+	}
+	var k arpKeepaliveModel
+	m.ARPKeepalive.As(ctx, &k, basetypes.ObjectAsOptions{})
+	if !k.Enabled.IsNull() && !k.Enabled.IsUnknown() {
+		data.Enabled = k.Enabled.ValueBool()
+	}
+	if !k.GatewayIP.IsNull() && !k.GatewayIP.IsUnknown() {
+		data.GatewayIP = k.GatewayIP.ValueString()
+	}
+	if !k.TestIP.IsNull() && !k.TestIP.IsUnknown() {
+		data.TestIP = k.TestIP.ValueString()
+	}
+	data.IntervalSeconds = int64OrDefault(k.IntervalSeconds, data.IntervalSeconds)
+	return data
+}
+
+// renderARPKeepalive renders the shell snippet installing or removing the service
+func renderARPKeepalive(data ARPKeepaliveTemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := arpKeepaliveTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.Trim(buf.String(), "\n"), nil
+}
+
+// validateARPKeepalive checks arp_keepalive; the addresses end up in a systemd unit, so only
+// plain IPv4 addresses are accepted
+func validateARPKeepalive(ctx context.Context, diags *diag.Diagnostics, config configurationModel) {
+	if config.ARPKeepalive.IsNull() || config.ARPKeepalive.IsUnknown() {
+		return
+	}
+	var k arpKeepaliveModel
+	config.ARPKeepalive.As(ctx, &k, basetypes.ObjectAsOptions{UnhandledUnknownAsEmpty: true})
+	p := path.Root("arp_keepalive")
+
+	for name, v := range map[string]types.String{"gateway_ip": k.GatewayIP, "test_ip": k.TestIP} {
+		if v.IsNull() || v.IsUnknown() {
+			continue
+		}
+		if ip := net.ParseIP(v.ValueString()); ip == nil || ip.To4() == nil {
+			diags.AddAttributeError(p.AtName(name), "Invalid "+name, fmt.Sprintf("%s must be an IPv4 address, got %q.", name, v.ValueString()))
+		}
+	}
+	if !k.IntervalSeconds.IsNull() && !k.IntervalSeconds.IsUnknown() && (k.IntervalSeconds.ValueInt64() < 1 || k.IntervalSeconds.ValueInt64() > 3600) {
+		diags.AddAttributeError(p.AtName("interval_seconds"), "Invalid interval_seconds",
+			fmt.Sprintf("interval_seconds must be between 1 and 3600, got %d.", k.IntervalSeconds.ValueInt64()))
+	}
+}
+
+// applyARPKeepalive rewrites the keepalive service on the installed server over SSH (daemon-reload
+// and restart), so that arp_keepalive changes don't need a reinstall
+func (r *configurationResource) applyARPKeepalive(ctx context.Context, plan configurationModel) (string, string) {
+	data := arpKeepaliveData(ctx, plan)
+	snippet, err := renderARPKeepalive(data)
+	if err != nil {
+		return "render arp keepalive", err.Error()
+	}
+
+	auth, summary, detail := r.sshAuth(ctx, plan)
+	if summary != "" {
+		return summary, detail
+	}
+	conn, closeFn, err := sshx.Connect(sshx.Conn{Host: plan.ServerIP.ValueString(), User: "root", DialTimeout: 1 * time.Minute, Auth: auth, InsecureIgnoreHostKey: true})
+	if err != nil {
+		return "ssh connect failed", fmt.Sprintf("Connecting to %s to update the ARP keepalive service: %v", plan.ServerIP.ValueString(), err)
+	}
+	defer closeFn()
+
+	out, err := sshx.Run(conn, "bash -s <<'HROBOT_EOF'\n"+vlanIfaceCmd+snippet+"\nHROBOT_EOF")
+	if err != nil {
+		return "update arp keepalive failed", fmt.Sprintf("%v\n%s", err, out)
+	}
+	tflog.Info(ctx, "updated ARP keepalive service", map[string]interface{}{
+		"server_number":    plan.ServerNumber.ValueInt64(),
+		"enabled":          data.Enabled,
+		"gateway_ip":       data.GatewayIP,
+		"test_ip":          data.TestIP,
+		"interval_seconds": data.IntervalSeconds,
+	})
+	return "", ""
+}
+
+// readVLANInterface returns the name of the private VLAN interface on the installed server; run
+// executes a command over SSH
+func readVLANInterface(run func(cmd string) (string, error)) (string, error) {
+	out, err := run("bash -s <<'HROBOT_EOF'\n" + vlanIfaceCmd + "echo \"$VLAN_IFACE\"\nHROBOT_EOF")
+	if err != nil {
+		return "", fmt.Errorf("%v\n%s", err, out)
+	}
+	return strings.TrimSpace(out), nil
+}
@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestARPKeepaliveScript(t *testing.T) {
+	ctx := context.Background()
+	keepaliveType := map[string]attr.Type{
+		"enabled":          types.BoolType,
+		"gateway_ip":       types.StringType,
+		"test_ip":          types.StringType,
+		"interval_seconds": types.Int64Type,
+	}
+	bashSyntax := func(t *testing.T, script string) {
+		t.Helper()
+		bash, err := exec.LookPath("bash")
+		if err != nil {
+			return
+		}
+		cmd := exec.Command(bash, "-n")
+		cmd.Stdin = strings.NewReader(script)
+		if msg, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("not valid bash: %v\n%s", err, msg)
+		}
+	}
+
+	defaults := arpKeepaliveData(ctx, configurationModel{ARPKeepalive: types.ObjectNull(keepaliveType)})
+	if defaults != (ARPKeepaliveTemplateData{Enabled: true, GatewayIP: "10.1.0.1", TestIP: "10.0.0.2", IntervalSeconds: 5}) {
+		t.Fatalf("unexpected defaults: %+v", defaults)
+	}
+	snippet, err := renderARPKeepalive(defaults)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(snippet, "ExecStart=/usr/local/bin/vlan-arp-keepalive.sh ${VLAN_IFACE} 10.0.0.2 10.1.0.1 5\n") {
+		t.Fatalf("unit does not pass the default settings:\n%s", snippet)
+	}
+	first, err := renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{LocalIP: "10.1.0.5", ARPKeepalive: snippet})
+	if err != nil {
+		t.Fatalf("render first-run: %v", err)
+	}
+	bashSyntax(t, first)
+	if !strings.Contains(first, `VLAN_IFACE="${DEFAULT_IFACE}.4001"`) || !strings.Contains(first, "systemctl enable vlan-arp-keepalive.service") {
+		t.Fatalf("first-run script does not install the keepalive service")
+	}
+
+	custom, _ := types.ObjectValueFrom(ctx, keepaliveType, arpKeepaliveModel{
+		Enabled:         types.BoolNull(),
+		GatewayIP:       types.StringValue("10.1.0.254"),
+		TestIP:          types.StringNull(),
+		IntervalSeconds: types.Int64Value(30),
+	})
+	snippet, _ = renderARPKeepalive(arpKeepaliveData(ctx, configurationModel{ARPKeepalive: custom}))
+	if !strings.Contains(snippet, "vlan-arp-keepalive.sh ${VLAN_IFACE} 10.0.0.2 10.1.0.254 30\n") {
+		t.Fatalf("unit does not pass the custom settings:\n%s", snippet)
+	}
+	bashSyntax(t, vlanIfaceCmd+snippet)
+
+	disabled, _ := types.ObjectValueFrom(ctx, keepaliveType, arpKeepaliveModel{
+		Enabled:         types.BoolValue(false),
+		GatewayIP:       types.StringNull(),
+		TestIP:          types.StringNull(),
+		IntervalSeconds: types.Int64Null(),
+	})
+	snippet, _ = renderARPKeepalive(arpKeepaliveData(ctx, configurationModel{ARPKeepalive: disabled}))
+	if strings.Contains(snippet, "SCRIPT_EOF") || !strings.Contains(snippet, "systemctl disable --now vlan-arp-keepalive.service") {
+		t.Fatalf("disabled keepalive must remove the service:\n%s", snippet)
+	}
+	bashSyntax(t, vlanIfaceCmd+snippet)
+
+	invalid, _ := types.ObjectValueFrom(ctx, keepaliveType, arpKeepaliveModel{
+		Enabled:         types.BoolNull(),
+		GatewayIP:       types.StringValue("10.1.0.1; reboot"),
+		TestIP:          types.StringValue("fe80::1"),
+		IntervalSeconds: types.Int64Value(0),
+	})
+	var diags diag.Diagnostics
+	validateARPKeepalive(ctx, &diags, configurationModel{ARPKeepalive: invalid})
+	if len(diags.Errors()) != 3 {
+		t.Fatalf("expected gateway_ip, test_ip and interval_seconds errors, got %v", diags)
+	}
+}
+
+func TestUpdateARPKeepalive(t *testing.T) {
+	var ssh testSSHSteps
+	res := &configurationResource{providerData: testProviderData(t, testServer111), ssh: ssh.steps()}
+	_, objType := configurationType(t)
+	keepalive := func(interval int) tftypes.Value {
+		kt := objType.AttributeTypes["arp_keepalive"].(tftypes.Object)
+		return tftypes.NewValue(kt, map[string]tftypes.Value{
+			"enabled":          tftypes.NewValue(tftypes.Bool, nil),
+			"gateway_ip":       tftypes.NewValue(tftypes.String, nil),
+			"test_ip":          tftypes.NewValue(tftypes.String, nil),
+			"interval_seconds": tftypes.NewValue(tftypes.Number, interval),
+		})
+	}
+	version := tftypes.NewValue(tftypes.Number, 3)
+
+	// A changed keepalive is rewritten over SSH, the version is unchanged so nothing is reinstalled
+	resp := updateConfiguration(t, res,
+		configurationRaw(t, map[string]tftypes.Value{"version": version, "arp_keepalive": keepalive(10)}),
+		configurationRaw(t, map[string]tftypes.Value{"version": version, "arp_keepalive": keepalive(30)}))
+	if resp.Diagnostics.HasError() || ssh.configured != 0 || ssh.keepalives != 1 {
+		t.Fatalf("expected the keepalive to be rewritten in place, got %+v: %v", ssh, resp.Diagnostics)
+	}
+
+	ssh = testSSHSteps{}
+	resp = updateConfiguration(t, res,
+		configurationRaw(t, map[string]tftypes.Value{"version": version, "arp_keepalive": keepalive(30)}),
+		configurationRaw(t, map[string]tftypes.Value{"version": version, "arp_keepalive": keepalive(30)}))
+	if resp.Diagnostics.HasError() || ssh != (testSSHSteps{}) {
+		t.Fatalf("expected nothing to run for an unchanged keepalive, got %+v: %v", ssh, resp.Diagnostics)
+	}
+}
@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+)
+
+// rotateKeysInPlace reports whether an update only changes rescue_authorized_key_fingerprints in a
+// way that can be applied over SSH: no reinstall is pending and the keys are not ephemeral
+func rotateKeysInPlace(plan, state configurationModel, reinstall bool) bool {
+	if reinstall || plan.RescueKeyFPs.IsUnknown() || plan.RescueKeyFPs.Equal(state.RescueKeyFPs) {
+		return false
+	}
+	return plan.UseEphemeralSSHKey.IsNull() || !plan.UseEphemeralSSHKey.ValueBool()
+}
+
+// keepsWorkingKey reports whether one of the new fingerprints is a key the provider logs in with,
+// i.e. it can still log in once the old keys are gone
+func keepsWorkingKey(fingerprints, loginFingerprints []string) bool {
+	loaded := make(map[string]bool, len(loginFingerprints))
+	for _, fp := range loginFingerprints {
+		loaded[strings.ToLower(fp)] = true
+	}
+	for _, fp := range fingerprints {
+		if loaded[strings.ToLower(fp)] {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizedKeysScript replaces root's authorized_keys with keys and, on encrypted installs, the
+// dropbear keys in the initramfs so the remote unlock keys rotate too
+func authorizedKeysScript(keys []string) string {
+	return fmt.Sprintf(`set -euo pipefail
+umask 077
+mkdir -p /root/.ssh
+cat > /root/.ssh/authorized_keys.new <<'HROBOT_KEYS'
+%s
+HROBOT_KEYS
+mv /root/.ssh/authorized_keys.new /root/.ssh/authorized_keys
+if [ -d /etc/dropbear/initramfs ]; then
+  cp /root/.ssh/authorized_keys /etc/dropbear/initramfs/authorized_keys
+  chmod 600 /etc/dropbear/initramfs/authorized_keys
+  update-initramfs -u
+fi
+`, strings.Join(keys, "\n"))
+}
+
+// rotateAuthorizedKeys writes the keys of the given fingerprints to the installed OS, logging in
+// with the configured SSH auth (which still holds an old key). It refuses a key set that would
+// leave that auth without a key the server accepts
+func (r *configurationResource) rotateAuthorizedKeys(ctx context.Context, fingerprints []string, plan configurationModel) (string, string) {
+	if len(fingerprints) == 0 {
+		return "no ssh keys", "rescue_authorized_key_fingerprints cannot be emptied on an installed server; that would lock out every login."
+	}
+
+	auth, summary, detail := r.sshAuth(ctx, plan)
+	if summary != "" {
+		return summary, detail
+	}
+	// Password logins don't depend on the keys; key logins must keep one of theirs
+	if auth.Method() != sshAuthPassword {
+		loginFingerprints, err := auth.Fingerprints()
+		if err != nil {
+			return "ssh keys unavailable", fmt.Sprintf("Listing the %s keys to check the new key set: %v", auth.Method(), err)
+		}
+		if !keepsWorkingKey(fingerprints, loginFingerprints) {
+			return "key rotation would lock out the provider",
+				fmt.Sprintf("None of the new rescue_authorized_key_fingerprints is a key the provider logs in with (%s auth), so once the old keys are replaced nothing could log in to the server. Include that key, or rotate in two steps: add the new key, apply, then remove the old one.", auth.Method())
+		}
+	}
+
+	stored, err := r.providerData.Client.ListSSHKeys()
+	if err != nil {
+		return "list ssh keys failed", robotErrorDetail(err, "read SSH keys", "Key")
+	}
+	if unknown := unknownFingerprints(fingerprints, stored); len(unknown) > 0 {
+		return "unknown ssh keys", fmt.Sprintf("These fingerprints are not stored in Robot: %s", strings.Join(unknown, ", "))
+	}
+	byFingerprint := make(map[string]string, len(stored))
+	for _, k := range stored {
+		byFingerprint[strings.ToLower(k.Fingerprint)] = strings.TrimSpace(k.Data)
+	}
+	keys := make([]string, 0, len(fingerprints))
+	for _, fp := range fingerprints {
+		key := byFingerprint[strings.ToLower(fp)]
+		if strings.ContainsAny(key, "\r\n") {
+			return "invalid ssh key", fmt.Sprintf("The public key stored in Robot for %s spans several lines.", fp)
+		}
+		keys = append(keys, key)
+	}
+
+	conn, closeFn, err := sshx.Connect(sshx.Conn{Host: plan.ServerIP.ValueString(), User: "root", DialTimeout: 1 * time.Minute, Auth: auth, InsecureIgnoreHostKey: true})
+	if err != nil {
+		return "ssh connect failed", fmt.Sprintf("Connecting to %s to rotate the authorized keys: %v", plan.ServerIP.ValueString(), err)
+	}
+	defer closeFn()
+
+	out, err := sshx.Run(conn, "bash -s <<'HROBOT_EOF'\n"+authorizedKeysScript(keys)+"HROBOT_EOF")
+	if err != nil {
+		return "rotate authorized keys failed", fmt.Sprintf("%v\n%s", err, out)
+	}
+	tflog.Info(ctx, "rotated authorized keys", map[string]interface{}{
+		"server_number": plan.ServerNumber.ValueInt64(),
+		"keys":          len(keys),
+	})
+	return "", ""
+}
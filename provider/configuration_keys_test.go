@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestKeyRotation(t *testing.T) {
+	list := func(fps ...string) types.List {
+		return types.ListValueMust(types.StringType, func() []attr.Value {
+			out := make([]attr.Value, len(fps))
+			for i, fp := range fps {
+				out[i] = types.StringValue(fp)
+			}
+			return out
+		}())
+	}
+	state := configurationModel{RescueKeyFPs: list("aa:01", "aa:02"), UseEphemeralSSHKey: types.BoolNull()}
+
+	plan := state
+	plan.RescueKeyFPs = list("aa:02", "aa:03")
+	if !rotateKeysInPlace(plan, state, false) {
+		t.Fatal("expected a key-only change to rotate in place")
+	}
+	if rotateKeysInPlace(plan, state, true) {
+		t.Fatal("a pending reinstall installs the new keys itself")
+	}
+	if rotateKeysInPlace(state, state, false) {
+		t.Fatal("unchanged keys need no rotation")
+	}
+	ephemeral := plan
+	ephemeral.UseEphemeralSSHKey = types.BoolValue(true)
+	if rotateKeysInPlace(ephemeral, state, false) {
+		t.Fatal("ephemeral keys are not written to authorized_keys")
+	}
+
+	agent := []string{"AA:02"}
+	if !keepsWorkingKey([]string{"aa:02", "aa:03"}, agent) {
+		t.Fatal("expected the agent key aa:02 to keep working")
+	}
+	if keepsWorkingKey([]string{"aa:03"}, agent) || keepsWorkingKey([]string{"aa:03"}, nil) {
+		t.Fatal("expected removing the last agent key to be refused")
+	}
+
+	script := authorizedKeysScript([]string{"ssh-ed25519 AAAAC3Nza one", "ssh-rsa AAAAB3Nza two"})
+	for _, want := range []string{"ssh-ed25519 AAAAC3Nza one\nssh-rsa AAAAB3Nza two\nHROBOT_KEYS", "/etc/dropbear/initramfs/authorized_keys", "update-initramfs -u"} {
+		if !strings.Contains(script, want) {
+			t.Fatalf("script missing %q:\n%s", want, script)
+		}
+	}
+	if out, err := exec.Command("bash", "-n", "-c", script).CombinedOutput(); err != nil {
+		t.Fatalf("script does not parse: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+)
+
+// luksVerifyScript checks, on the rebooted server, what an unattended boot needs: the keyfile in
+// the initramfs of the running kernel, a keyslot for it next to the passphrase, the keyfile
+// opening the device, and a crypt unit that didn't fail. Each failed check prints a FAIL line
+const luksVerifyScript = `
+KEYFILE=/etc/luks-keys/boot.key
+STATUS=0
+fail() { echo "FAIL: $*"; STATUS=1; }
+
+INITRD="/boot/initrd.img-$(uname -r)"
+if [ ! -s "$KEYFILE" ]; then
+    fail "keyfile $KEYFILE is missing or empty"
+elif ! lsinitramfs "$INITRD" 2>/dev/null | grep -q "etc/luks-keys/boot.key"; then
+    fail "keyfile is not in $INITRD; the next unattended boot would stop at the passphrase prompt"
+fi
+
+NAME=$(awk '!/^#/ && NF {print $1; exit}' /etc/crypttab)
+DEVICE=""
+if [ -z "$NAME" ]; then
+    fail "/etc/crypttab has no entry"
+else
+    DEVICE=$(cryptsetup status "$NAME" 2>/dev/null | awk '$1 == "device:" {print $2}')
+    [ -n "$DEVICE" ] || fail "crypt device $NAME is not open"
+    if systemctl is-failed --quiet "systemd-cryptsetup@$(systemd-escape "$NAME").service"; then
+        fail "systemd-cryptsetup@$NAME.service failed: $(systemctl status --no-pager "systemd-cryptsetup@$(systemd-escape "$NAME").service" 2>&1 | head -5 | tr '\n' ' ')"
+    fi
+fi
+
+if [ -n "$DEVICE" ]; then
+    SLOTS=$(cryptsetup luksDump "$DEVICE" 2>/dev/null | grep -cE '^[[:space:]]+[0-9]+: luks2$|^Key Slot [0-9]+: ENABLED')
+    [ "$SLOTS" -ge 2 ] || fail "$DEVICE has $SLOTS keyslot(s), expected the passphrase and the keyfile"
+    if [ -s "$KEYFILE" ] && ! cryptsetup open --test-passphrase --key-file "$KEYFILE" "$DEVICE" 2>/dev/null; then
+        fail "keyfile $KEYFILE does not open $DEVICE"
+    fi
+fi
+
+[ "$STATUS" -eq 0 ] && echo "LUKS auto-unlock verified"
+exit $STATUS
+`
+
+// verifyLUKSAutoUnlock runs luksVerifyScript on the server (run executes a command over SSH) and
+// returns the failed checks
+func verifyLUKSAutoUnlock(run func(cmd string) (string, error)) error {
+	out, err := run(luksVerifyScript)
+	return failedChecks(out, err)
+}
+
+// failedChecks turns the FAIL lines a check script printed before exiting non-zero into an error
+func failedChecks(out string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var failed []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "FAIL: ") {
+			failed = append(failed, "- "+strings.TrimPrefix(line, "FAIL: "))
+		}
+	}
+	if len(failed) == 0 {
+		return fmt.Errorf("verification did not run: %v\n%s", err, out)
+	}
+	return fmt.Errorf("%s", strings.Join(failed, "\n"))
+}
@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestVerifyLUKSAutoUnlock(t *testing.T) {
+	if bash, err := exec.LookPath("bash"); err == nil {
+		if out, err := exec.Command(bash, "-n", "-c", luksVerifyScript).CombinedOutput(); err != nil {
+			t.Fatalf("luksVerifyScript has a syntax error: %v\n%s", err, out)
+		}
+	}
+
+	ok := func(string) (string, error) { return "LUKS auto-unlock verified\n", nil }
+	if err := verifyLUKSAutoUnlock(ok); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	failed := func(string) (string, error) {
+		return "FAIL: keyfile is not in /boot/initrd.img-6.8.0-45-generic; the next unattended boot would stop at the passphrase prompt\n" +
+			"FAIL: /dev/md2 has 1 keyslot(s), expected the passphrase and the keyfile\n", fmt.Errorf("Process exited with status 1")
+	}
+	err := verifyLUKSAutoUnlock(failed)
+	if err == nil || !strings.Contains(err.Error(), "- keyfile is not in /boot/initrd.img-6.8.0-45-generic") || !strings.Contains(err.Error(), "- /dev/md2 has 1 keyslot(s)") {
+		t.Fatalf("expected both failed checks, got %v", err)
+	}
+
+	broken := func(string) (string, error) {
+		return "bash: lsinitramfs: command not found", fmt.Errorf("connection lost")
+	}
+	if err := verifyLUKSAutoUnlock(broken); err == nil || !strings.Contains(err.Error(), "did not run") {
+		t.Fatalf("expected an error when no check reported, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+	"github.com/mokto/terraform-provider-hrobot/internal/tfutil"
+)
+
+// forceRebootRequested reports whether force_reboot was switched on by this update. It is an
+// edge trigger: keeping it true doesn't reboot again, and a reinstall reboots anyway
+func forceRebootRequested(plan, state configurationModel) bool {
+	return plan.ForceReboot.ValueBool() && !state.ForceReboot.ValueBool()
+}
+
+// rebootServer reboots the installed OS over SSH and waits until SSH answers again
+func (r *configurationResource) rebootServer(ctx context.Context, plan configurationModel) (string, string) {
+	ip := plan.ServerIP.ValueString()
+	auth, summary, detail := r.sshAuth(ctx, plan)
+	if summary != "" {
+		return summary, detail
+	}
+	conn, closeFn, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", DialTimeout: 30 * time.Second, Auth: auth, InsecureIgnoreHostKey: true})
+	if err != nil {
+		return "ssh connect failed", fmt.Sprintf("Connecting to %s to reboot it: %v", ip, err)
+	}
+	tflog.Info(ctx, "force_reboot: rebooting server", map[string]interface{}{
+		"server_number": plan.ServerNumber.ValueInt64(),
+		"ip":            ip,
+	})
+	// The connection drops when the reboot starts, so the result is not checked
+	_, _ = sshx.Run(conn, "nohup reboot > /dev/null 2>&1 &")
+	closeFn()
+
+	time.Sleep(bootDelay(plan))
+	if err := tfutil.WaitForPort(ctx, ip+":22", rebootTimeout(plan)); err != nil {
+		return "reboot ssh timeout", fmt.Sprintf("SSH on %s did not come back within %s after force_reboot: %v. Check the server in the Robot console (KVM) or trigger a hardware reset.", ip, rebootTimeout(plan), err)
+	}
+	tflog.Info(ctx, "force_reboot: server back online", map[string]interface{}{
+		"server_number": plan.ServerNumber.ValueInt64(),
+	})
+	return "", ""
+}
+
+// k3sServiceName returns the systemd unit the K3S installer creates for role
+func k3sServiceName(role string) string {
+	if role == k3sRoleServer {
+		return "k3s"
+	}
+	return "k3s-agent"
+}
+
+// rebootCheckScript checks, for up to two minutes after the reboot of verify_reboot, that service
+// (when set) is active and localIP (when set) is on an interface. It prints a FAIL line for each
+// check that still fails at the end
+func rebootCheckScript(service, localIP string) string {
+	var script strings.Builder
+	script.WriteString("for i in $(seq 1 24); do\n    FAILED=\"\"\n")
+	if service != "" {
+		fmt.Fprintf(&script, "    systemctl is-active --quiet %[1]s || FAILED=\"${FAILED}FAIL: %[1]s is $(systemctl is-active %[1]s)\\n\"\n", service)
+	}
+	if localIP != "" {
+		fmt.Fprintf(&script, "    ip -o addr show | awk '{print $4}' | cut -d/ -f1 | grep -qxF %[1]s || FAILED=\"${FAILED}FAIL: local_ip %[1]s is not on any interface\\n\"\n", localIP)
+	}
+	script.WriteString("    [ -z \"$FAILED\" ] && { echo \"services healthy\"; exit 0; }\n    sleep 5\ndone\nprintf \"%b\" \"$FAILED\"\nexit 1\n")
+	return script.String()
+}
+
+// verifyReboot reboots the configured server once more without anyone at the console and checks
+// that it comes back with K3S and local_ip; conn is the connection to the installed OS. The
+// results are recorded in boot_seconds and services_healthy
+func (r *configurationResource) verifyReboot(ctx context.Context, conn *sshx.Handle, auth sshx.Auth, ip string, plan *configurationModel) *ConfigureError {
+	tflog.Info(ctx, "verify_reboot: rebooting server", map[string]interface{}{
+		"server_number": plan.ServerNumber.ValueInt64(),
+		"ip":            ip,
+	})
+	// The connection drops when the reboot starts, so the result is not checked
+	_, _ = sshx.Run(conn, "nohup reboot > /dev/null 2>&1 &")
+	start := time.Now()
+
+	time.Sleep(bootDelay(*plan))
+	if err := tfutil.WaitForPort(ctx, ip+":22", rebootTimeout(*plan)); err != nil {
+		return configureError(configurePhaseOSBoot, "verify reboot ssh timeout",
+			fmt.Sprintf("SSH on %s did not come back within %s after the verification reboot: %v. The LUKS auto-unlock or the network configuration may not survive an unattended boot; unlock it over dropbear on port 2222 or check the Robot console (KVM).", ip, rebootTimeout(*plan), err))
+	}
+	plan.BootSeconds = types.Int64Value(int64(time.Since(start).Seconds()))
+
+	checkConn, closeFn, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", DialTimeout: 3 * time.Minute, Auth: auth, InsecureIgnoreHostKey: true})
+	if err != nil {
+		return configureError(configurePhaseOSBoot, "verify reboot ssh connect", err.Error())
+	}
+	defer closeFn()
+
+	service := ""
+	if k3s := k3sConfig(ctx, *plan); k3s != nil {
+		service = k3sServiceName(k3s.Role)
+	}
+	localIP := ""
+	if !plan.LocalIP.IsNull() && !plan.LocalIP.IsUnknown() {
+		localIP = plan.LocalIP.ValueString()
+	}
+	out, err := sshx.Run(checkConn, rebootCheckScript(service, localIP))
+	err = failedChecks(out, err)
+	plan.ServicesHealthy = types.BoolValue(err == nil)
+	tflog.Info(ctx, "verify_reboot: server back online", map[string]interface{}{
+		"server_number":    plan.ServerNumber.ValueInt64(),
+		"boot_seconds":     plan.BootSeconds.ValueInt64(),
+		"services_healthy": err == nil,
+	})
+	if err != nil {
+		return configureError(configurePhasePostInstall, "reboot verification failed",
+			fmt.Sprintf("The server came back %d seconds after the verification reboot, but:\n%s", plan.BootSeconds.ValueInt64(), err))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestUpdateForceReboot(t *testing.T) {
+	var ssh testSSHSteps
+	res := &configurationResource{providerData: testProviderData(t, testServer111), ssh: ssh.steps()}
+	version := func(v int) tftypes.Value { return tftypes.NewValue(tftypes.Number, v) }
+	forceReboot := func(v bool) tftypes.Value { return tftypes.NewValue(tftypes.Bool, v) }
+
+	// Switching force_reboot on with an unchanged version reboots without a reinstall
+	resp := updateConfiguration(t, res,
+		configurationRaw(t, map[string]tftypes.Value{"version": version(3), "force_reboot": forceReboot(false)}),
+		configurationRaw(t, map[string]tftypes.Value{"version": version(3), "force_reboot": forceReboot(true)}))
+	if resp.Diagnostics.HasError() || ssh.configured != 0 || ssh.rebooted != 1 {
+		t.Fatalf("expected a reboot only, got %+v: %v", ssh, resp.Diagnostics)
+	}
+
+	// Keeping it on does nothing
+	ssh = testSSHSteps{}
+	resp = updateConfiguration(t, res,
+		configurationRaw(t, map[string]tftypes.Value{"version": version(3), "force_reboot": forceReboot(true)}),
+		configurationRaw(t, map[string]tftypes.Value{"version": version(3), "force_reboot": forceReboot(true)}))
+	if resp.Diagnostics.HasError() || ssh != (testSSHSteps{}) {
+		t.Fatalf("expected nothing to run, got %+v: %v", ssh, resp.Diagnostics)
+	}
+
+	// A version bump reinstalls, which reboots anyway
+	resp = updateConfiguration(t, res,
+		configurationRaw(t, map[string]tftypes.Value{"version": version(3), "force_reboot": forceReboot(false)}),
+		configurationRaw(t, map[string]tftypes.Value{"version": version(4), "force_reboot": forceReboot(true)}))
+	if resp.Diagnostics.HasError() || ssh.configured != 1 || ssh.rebooted != 0 {
+		t.Fatalf("expected a reinstall only, got %+v: %v", ssh, resp.Diagnostics)
+	}
+}
+
+func TestVerifyReboot(t *testing.T) {
+	if k3sServiceName(k3sRoleServer) != "k3s" || k3sServiceName(k3sRoleAgent) != "k3s-agent" {
+		t.Fatalf("unexpected K3S unit names %q, %q", k3sServiceName(k3sRoleServer), k3sServiceName(k3sRoleAgent))
+	}
+
+	script := rebootCheckScript("k3s-agent", "10.0.1.5")
+	if bash, err := exec.LookPath("bash"); err == nil {
+		if out, err := exec.Command(bash, "-n", "-c", script).CombinedOutput(); err != nil {
+			t.Fatalf("rebootCheckScript has a syntax error: %v\n%s", err, out)
+		}
+	}
+	for _, want := range []string{"systemctl is-active --quiet k3s-agent", "grep -qxF 10.0.1.5"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script does not contain %q:\n%s", want, script)
+		}
+	}
+	if script := rebootCheckScript("", ""); strings.Contains(script, "systemctl") || strings.Contains(script, "ip -o") {
+		t.Errorf("script checks something without K3S and local_ip:\n%s", script)
+	}
+
+	err := failedChecks("FAIL: k3s-agent is activating\n", fmt.Errorf("Process exited with status 1"))
+	if err == nil || err.Error() != "- k3s-agent is activating" {
+		t.Fatalf("expected the failed check, got %v", err)
+	}
+
+	if got := rebootTimeout(configurationModel{RebootTimeout: types.Int64Null()}); got != 20*time.Minute {
+		t.Fatalf("expected a 20m default, got %s", got)
+	}
+	if got := rebootTimeout(configurationModel{RebootTimeout: types.Int64Value(45)}); got != 45*time.Minute {
+		t.Fatalf("expected 45m, got %s", got)
+	}
+}
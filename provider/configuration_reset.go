@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+	"github.com/mokto/terraform-provider-hrobot/internal/tfutil"
+)
+
+// resetPreference are the automatic reset types in order of preference: a hardware reset works
+// whatever state the OS is in, a software reset needs it to respond
+var resetPreference = []string{"hw", "sw"}
+
+// wait_for_manual_reset polls this long, this often, for the rescue system after a reset done by hand
+const (
+	manualResetWait = 2 * time.Hour
+	manualResetPoll = 30 * time.Second
+)
+
+// bestResetType returns the preferred automatic reset type among those Robot offers, "" when there
+// is none (only a manual power cycle)
+func bestResetType(offered []string) string {
+	for _, typ := range resetPreference {
+		if containsString(offered, typ) {
+			return typ
+		}
+	}
+	return ""
+}
+
+// resetType picks the reset for a server from the types Robot offers. When they can't be read, a
+// hardware reset is tried as before the check existed
+func (r *configurationResource) resetType(ctx context.Context, serverNumber int) (string, []string) {
+	options, err := r.providerData.Client.GetResetOptions(serverNumber)
+	if err != nil || len(options.Type) == 0 {
+		fields := map[string]interface{}{"server_number": serverNumber}
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+		tflog.Warn(ctx, "could not read the reset options, trying a hardware reset", fields)
+		return "hw", nil
+	}
+	return bestResetType(options.Type), options.Type
+}
+
+// isRescueSystem reports whether run executes in the Hetzner rescue system, whose hostname is rescue
+func isRescueSystem(run func(cmd string) (string, error)) bool {
+	out, err := run("hostname")
+	return err == nil && strings.TrimSpace(out) == "rescue"
+}
+
+// waitForRescue polls until a login through connect lands in the rescue system. Until the server
+// is reset by hand the installed OS may still answer on port 22, so an open port is not enough
+func waitForRescue(ctx context.Context, connect func() (func(cmd string) (string, error), func(), error), timeout, poll time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if run, closeFn, err := connect(); err == nil {
+			rescue := isRescueSystem(run)
+			closeFn()
+			if rescue {
+				return nil
+			}
+		}
+		if time.Now().Add(poll).After(deadline) {
+			return fmt.Errorf("the rescue system did not answer over SSH within %s", timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// manualResetDetail tells the user how to get a server without automatic reset into the rescue system
+func manualResetDetail(serverNumber int64, offered []string) string {
+	return fmt.Sprintf("Robot offers no hardware or software reset for server %d (reset types: %s), as on older servers without IPMI. "+
+		"The rescue system is activated: order a manual power cycle in Robot (Server > Reset > \"Order a manual power cycle\") or through Hetzner support.",
+		serverNumber, strings.Join(offered, ", "))
+}
+
+// resetIntoRescue resets the server into the activated rescue system and waits for its SSH, using
+// the best reset Robot offers. Without an automatic reset it fails with what to do, or with
+// wait_for_manual_reset waits for someone to power cycle the server
+func (r *configurationResource) resetIntoRescue(ctx context.Context, plan *configurationModel, ip string, auth sshx.Auth) *ConfigureError {
+	serverNumber := int(plan.ServerNumber.ValueInt64())
+	typ, offered := r.resetType(ctx, serverNumber)
+
+	if typ == "" {
+		detail := manualResetDetail(plan.ServerNumber.ValueInt64(), offered)
+		if !plan.WaitForManualReset.ValueBool() {
+			return configureError(configurePhaseRescue, "no automatic reset available",
+				detail+" Then apply again, or set wait_for_manual_reset = true to have the apply wait for the reset.")
+		}
+		tflog.Warn(ctx, "no automatic reset available, waiting for a manual reset into the rescue system", map[string]interface{}{
+			"server_number": serverNumber,
+			"reset_types":   offered,
+			"timeout":       manualResetWait.String(),
+		})
+		connect := func() (func(cmd string) (string, error), func(), error) {
+			conn, closeFn, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", DialTimeout: sshDialTimeout, Auth: auth, InsecureIgnoreHostKey: true})
+			if err != nil {
+				return nil, nil, err
+			}
+			return func(cmd string) (string, error) { return sshx.Run(conn, cmd) }, closeFn, nil
+		}
+		if err := waitForRescue(ctx, connect, manualResetWait, manualResetPoll); err != nil {
+			return configureError(configurePhaseRescue, "manual reset timeout", fmt.Sprintf("%s\n\nWaited for the manual reset: %v", detail, err))
+		}
+		r.providerData.CacheManager.InvalidateServer(serverNumber)
+		tflog.Info(ctx, "server reset by hand, rescue system available", map[string]interface{}{
+			"server_number": serverNumber,
+		})
+		return nil
+	}
+
+	if typ != resetPreference[0] {
+		tflog.Warn(ctx, "hardware reset not offered for this server, falling back", map[string]interface{}{
+			"server_number": serverNumber,
+			"reset_type":    typ,
+			"reset_types":   offered,
+		})
+	}
+	if err := r.providerData.Client.Reset(serverNumber, typ); err != nil {
+		return &ConfigureError{Phase: configurePhaseRescue, Summary: "reset failed", Detail: robotErrorDetail(err, "reset servers", "Reset"), Recoverable: client.IsRateLimited(err)}
+	}
+	r.providerData.CacheManager.InvalidateServer(serverNumber)
+
+	tflog.Info(ctx, "server reset completed", map[string]interface{}{
+		"server_number": serverNumber,
+		"reset_type":    typ,
+	})
+
+	waitMin := int64(5)
+	tflog.Info(ctx, "waiting for SSH to become available", map[string]interface{}{
+		"server_number":   serverNumber,
+		"server_ip":       ip,
+		"timeout_minutes": waitMin,
+	})
+
+	if err := tfutil.WaitForPort(ctx, ip+":22", time.Duration(waitMin)*time.Minute); err != nil {
+		return recoverableError(configurePhaseRescue, "rescue ssh timeout", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+)
+
+func TestResetIntoRescue(t *testing.T) {
+	for _, tc := range []struct {
+		offered []string
+		want    string
+	}{
+		{[]string{"sw", "hw", "man", "power", "power_long"}, "hw"},
+		{[]string{"sw", "man"}, "sw"},
+		{[]string{"man"}, ""},
+		{nil, ""},
+	} {
+		if got := bestResetType(tc.offered); got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.offered, got, tc.want)
+		}
+	}
+
+	var resets int32
+	offered := `["man"]`
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/reset/111":
+			_, _ = w.Write([]byte(`{"reset":{"server_number":111,"type":` + offered + `}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/reset/111":
+			atomic.AddInt32(&resets, 1)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	res := &configurationResource{providerData: pd}
+
+	ctx := context.Background()
+	plan := configurationModel{ServerNumber: types.Int64Value(111), WaitForManualReset: types.BoolNull()}
+	cerr := res.resetIntoRescue(ctx, &plan, "1.2.3.4", sshx.AuthFromAgent(""))
+	if cerr == nil || cerr.Summary != "no automatic reset available" || cerr.Recoverable ||
+		!strings.Contains(cerr.Detail, "manual power cycle") || !strings.Contains(cerr.Detail, "wait_for_manual_reset") {
+		t.Fatalf("expected an actionable error, got %+v", cerr)
+	}
+	if resets != 0 {
+		t.Fatalf("expected no reset to be sent, got %d", resets)
+	}
+
+	offered = `["sw","man"]`
+	if typ, _ := res.resetType(ctx, 111); typ != "sw" {
+		t.Fatalf("expected a fallback to sw, got %q", typ)
+	}
+	if typ, _ := res.resetType(ctx, 222); typ != "hw" {
+		t.Fatalf("expected hw when the options can't be read, got %q", typ)
+	}
+
+	// The installed OS answers until the manual reset, then the rescue system does
+	hostnames := []string{"", "web-1", "rescue"}
+	connect := func() (func(cmd string) (string, error), func(), error) {
+		hostname := hostnames[0]
+		hostnames = hostnames[1:]
+		if hostname == "" {
+			return nil, nil, fmt.Errorf("connection refused")
+		}
+		return func(string) (string, error) { return hostname + "\n", nil }, func() {}, nil
+	}
+	if err := waitForRescue(ctx, connect, time.Second, time.Millisecond); err != nil || len(hostnames) != 0 {
+		t.Fatalf("expected to wait for the rescue system, got %v with %v left", err, hostnames)
+	}
+	installed := func() (func(cmd string) (string, error), func(), error) {
+		return func(string) (string, error) { return "web-1\n", nil }, func() {}, nil
+	}
+	if err := waitForRescue(ctx, installed, 20*time.Millisecond, 5*time.Millisecond); err == nil {
+		t.Fatalf("expected a timeout while the installed OS answers")
+	}
+}
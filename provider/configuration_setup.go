@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+)
+
+// setupCheckTimeout bounds the SSH connection Read uses to check setup_complete, so an
+// unreachable server doesn't hold up the refresh
+const setupCheckTimeout = 10 * time.Second
+
+// setupIncomplete reports whether the state records a configuration that was started but not
+// finished; null (states written before setup_complete existed) counts as finished
+func setupIncomplete(m configurationModel) bool {
+	return !m.SetupComplete.IsNull() && !m.SetupComplete.IsUnknown() && !m.SetupComplete.ValueBool()
+}
+
+// reinstallUnchanged reports whether the provider sets allow_reinstall_without_version_change, so
+// every apply reinstalls the server
+func (r *configurationResource) reinstallUnchanged() bool {
+	return r.providerData != nil && r.providerData.AllowReinstallWithoutVersionChange
+}
+
+// ModifyPlan plans an update for a server whose setup did not complete, or for every server when
+// the provider allows reinstalls without a version change, so the next apply runs the
+// configuration again. It also detaches the server from a vswitch_id removed from the
+// configuration
+func (r *configurationResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() || req.State.Raw.IsNull() {
+		return
+	}
+	planVSwitchID(ctx, req, resp)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var setupComplete types.Bool
+	resp.Diagnostics.Append(req.State.GetAttribute(ctx, path.Root("setup_complete"), &setupComplete)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	switch {
+	case setupIncomplete(configurationModel{SetupComplete: setupComplete}):
+		resp.Diagnostics.AddWarning("Server setup incomplete",
+			"The last configuration of this server did not complete, so it will be configured again.")
+	case r.reinstallUnchanged():
+		resp.Diagnostics.AddWarning("Server will be reinstalled",
+			"allow_reinstall_without_version_change is set on the provider, so this server is reinstalled on every apply, wiping its disks. Only use it for development and testing.")
+	default:
+		return
+	}
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("setup_complete"), types.BoolUnknown())...)
+}
+
+// setupMarker is created on the installed OS by post-install.sh and removed once the provider
+// finished the first run on it, so it only exists after an install that was interrupted
+const setupMarker = "/var/lib/hrobot/setup-incomplete"
+
+// setupInterrupted reports whether the OS on the server carries setupMarker; run executes a
+// command on the server. The rescue system and an OS with a different hostname don't
+func setupInterrupted(run func(cmd string) (string, error)) (bool, error) {
+	out, err := run("test -e " + setupMarker + " && echo interrupted || true")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) == "interrupted", nil
+}
+
+// readSetupMarker logs in to the server and checks it for setupMarker
+func (r *configurationResource) readSetupMarker(ctx context.Context, m configurationModel) (bool, error) {
+	auth, summary, detail := r.sshAuth(ctx, m)
+	if summary != "" {
+		return false, fmt.Errorf("%s: %s", summary, detail)
+	}
+	conn, closeFn, err := sshx.Connect(sshx.Conn{Host: m.ServerIP.ValueString(), User: "root", DialTimeout: setupCheckTimeout, Auth: auth, InsecureIgnoreHostKey: true})
+	if err != nil {
+		return false, err
+	}
+	defer closeFn()
+	return setupInterrupted(func(cmd string) (string, error) { return sshx.Run(conn, cmd) })
+}
+
+// refreshSetupComplete sets setup_complete to false when the installed OS carries setupMarker.
+// Nothing else changes it: a renamed server, the rescue system or a server that can't be reached
+// keeps the previous value
+func (r *configurationResource) refreshSetupComplete(ctx context.Context, m *configurationModel) {
+	if m.ServerIP.IsNull() || m.ServerIP.IsUnknown() || setupIncomplete(*m) {
+		return
+	}
+	interrupted, err := r.sshSteps().setupMarker(ctx, *m)
+	if err != nil {
+		tflog.Warn(ctx, "could not check whether the setup is complete", map[string]interface{}{
+			"server_number": m.ServerNumber.ValueInt64(),
+			"error":         err.Error(),
+		})
+		return
+	}
+	if interrupted {
+		m.SetupComplete = types.BoolValue(false)
+	}
+}
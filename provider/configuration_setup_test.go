@@ -0,0 +1,192 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestSetupComplete(t *testing.T) {
+	ctx := context.Background()
+
+	// The marker is checked with the shell, as on the server
+	marker := filepath.Join(t.TempDir(), "setup-incomplete")
+	run := func(out string, err error) func(string) (string, error) {
+		return func(cmd string) (string, error) {
+			if cmd != "test -e "+setupMarker+" && echo interrupted || true" {
+				t.Fatalf("unexpected command %q", cmd)
+			}
+			if out != "" || err != nil {
+				return out, err
+			}
+			b, err := exec.Command("sh", "-c", strings.ReplaceAll(cmd, setupMarker, marker)).CombinedOutput()
+			return string(b), err
+		}
+	}
+	if interrupted, err := setupInterrupted(run("", nil)); err != nil || interrupted {
+		t.Fatalf("expected an OS without the marker to count as complete, got %v %v", interrupted, err)
+	}
+	if err := os.WriteFile(marker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if interrupted, err := setupInterrupted(run("", nil)); err != nil || !interrupted {
+		t.Fatalf("expected the marker to count as interrupted, got %v %v", interrupted, err)
+	}
+	if _, err := setupInterrupted(run("", fmt.Errorf("connection reset"))); err == nil {
+		t.Fatal("expected the command error to be returned")
+	}
+	script, err := renderScript(postinstallTemplate, &PostInstallTemplateData{CryptPassword: "secret"})
+	if err != nil || !strings.Contains(script, "touch "+setupMarker+"\n") {
+		t.Fatalf("post-install.sh does not create the marker: %v", err)
+	}
+
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	raw := func(setupComplete interface{}) tftypes.Value {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["setup_complete"] = tftypes.NewValue(tftypes.Bool, setupComplete)
+		return tftypes.NewValue(objType, vals)
+	}
+	for _, tc := range []struct {
+		name        string
+		state       interface{}
+		wantUnknown bool
+	}{
+		{"complete", true, false},
+		{"interrupted", false, true},
+		{"not tracked", nil, false},
+	} {
+		req := resource.ModifyPlanRequest{
+			Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: raw(tc.state)},
+			State:  tfsdk.State{Schema: schemaResp.Schema, Raw: raw(tc.state)},
+			Plan:   tfsdk.Plan{Schema: schemaResp.Schema, Raw: raw(tc.state)},
+		}
+		resp := resource.ModifyPlanResponse{Plan: req.Plan}
+		res.ModifyPlan(ctx, req, &resp)
+		var planned types.Bool
+		resp.Plan.GetAttribute(ctx, path.Root("setup_complete"), &planned)
+		if planned.IsUnknown() != tc.wantUnknown || resp.Diagnostics.WarningsCount() != len(resp.Diagnostics) || (resp.Diagnostics.WarningsCount() == 1) != tc.wantUnknown {
+			t.Errorf("%s: planned setup_complete = %s, diags %v", tc.name, planned, resp.Diagnostics)
+		}
+	}
+
+	// allow_reinstall_without_version_change plans a reinstall of a complete, unchanged server,
+	// but not of a new one
+	res.providerData = &ProviderData{AllowReinstallWithoutVersionChange: true}
+	for _, tc := range []struct {
+		name        string
+		state       tftypes.Value
+		wantUnknown bool
+	}{
+		{"complete", raw(true), true},
+		{"not tracked", raw(nil), true},
+		{"create", tftypes.NewValue(objType, nil), false},
+	} {
+		req := resource.ModifyPlanRequest{
+			Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: raw(true)},
+			State:  tfsdk.State{Schema: schemaResp.Schema, Raw: tc.state},
+			Plan:   tfsdk.Plan{Schema: schemaResp.Schema, Raw: raw(true)},
+		}
+		resp := resource.ModifyPlanResponse{Plan: req.Plan}
+		res.ModifyPlan(ctx, req, &resp)
+		var planned types.Bool
+		resp.Plan.GetAttribute(ctx, path.Root("setup_complete"), &planned)
+		if planned.IsUnknown() != tc.wantUnknown || resp.Diagnostics.HasError() || (resp.Diagnostics.WarningsCount() == 1) != tc.wantUnknown {
+			t.Errorf("allow reinstall, %s: planned setup_complete = %s, diags %v", tc.name, planned, resp.Diagnostics)
+		}
+	}
+}
+
+func TestUpdateAllowReinstallWithoutVersionChange(t *testing.T) {
+	var ssh testSSHSteps
+	pd := testProviderData(t, testServer111)
+	res := &configurationResource{providerData: pd, ssh: ssh.steps()}
+	version := tftypes.NewValue(tftypes.Number, 3)
+	state := configurationRaw(t, map[string]tftypes.Value{"version": version, "setup_complete": tftypes.NewValue(tftypes.Bool, true)})
+	plan := configurationRaw(t, map[string]tftypes.Value{"version": version, "setup_complete": tftypes.NewValue(tftypes.Bool, tftypes.UnknownValue)})
+
+	resp := updateConfiguration(t, res, state, plan)
+	if resp.Diagnostics.HasError() || ssh.configured != 0 {
+		t.Fatalf("expected no reinstall without the flag, got %+v: %v", ssh, resp.Diagnostics)
+	}
+
+	pd.AllowReinstallWithoutVersionChange = true
+	resp = updateConfiguration(t, res, state, plan)
+	var complete types.Bool
+	resp.State.GetAttribute(context.Background(), path.Root("setup_complete"), &complete)
+	if resp.Diagnostics.HasError() || ssh.configured != 1 || !complete.ValueBool() {
+		t.Fatalf("expected the flag to reinstall the unchanged server, got %+v, setup_complete %s: %v", ssh, complete, resp.Diagnostics)
+	}
+}
+
+// A renamed server, or one booted into the rescue system, has another hostname but finished its
+// setup: refreshing it must not plan a reinstall
+func TestRenameKeepsSetupComplete(t *testing.T) {
+	ctx := context.Background()
+	var ssh testSSHSteps
+	res := &configurationResource{providerData: testProviderData(t, testServer111), ssh: ssh.steps()}
+	schema, _ := configurationType(t)
+	complete := tftypes.NewValue(tftypes.Bool, true)
+	version := tftypes.NewValue(tftypes.Number, 3)
+
+	resp := updateConfiguration(t, res,
+		configurationRaw(t, map[string]tftypes.Value{"version": version, "setup_complete": complete}),
+		configurationRaw(t, map[string]tftypes.Value{"version": version, "setup_complete": complete,
+			"name": tftypes.NewValue(tftypes.String, "db"), "hostname": tftypes.NewValue(tftypes.String, "db.example.com")}))
+	if resp.Diagnostics.HasError() || ssh.configured != 0 {
+		t.Fatalf("expected the rename without a reinstall, got %+v: %v", ssh, resp.Diagnostics)
+	}
+
+	refresh := func(state tfsdk.State) tfsdk.State {
+		t.Helper()
+		readResp := resource.ReadResponse{State: state}
+		res.Read(ctx, resource.ReadRequest{State: state}, &readResp)
+		if readResp.Diagnostics.HasError() {
+			t.Fatalf("read: %v", readResp.Diagnostics)
+		}
+		return readResp.State
+	}
+	plan := func(state tfsdk.State) resource.ModifyPlanResponse {
+		t.Helper()
+		req := resource.ModifyPlanRequest{
+			Config: tfsdk.Config{Schema: schema, Raw: state.Raw},
+			State:  state,
+			Plan:   tfsdk.Plan{Schema: schema, Raw: state.Raw},
+		}
+		planResp := resource.ModifyPlanResponse{Plan: req.Plan}
+		res.ModifyPlan(ctx, req, &planResp)
+		return planResp
+	}
+
+	state := refresh(resp.State)
+	planResp := plan(state)
+	var planned types.Bool
+	planResp.Plan.GetAttribute(ctx, path.Root("setup_complete"), &planned)
+	if planned.IsUnknown() || !planned.ValueBool() || len(planResp.Diagnostics) != 0 {
+		t.Fatalf("expected no reinstall after the rename, planned setup_complete %s: %v", planned, planResp.Diagnostics)
+	}
+
+	// Only the marker of an interrupted install plans one
+	ssh.interrupted = true
+	state = refresh(state)
+	planResp = plan(state)
+	planResp.Plan.GetAttribute(ctx, path.Root("setup_complete"), &planned)
+	if !planned.IsUnknown() || planResp.Diagnostics.WarningsCount() != 1 {
+		t.Fatalf("expected the interrupted install to be configured again, planned setup_complete %s: %v", planned, planResp.Diagnostics)
+	}
+}
@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	userDataFormatCloudConfig = "cloud-config"
+	userDataFormatShell       = "shell"
+
+	// maxUserDataBytes matches what most clouds accept as user data
+	maxUserDataBytes = 64 * 1024
+
+	// userDataSeedDir is the NoCloud seed cloud-init reads user_data from on the next boot
+	userDataSeedDir = "/var/lib/cloud/seed/nocloud"
+
+	// userDataCloudConfig restricts cloud-init to the seed and leaves the network to the
+	// first-run script
+	userDataCloudConfig = `datasource_list: [ NoCloud, None ]
+network:
+  config: disabled
+`
+)
+
+// userData returns user_data with the header of its user_data_format added when missing, so
+// cloud-init recognizes it; empty skips cloud-init
+func userData(m configurationModel) string {
+	if m.UserData.IsNull() || m.UserData.IsUnknown() || m.UserData.ValueString() == "" {
+		return ""
+	}
+	data := m.UserData.ValueString()
+	switch m.UserDataFormat.ValueString() {
+	case userDataFormatShell:
+		if !strings.HasPrefix(data, "#!") {
+			data = "#!/bin/bash\n" + data
+		}
+	default:
+		if !strings.HasPrefix(data, "#cloud-config") {
+			data = "#cloud-config\n" + data
+		}
+	}
+	return data
+}
+
+// seedUserData installs cloud-init when needed and writes user_data as a NoCloud seed, so
+// cloud-init runs it on the next boot. The instance id changes with version, which makes
+// cloud-init run it again after a reinstall or a configure_only version bump
+func seedUserData(ctx context.Context, run func(cmd string) (string, error), upload func(dst string, data []byte) error, instanceID, data string) (string, string) {
+	tflog.Info(ctx, "seeding cloud-init user data", map[string]interface{}{
+		"instance_id": instanceID,
+		"size":        len(data),
+	})
+
+	prepare := "command -v cloud-init >/dev/null 2>&1 || { apt-get update -qq >/dev/null && DEBIAN_FRONTEND=noninteractive apt-get install -y -qq cloud-init >/dev/null; }" +
+		" && cloud-init clean --logs" +
+		fmt.Sprintf(" && install -d -m 0700 %s && install -m 0600 /dev/null %s/user-data", userDataSeedDir, userDataSeedDir)
+	if out, err := run(prepare); err != nil {
+		return "install cloud-init", fmt.Sprintf("%v\n%s", err, out)
+	}
+
+	// user-data was created root-only above: the upload keeps the mode of an existing file
+	files := []struct {
+		path string
+		data string
+	}{
+		{userDataSeedDir + "/user-data", data},
+		{userDataSeedDir + "/meta-data", fmt.Sprintf("instance-id: %s\n", instanceID)},
+		{"/etc/cloud/cloud.cfg.d/99-hrobot.cfg", userDataCloudConfig},
+	}
+	for _, f := range files {
+		if err := upload(f.path, []byte(f.data)); err != nil {
+			return "upload user data", fmt.Sprintf("%s: %v", f.path, err)
+		}
+	}
+	return "", ""
+}
+
+// validateUserData checks user_data against its format and size limit
+func validateUserData(diags *diag.Diagnostics, config configurationModel) {
+	format := config.UserDataFormat
+	if !format.IsNull() && !format.IsUnknown() && format.ValueString() != userDataFormatCloudConfig && format.ValueString() != userDataFormatShell {
+		diags.AddAttributeError(path.Root("user_data_format"), "Invalid user_data_format",
+			fmt.Sprintf("user_data_format must be %q or %q, got %q.", userDataFormatCloudConfig, userDataFormatShell, format.ValueString()))
+		return
+	}
+	if config.UserData.IsNull() {
+		if !format.IsNull() {
+			diags.AddAttributeWarning(path.Root("user_data_format"), "Missing user_data",
+				"user_data_format has no effect without user_data.")
+		}
+		return
+	}
+	if config.UserData.IsUnknown() {
+		return
+	}
+
+	data := config.UserData.ValueString()
+	if len(data) > maxUserDataBytes {
+		diags.AddAttributeError(path.Root("user_data"), "user_data too large",
+			fmt.Sprintf("user_data must be at most %d bytes, got %d.", maxUserDataBytes, len(data)))
+	}
+	if format.ValueString() == userDataFormatShell && strings.HasPrefix(data, "#cloud-config") {
+		diags.AddAttributeError(path.Root("user_data"), "Invalid user_data",
+			`user_data starts with "#cloud-config" but user_data_format is "shell"; set user_data_format = "cloud-config".`)
+	}
+	if format.ValueString() != userDataFormatShell && strings.HasPrefix(data, "#!") {
+		diags.AddAttributeError(path.Root("user_data"), "Invalid user_data",
+			`user_data is a script but user_data_format is "cloud-config"; set user_data_format = "shell".`)
+	}
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestUserData(t *testing.T) {
+	ctx := context.Background()
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	validate := func(data, format interface{}) diag.Diagnostics {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["server_number"] = tftypes.NewValue(tftypes.Number, 111)
+		vals["server_ip"] = tftypes.NewValue(tftypes.String, "1.2.3.4")
+		vals["name"] = tftypes.NewValue(tftypes.String, "web")
+		vals["install_mode"] = tftypes.NewValue(tftypes.String, installModeConfigureOnly)
+		vals["user_data"] = tftypes.NewValue(tftypes.String, data)
+		vals["user_data_format"] = tftypes.NewValue(tftypes.String, format)
+		req := resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}
+		var resp resource.ValidateConfigResponse
+		res.ValidateConfig(ctx, req, &resp)
+		return resp.Diagnostics
+	}
+
+	if diags := validate("#cloud-config\nruncmd:\n  - echo hi\n", nil); diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+	if diags := validate("echo hi", "shell"); diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+	for _, tc := range []struct {
+		data, format interface{}
+		want         string
+	}{
+		{"runcmd: []", "yaml", "Invalid user_data_format"},
+		{strings.Repeat("x", maxUserDataBytes+1), nil, "user_data too large"},
+		{"#!/bin/sh\necho hi", "cloud-config", "Invalid user_data"},
+		{"#cloud-config\n", "shell", "Invalid user_data"},
+	} {
+		if diags := validate(tc.data, tc.format); !diags.HasError() || diags.Errors()[0].Summary() != tc.want {
+			t.Fatalf("%v/%v: expected %q, got %v", tc.data, tc.format, tc.want, diags)
+		}
+	}
+
+	if got := userData(configurationModel{UserData: types.StringValue("runcmd: []"), UserDataFormat: types.StringNull()}); got != "#cloud-config\nruncmd: []" {
+		t.Fatalf("expected the cloud-config header, got %q", got)
+	}
+	if got := userData(configurationModel{UserData: types.StringValue("echo hi"), UserDataFormat: types.StringValue("shell")}); got != "#!/bin/bash\necho hi" {
+		t.Fatalf("expected a shebang, got %q", got)
+	}
+	if got := userData(configurationModel{UserData: types.StringNull()}); got != "" {
+		t.Fatalf("expected no user data, got %q", got)
+	}
+
+	var ran []string
+	uploaded := map[string]string{}
+	run := func(cmd string) (string, error) { ran = append(ran, cmd); return "", nil }
+	upload := func(dst string, data []byte) error { uploaded[dst] = string(data); return nil }
+	if summary, detail := seedUserData(ctx, run, upload, "hrobot-111-2", "#cloud-config\npassword: secret\n"); summary != "" {
+		t.Fatalf("unexpected failure: %s", detail)
+	}
+	if len(ran) != 1 || strings.Contains(ran[0], "secret") || !strings.Contains(ran[0], "install -m 0600 /dev/null "+userDataSeedDir+"/user-data") {
+		t.Fatalf("user-data must be created root-only before the upload, ran %q", ran)
+	}
+	if uploaded[userDataSeedDir+"/user-data"] != "#cloud-config\npassword: secret\n" || uploaded[userDataSeedDir+"/meta-data"] != "instance-id: hrobot-111-2\n" {
+		t.Fatalf("unexpected seed: %v", uploaded)
+	}
+	if !strings.Contains(uploaded["/etc/cloud/cloud.cfg.d/99-hrobot.cfg"], "config: disabled") {
+		t.Fatalf("cloud-init must leave the network alone: %v", uploaded)
+	}
+}
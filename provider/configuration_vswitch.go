@@ -0,0 +1,464 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+// privateVLAN is the VLAN the first-run script configures with local_ip
+const privateVLAN = 4001
+
+// privateSubnet is where local_ip is assigned from; it is also the on-link range for route gateways
+const privateSubnet = "10.1.0.0/24"
+
+// Defaults of the private VLAN interface, matching what the first-run script always used
+const (
+	defaultVLANMTU   = 1400
+	defaultParentMTU = 1500
+)
+
+var defaultRoutes = []RouteTemplateData{{To: "10.0.0.0/16", Via: "10.1.0.1", Metric: 100}}
+
+type vswitchEntryModel struct {
+	VSwitchID types.Int64  `tfsdk:"vswitch_id"`
+	VLAN      types.Int64  `tfsdk:"vlan"`
+	LocalIP   types.String `tfsdk:"local_ip"`
+	MTU       types.Int64  `tfsdk:"mtu"`
+}
+
+type routeModel struct {
+	To     types.String `tfsdk:"to"`
+	Via    types.String `tfsdk:"via"`
+	Metric types.Int64  `tfsdk:"metric"`
+}
+
+// RouteTemplateData is a static route on the private VLAN interface
+type RouteTemplateData struct {
+	To     string
+	Via    string
+	Metric int64
+}
+
+// VLANTemplateData describes an additional VLAN interface in the first-run network config
+type VLANTemplateData struct {
+	ID      int64
+	MTU     int64
+	Address string              // CIDR, empty for an interface without address
+	Routes  []RouteTemplateData // only set for the private VLAN
+	Name    string              // interface name, empty for ${DEFAULT_IFACE}.<ID>
+}
+
+// Interface returns the name of the VLAN interface in the first-run script
+func (v VLANTemplateData) Interface() string {
+	if v.Name != "" {
+		return v.Name
+	}
+	return fmt.Sprintf("${DEFAULT_IFACE}.%d", v.ID)
+}
+
+func vswitchEntries(ctx context.Context, m configurationModel) []vswitchEntryModel {
+	if m.VSwitches.IsNull() || m.VSwitches.IsUnknown() {
+		return nil
+	}
+	var entries []vswitchEntryModel
+	m.VSwitches.ElementsAs(ctx, &entries, false)
+	return entries
+}
+
+// attachedVSwitchIDs returns every vSwitch the server should belong to: the deprecated
+// vswitch_id, vswitch_ids and the vswitches entries, without duplicates
+func attachedVSwitchIDs(ctx context.Context, m configurationModel) []int64 {
+	var ids []int64
+	seen := map[int64]bool{}
+	add := func(v types.Int64) {
+		if v.IsNull() || v.IsUnknown() || seen[v.ValueInt64()] {
+			return
+		}
+		seen[v.ValueInt64()] = true
+		ids = append(ids, v.ValueInt64())
+	}
+	add(m.VSwitchID)
+	for _, id := range vswitchIDs(ctx, m) {
+		add(types.Int64Value(id))
+	}
+	for _, e := range vswitchEntries(ctx, m) {
+		add(e.VSwitchID)
+	}
+	return ids
+}
+
+func vswitchIDs(ctx context.Context, m configurationModel) []int64 {
+	if m.VSwitchIDs.IsNull() || m.VSwitchIDs.IsUnknown() {
+		return nil
+	}
+	var ids []int64
+	m.VSwitchIDs.ElementsAs(ctx, &ids, false)
+	return ids
+}
+
+// vlanAddress normalises local_ip to CIDR notation, defaulting to /24
+func vlanAddress(localIP string) (string, error) {
+	if !strings.Contains(localIP, "/") {
+		localIP += "/24"
+	}
+	ip, _, err := net.ParseCIDR(localIP)
+	if err != nil || ip.To4() == nil {
+		return "", fmt.Errorf("%q is not an IPv4 address or CIDR", localIP)
+	}
+	return localIP, nil
+}
+
+// extraVLANs returns the VLAN interfaces to configure besides the private one
+func extraVLANs(ctx context.Context, m configurationModel) []VLANTemplateData {
+	var vlans []VLANTemplateData
+	for _, e := range vswitchEntries(ctx, m) {
+		if e.VLAN.ValueInt64() == privateVLAN {
+			continue
+		}
+		v := VLANTemplateData{ID: e.VLAN.ValueInt64(), MTU: 1400}
+		if !e.MTU.IsNull() && !e.MTU.IsUnknown() {
+			v.MTU = e.MTU.ValueInt64()
+		}
+		if !e.LocalIP.IsNull() && !e.LocalIP.IsUnknown() {
+			v.Address, _ = vlanAddress(e.LocalIP.ValueString())
+		}
+		vlans = append(vlans, v)
+	}
+	return vlans
+}
+
+// int64OrDefault returns v, or def when v is null or unknown
+func int64OrDefault(v types.Int64, def int64) int64 {
+	if v.IsNull() || v.IsUnknown() {
+		return def
+	}
+	return v.ValueInt64()
+}
+
+// privateRoutes returns the routes of the private VLAN; an explicitly empty list means none
+func privateRoutes(ctx context.Context, m configurationModel) []RouteTemplateData {
+	if m.Routes.IsNull() || m.Routes.IsUnknown() {
+		return defaultRoutes
+	}
+	var entries []routeModel
+	m.Routes.ElementsAs(ctx, &entries, false)
+	routes := make([]RouteTemplateData, 0, len(entries))
+	for _, e := range entries {
+		routes = append(routes, RouteTemplateData{To: e.To.ValueString(), Via: e.Via.ValueString(), Metric: int64OrDefault(e.Metric, 100)})
+	}
+	return routes
+}
+
+// validatePrivateNetwork checks vlan_mtu, parent_mtu, vlan_interface_name and routes
+func validatePrivateNetwork(ctx context.Context, diags *diag.Diagnostics, config configurationModel) {
+	if name := config.VLANInterfaceName; !name.IsNull() && !name.IsUnknown() && !ifaceName.MatchString(name.ValueString()) {
+		diags.AddAttributeError(path.Root("vlan_interface_name"), "Invalid vlan_interface_name",
+			fmt.Sprintf("vlan_interface_name must be a Linux interface name of up to 15 letters, digits, '.', '_' or '-', got %q.", name.ValueString()))
+	}
+	if ip := config.LocalIP; !ip.IsNull() && !ip.IsUnknown() && net.ParseIP(ip.ValueString()).To4() == nil {
+		diags.AddAttributeError(path.Root("local_ip"), "Invalid local_ip", fmt.Sprintf("local_ip must be an IPv4 address without prefix length, got %q.", ip.ValueString()))
+	}
+	parentMTU := int64OrDefault(config.ParentMTU, defaultParentMTU)
+	if !config.ParentMTU.IsNull() && !config.ParentMTU.IsUnknown() && (parentMTU < 576 || parentMTU > 9000) {
+		diags.AddAttributeError(path.Root("parent_mtu"), "Invalid parent_mtu", fmt.Sprintf("parent_mtu must be between 576 and 9000, got %d.", parentMTU))
+	}
+	if !config.VLANMTU.IsNull() && !config.VLANMTU.IsUnknown() {
+		if mtu := config.VLANMTU.ValueInt64(); mtu < 576 {
+			diags.AddAttributeError(path.Root("vlan_mtu"), "Invalid vlan_mtu", fmt.Sprintf("vlan_mtu must be at least 576, got %d.", mtu))
+		}
+	}
+	if !config.ParentMTU.IsUnknown() && !config.VLANMTU.IsUnknown() {
+		if mtu := int64OrDefault(config.VLANMTU, defaultVLANMTU); mtu > parentMTU {
+			diags.AddAttributeError(path.Root("vlan_mtu"), "vlan_mtu above parent_mtu",
+				fmt.Sprintf("vlan_mtu (%d) cannot be larger than the MTU of the parent interface (%d).", mtu, parentMTU))
+		}
+	}
+	if !config.ParentMTU.IsUnknown() {
+		for i, e := range vswitchEntries(ctx, config) {
+			if !e.MTU.IsNull() && !e.MTU.IsUnknown() && e.MTU.ValueInt64() > parentMTU {
+				diags.AddAttributeError(path.Root("vswitches").AtListIndex(i).AtName("mtu"), "mtu above parent_mtu",
+					fmt.Sprintf("mtu (%d) cannot be larger than the MTU of the parent interface (%d).", e.MTU.ValueInt64(), parentMTU))
+			}
+		}
+	}
+
+	if config.Routes.IsNull() || config.Routes.IsUnknown() {
+		return
+	}
+	_, subnet, _ := net.ParseCIDR(privateSubnet)
+	var entries []routeModel
+	config.Routes.ElementsAs(ctx, &entries, false)
+	for i, e := range entries {
+		p := path.Root("routes").AtListIndex(i)
+		if !e.To.IsUnknown() {
+			if _, _, err := net.ParseCIDR(e.To.ValueString()); err != nil && e.To.ValueString() != "default" {
+				diags.AddAttributeError(p.AtName("to"), "Invalid route destination",
+					fmt.Sprintf("to must be a CIDR (e.g., 10.0.0.0/16) or \"default\", got %q.", e.To.ValueString()))
+			}
+		}
+		if !e.Via.IsUnknown() {
+			if via := net.ParseIP(e.Via.ValueString()); via == nil || !subnet.Contains(via) {
+				diags.AddAttributeError(p.AtName("via"), "Invalid route gateway",
+					fmt.Sprintf("via must be an address in the private subnet %s, got %q.", privateSubnet, e.Via.ValueString()))
+			}
+		}
+		if !e.Metric.IsNull() && !e.Metric.IsUnknown() && e.Metric.ValueInt64() < 0 {
+			diags.AddAttributeError(p.AtName("metric"), "Invalid route metric", fmt.Sprintf("metric cannot be negative, got %d.", e.Metric.ValueInt64()))
+		}
+	}
+}
+
+// validateVSwitches checks the vswitch settings that can be verified without calling Robot
+func validateVSwitches(ctx context.Context, diags *diag.Diagnostics, config configurationModel) {
+	if !config.VSwitchID.IsNull() && !config.VSwitchID.IsUnknown() && config.VSwitchID.ValueInt64() <= 0 {
+		diags.AddAttributeError(path.Root("vswitch_id"), "Invalid vswitch_id",
+			fmt.Sprintf("vswitch_id must be a positive vSwitch ID, got %d.", config.VSwitchID.ValueInt64()))
+	}
+	if !config.VSwitchID.IsNull() && !config.VSwitchName.IsNull() {
+		diags.AddAttributeError(path.Root("vswitch_name"), "Conflicting vSwitch settings",
+			"Only one of vswitch_id and vswitch_name can be set.")
+	}
+	if !config.VSwitchIDs.IsNull() && !config.VSwitches.IsNull() {
+		diags.AddAttributeError(path.Root("vswitch_ids"), "Conflicting vSwitch settings",
+			"Only one of vswitch_ids and vswitches can be set. List the vSwitches that don't need a VLAN interface in vswitches too, on VLAN 4001 if they only attach the server.")
+	}
+
+	seen := map[int64]bool{}
+	for i, id := range vswitchIDs(ctx, config) {
+		p := path.Root("vswitch_ids").AtListIndex(i)
+		if id <= 0 {
+			diags.AddAttributeError(p, "Invalid vswitch_ids", fmt.Sprintf("vswitch_ids must only contain positive vSwitch IDs, got %d.", id))
+		}
+		if seen[id] {
+			diags.AddAttributeError(p, "Duplicate vswitch_ids", fmt.Sprintf("vSwitch %d is listed more than once.", id))
+		}
+		seen[id] = true
+	}
+
+	vlans := map[int64]bool{}
+	for i, e := range vswitchEntries(ctx, config) {
+		p := path.Root("vswitches").AtListIndex(i)
+		if !e.VSwitchID.IsUnknown() && e.VSwitchID.ValueInt64() <= 0 {
+			diags.AddAttributeError(p.AtName("vswitch_id"), "Invalid vswitch_id",
+				fmt.Sprintf("vswitch_id must be a positive vSwitch ID, got %d.", e.VSwitchID.ValueInt64()))
+		}
+		if e.VLAN.IsUnknown() {
+			continue
+		}
+		vlan := e.VLAN.ValueInt64()
+		if vlan < 4000 || vlan > 4091 {
+			diags.AddAttributeError(p.AtName("vlan"), "Invalid vlan", fmt.Sprintf("vlan must be between 4000 and 4091, got %d.", vlan))
+		}
+		if vlans[vlan] {
+			diags.AddAttributeError(p.AtName("vlan"), "Duplicate vlan", fmt.Sprintf("vlan %d is used by more than one vswitches entry.", vlan))
+		}
+		vlans[vlan] = true
+
+		if !e.LocalIP.IsNull() && !e.LocalIP.IsUnknown() {
+			if vlan == privateVLAN {
+				diags.AddAttributeError(p.AtName("local_ip"), "Unsupported local_ip",
+					fmt.Sprintf("VLAN %d is configured with the computed local_ip; leave local_ip unset for this entry.", privateVLAN))
+			} else if _, err := vlanAddress(e.LocalIP.ValueString()); err != nil {
+				diags.AddAttributeError(p.AtName("local_ip"), "Invalid local_ip", err.Error())
+			}
+		}
+		if !e.MTU.IsNull() && !e.MTU.IsUnknown() && (e.MTU.ValueInt64() < 576 || e.MTU.ValueInt64() > 1400) {
+			diags.AddAttributeError(p.AtName("mtu"), "Invalid mtu", fmt.Sprintf("mtu must be between 576 and 1400 on a vSwitch, got %d.", e.MTU.ValueInt64()))
+		}
+	}
+}
+
+// planVSwitchID plans vswitch_id as null once neither vswitch_id nor vswitch_name is configured.
+// vswitch_id is also computed from vswitch_name, so Terraform would otherwise keep the ID from
+// state and never detach the server
+func planVSwitchID(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	var id types.Int64
+	var name types.String
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("vswitch_id"), &id)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("vswitch_name"), &name)...)
+	if resp.Diagnostics.HasError() || !id.IsNull() || !name.IsNull() {
+		return
+	}
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("vswitch_id"), types.Int64Null())...)
+}
+
+// resolveVSwitch looks up vswitch_name and checks that every selected vSwitch exists, setting
+// plan.VSwitchID to the resolved ID (null when neither vswitch_id nor vswitch_name is configured)
+func (r *configurationResource) resolveVSwitch(ctx context.Context, diags *diag.Diagnostics, plan *configurationModel) {
+	if !plan.VSwitchName.IsNull() && !plan.VSwitchName.IsUnknown() {
+		name := plan.VSwitchName.ValueString()
+		vswitches, err := r.providerData.Client.ListVSwitches()
+		if err != nil {
+			diags.AddError("list vswitches failed", robotErrorDetail(err, "list vSwitches", "vSwitch"))
+			return
+		}
+		var matches []client.VSwitch
+		for _, v := range vswitches {
+			if v.Name == name && !v.Cancelled {
+				matches = append(matches, v)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			diags.AddAttributeError(path.Root("vswitch_name"), "vSwitch not found", fmt.Sprintf("No active vSwitch is named %q.", name))
+			return
+		case 1:
+			plan.VSwitchID = types.Int64Value(int64(matches[0].ID))
+		default:
+			ids := make([]string, len(matches))
+			for i, v := range matches {
+				ids[i] = fmt.Sprintf("%d", v.ID)
+			}
+			diags.AddAttributeError(path.Root("vswitch_name"), "Ambiguous vSwitch name",
+				fmt.Sprintf("%d vSwitches are named %q (IDs %s); use vswitch_id instead.", len(matches), name, strings.Join(ids, ", ")))
+			return
+		}
+		tflog.Info(ctx, "resolved vswitch by name", map[string]interface{}{
+			"vswitch_name": name,
+			"vswitch_id":   plan.VSwitchID.ValueInt64(),
+		})
+	} else if plan.VSwitchID.IsUnknown() {
+		// Computed and not configured
+		plan.VSwitchID = types.Int64Null()
+	} else if !plan.VSwitchID.IsNull() {
+		r.checkVSwitch(diags, path.Root("vswitch_id"), plan.VSwitchID.ValueInt64())
+	}
+
+	for i, id := range vswitchIDs(ctx, *plan) {
+		r.checkVSwitch(diags, path.Root("vswitch_ids").AtListIndex(i), id)
+	}
+	for i, e := range vswitchEntries(ctx, *plan) {
+		r.checkVSwitch(diags, path.Root("vswitches").AtListIndex(i).AtName("vswitch_id"), e.VSwitchID.ValueInt64())
+	}
+}
+
+// checkVSwitch reports an error on p unless the vSwitch exists and is active
+func (r *configurationResource) checkVSwitch(diags *diag.Diagnostics, p path.Path, id int64) {
+	vswitch, err := r.providerData.Client.GetVSwitch(int(id))
+	if err != nil {
+		if client.IsNotFound(err) {
+			diags.AddAttributeError(p, "vSwitch not found", fmt.Sprintf("vSwitch %d does not exist.", id))
+			return
+		}
+		diags.AddError("get vswitch failed", robotErrorDetail(err, "read vSwitches", "vSwitch"))
+		return
+	}
+	if vswitch.Cancelled {
+		diags.AddAttributeError(p, "vSwitch cancelled",
+			fmt.Sprintf("vSwitch %d (%q, VLAN %d) has been cancelled in Robot, so servers can't be attached to it. Use an active vSwitch or remove it from the configuration.", id, vswitch.Name, vswitch.VLAN))
+	}
+}
+
+// syncVSwitches moves the server from the vSwitches in state to the planned ones. The server is
+// removed from vSwitches it no longer belongs to first, so it never ends up attached to both an
+// old and a new one.
+func (r *configurationResource) syncVSwitches(ctx context.Context, diags *diag.Diagnostics, serverNumber int64, serverIP string, oldIDs, newIDs []int64) {
+	contains := func(ids []int64, id int64) bool {
+		for _, v := range ids {
+			if v == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, id := range oldIDs {
+		if contains(newIDs, id) {
+			continue
+		}
+		if err := r.providerData.Client.RemoveServerFromVSwitch(int(id), serverIP); err != nil && !client.IsNotFound(err) {
+			diags.AddError("remove server from vswitch failed", robotErrorDetail(err, "manage vSwitches", "vSwitch"))
+			return
+		}
+		r.providerData.CacheManager.InvalidateVSwitch(int(id))
+		tflog.Info(ctx, "removed server from previous vswitch", map[string]interface{}{
+			"server_number": serverNumber,
+			"server_ip":     serverIP,
+			"vswitch_id":    id,
+		})
+	}
+
+	for _, id := range newIDs {
+		if contains(oldIDs, id) {
+			continue
+		}
+		if err := r.providerData.Client.AddServerToVSwitch(int(id), serverIP); err != nil {
+			diags.AddError("add server to vswitch failed", robotErrorDetail(err, "manage vSwitches", "vSwitch"))
+			return
+		}
+		r.providerData.CacheManager.InvalidateVSwitch(int(id))
+		tflog.Info(ctx, "server added to vswitch", map[string]interface{}{
+			"server_number": serverNumber,
+			"server_ip":     serverIP,
+			"vswitch_id":    id,
+		})
+	}
+}
+
+// checkVSwitchLocations warns when a vSwitch the server joined only has members in other
+// locations. Robot doesn't report a location for vSwitches, so it comes from their other members
+func (r *configurationResource) checkVSwitchLocations(ctx context.Context, diags *diag.Diagnostics, m configurationModel) {
+	ids := attachedVSwitchIDs(ctx, m)
+	if len(ids) == 0 || m.ServerLocation.IsNull() || m.ServerLocation.IsUnknown() {
+		return
+	}
+	servers, err := r.providerData.CacheManager.GetServers(r.providerData.Client)
+	if err != nil {
+		tflog.Warn(ctx, "failed to list servers to check vswitch locations", map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	location := m.ServerLocation.ValueString()
+	for _, id := range ids {
+		members, err := r.providerData.CacheManager.GetVSwitchServers(r.providerData.Client, int(id))
+		if err != nil {
+			tflog.Warn(ctx, "failed to read vswitch members to check locations", map[string]interface{}{
+				"vswitch_id": id,
+				"error":      err.Error(),
+			})
+			continue
+		}
+		locations := vswitchLocations(members, servers, m.ServerNumber.ValueInt64())
+		if i := sort.SearchStrings(locations, strings.ToUpper(location)); len(locations) == 0 || (i < len(locations) && locations[i] == strings.ToUpper(location)) {
+			continue
+		}
+		summary, detail := "vSwitch in another location", fmt.Sprintf("Server %d is in %s, but the other servers of vSwitch %d are in %s. Check that this is the vSwitch you meant: traffic between locations has a higher latency.",
+			m.ServerNumber.ValueInt64(), location, id, strings.Join(locations, ", "))
+		if !m.VSwitchID.IsNull() && m.VSwitchID.ValueInt64() == id {
+			diags.AddAttributeWarning(path.Root("vswitch_id"), summary, detail)
+		} else {
+			diags.AddWarning(summary, detail)
+		}
+	}
+}
+
+// vswitchLocations returns the sorted locations of the vSwitch members other than serverNumber
+func vswitchLocations(members []client.VSwitchServer, servers []client.Server, serverNumber int64) []string {
+	locationOf := map[int]string{}
+	for _, s := range servers {
+		locationOf[s.ServerNumber] = s.Location
+	}
+	seen := map[string]bool{}
+	var locations []string
+	for _, member := range members {
+		loc := strings.ToUpper(locationOf[member.ServerNumber])
+		if int64(member.ServerNumber) == serverNumber || loc == "" || seen[loc] {
+			continue
+		}
+		seen[loc] = true
+		locations = append(locations, loc)
+	}
+	sort.Strings(locations)
+	return locations
+}
@@ -0,0 +1,473 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+func TestSyncVSwitchesRemovesFromOldBeforeAdding(t *testing.T) {
+	var calls []string
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		// ParseForm ignores DELETE bodies, Robot expects the server list there anyway
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		calls = append(calls, r.Method+" "+r.URL.Path+" "+form.Get("server[]"))
+	})
+	res := &configurationResource{providerData: pd}
+
+	var diags diag.Diagnostics
+	res.syncVSwitches(context.Background(), &diags, 111, "1.2.3.4", []int64{10, 30}, []int64{20, 30})
+	if diags.HasError() {
+		t.Fatalf("syncVSwitches: %v", diags)
+	}
+	want := []string{
+		"DELETE /vswitch/10/server 1.2.3.4",
+		"POST /vswitch/20/server 1.2.3.4",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected API calls:\n%s", strings.Join(calls, "\n"))
+	}
+
+	calls = nil
+	res.syncVSwitches(context.Background(), &diags, 111, "1.2.3.4", []int64{20}, []int64{20})
+	if len(calls) != 0 {
+		t.Fatalf("expected no API calls when the vswitches are unchanged, got %v", calls)
+	}
+}
+
+func TestExtraVLANsInFirstRunScript(t *testing.T) {
+	ctx := context.Background()
+	entries, diags := types.ListValueFrom(ctx, types.ObjectType{AttrTypes: map[string]attr.Type{
+		"vswitch_id": types.Int64Type,
+		"vlan":       types.Int64Type,
+		"local_ip":   types.StringType,
+		"mtu":        types.Int64Type,
+	}}, []vswitchEntryModel{
+		{VSwitchID: types.Int64Value(1), VLAN: types.Int64Value(4001), LocalIP: types.StringNull(), MTU: types.Int64Null()},
+		{VSwitchID: types.Int64Value(2), VLAN: types.Int64Value(4002), LocalIP: types.StringValue("10.2.0.5"), MTU: types.Int64Null()},
+		{VSwitchID: types.Int64Value(3), VLAN: types.Int64Value(4003), LocalIP: types.StringNull(), MTU: types.Int64Value(1350)},
+	})
+	if diags.HasError() {
+		t.Fatalf("build list: %v", diags)
+	}
+	m := configurationModel{VSwitchID: types.Int64Value(1), VSwitches: entries}
+
+	if got := attachedVSwitchIDs(ctx, m); len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Fatalf("unexpected attached vswitches: %v", got)
+	}
+
+	out, err := renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{LocalIP: "10.1.0.5", ExtraVLANs: extraVLANs(ctx, m)})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	for _, want := range []string{
+		"    ${DEFAULT_IFACE}.4002:\n      id: 4002\n      link: ${DEFAULT_IFACE}\n      mtu: 1400\n      addresses:\n        - 10.2.0.5/24\n",
+		"    ${DEFAULT_IFACE}.4003:\n      id: 4003\n      link: ${DEFAULT_IFACE}\n      mtu: 1350\n      optional: true\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("first-run script missing VLAN stanza:\n%s", want)
+		}
+	}
+	if strings.Count(out, "id: 4001") != 1 {
+		t.Fatalf("private VLAN must only be configured once")
+	}
+}
+
+func TestResolveVSwitchByName(t *testing.T) {
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vswitch":
+			_, _ = w.Write([]byte(`[{"id":1,"vlan":4000,"name":"private","cancelled":false},
+				{"id":2,"vlan":4001,"name":"dup","cancelled":false},
+				{"id":3,"vlan":4002,"name":"dup","cancelled":false},
+				{"id":4,"vlan":4003,"name":"private","cancelled":true}]`))
+		case "/vswitch/9":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"status":404,"code":"NOT_FOUND","message":"vSwitch not found"}}`))
+		case "/vswitch/4":
+			_, _ = w.Write([]byte(`{"id":4,"vlan":4003,"name":"private","cancelled":true,"server":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	res := &configurationResource{providerData: pd}
+
+	ctx := context.Background()
+
+	plan := configurationModel{VSwitchID: types.Int64Unknown(), VSwitchName: types.StringValue("private")}
+	var diags diag.Diagnostics
+	res.resolveVSwitch(ctx, &diags, &plan)
+	if diags.HasError() || plan.VSwitchID.ValueInt64() != 1 {
+		t.Fatalf("expected vSwitch 1, got %v (%v)", plan.VSwitchID, diags)
+	}
+
+	plan = configurationModel{VSwitchID: types.Int64Unknown(), VSwitchName: types.StringValue("dup")}
+	diags = nil
+	res.resolveVSwitch(ctx, &diags, &plan)
+	if !diags.HasError() || !strings.Contains(diags[0].Detail(), "IDs 2, 3") {
+		t.Fatalf("expected ambiguous name error, got %v", diags)
+	}
+
+	plan = configurationModel{VSwitchID: types.Int64Value(9), VSwitchName: types.StringNull()}
+	diags = nil
+	res.resolveVSwitch(ctx, &diags, &plan)
+	if !diags.HasError() || diags[0].Summary() != "vSwitch not found" {
+		t.Fatalf("expected not found error, got %v", diags)
+	}
+
+	// A cancelled vSwitch is refused before the server is attached, wherever it is listed
+	plan = configurationModel{VSwitchID: types.Int64Null(), VSwitchName: types.StringNull(), VSwitchIDs: types.ListValueMust(types.Int64Type, []attr.Value{types.Int64Value(4)})}
+	diags = nil
+	res.resolveVSwitch(ctx, &diags, &plan)
+	if !diags.HasError() || diags[0].Summary() != "vSwitch cancelled" || !strings.Contains(diags[0].Detail(), `"private", VLAN 4003`) {
+		t.Fatalf("expected cancelled error, got %v", diags)
+	}
+}
+
+func TestPrivateVLANMTUAndRoutes(t *testing.T) {
+	ctx := context.Background()
+	routeType := types.ObjectType{AttrTypes: map[string]attr.Type{
+		"to":     types.StringType,
+		"via":    types.StringType,
+		"metric": types.Int64Type,
+	}}
+	routes := func(t *testing.T, r ...routeModel) types.List {
+		t.Helper()
+		v, diags := types.ListValueFrom(ctx, routeType, append([]routeModel{}, r...))
+		if diags.HasError() {
+			t.Fatalf("build list: %v", diags)
+		}
+		return v
+	}
+	render := func(t *testing.T, m configurationModel) string {
+		t.Helper()
+		out, err := renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{
+			LocalIP:   "10.1.0.5",
+			VLANMTU:   int64OrDefault(m.VLANMTU, defaultVLANMTU),
+			ParentMTU: int64OrDefault(m.ParentMTU, defaultParentMTU),
+			Routes:    privateRoutes(ctx, m),
+		})
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		return out
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		m := configurationModel{Routes: types.ListNull(routeType)}
+		out := render(t, m)
+		want := "    ${DEFAULT_IFACE}:\n      mtu: 1500\n      optional: false\n  vlans:\n    ${DEFAULT_IFACE}.4001:\n      id: 4001\n      link: ${DEFAULT_IFACE}\n      mtu: 1400\n" +
+			"      addresses:\n        - ${LOCAL_IP}/24\n      routes:\n        - to: \"10.0.0.0/16\"\n          via: \"10.1.0.1\"\n          metric: 100\n      optional: false\n"
+		if !strings.Contains(out, want) {
+			t.Fatalf("default private VLAN config changed, expected:\n%s", want)
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		m := configurationModel{
+			VLANMTU:   types.Int64Value(8950),
+			ParentMTU: types.Int64Value(9000),
+			Routes: routes(t,
+				routeModel{To: types.StringValue("10.0.0.0/16"), Via: types.StringValue("10.1.0.254"), Metric: types.Int64Null()},
+				routeModel{To: types.StringValue("10.2.0.0/16"), Via: types.StringValue("10.1.0.254"), Metric: types.Int64Value(50)},
+			),
+		}
+		var diags diag.Diagnostics
+		validatePrivateNetwork(ctx, &diags, m)
+		if diags.HasError() {
+			t.Fatalf("unexpected validation errors: %v", diags)
+		}
+		out := render(t, m)
+		for _, want := range []string{
+			"      mtu: 9000\n",
+			"      mtu: 8950\n",
+			"        - to: \"10.0.0.0/16\"\n          via: \"10.1.0.254\"\n          metric: 100\n",
+			"        - to: \"10.2.0.0/16\"\n          via: \"10.1.0.254\"\n          metric: 50\n",
+		} {
+			if !strings.Contains(out, want) {
+				t.Fatalf("first-run script missing:\n%s", want)
+			}
+		}
+	})
+
+	t.Run("no routes", func(t *testing.T) {
+		out := render(t, configurationModel{Routes: routes(t)})
+		if strings.Contains(out, "      routes:\n") {
+			t.Fatalf("expected no routes block for an empty routes list")
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			m       configurationModel
+			wantErr string
+		}{
+			{name: "vlan above default parent", m: configurationModel{VLANMTU: types.Int64Value(1600), Routes: types.ListNull(routeType)}, wantErr: "vlan_mtu above parent_mtu"},
+			{name: "vlan above parent", m: configurationModel{VLANMTU: types.Int64Value(1400), ParentMTU: types.Int64Value(1300), Routes: types.ListNull(routeType)}, wantErr: "vlan_mtu above parent_mtu"},
+			{name: "parent too large", m: configurationModel{ParentMTU: types.Int64Value(9216), Routes: types.ListNull(routeType)}, wantErr: "Invalid parent_mtu"},
+			{name: "via outside subnet", m: configurationModel{Routes: routes(t, routeModel{To: types.StringValue("10.2.0.0/16"), Via: types.StringValue("10.2.0.1")})}, wantErr: "Invalid route gateway"},
+			{name: "bad destination", m: configurationModel{Routes: routes(t, routeModel{To: types.StringValue("10.2.0.0"), Via: types.StringValue("10.1.0.1")})}, wantErr: "Invalid route destination"},
+		}
+		for _, tt := range tests {
+			var diags diag.Diagnostics
+			validatePrivateNetwork(ctx, &diags, tt.m)
+			found := false
+			for _, d := range diags.Errors() {
+				found = found || d.Summary() == tt.wantErr
+			}
+			if !found {
+				t.Errorf("%s: expected %q, got %v", tt.name, tt.wantErr, diags)
+			}
+		}
+	})
+}
+
+func TestVSwitchIDsDiff(t *testing.T) {
+	ctx := context.Background()
+	ids := func(v ...int64) types.List {
+		l, _ := types.ListValueFrom(ctx, types.Int64Type, v)
+		return l
+	}
+	entries := types.ListNull(types.ObjectType{AttrTypes: map[string]attr.Type{
+		"vswitch_id": types.Int64Type,
+		"vlan":       types.Int64Type,
+		"local_ip":   types.StringType,
+		"mtu":        types.Int64Type,
+	}})
+
+	old := configurationModel{VSwitchID: types.Int64Null(), VSwitchIDs: ids(10, 20), VSwitches: entries}
+	plan := configurationModel{VSwitchID: types.Int64Value(30), VSwitchIDs: ids(20, 30, 40), VSwitches: entries}
+	if got := attachedVSwitchIDs(ctx, plan); len(got) != 3 || got[0] != 30 || got[1] != 20 || got[2] != 40 {
+		t.Fatalf("expected [30 20 40], got %v", got)
+	}
+
+	var calls []string
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+	})
+	res := &configurationResource{providerData: pd}
+
+	var diags diag.Diagnostics
+	res.syncVSwitches(ctx, &diags, 111, "1.2.3.4", attachedVSwitchIDs(ctx, old), attachedVSwitchIDs(ctx, plan))
+	want := []string{"DELETE /vswitch/10/server", "POST /vswitch/30/server", "POST /vswitch/40/server"}
+	if diags.HasError() || strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v (%v)", want, calls, diags)
+	}
+
+	// Destroy detaches from everything
+	calls = nil
+	res.syncVSwitches(ctx, &diags, 111, "1.2.3.4", attachedVSwitchIDs(ctx, plan), nil)
+	want = []string{"DELETE /vswitch/30/server", "DELETE /vswitch/20/server", "DELETE /vswitch/40/server"}
+	if diags.HasError() || strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v (%v)", want, calls, diags)
+	}
+
+	validateVSwitches(ctx, &diags, configurationModel{VSwitchID: types.Int64Null(), VSwitchName: types.StringNull(), VSwitchIDs: ids(5, 0, 5), VSwitches: entries})
+	if len(diags.Errors()) != 2 {
+		t.Fatalf("expected an invalid and a duplicate ID error, got %v", diags)
+	}
+
+	diags = nil
+	both, _ := types.ListValueFrom(ctx, entries.ElementType(ctx), []vswitchEntryModel{{
+		VSwitchID: types.Int64Value(6), VLAN: types.Int64Value(4010), LocalIP: types.StringNull(), MTU: types.Int64Null(),
+	}})
+	validateVSwitches(ctx, &diags, configurationModel{VSwitchID: types.Int64Null(), VSwitchName: types.StringNull(), VSwitchIDs: ids(5), VSwitches: both})
+	if len(diags.Errors()) != 1 || diags.Errors()[0].Summary() != "Conflicting vSwitch settings" {
+		t.Fatalf("expected vswitch_ids and vswitches to conflict, got %v", diags)
+	}
+}
+
+func TestCheckVSwitchLocations(t *testing.T) {
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/server":
+			_, _ = w.Write([]byte(`[
+				{"server":{"server_number":123,"server_ip":"1.1.1.1","product":"AX41","dc":"HEL1-DC2","status":"ready"}},
+				{"server":{"server_number":2,"server_ip":"2.2.2.2","dc":"FSN1-DC14","status":"ready"}},
+				{"server":{"server_number":3,"server_ip":"3.3.3.3","dc":"NBG1-DC3","status":"ready"}}
+			]`))
+		case "/vswitch/7":
+			_, _ = w.Write([]byte(`{"id":7,"vlan":4001,"name":"private","server":[{"server_number":123},{"server_number":2},{"server_number":3}]}`))
+		case "/vswitch/8":
+			_, _ = w.Write([]byte(`{"id":8,"vlan":4002,"name":"hel","server":[{"server_number":123}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	res := &configurationResource{providerData: pd}
+	ctx := context.Background()
+
+	m := configurationModel{
+		ServerNumber:   types.Int64Value(123),
+		ServerLocation: types.StringUnknown(),
+		VSwitchID:      types.Int64Value(7),
+		VSwitchIDs:     types.ListNull(types.Int64Type),
+		VSwitches:      types.ListNull(types.ObjectType{AttrTypes: map[string]attr.Type{}}),
+	}
+	res.refreshServerDetails(ctx, &m)
+	if m.ServerLocation.ValueString() != "HEL1" || m.ServerProduct.ValueString() != "AX41" {
+		t.Fatalf("unexpected server details: %s %s", m.ServerLocation, m.ServerProduct)
+	}
+
+	var diags diag.Diagnostics
+	res.checkVSwitchLocations(ctx, &diags, m)
+	if diags.WarningsCount() != 1 || !strings.Contains(diags.Warnings()[0].Detail(), "in HEL1, but the other servers of vSwitch 7 are in FSN1, NBG1") {
+		t.Fatalf("expected a location warning, got %v", diags)
+	}
+
+	// A vSwitch without other members, or with one in the same location, is fine
+	m.VSwitchID = types.Int64Value(8)
+	diags = nil
+	res.checkVSwitchLocations(ctx, &diags, m)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	if got := vswitchLocations([]client.VSwitchServer{{ServerNumber: 2}, {ServerNumber: 4}}, []client.Server{{ServerNumber: 2, Location: "hel1"}}, 123); strings.Join(got, ",") != "HEL1" {
+		t.Fatalf("unexpected locations %q", got)
+	}
+}
+
+func TestVLANInterfaceName(t *testing.T) {
+	ctx := context.Background()
+	for name, valid := range map[string]bool{
+		"vlan4001":          true,
+		"priv0":             true,
+		"eth0;reboot":       false,
+		"":                  false,
+		"private-vlan-4001": false,
+	} {
+		var diags diag.Diagnostics
+		validatePrivateNetwork(ctx, &diags, configurationModel{VLANInterfaceName: types.StringValue(name)})
+		if diags.HasError() == valid {
+			t.Errorf("vlan_interface_name %q: valid = %v, diags %v", name, !diags.HasError(), diags)
+		}
+	}
+
+	data := &PostInstallTemplateData{LocalIP: "10.1.0.5", VLANMTU: 1400, ParentMTU: 1500, Routes: defaultRoutes, VLANName: "vlan4001"}
+	execute := func(name string, data interface{}) string {
+		t.Helper()
+		var buf strings.Builder
+		if err := postinstallFirstRunTemplate.ExecuteTemplate(&buf, name, data); err != nil {
+			t.Fatalf("render %s: %v", name, err)
+		}
+		return buf.String()
+	}
+	if out := execute("netplan", data); !strings.Contains(out, "  vlans:\n    vlan4001:\n      id: 4001\n      link: ${DEFAULT_IFACE}\n") {
+		t.Errorf("netplan does not use vlan_interface_name:\n%s", out)
+	}
+	ifupdown := execute("ifupdown", data)
+	for _, want := range []string{
+		"iface vlan4001 inet static\n",
+		"pre-up ip link add link ${DEFAULT_IFACE} name vlan4001 type vlan id 4001\n",
+		"metric 100 dev vlan4001",
+	} {
+		if !strings.Contains(ifupdown, want) {
+			t.Errorf("ifupdown is missing %q:\n%s", want, ifupdown)
+		}
+	}
+	if strings.Contains(ifupdown, "vlan-raw-device") {
+		t.Errorf("ifupdown names the interface after its parent:\n%s", ifupdown)
+	}
+	if out := execute("networkd-parent", data); !strings.Contains(out, "VLAN=vlan4001\n") {
+		t.Errorf("networkd parent does not use vlan_interface_name:\n%s", out)
+	}
+	if out := execute("networkd-netdev", data.NetworkVLANs()[0]); !strings.Contains(out, "Name=vlan4001\n") {
+		t.Errorf("networkd netdev does not use vlan_interface_name:\n%s", out)
+	}
+
+	first, err := renderScript(postinstallFirstRunTemplate, data)
+	if err != nil {
+		t.Fatalf("render first-run: %v", err)
+	}
+	if bash, err := exec.LookPath("bash"); err == nil {
+		cmd := exec.Command(bash, "-n")
+		cmd.Stdin = strings.NewReader(first)
+		if msg, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("rendered script is not valid bash: %v\n%s", err, msg)
+		}
+	}
+	if !strings.Contains(first, `VLAN_IFACE="vlan4001"`) || strings.Contains(first, "${DEFAULT_IFACE}.4001") {
+		t.Error("expected the first-run script to wait on vlan_interface_name")
+	}
+
+	script := buildK3SScript(ctx, K3SConfig{Role: k3sRoleAgent, Token: "t", URL: "https://10.0.0.2:6443", VLANInterface: "vlan4001"}, "10.1.0.5", "1.2.3.4")
+	if !strings.Contains(script, "VLAN_IFACE=\"vlan4001\"\n") || strings.Contains(script, "DEFAULT_IFACE") {
+		t.Errorf("K3S script does not use vlan_interface_name:\n%s", script)
+	}
+
+	// vlan_interface_computed comes from the VLAN ID, whatever the interface is named
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+	run := func(cmd string) (string, error) {
+		fake := `ip() { printf '%s\n' '2: enp9s0: <BROADCAST,MULTICAST,UP> mtu 1500 state UP' '3: vlan100@enp9s0: <BROADCAST,UP> mtu 1400 state UP \    vlan protocol 802.1Q id 100 <REORDER_HDR>' '4: vlan4001@enp9s0: <BROADCAST,UP> mtu 1400 state UP \    vlan protocol 802.1Q id 4001 <REORDER_HDR>'; }
+export -f ip
+`
+		out, err := exec.Command(bash, "-c", fake+cmd).CombinedOutput()
+		return string(out), err
+	}
+	if got, err := readVLANInterface(run); err != nil || got != "vlan4001" {
+		t.Errorf("readVLANInterface = %q, %v", got, err)
+	}
+}
+
+// vswitch_id is computed from vswitch_name, so removing both from the configuration leaves the
+// ID from state in the proposed plan
+func TestRemoveVSwitchIDDetachesServer(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	res := &configurationResource{providerData: testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/vswitch/") {
+			calls = append(calls, r.Method+" "+r.URL.Path)
+			return
+		}
+		testServer111(w, r)
+	})}
+	schema, _ := configurationType(t)
+	version := tftypes.NewValue(tftypes.Number, 3)
+	state := configurationRaw(t, map[string]tftypes.Value{"version": version, "vswitch_id": tftypes.NewValue(tftypes.Number, 5)})
+
+	req := resource.ModifyPlanRequest{
+		Config: tfsdk.Config{Schema: schema, Raw: configurationRaw(t, map[string]tftypes.Value{"version": version})},
+		State:  tfsdk.State{Schema: schema, Raw: state},
+		Plan:   tfsdk.Plan{Schema: schema, Raw: state},
+	}
+	planResp := resource.ModifyPlanResponse{Plan: req.Plan}
+	res.ModifyPlan(ctx, req, &planResp)
+	var planned types.Int64
+	planResp.Plan.GetAttribute(ctx, path.Root("vswitch_id"), &planned)
+	if planResp.Diagnostics.HasError() || !planned.IsNull() {
+		t.Fatalf("expected vswitch_id to be planned as null, got %s: %v", planned, planResp.Diagnostics)
+	}
+
+	resp := updateConfiguration(t, res, state, planResp.Plan.Raw)
+	if resp.Diagnostics.HasError() || strings.Join(calls, ",") != "DELETE /vswitch/5/server" {
+		t.Fatalf("expected the server to be detached from vSwitch 5, got %v: %v", calls, resp.Diagnostics)
+	}
+
+	// A vswitch_name still in the configuration keeps the resolved ID
+	req.Config.Raw = configurationRaw(t, map[string]tftypes.Value{"version": version, "vswitch_name": tftypes.NewValue(tftypes.String, "private")})
+	planResp = resource.ModifyPlanResponse{Plan: req.Plan}
+	res.ModifyPlan(ctx, req, &planResp)
+	planResp.Plan.GetAttribute(ctx, path.Root("vswitch_id"), &planned)
+	if planned.ValueInt64() != 5 {
+		t.Fatalf("expected vswitch_id 5 to be kept, got %s", planned)
+	}
+}
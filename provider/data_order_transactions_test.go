@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestOrderTransactionsDataSource(t *testing.T) {
+	ctx := context.Background()
+	var paths []string
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/order/server/transaction":
+			_, _ = w.Write([]byte(`[
+				{"transaction":{"id":"B1","date":"2024-01-01T10:00:00+01:00","status":"ready","server_number":1,"server_ip":"192.0.2.1","product":{"id":"EX44"}}},
+				{"transaction":{"id":"B2","date":"","status":"in process","server_number":null,"server_ip":null,"product":{"id":"AX42"}}}
+			]`))
+		case "/order/server_market/transaction":
+			_, _ = w.Write([]byte(`[{"transaction":{"id":"M1","status":"Ready","server_number":2,"server_ip":"192.0.2.2","product":{"id":2783507}}}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	ds := &orderTransactionsDataSource{providerData: pd}
+
+	var schemaResp datasource.SchemaResponse
+	ds.Schema(ctx, datasource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	read := func(market interface{}, status interface{}) orderTransactionsModel {
+		t.Helper()
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["market"] = tftypes.NewValue(tftypes.Bool, market)
+		vals["status"] = tftypes.NewValue(tftypes.String, status)
+		req := datasource.ReadRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}
+		resp := datasource.ReadResponse{State: tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, nil)}}
+		ds.Read(ctx, req, &resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatalf("unexpected errors: %v", resp.Diagnostics)
+		}
+		var state orderTransactionsModel
+		resp.Diagnostics.Append(resp.State.Get(ctx, &state)...)
+		return state
+	}
+
+	all := read(nil, nil)
+	if len(all.Transactions) != 2 || all.Transactions[0].Date.ValueString() != "2024-01-01T10:00:00+01:00" ||
+		!all.Transactions[1].Date.IsNull() || !all.Transactions[1].ServerNumber.IsNull() || all.Transactions[1].Product.ValueString() != "AX42" {
+		t.Fatalf("unexpected transactions: %+v", all.Transactions)
+	}
+	ready := read(nil, "READY")
+	if len(ready.Transactions) != 1 || ready.Transactions[0].ID.ValueString() != "B1" || ready.ID.Equal(all.ID) {
+		t.Fatalf("unexpected filtered transactions: %+v", ready)
+	}
+	market := read(true, "ready")
+	if len(market.Transactions) != 1 || market.Transactions[0].Product.ValueString() != "2783507" || paths[len(paths)-1] != "/order/server_market/transaction" {
+		t.Fatalf("unexpected market transactions: %+v (paths %v)", market.Transactions, paths)
+	}
+}
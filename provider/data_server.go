@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	dschema "github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+type serverDataSource struct {
+	providerData *ProviderData
+}
+
+func NewDataServer() datasource.DataSource {
+	return &serverDataSource{}
+}
+
+func (d *serverDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_server"
+}
+
+func (d *serverDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	attributes := serverAttributes()
+	attributes["server_number"] = dschema.Int64Attribute{
+		Required:    true,
+		Description: "The server number to look up",
+	}
+	resp.Schema = dschema.Schema{
+		Description: "Fetches a single server from Hetzner Robot, served from the same bulk cache as hrobot_servers.",
+		Attributes:  attributes,
+	}
+}
+
+func (d *serverDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, _ *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	d.providerData = req.ProviderData.(*ProviderData)
+}
+
+func (d *serverDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var serverNumber types.Int64
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("server_number"), &serverNumber)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	server, err := d.providerData.CacheManager.GetServer(d.providerData.Client, int(serverNumber.ValueInt64()))
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to fetch server", err.Error())
+		return
+	}
+
+	tflog.Info(ctx, "Read server", map[string]interface{}{
+		"server_number":      server.ServerNumber,
+		"status":             server.Status,
+		"cache_refreshed_at": d.providerData.CacheManager.RefreshedAt().Format(time.RFC3339),
+	})
+
+	state := newServerModel(*server)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
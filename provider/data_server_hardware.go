@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	dschema "github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+type serverHardwareDataSource struct {
+	providerData *ProviderData
+}
+
+type serverHardwareModel struct {
+	ID           types.String         `tfsdk:"id"`
+	ServerNumber types.Int64          `tfsdk:"server_number"`
+	CPU          types.String         `tfsdk:"cpu"`
+	MemoryGB     types.Int64          `tfsdk:"memory_gb"`
+	Drives       []hardwareDriveModel `tfsdk:"drives"`
+}
+
+type hardwareDriveModel struct {
+	Model     types.String `tfsdk:"model"`
+	Serial    types.String `tfsdk:"serial"`
+	SizeBytes types.Int64  `tfsdk:"size_bytes"`
+}
+
+func NewDataServerHardware() datasource.DataSource {
+	return &serverHardwareDataSource{}
+}
+
+func (d *serverHardwareDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_server_hardware"
+}
+
+func (d *serverHardwareDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = dschema.Schema{
+		Description: "Reads the hardware Robot reports for a server (CPU, memory, drives with serials). Robot only has it for some servers; otherwise drives is empty and a warning is shown.",
+		Attributes: map[string]dschema.Attribute{
+			"id": dschema.StringAttribute{
+				Computed:    true,
+				Description: "The server number",
+			},
+			"server_number": dschema.Int64Attribute{
+				Required:    true,
+				Description: "The server number to look up",
+			},
+			"cpu": dschema.StringAttribute{
+				Computed:    true,
+				Description: "CPU model; null when Robot doesn't report it",
+			},
+			"memory_gb": dschema.Int64Attribute{
+				Computed:    true,
+				Description: "Memory in GB; null when Robot doesn't report it",
+			},
+			"drives": dschema.ListNestedAttribute{
+				Computed:    true,
+				Description: "Drives in the order Robot returns them. The serial identifies a drive across reinstalls, unlike its device name",
+				NestedObject: dschema.NestedAttributeObject{
+					Attributes: map[string]dschema.Attribute{
+						"model":      dschema.StringAttribute{Computed: true, Description: "Drive model"},
+						"serial":     dschema.StringAttribute{Computed: true, Description: "Drive serial number"},
+						"size_bytes": dschema.Int64Attribute{Computed: true, Description: "Drive size in bytes"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *serverHardwareDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, _ *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	d.providerData = req.ProviderData.(*ProviderData)
+}
+
+func (d *serverHardwareDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var serverNumber types.Int64
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("server_number"), &serverNumber)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	hardware, err := d.providerData.Client.GetServerHardware(int(serverNumber.ValueInt64()))
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to fetch server hardware", robotErrorDetail(err, "read servers", "Server"))
+		return
+	}
+	if hardware == nil {
+		resp.Diagnostics.AddWarning("No hardware data",
+			fmt.Sprintf("Robot does not report hardware details for server %d, so drives is empty.", serverNumber.ValueInt64()))
+	}
+
+	state := newServerHardwareModel(serverNumber.ValueInt64(), hardware)
+	tflog.Info(ctx, "Read server hardware", map[string]interface{}{
+		"server_number": serverNumber.ValueInt64(),
+		"drives":        len(state.Drives),
+	})
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// newServerHardwareModel converts what Robot reported; nil hardware gives an empty drive list
+func newServerHardwareModel(serverNumber int64, hardware *client.ServerHardware) serverHardwareModel {
+	m := serverHardwareModel{
+		ID:           types.StringValue(fmt.Sprintf("%d", serverNumber)),
+		ServerNumber: types.Int64Value(serverNumber),
+		CPU:          types.StringNull(),
+		MemoryGB:     types.Int64Null(),
+		Drives:       []hardwareDriveModel{},
+	}
+	if hardware == nil {
+		return m
+	}
+	if hardware.CPU != "" {
+		m.CPU = types.StringValue(hardware.CPU)
+	}
+	if hardware.MemoryGB > 0 {
+		m.MemoryGB = types.Int64Value(int64(hardware.MemoryGB))
+	}
+	for _, drive := range hardware.Drives {
+		m.Drives = append(m.Drives, hardwareDriveModel{
+			Model:     types.StringValue(drive.Model),
+			Serial:    types.StringValue(drive.Serial),
+			SizeBytes: types.Int64Value(drive.SizeBytes),
+		})
+	}
+	return m
+}
@@ -2,11 +2,19 @@ package provider
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	dschema "github.com/hashicorp/terraform-plugin-framework/datasource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
 )
 
 type serversDataSource struct {
@@ -14,16 +22,139 @@ type serversDataSource struct {
 }
 
 type serversModel struct {
-	Servers []serverModel `tfsdk:"servers"`
+	ID              types.String           `tfsdk:"id"`
+	VSwitchID       types.Int64            `tfsdk:"vswitch_id"`
+	Servers         []serverModel          `tfsdk:"servers"`
+	ServersByNumber map[string]serverModel `tfsdk:"servers_by_number"`
+	ServersByName   map[string]serverModel `tfsdk:"servers_by_name"`
 }
 
 type serverModel struct {
-	ServerNumber types.Int64  `tfsdk:"server_number"`
-	ServerName   types.String `tfsdk:"server_name"`
-	ServerIP     types.String `tfsdk:"server_ip"`
-	Status       types.String `tfsdk:"status"`
-	Product      types.String `tfsdk:"product"`
-	Location     types.String `tfsdk:"location"`
+	ServerNumber     types.Int64    `tfsdk:"server_number"`
+	ServerName       types.String   `tfsdk:"server_name"`
+	ServerIP         types.String   `tfsdk:"server_ip"`
+	Status           types.String   `tfsdk:"status"`
+	Product          types.String   `tfsdk:"product"`
+	Location         types.String   `tfsdk:"location"`
+	DC               types.String   `tfsdk:"dc"`
+	Traffic          types.String   `tfsdk:"traffic"`
+	Flatrate         types.Bool     `tfsdk:"flatrate"`
+	Cancelled        types.Bool     `tfsdk:"cancelled"`
+	PaidUntil        types.String   `tfsdk:"paid_until"`
+	IPs              []types.String `tfsdk:"ips"`
+	Subnets          []subnetModel  `tfsdk:"subnets"`
+	LinkedStoragebox types.Int64    `tfsdk:"linked_storagebox"`
+	Locked           types.Bool     `tfsdk:"locked"`
+}
+
+type subnetModel struct {
+	IP   types.String `tfsdk:"ip"`
+	Mask types.String `tfsdk:"mask"`
+}
+
+// newServerModel converts a Robot server record into its data source representation
+func newServerModel(server client.Server) serverModel {
+	m := serverModel{
+		ServerNumber:     types.Int64Value(int64(server.ServerNumber)),
+		ServerName:       types.StringValue(server.ServerName),
+		ServerIP:         types.StringValue(server.ServerIP),
+		Status:           types.StringValue(server.Status),
+		Product:          types.StringValue(server.Product),
+		Location:         types.StringValue(server.Location),
+		DC:               types.StringValue(server.DC),
+		Traffic:          types.StringValue(server.Traffic),
+		Flatrate:         types.BoolValue(server.Flatrate),
+		Cancelled:        types.BoolValue(server.Cancelled),
+		PaidUntil:        types.StringValue(server.PaidUntil),
+		IPs:              make([]types.String, len(server.IP)),
+		Subnets:          make([]subnetModel, len(server.Subnet)),
+		LinkedStoragebox: types.Int64Null(),
+	}
+	_, locked := server.LockReason()
+	m.Locked = types.BoolValue(locked)
+	for i, ip := range server.IP {
+		m.IPs[i] = types.StringValue(ip)
+	}
+	for i, subnet := range server.Subnet {
+		m.Subnets[i] = subnetModel{IP: types.StringValue(subnet.IP), Mask: types.StringValue(subnet.Mask)}
+	}
+	if server.LinkedStoragebox != nil {
+		m.LinkedStoragebox = types.Int64Value(int64(*server.LinkedStoragebox))
+	}
+	return m
+}
+
+// serverAttributes describes a single server; shared by hrobot_servers and hrobot_server
+func serverAttributes() map[string]dschema.Attribute {
+	return map[string]dschema.Attribute{
+		"server_number": dschema.Int64Attribute{
+			Computed:    true,
+			Description: "The server number",
+		},
+		"server_name": dschema.StringAttribute{
+			Computed:    true,
+			Description: "The server name",
+		},
+		"server_ip": dschema.StringAttribute{
+			Computed:    true,
+			Description: "The server IP address",
+		},
+		"status": dschema.StringAttribute{
+			Computed:    true,
+			Description: "The server status",
+		},
+		"product": dschema.StringAttribute{
+			Computed:    true,
+			Description: "The server product",
+		},
+		"location": dschema.StringAttribute{
+			Computed:    true,
+			Description: "The server location",
+		},
+		"dc": dschema.StringAttribute{
+			Computed:    true,
+			Description: "The datacenter the server is in (e.g., FSN1-DC14)",
+		},
+		"traffic": dschema.StringAttribute{
+			Computed:    true,
+			Description: "The included traffic (e.g., 5 TB or unlimited)",
+		},
+		"flatrate": dschema.BoolAttribute{
+			Computed:    true,
+			Description: "Whether the server has a traffic flatrate",
+		},
+		"cancelled": dschema.BoolAttribute{
+			Computed:    true,
+			Description: "Whether the server has been cancelled",
+		},
+		"locked": dschema.BoolAttribute{
+			Computed:    true,
+			Description: "Whether Robot has locked the server (abuse, unpaid invoices); resets and installs are refused while locked",
+		},
+		"paid_until": dschema.StringAttribute{
+			Computed:    true,
+			Description: "The date the server is paid until",
+		},
+		"ips": dschema.ListAttribute{
+			Computed:    true,
+			ElementType: types.StringType,
+			Description: "The single IP addresses assigned to the server",
+		},
+		"subnets": dschema.ListNestedAttribute{
+			Computed:    true,
+			Description: "The subnets assigned to the server",
+			NestedObject: dschema.NestedAttributeObject{
+				Attributes: map[string]dschema.Attribute{
+					"ip":   dschema.StringAttribute{Computed: true, Description: "Subnet address"},
+					"mask": dschema.StringAttribute{Computed: true, Description: "Subnet mask"},
+				},
+			},
+		},
+		"linked_storagebox": dschema.Int64Attribute{
+			Computed:    true,
+			Description: "ID of the linked storage box, if any",
+		},
+	}
 }
 
 func NewDataServers() datasource.DataSource {
@@ -38,36 +169,33 @@ func (d *serversDataSource) Schema(_ context.Context, _ datasource.SchemaRequest
 	resp.Schema = dschema.Schema{
 		Description: "Fetches all servers from Hetzner Robot using bulk API call for efficiency.",
 		Attributes: map[string]dschema.Attribute{
+			"id": dschema.StringAttribute{
+				Computed:    true,
+				Description: "SHA256 of the sorted server numbers; changes whenever the server list does",
+			},
+			"vswitch_id": dschema.Int64Attribute{
+				Optional:    true,
+				Description: "Only list the servers attached to this vSwitch",
+			},
 			"servers": dschema.ListNestedAttribute{
 				Computed:    true,
-				Description: "List of all servers",
+				Description: "List of all servers, or of the vSwitch members when vswitch_id is set",
+				NestedObject: dschema.NestedAttributeObject{
+					Attributes: serverAttributes(),
+				},
+			},
+			"servers_by_number": dschema.MapNestedAttribute{
+				Computed:    true,
+				Description: "The same servers keyed by server number (as a string), for for_each",
+				NestedObject: dschema.NestedAttributeObject{
+					Attributes: serverAttributes(),
+				},
+			},
+			"servers_by_name": dschema.MapNestedAttribute{
+				Computed:    true,
+				Description: "The same servers keyed by server name, leaving out unnamed ones. When names repeat, the server with the highest number wins and a warning lists the names",
 				NestedObject: dschema.NestedAttributeObject{
-					Attributes: map[string]dschema.Attribute{
-						"server_number": dschema.Int64Attribute{
-							Computed:    true,
-							Description: "The server number",
-						},
-						"server_name": dschema.StringAttribute{
-							Computed:    true,
-							Description: "The server name",
-						},
-						"server_ip": dschema.StringAttribute{
-							Computed:    true,
-							Description: "The server IP address",
-						},
-						"status": dschema.StringAttribute{
-							Computed:    true,
-							Description: "The server status",
-						},
-						"product": dschema.StringAttribute{
-							Computed:    true,
-							Description: "The server product",
-						},
-						"location": dschema.StringAttribute{
-							Computed:    true,
-							Description: "The server location",
-						},
-					},
+					Attributes: serverAttributes(),
 				},
 			},
 		},
@@ -82,6 +210,12 @@ func (d *serversDataSource) Configure(_ context.Context, req datasource.Configur
 }
 
 func (d *serversDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var config serversModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	tflog.Info(ctx, "Fetching all servers using bulk API call")
 
 	// Use the cache manager to get all servers (fetches once per apply)
@@ -91,23 +225,89 @@ func (d *serversDataSource) Read(ctx context.Context, req datasource.ReadRequest
 		return
 	}
 
+	if !config.VSwitchID.IsNull() {
+		members, err := d.providerData.CacheManager.GetVSwitchServers(d.providerData.Client, int(config.VSwitchID.ValueInt64()))
+		if err != nil {
+			resp.Diagnostics.AddError("Failed to fetch vSwitch servers", robotErrorDetail(err, "read vSwitches", "vSwitch"))
+			return
+		}
+		servers = vswitchMembers(servers, members)
+	}
+
 	tflog.Info(ctx, "Successfully fetched servers", map[string]interface{}{
-		"count": len(servers),
+		"count":              len(servers),
+		"cache_refreshed_at": d.providerData.CacheManager.RefreshedAt().Format(time.RFC3339),
 	})
 
-	var state serversModel
+	state := serversModel{VSwitchID: config.VSwitchID}
 	state.Servers = make([]serverModel, len(servers))
 
 	for i, server := range servers {
-		state.Servers[i] = serverModel{
-			ServerNumber: types.Int64Value(int64(server.ServerNumber)),
-			ServerName:   types.StringValue(server.ServerName),
-			ServerIP:     types.StringValue(server.ServerIP),
-			Status:       types.StringValue(server.Status),
-			Product:      types.StringValue(server.Product),
-			Location:     types.StringValue(server.Location),
-		}
+		state.Servers[i] = newServerModel(server)
+	}
+	state.ID = types.StringValue(serversID(servers))
+
+	var duplicates []string
+	state.ServersByNumber, state.ServersByName, duplicates = serverMaps(state.Servers)
+	if len(duplicates) > 0 {
+		resp.Diagnostics.AddWarning("Duplicate server names",
+			fmt.Sprintf("Several servers are named %s; servers_by_name only has the one with the highest server number for each. Give them distinct names in Robot to address each of them by name.", strings.Join(duplicates, ", ")))
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
 }
+
+// serverMaps keys servers by number and by name. Servers are taken in server number order, so a
+// repeated name deterministically maps to the highest number; the repeated names are returned
+func serverMaps(servers []serverModel) (map[string]serverModel, map[string]serverModel, []string) {
+	sorted := append([]serverModel(nil), servers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ServerNumber.ValueInt64() < sorted[j].ServerNumber.ValueInt64() })
+
+	byNumber := make(map[string]serverModel, len(sorted))
+	byName := make(map[string]serverModel, len(sorted))
+	var duplicates []string
+	for _, s := range sorted {
+		byNumber[strconv.FormatInt(s.ServerNumber.ValueInt64(), 10)] = s
+		name := s.ServerName.ValueString()
+		if name == "" {
+			continue
+		}
+		if _, ok := byName[name]; ok && !containsString(duplicates, name) {
+			duplicates = append(duplicates, name)
+		}
+		byName[name] = s
+	}
+	sort.Strings(duplicates)
+	return byNumber, byName, duplicates
+}
+
+// vswitchMembers keeps the servers whose main IP is attached to the vSwitch
+func vswitchMembers(servers []client.Server, members []client.VSwitchServer) []client.Server {
+	ips := make(map[string]bool, len(members))
+	for _, m := range members {
+		ips[m.ServerIP] = true
+	}
+	var out []client.Server
+	for _, s := range servers {
+		if ips[s.ServerIP] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// serversID derives a stable ID from the set of server numbers, independent of API order
+func serversID(servers []client.Server) string {
+	numbers := make([]int, len(servers))
+	for i, s := range servers {
+		numbers[i] = s.ServerNumber
+	}
+	sort.Ints(numbers)
+
+	parts := make([]string, len(numbers))
+	for i, n := range numbers {
+		parts[i] = strconv.Itoa(n)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, ",")))
+	return hex.EncodeToString(sum[:])
+}
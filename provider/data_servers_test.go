@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+func TestServersIDDeterministic(t *testing.T) {
+	a := []client.Server{{ServerNumber: 3}, {ServerNumber: 1}, {ServerNumber: 2}}
+	b := []client.Server{{ServerNumber: 1}, {ServerNumber: 2}, {ServerNumber: 3}}
+	if serversID(a) != serversID(b) {
+		t.Fatalf("ID must not depend on server order")
+	}
+	if len(serversID(a)) != 64 {
+		t.Fatalf("expected sha256 hex, got %q", serversID(a))
+	}
+	if serversID(append(b, client.Server{ServerNumber: 4})) == serversID(b) {
+		t.Fatalf("ID must change when a server is added")
+	}
+	// "1,23" and "12,3" must not collide
+	if serversID([]client.Server{{ServerNumber: 1}, {ServerNumber: 23}}) == serversID([]client.Server{{ServerNumber: 12}, {ServerNumber: 3}}) {
+		t.Fatalf("ID must separate server numbers")
+	}
+}
+
+func TestServersDataSourceVSwitchFilter(t *testing.T) {
+	ctx := context.Background()
+	var vswitchCalls int32
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/server":
+			var entries []string
+			for i := 1; i <= 5; i++ {
+				entries = append(entries, fmt.Sprintf(`{"server":{"server_number":%d,"server_name":"s%d","server_ip":"192.0.2.%d"}}`, i, i, i))
+			}
+			_, _ = w.Write([]byte("[" + strings.Join(entries, ",") + "]"))
+		case "/vswitch/4321":
+			atomic.AddInt32(&vswitchCalls, 1)
+			_, _ = w.Write([]byte(`{"id":4321,"vlan":4000,"name":"private","cancelled":false,"server":[
+				{"server_ip":"192.0.2.2","server_number":2,"status":"ready"},
+				{"server_ip":"192.0.2.4","server_number":4,"status":"in process"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	ds := &serversDataSource{providerData: pd}
+
+	var schemaResp datasource.SchemaResponse
+	ds.Schema(ctx, datasource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	read := func(vswitchID interface{}) serversModel {
+		t.Helper()
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["vswitch_id"] = tftypes.NewValue(tftypes.Number, vswitchID)
+		req := datasource.ReadRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}
+		resp := datasource.ReadResponse{State: tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, nil)}}
+		ds.Read(ctx, req, &resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatalf("unexpected errors: %v", resp.Diagnostics)
+		}
+		var state serversModel
+		resp.Diagnostics.Append(resp.State.Get(ctx, &state)...)
+		return state
+	}
+
+	if all := read(nil); len(all.Servers) != 5 {
+		t.Fatalf("expected 5 servers without a filter, got %d", len(all.Servers))
+	}
+	for i := 0; i < 2; i++ {
+		members := read(4321)
+		if len(members.Servers) != 2 || members.Servers[0].ServerNumber.ValueInt64() != 2 || members.Servers[1].ServerNumber.ValueInt64() != 4 {
+			t.Fatalf("expected servers 2 and 4, got %+v", members.Servers)
+		}
+	}
+	if got := atomic.LoadInt32(&vswitchCalls); got != 1 {
+		t.Fatalf("expected the vSwitch members to be fetched once, got %d calls", got)
+	}
+}
+
+func TestServersDataSourceMaps(t *testing.T) {
+	ctx := context.Background()
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/server" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+			{"server":{"server_number":30,"server_name":"web","server_ip":"192.0.2.30"}},
+			{"server":{"server_number":10,"server_name":"web","server_ip":"192.0.2.10"}},
+			{"server":{"server_number":20,"server_name":"db","server_ip":"192.0.2.20"}},
+			{"server":{"server_number":40,"server_name":"","server_ip":"192.0.2.40"}}]`))
+	})
+	ds := &serversDataSource{providerData: pd}
+
+	var schemaResp datasource.SchemaResponse
+	ds.Schema(ctx, datasource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	vals := map[string]tftypes.Value{}
+	for name, typ := range objType.AttributeTypes {
+		vals[name] = tftypes.NewValue(typ, nil)
+	}
+	req := datasource.ReadRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}
+	resp := datasource.ReadResponse{State: tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, nil)}}
+	ds.Read(ctx, req, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("unexpected errors: %v", resp.Diagnostics)
+	}
+	if resp.Diagnostics.WarningsCount() != 1 || !strings.Contains(resp.Diagnostics.Warnings()[0].Detail(), "named web;") {
+		t.Fatalf("expected a warning about the repeated name, got %v", resp.Diagnostics)
+	}
+	var state serversModel
+	resp.Diagnostics.Append(resp.State.Get(ctx, &state)...)
+
+	if len(state.ServersByNumber) != 4 || state.ServersByNumber["20"].ServerName.ValueString() != "db" || state.ServersByNumber["40"].ServerIP.ValueString() != "192.0.2.40" {
+		t.Fatalf("unexpected servers_by_number: %+v", state.ServersByNumber)
+	}
+	if len(state.ServersByName) != 2 || state.ServersByName["db"].ServerNumber.ValueInt64() != 20 {
+		t.Fatalf("unexpected servers_by_name: %+v", state.ServersByName)
+	}
+	// The highest number wins whatever order Robot lists them in
+	if got := state.ServersByName["web"].ServerNumber.ValueInt64(); got != 30 {
+		t.Fatalf("expected web to be server 30, got %d", got)
+	}
+	if len(state.Servers) != 4 || state.Servers[0].ServerNumber.ValueInt64() != 30 {
+		t.Fatalf("the servers list must keep the Robot order, got %+v", state.Servers)
+	}
+}
@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+)
+
+// Commands run in the rescue system to inventory the hardware before installing
+const (
+	lsblkCmd   = "lsblk -d -b -n -P -o NAME,SIZE,ROTA,TYPE,MODEL"
+	cpuCmd     = `lscpu | sed -n 's/^Model name:[[:space:]]*//p' | head -n1`
+	memCmd     = `awk '/^MemTotal:/ {print $2}' /proc/meminfo`
+	nicNameCmd = "ls -d /sys/class/net/*/device 2>/dev/null | cut -d/ -f5"
+)
+
+// diskInfo describes a block device found in the rescue system
+type diskInfo struct {
+	name       string
+	sizeBytes  int64
+	rotational bool
+	model      string
+}
+
+// selectDisks picks the install disks from disks sorted largest first, and the disks to wipe:
+// 1 disk:  use it (no RAID)
+// 2 disks: use both (RAID)
+// 3 disks: use only the largest (no RAID), wipe the 2 smaller
+// 4 disks: use the 2 largest (RAID), wipe the 2 smaller
+func selectDisks(disks []diskInfo) (drive1, drive2 string, unused []string) {
+	switch len(disks) {
+	case 1:
+		drive1 = disks[0].name
+	case 2:
+		drive1, drive2 = disks[0].name, disks[1].name
+	case 3:
+		drive1 = disks[0].name
+		unused = []string{disks[1].name, disks[2].name}
+	case 4:
+		drive1, drive2 = disks[0].name, disks[1].name
+		unused = []string{disks[2].name, disks[3].name}
+	}
+	return drive1, drive2, unused
+}
+
+type detectedDriveModel struct {
+	Name       types.String `tfsdk:"name"`
+	Size       types.Int64  `tfsdk:"size"`
+	Rotational types.Bool   `tfsdk:"rotational"`
+	Model      types.String `tfsdk:"model"`
+}
+
+var detectedDriveAttrTypes = map[string]attr.Type{
+	"name":       types.StringType,
+	"size":       types.Int64Type,
+	"rotational": types.BoolType,
+	"model":      types.StringType,
+}
+
+func detectedDriveAttributes() map[string]rschema.Attribute {
+	return map[string]rschema.Attribute{
+		"name":       rschema.StringAttribute{Computed: true, Description: "Device path (e.g., /dev/nvme0n1)"},
+		"size":       rschema.Int64Attribute{Computed: true, Description: "Size in bytes"},
+		"rotational": rschema.BoolAttribute{Computed: true, Description: "True for spinning disks (HDD)"},
+		"model":      rschema.StringAttribute{Computed: true, Description: "Model reported by the disk"},
+	}
+}
+
+var lsblkPair = regexp.MustCompile(`([A-Z]+)="([^"]*)"`)
+
+// parseLsblkPairs parses `lsblk -P` output, keeping only whole disks
+func parseLsblkPairs(out string) ([]diskInfo, error) {
+	var disks []diskInfo
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := map[string]string{}
+		for _, m := range lsblkPair.FindAllStringSubmatch(line, -1) {
+			fields[m[1]] = m[2]
+		}
+		if fields["TYPE"] != "disk" {
+			continue
+		}
+		if fields["NAME"] == "" {
+			return nil, fmt.Errorf("could not parse disk line: %s", line)
+		}
+		size, err := strconv.ParseInt(fields["SIZE"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse disk size from line: %s", line)
+		}
+		disks = append(disks, diskInfo{
+			name:       "/dev/" + fields["NAME"],
+			sizeBytes:  size,
+			rotational: fields["ROTA"] == "1",
+			model:      strings.TrimSpace(fields["MODEL"]),
+		})
+	}
+	return disks, nil
+}
+
+// memTotalGB converts the MemTotal line value (kB) to whole GiB, rounded
+func memTotalGB(out string) int64 {
+	kb, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return (kb + 512*1024) / (1024 * 1024)
+}
+
+// clearHardware sets the hardware attributes to empty values when no rescue system is booted
+func clearHardware(plan *configurationModel) {
+	plan.CPUModel = types.StringNull()
+	plan.MemoryGB = types.Int64Null()
+	plan.NICNames = types.ListValueMust(types.StringType, []attr.Value{})
+	plan.DetectedDrives = types.ListValueMust(types.ObjectType{AttrTypes: detectedDriveAttrTypes}, []attr.Value{})
+}
+
+// detectHardware records CPU, memory and NICs of the rescue system on the plan.
+// Failures are not fatal: the attributes are left empty and the install continues.
+func detectHardware(ctx context.Context, conn *sshx.Handle, plan *configurationModel, disks []diskInfo) {
+	cpuModel, _ := sshx.Run(conn, cpuCmd)
+	memOut, _ := sshx.Run(conn, memCmd)
+	nicOut, _ := sshx.Run(conn, nicNameCmd)
+
+	nics := append([]string{}, strings.Fields(nicOut)...)
+
+	drives := make([]detectedDriveModel, 0, len(disks))
+	for _, d := range disks {
+		drives = append(drives, detectedDriveModel{
+			Name:       types.StringValue(d.name),
+			Size:       types.Int64Value(d.sizeBytes),
+			Rotational: types.BoolValue(d.rotational),
+			Model:      types.StringValue(d.model),
+		})
+	}
+
+	plan.CPUModel = types.StringValue(strings.TrimSpace(cpuModel))
+	plan.MemoryGB = types.Int64Value(memTotalGB(memOut))
+	plan.NICNames, _ = types.ListValueFrom(ctx, types.StringType, nics)
+	plan.DetectedDrives, _ = types.ListValueFrom(ctx, types.ObjectType{AttrTypes: detectedDriveAttrTypes}, drives)
+
+	// Logged so that a failed install still leaves a hardware record in the apply output
+	driveSummary := make([]string, 0, len(disks))
+	for _, d := range disks {
+		kind := "ssd"
+		if d.rotational {
+			kind = "hdd"
+		}
+		driveSummary = append(driveSummary, fmt.Sprintf("%s %dB %s %s", d.name, d.sizeBytes, kind, d.model))
+	}
+	tflog.Info(ctx, "detected hardware", map[string]interface{}{
+		"server_number": plan.ServerNumber.ValueInt64(),
+		"cpu_model":     plan.CPUModel.ValueString(),
+		"memory_gb":     plan.MemoryGB.ValueInt64(),
+		"nic_names":     nics,
+		"drives":        driveSummary,
+	})
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLsblkPairs(t *testing.T) {
+	out := `NAME="nvme0n1" SIZE="512110190592" ROTA="0" TYPE="disk" MODEL="SAMSUNG MZVL2512HCJQ-00B00"
+NAME="sda" SIZE="16000900661248" ROTA="1" TYPE="disk" MODEL="TOSHIBA MG08ACA16TE"
+NAME="loop0" SIZE="3546865664" ROTA="0" TYPE="loop" MODEL=""
+`
+	disks, err := parseLsblkPairs(out)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(disks) != 2 {
+		t.Fatalf("expected 2 disks, got %d: %+v", len(disks), disks)
+	}
+	want := diskInfo{name: "/dev/sda", sizeBytes: 16000900661248, rotational: true, model: "TOSHIBA MG08ACA16TE"}
+	if disks[1] != want {
+		t.Fatalf("unexpected disk: %+v", disks[1])
+	}
+	if disks[0].rotational || disks[0].model != "SAMSUNG MZVL2512HCJQ-00B00" {
+		t.Fatalf("unexpected disk: %+v", disks[0])
+	}
+
+	if _, err := parseLsblkPairs(`NAME="sdb" SIZE="abc" ROTA="1" TYPE="disk" MODEL=""`); err == nil {
+		t.Fatalf("expected size parse error")
+	}
+	if got := memTotalGB("65598412\n"); got != 63 {
+		t.Fatalf("expected 63 GiB, got %d", got)
+	}
+}
+
+func TestSelectDisksUnusedInPostInstall(t *testing.T) {
+	disks := []diskInfo{{name: "/dev/nvme0n1"}, {name: "/dev/nvme1n1"}, {name: "/dev/sda"}, {name: "/dev/sdb"}}
+	cases := []struct {
+		n              int
+		drive1, drive2 string
+		unused         string
+	}{
+		{1, "/dev/nvme0n1", "", ""},
+		{2, "/dev/nvme0n1", "/dev/nvme1n1", ""},
+		{3, "/dev/nvme0n1", "", "/dev/nvme1n1 /dev/sda"},
+		{4, "/dev/nvme0n1", "/dev/nvme1n1", "/dev/sda /dev/sdb"},
+	}
+	for _, c := range cases {
+		drive1, drive2, unused := selectDisks(disks[:c.n])
+		if drive1 != c.drive1 || drive2 != c.drive2 || strings.Join(unused, " ") != c.unused {
+			t.Fatalf("%d disks: got %q %q %q", c.n, drive1, drive2, unused)
+		}
+		out, err := renderScript(postinstallTemplate, &PostInstallTemplateData{CryptPassword: "s3cret", UnusedDisks: strings.Join(unused, " ")})
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		if want := `UNUSED_DISKS="` + c.unused + `"`; !strings.Contains(out, want) {
+			t.Fatalf("%d disks: post-install script missing %s", c.n, want)
+		}
+	}
+}
@@ -1,10 +1,23 @@
 package provider
 
 import (
+	"context"
 	"fmt"
-	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+// rateLimitAttempts and rateLimitWait bound retryRateLimited: Robot's limits reset within minutes
+var (
+	rateLimitAttempts = 5
+	rateLimitWait     = 60 * time.Second
 )
 
 func getenv(k string) string { return os.Getenv(k) }
@@ -17,15 +30,81 @@ func firstNonEmpty(vals ...string) string {
 	return ""
 }
 
-func waitTCP(addr string, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
-		if err == nil {
-			_ = conn.Close()
-			return nil
+// defaultBaseURL is the Robot webservice endpoint used without base_url or HROBOT_BASE_URL
+const defaultBaseURL = "https://robot-ws.your-server.de"
+
+// resolveBaseURL returns base_url, else HROBOT_BASE_URL, else the Robot endpoint. It must be an
+// http(s) URL; trailing slashes are dropped since Robot may 404 on the double slash they cause
+func resolveBaseURL(configured string) (string, error) {
+	base := firstNonEmpty(configured, getenv("HROBOT_BASE_URL"), defaultBaseURL)
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("base_url must be an http(s) URL like %s, got %q", defaultBaseURL, base)
+	}
+	return strings.TrimRight(base, "/"), nil
+}
+
+// resolveAgentSocket returns ssh_agent_socket, else HROBOT_SSH_AGENT_SOCKET, else SSH_AUTH_SOCK
+func resolveAgentSocket(configured string) string {
+	return firstNonEmpty(configured, getenv("HROBOT_SSH_AGENT_SOCKET"), getenv("SSH_AUTH_SOCK"))
+}
+
+// resolveTimeout returns timeout_seconds when set above 0, else HROBOT_TIMEOUT_SECONDS, else 30s
+func resolveTimeout(configured int64) (time.Duration, error) {
+	if configured > 0 {
+		return time.Duration(configured) * time.Second, nil
+	}
+	if env := getenv("HROBOT_TIMEOUT_SECONDS"); env != "" {
+		seconds, err := strconv.Atoi(env)
+		if err != nil || seconds <= 0 {
+			return 0, fmt.Errorf("HROBOT_TIMEOUT_SECONDS must be a positive number of seconds, got %q", env)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 30 * time.Second, nil
+}
+
+// resolveCacheFile returns the transaction cache file in cache_dir, else HROBOT_CACHE_DIR, else
+// the default .cache directory
+func resolveCacheFile(configured string) (string, error) {
+	dir := firstNonEmpty(configured, getenv("HROBOT_CACHE_DIR"))
+	if dir == "" {
+		return cacheFile, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create cache directory: %w", err)
+	}
+	return filepath.Join(dir, "transaction-cache.json"), nil
+}
+
+// robotErrorDetail turns a Robot "not allowed" refusal into a message naming the
+// webservice permission to enable; any other error is returned unchanged.
+func robotErrorDetail(err error, action, permission string) string {
+	if client.IsNotAllowed(err) {
+		return fmt.Sprintf("Your Robot webservice user is not permitted to %s; enable '%s' for the webservice user in Robot settings.\n\n%s", action, permission, err.Error())
+	}
+	return err.Error()
+}
+
+// retryRateLimited runs a Robot call, retrying it while Robot rejects it as rate limited so
+// a busy account doesn't fail the apply; other errors are returned at once
+func retryRateLimited[T any](ctx context.Context, what string, call func() (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		v, err := call()
+		if err == nil || !client.IsRateLimited(err) || attempt >= rateLimitAttempts {
+			return v, err
+		}
+		tflog.Warn(ctx, "Robot rate limit hit, retrying", map[string]interface{}{
+			"call":     what,
+			"attempt":  attempt,
+			"of":       rateLimitAttempts,
+			"wait":     rateLimitWait.String(),
+			"response": err.Error(),
+		})
+		select {
+		case <-ctx.Done():
+			return v, ctx.Err()
+		case <-time.After(rateLimitWait):
 		}
-		time.Sleep(5 * time.Second)
 	}
-	return fmt.Errorf("timeout waiting for %s", addr)
 }
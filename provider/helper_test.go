@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+// testProviderData returns provider data whose client talks to handler, like a configured
+// provider does to Robot
+func testProviderData(t *testing.T, handler http.HandlerFunc) *ProviderData {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return &ProviderData{
+		Client:       client.New(ts.URL, "user", "pass", ts.Client(), client.ClientConfig{}),
+		CacheManager: client.NewCacheManager(),
+		UsedIPs:      make(map[string]bool),
+	}
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	oldWait := rateLimitWait
+	rateLimitWait = time.Millisecond
+	t.Cleanup(func() { rateLimitWait = oldWait })
+
+	var calls int32
+	cl := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":{"status":429,"code":"TOO_MANY_REQUESTS","message":"slow down"}}`))
+		case 2:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"status":403,"code":"RATE_LIMIT_EXCEEDED","message":"rate limit exceeded","max_request":200,"interval":3600}}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"transaction":{"id":"B20250101-3","status":"in process"}}`))
+		}
+	}).Client
+
+	tx, err := retryRateLimited(context.Background(), "order server", func() (*client.Transaction, error) {
+		return cl.OrderServer(client.OrderParams{ProductID: "EX44"})
+	})
+	if err != nil || tx.ID != "B20250101-3" || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("expected success on the third attempt, got %+v, %v after %d calls", tx, err, calls)
+	}
+
+	// Other errors are not retried, and retries stop after rateLimitAttempts
+	calls = 0
+	_, err = retryRateLimited(context.Background(), "get server", func() (struct{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return struct{}{}, &client.APIError{Status: http.StatusNotFound, Code: "SERVER_NOT_FOUND"}
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("expected a single attempt for a non rate limit error, got %d", calls)
+	}
+	calls = 0
+	_, err = retryRateLimited(context.Background(), "set server name", func() (struct{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return struct{}{}, &client.APIError{Status: http.StatusTooManyRequests}
+	})
+	if !client.IsRateLimited(err) || int(calls) != rateLimitAttempts {
+		t.Fatalf("expected %d attempts, got %d (%v)", rateLimitAttempts, calls, err)
+	}
+	if client.IsNotAllowed(&client.APIError{Status: http.StatusForbidden, Code: "RATE_LIMIT_EXCEEDED"}) {
+		t.Fatal("a rate limit is not a missing permission")
+	}
+}
+
+func TestProviderEnvironment(t *testing.T) {
+	t.Setenv("HROBOT_BASE_URL", "")
+	t.Setenv("HROBOT_TIMEOUT_SECONDS", "")
+	t.Setenv("HROBOT_CACHE_DIR", "")
+
+	if base, err := resolveBaseURL(""); err != nil || base != defaultBaseURL {
+		t.Fatalf("expected the default base URL, got %q, %v", base, err)
+	}
+	t.Setenv("HROBOT_BASE_URL", "http://localhost:8080/robot//")
+	if base, err := resolveBaseURL(""); err != nil || base != "http://localhost:8080/robot" {
+		t.Fatalf("expected the environment base URL without trailing slashes, got %q, %v", base, err)
+	}
+	if base, err := resolveBaseURL("https://robot.example.com/"); err != nil || base != "https://robot.example.com" {
+		t.Fatalf("expected base_url to take precedence, got %q, %v", base, err)
+	}
+	for _, bad := range []string{"robot-ws.your-server.de", "ftp://robot.example.com", "https://", "http://%zz"} {
+		if _, err := resolveBaseURL(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+
+	if timeout, err := resolveTimeout(0); err != nil || timeout != 30*time.Second {
+		t.Fatalf("expected a 30s default, got %s, %v", timeout, err)
+	}
+	t.Setenv("HROBOT_TIMEOUT_SECONDS", "90")
+	if timeout, err := resolveTimeout(0); err != nil || timeout != 90*time.Second {
+		t.Fatalf("expected the environment timeout, got %s, %v", timeout, err)
+	}
+	if timeout, err := resolveTimeout(10); err != nil || timeout != 10*time.Second {
+		t.Fatalf("expected timeout_seconds to take precedence, got %s, %v", timeout, err)
+	}
+	t.Setenv("HROBOT_TIMEOUT_SECONDS", "soon")
+	if _, err := resolveTimeout(0); err == nil {
+		t.Fatalf("expected an invalid HROBOT_TIMEOUT_SECONDS to be rejected")
+	}
+
+	if file, err := resolveCacheFile(""); err != nil || file != cacheFile {
+		t.Fatalf("expected the default cache file, got %q, %v", file, err)
+	}
+	envDir, configDir := filepath.Join(t.TempDir(), "env"), filepath.Join(t.TempDir(), "config")
+	t.Setenv("HROBOT_CACHE_DIR", envDir)
+	if file, err := resolveCacheFile(""); err != nil || file != filepath.Join(envDir, "transaction-cache.json") {
+		t.Fatalf("expected the environment cache dir, got %q, %v", file, err)
+	}
+	if _, err := os.Stat(envDir); err != nil {
+		t.Fatalf("expected the cache dir to be created: %v", err)
+	}
+	if file, err := resolveCacheFile(configDir); err != nil || file != filepath.Join(configDir, "transaction-cache.json") {
+		t.Fatalf("expected cache_dir to take precedence, got %q, %v", file, err)
+	}
+}
@@ -0,0 +1,113 @@
+package provider
+
+// networkBackendTemplates render the private network configuration for each network_backend from
+// the same PostInstallTemplateData fields (ParentMTU, VLANMTU, VLANName, Routes, ExtraVLANs). They are
+// parsed into the first-run template; ${DEFAULT_IFACE} and ${LOCAL_IP} are expanded by the script.
+const networkBackendTemplates = `
+{{- define "netplan" -}}
+network:
+  version: 2
+  ${PARENT_KIND}:
+    ${DEFAULT_IFACE}:
+      mtu: {{.ParentMTU}}
+      optional: false
+  vlans:
+    {{.PrivateVLANInterface}}:
+      id: 4001
+      link: ${DEFAULT_IFACE}
+      mtu: {{.VLANMTU}}
+      addresses:
+        - ${LOCAL_IP}/24
+{{- if .Routes}}
+      routes:
+{{- range .Routes}}
+        - to: "{{.To}}"
+          via: "{{.Via}}"
+          metric: {{.Metric}}
+{{- end}}
+{{- end}}
+      optional: false
+      accept-ra: false
+{{- range .ExtraVLANs}}
+    ${DEFAULT_IFACE}.{{.ID}}:
+      id: {{.ID}}
+      link: ${DEFAULT_IFACE}
+      mtu: {{.MTU}}
+{{- if .Address}}
+      addresses:
+        - {{.Address}}
+{{- end}}
+      optional: true
+      accept-ra: false
+{{- end}}
+{{- end}}
+
+{{- define "ifupdown" -}}
+auto {{.PrivateVLANInterface}}
+iface {{.PrivateVLANInterface}} inet static
+    address ${LOCAL_IP}/24
+    mtu {{.VLANMTU}}
+{{- if .VLANName}}
+    pre-up ip link set dev ${DEFAULT_IFACE} mtu {{.ParentMTU}}
+    pre-up ip link add link ${DEFAULT_IFACE} name {{.VLANName}} type vlan id 4001
+    post-down ip link delete {{.VLANName}}
+{{- else}}
+    vlan-raw-device ${DEFAULT_IFACE}
+    pre-up ip link set dev ${DEFAULT_IFACE} mtu {{.ParentMTU}}
+{{- end}}
+{{- range .Routes}}
+    up ip route replace {{.To}} via {{.Via}} metric {{.Metric}} dev {{$.PrivateVLANInterface}}
+{{- end}}
+{{- range .ExtraVLANs}}
+
+auto ${DEFAULT_IFACE}.{{.ID}}
+iface ${DEFAULT_IFACE}.{{.ID}} inet {{if .Address}}static{{else}}manual{{end}}
+{{- if .Address}}
+    address {{.Address}}
+{{- end}}
+    mtu {{.MTU}}
+    vlan-raw-device ${DEFAULT_IFACE}
+{{- end}}
+{{- end}}
+
+{{- define "networkd-parent" -}}
+[Network]
+VLAN={{.PrivateVLANInterface}}
+{{- range .ExtraVLANs}}
+VLAN=${DEFAULT_IFACE}.{{.ID}}
+{{- end}}
+
+[Link]
+MTUBytes={{.ParentMTU}}
+{{- end}}
+
+{{- define "networkd-netdev" -}}
+[NetDev]
+Name={{.Interface}}
+Kind=vlan
+MTUBytes={{.MTU}}
+
+[VLAN]
+Id={{.ID}}
+{{- end}}
+
+{{- define "networkd-network" -}}
+[Match]
+Name={{.Interface}}
+
+[Network]
+{{- if .Address}}
+Address={{.Address}}
+{{- end}}
+LinkLocalAddressing=no
+IPv6AcceptRA=no
+{{- range .Routes}}
+
+[Route]
+{{- if ne .To "default"}}
+Destination={{.To}}
+{{- end}}
+Gateway={{.Via}}
+Metric={{.Metric}}
+{{- end}}
+{{- end}}`
@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestNetworkBackendTemplates(t *testing.T) {
+	data := &PostInstallTemplateData{
+		LocalIP:    "10.1.0.5",
+		VLANMTU:    1400,
+		ParentMTU:  1500,
+		Routes:     defaultRoutes,
+		ExtraVLANs: []VLANTemplateData{{ID: 4010, MTU: 1400, Address: "10.10.0.5/24"}, {ID: 4020, MTU: 1350}},
+	}
+	execute := func(t *testing.T, name string, data interface{}) string {
+		t.Helper()
+		var buf strings.Builder
+		if err := postinstallFirstRunTemplate.ExecuteTemplate(&buf, name, data); err != nil {
+			t.Fatalf("render %s: %v", name, err)
+		}
+		return buf.String()
+	}
+
+	t.Run("ifupdown", func(t *testing.T) {
+		want := `auto ${DEFAULT_IFACE}.4001
+iface ${DEFAULT_IFACE}.4001 inet static
+    address ${LOCAL_IP}/24
+    mtu 1400
+    vlan-raw-device ${DEFAULT_IFACE}
+    pre-up ip link set dev ${DEFAULT_IFACE} mtu 1500
+    up ip route replace 10.0.0.0/16 via 10.1.0.1 metric 100 dev ${DEFAULT_IFACE}.4001
+
+auto ${DEFAULT_IFACE}.4010
+iface ${DEFAULT_IFACE}.4010 inet static
+    address 10.10.0.5/24
+    mtu 1400
+    vlan-raw-device ${DEFAULT_IFACE}
+
+auto ${DEFAULT_IFACE}.4020
+iface ${DEFAULT_IFACE}.4020 inet manual
+    mtu 1350
+    vlan-raw-device ${DEFAULT_IFACE}`
+		if got := execute(t, "ifupdown", data); got != want {
+			t.Fatalf("unexpected ifupdown config:\n%s", got)
+		}
+	})
+
+	t.Run("systemd-networkd", func(t *testing.T) {
+		want := "[Network]\nVLAN=${DEFAULT_IFACE}.4001\nVLAN=${DEFAULT_IFACE}.4010\nVLAN=${DEFAULT_IFACE}.4020\n\n[Link]\nMTUBytes=1500"
+		if got := execute(t, "networkd-parent", data); got != want {
+			t.Fatalf("unexpected parent drop-in:\n%s", got)
+		}
+
+		vlans := data.NetworkVLANs()
+		if len(vlans) != 3 || vlans[0].ID != privateVLAN || vlans[0].Address != "${LOCAL_IP}/24" {
+			t.Fatalf("unexpected VLANs: %+v", vlans)
+		}
+		want = "[NetDev]\nName=${DEFAULT_IFACE}.4001\nKind=vlan\nMTUBytes=1400\n\n[VLAN]\nId=4001"
+		if got := execute(t, "networkd-netdev", vlans[0]); got != want {
+			t.Fatalf("unexpected netdev:\n%s", got)
+		}
+		want = "[Match]\nName=${DEFAULT_IFACE}.4001\n\n[Network]\nAddress=${LOCAL_IP}/24\nLinkLocalAddressing=no\nIPv6AcceptRA=no\n\n[Route]\nDestination=10.0.0.0/16\nGateway=10.1.0.1\nMetric=100"
+		if got := execute(t, "networkd-network", vlans[0]); got != want {
+			t.Fatalf("unexpected network:\n%s", got)
+		}
+		want = "[Match]\nName=${DEFAULT_IFACE}.4020\n\n[Network]\nLinkLocalAddressing=no\nIPv6AcceptRA=no"
+		if got := execute(t, "networkd-network", vlans[2]); got != want {
+			t.Fatalf("unexpected network without address:\n%s", got)
+		}
+	})
+
+	t.Run("script", func(t *testing.T) {
+		for _, backend := range []string{"", networkBackendNetplan, networkBackendIfupdown, networkBackendNetworkd} {
+			d := *data
+			d.NetworkBackend = backend
+			out, err := renderScript(postinstallFirstRunTemplate, &d)
+			if err != nil {
+				t.Fatalf("render %q: %v", backend, err)
+			}
+			if !strings.Contains(out, `NETWORK_BACKEND="`+backend+`"`) {
+				t.Fatalf("backend %q not passed to the script", backend)
+			}
+			for _, file := range []string{"/etc/netplan/50-local-ip.yaml", "/etc/network/interfaces.d/50-local-ip", "/etc/systemd/network/50-vlan4010.netdev"} {
+				if !strings.Contains(out, file) {
+					t.Fatalf("script is missing %s", file)
+				}
+			}
+			if bash, err := exec.LookPath("bash"); err == nil {
+				cmd := exec.Command(bash, "-n")
+				cmd.Stdin = strings.NewReader(out)
+				if msg, err := cmd.CombinedOutput(); err != nil {
+					t.Fatalf("rendered script is not valid bash: %v\n%s", err, msg)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/tfutil"
+)
+
+type addonQuantityModel struct {
+	ID    types.String `tfsdk:"id"`
+	Count types.Int64  `tfsdk:"count"`
+}
+
+func addonQuantitiesAttribute() rschema.ListNestedAttribute {
+	return rschema.ListNestedAttribute{
+		Optional:    true,
+		Description: "Addons ordered more than once (e.g., additional_ipv4), sent as one addon[] entry per unit; combined with addons",
+		NestedObject: rschema.NestedAttributeObject{
+			Attributes: map[string]rschema.Attribute{
+				"id":    rschema.StringAttribute{Required: true, Description: "Addon id"},
+				"count": rschema.Int64Attribute{Optional: true, Description: "Number of units, at least 1 (default: 1)"},
+			},
+		},
+	}
+}
+
+func effectiveAddonsAttribute() rschema.ListAttribute {
+	return rschema.ListAttribute{
+		Computed:    true,
+		ElementType: types.StringType,
+		Description: "Addons as accepted by Robot in the order transaction",
+	}
+}
+
+// expandAddons returns the addon[] entries for the order: the addons list followed by each
+// addon_quantities entry repeated count times
+func expandAddons(ctx context.Context, diags *diag.Diagnostics, addons, quantities types.List) []string {
+	out := tfutil.ElementsAsStrings(ctx, diags, addons)
+	for _, q := range tfutil.ElementsAs[addonQuantityModel](ctx, diags, quantities) {
+		for i := int64(0); i < int64OrDefault(q.Count, 1); i++ {
+			out = append(out, q.ID.ValueString())
+		}
+	}
+	return out
+}
+
+// validateAddonQuantities checks that every addon_quantities entry orders at least one unit
+func validateAddonQuantities(ctx context.Context, diags *diag.Diagnostics, quantities types.List) {
+	if quantities.IsNull() || quantities.IsUnknown() {
+		return
+	}
+	var entries []addonQuantityModel
+	quantities.ElementsAs(ctx, &entries, false)
+	for i, q := range entries {
+		if !q.Count.IsNull() && !q.Count.IsUnknown() && q.Count.ValueInt64() < 1 {
+			diags.AddAttributeError(path.Root("addon_quantities").AtListIndex(i).AtName("count"), "Invalid addon count",
+				fmt.Sprintf("count must be at least 1, got %d.", q.Count.ValueInt64()))
+		}
+	}
+}
+
+// effectiveAddons returns the addons echoed in the transaction as a list value
+func effectiveAddons(ctx context.Context, addons []string) (types.List, diag.Diagnostics) {
+	if addons == nil {
+		addons = []string{}
+	}
+	return types.ListValueFrom(ctx, types.StringType, addons)
+}
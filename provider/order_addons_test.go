@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestOrderAddonQuantities(t *testing.T) {
+	ctx := context.Background()
+
+	var sent []string
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/order/server/transaction" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = r.ParseForm()
+		sent = r.PostForm["addon[]"]
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"transaction":{"id":"B20250101-2","date":"2025-01-01T12:00:00+01:00","product":{"id":"EX44"},"status":"in process","dc":"FSN1-DC14","addons":["primary_ipv4","additional_ipv4","additional_ipv4"]}}`))
+	})
+	pd.TransactionCache = NewTransactionCache(filepath.Join(t.TempDir(), "transaction-cache.json"), "")
+	res := &serverOrderResource{providerData: pd}
+
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	qtyType := objType.AttributeTypes["addon_quantities"].(tftypes.List).ElementType.(tftypes.Object)
+	raw := func(count interface{}) tftypes.Value {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["product_id"] = tftypes.NewValue(tftypes.String, "EX44")
+		vals["addons"] = tftypes.NewValue(tftypes.List{ElementType: tftypes.String}, []tftypes.Value{tftypes.NewValue(tftypes.String, "primary_ipv4")})
+		vals["addon_quantities"] = tftypes.NewValue(objType.AttributeTypes["addon_quantities"], []tftypes.Value{
+			tftypes.NewValue(qtyType, map[string]tftypes.Value{
+				"id":    tftypes.NewValue(tftypes.String, "additional_ipv4"),
+				"count": tftypes.NewValue(tftypes.Number, count),
+			}),
+		})
+		return tftypes.NewValue(objType, vals)
+	}
+
+	var validateResp resource.ValidateConfigResponse
+	res.ValidateConfig(ctx, resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: raw(0)}}, &validateResp)
+	if !validateResp.Diagnostics.HasError() || validateResp.Diagnostics.Errors()[0].Summary() != "Invalid addon count" {
+		t.Fatalf("expected an invalid count error, got %v", validateResp.Diagnostics)
+	}
+
+	req := resource.CreateRequest{Plan: tfsdk.Plan{Schema: schemaResp.Schema, Raw: raw(2)}}
+	resp := resource.CreateResponse{State: tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, nil)}}
+	res.Create(ctx, req, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("unexpected errors: %v", resp.Diagnostics)
+	}
+	if strings.Join(sent, ",") != "primary_ipv4,additional_ipv4,additional_ipv4" {
+		t.Fatalf("unexpected addon[] entries: %v", sent)
+	}
+	var effective []string
+	resp.State.GetAttribute(ctx, path.Root("effective_addons"), &effective)
+	if strings.Join(effective, ",") != "primary_ipv4,additional_ipv4,additional_ipv4" {
+		t.Fatalf("unexpected effective_addons: %v", effective)
+	}
+	var createdAt, orderedProduct, datacenter string
+	resp.State.GetAttribute(ctx, path.Root("created_at"), &createdAt)
+	resp.State.GetAttribute(ctx, path.Root("ordered_product_id"), &orderedProduct)
+	resp.State.GetAttribute(ctx, path.Root("datacenter"), &datacenter)
+	if createdAt != "2025-01-01T12:00:00+01:00" || orderedProduct != "EX44" || datacenter != "FSN1-DC14" {
+		t.Fatalf("unexpected transaction echo: created_at=%q ordered_product_id=%q datacenter=%q", createdAt, orderedProduct, datacenter)
+	}
+}
@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// orderCredentialsValidator enforces Robot's rule for the root login of a preinstalled server:
+// with dist, exactly one of authorized_key_fingerprints and password; without dist, neither is needed
+type orderCredentialsValidator struct{}
+
+var _ resource.ConfigValidator = orderCredentialsValidator{}
+
+func (orderCredentialsValidator) Description(_ context.Context) string {
+	return "with dist set, exactly one of authorized_key_fingerprints and password must be set"
+}
+
+func (orderCredentialsValidator) MarkdownDescription(_ context.Context) string {
+	return "with `dist` set, exactly one of `authorized_key_fingerprints` and `password` must be set"
+}
+
+func (orderCredentialsValidator) ValidateResource(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var dist, password types.String
+	var keys types.List
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("dist"), &dist)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("password"), &password)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("authorized_key_fingerprints"), &keys)...)
+	if resp.Diagnostics.HasError() || dist.IsNull() || dist.IsUnknown() || password.IsUnknown() || keys.IsUnknown() {
+		return
+	}
+
+	hasKeys := !keys.IsNull() && len(keys.Elements()) > 0
+	hasPassword := !password.IsNull()
+	switch {
+	case !hasKeys && !hasPassword:
+		resp.Diagnostics.AddAttributeError(path.Root("authorized_key_fingerprints"), "Missing root login",
+			"Robot needs authorized_key_fingerprints or password to set up root access on the preinstalled dist; set one of them, or remove dist to order without preinstall.")
+	case hasKeys && hasPassword:
+		resp.Diagnostics.AddAttributeError(path.Root("password"), "Conflicting root login",
+			"authorized_key_fingerprints and password cannot both be set when dist is set; Robot rejects the order. Keep one of them.")
+	}
+}
@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestOrderCredentialsValidator(t *testing.T) {
+	ctx := context.Background()
+	oldCacheFile := cacheFile
+	cacheFile = filepath.Join(t.TempDir(), "transaction-cache.json")
+	t.Cleanup(func() { cacheFile = oldCacheFile })
+
+	var requests int32
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch {
+		case r.URL.Path == "/key":
+			_, _ = w.Write([]byte(`[{"key":{"fingerprint":"56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10"}}]`))
+		case r.URL.Path == "/order/server/product/EX44":
+			_, _ = w.Write([]byte(`{"product":{"id":44,"dist":["Rescue system","Ubuntu 24.04 LTS base"]}}`))
+		case r.URL.Path == "/order/server/transaction":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"transaction":{"id":"B20250101-3","status":"in process"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	pd.TransactionCache = NewTransactionCache(cacheFile, "")
+	res := &serverOrderResource{providerData: pd}
+
+	server, err := providerserver.NewProtocol6WithError(New("test")())()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+
+	// apply validates the configuration like Terraform does and only creates the order when that passes
+	apply := func(t *testing.T, typeName string, schema rschema.Schema, attrs map[string]interface{}) []string {
+		objType := schema.Type().TerraformType(ctx).(tftypes.Object)
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		for name, v := range attrs {
+			if fps, ok := v.([]string); ok {
+				var list []tftypes.Value
+				for _, fp := range fps {
+					list = append(list, tftypes.NewValue(tftypes.String, fp))
+				}
+				v = list
+			}
+			vals[name] = tftypes.NewValue(objType.AttributeTypes[name], v)
+		}
+		raw := tftypes.NewValue(objType, vals)
+		config, err := tfprotov6.NewDynamicValue(objType, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		validateResp, err := server.ValidateResourceConfig(ctx, &tfprotov6.ValidateResourceConfigRequest{TypeName: typeName, Config: &config})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var errs []string
+		for _, d := range validateResp.Diagnostics {
+			if d.Severity == tfprotov6.DiagnosticSeverityError {
+				errs = append(errs, d.Summary)
+			}
+		}
+		if len(errs) > 0 || typeName != "hrobot_server_order" {
+			return errs
+		}
+		createResp := resource.CreateResponse{State: tfsdk.State{Schema: schema, Raw: tftypes.NewValue(objType, nil)}}
+		res.Create(ctx, resource.CreateRequest{Plan: tfsdk.Plan{Schema: schema, Raw: raw}}, &createResp)
+		if createResp.Diagnostics.HasError() {
+			t.Fatalf("create failed: %v", createResp.Diagnostics)
+		}
+		return nil
+	}
+
+	key := []string{"56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10"}
+	tests := []struct {
+		name  string
+		attrs map[string]interface{}
+		want  string
+	}{
+		{name: "no preinstall", attrs: map[string]interface{}{}},
+		{name: "dist with keys", attrs: map[string]interface{}{"dist": "Ubuntu 24.04 LTS base", "authorized_key_fingerprints": key}},
+		{name: "dist with password", attrs: map[string]interface{}{"dist": "Ubuntu 24.04 LTS base", "password": "hunter2"}},
+		{name: "dist without login", attrs: map[string]interface{}{"dist": "Ubuntu 24.04 LTS base"}, want: "Missing root login"},
+		{name: "dist with empty keys", attrs: map[string]interface{}{"dist": "Ubuntu 24.04 LTS base", "authorized_key_fingerprints": []string{}}, want: "Missing root login"},
+		{name: "dist with both", attrs: map[string]interface{}{"dist": "Ubuntu 24.04 LTS base", "password": "hunter2", "authorized_key_fingerprints": key}, want: "Conflicting root login"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := atomic.LoadInt32(&requests)
+			order := map[string]interface{}{"product_id": "EX44"}
+			auction := map[string]interface{}{"product_id": big.NewFloat(12345)}
+			for k, v := range tt.attrs {
+				order[k], auction[k] = v, v
+			}
+
+			got := apply(t, "hrobot_server_order", schemaResp.Schema, order)
+			if strings.Join(got, ",") != tt.want {
+				t.Fatalf("server order errors = %v, want %q", got, tt.want)
+			}
+			if tt.want != "" && atomic.LoadInt32(&requests) != before {
+				t.Fatal("an invalid order reached the Robot API")
+			}
+			if tt.want == "" && atomic.LoadInt32(&requests) == before {
+				t.Fatal("a valid order was not placed")
+			}
+
+			var auctionSchema resource.SchemaResponse
+			(&serverAuctionOrderResource{}).Schema(ctx, resource.SchemaRequest{}, &auctionSchema)
+			if got := apply(t, "hrobot_server_auction_order", auctionSchema.Schema, auction); strings.Join(got, ",") != tt.want {
+				t.Fatalf("auction order errors = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
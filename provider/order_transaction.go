@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+func createdAtAttribute() rschema.StringAttribute {
+	return rschema.StringAttribute{
+		Computed:    true,
+		Description: "When the order transaction was created (RFC3339); null when Robot doesn't report a date",
+	}
+}
+
+func datacenterAttribute() rschema.StringAttribute {
+	return rschema.StringAttribute{
+		Computed:    true,
+		Description: "Datacenter of the ordered server (e.g., FSN1-DC14); null until Robot assigns one",
+	}
+}
+
+// transactionCreatedAt returns the transaction date as RFC3339, or null when Robot sent none
+// or one that doesn't parse
+func transactionCreatedAt(tx *client.Transaction) types.String {
+	ts, ok := tx.CreatedAt()
+	if !ok {
+		return types.StringNull()
+	}
+	return types.StringValue(ts.Format(time.RFC3339))
+}
+
+// transactionDatacenter returns the datacenter Robot reported for the order, falling back to the
+// server record once the order has a server; null while neither has one
+func transactionDatacenter(ctx context.Context, pd *ProviderData, tx *client.Transaction) types.String {
+	if tx.DC != "" {
+		return types.StringValue(tx.DC)
+	}
+	if tx.ServerNumber == nil {
+		return types.StringNull()
+	}
+	server, err := pd.CacheManager.GetServer(pd.Client, *tx.ServerNumber)
+	if err != nil {
+		tflog.Warn(ctx, "failed to read the datacenter of the ordered server", map[string]interface{}{
+			"transaction_id": tx.ID,
+			"server_number":  *tx.ServerNumber,
+			"error":          err.Error(),
+		})
+		return types.StringNull()
+	}
+	if server.DC == "" {
+		return types.StringNull()
+	}
+	return types.StringValue(server.DC)
+}
+
+// orderedProductID returns the product id Robot echoed for a standard order, or null
+func orderedProductID(tx *client.Transaction) types.String {
+	if tx.OrderedProduct == "" {
+		return types.StringNull()
+	}
+	return types.StringValue(tx.OrderedProduct)
+}
+
+// orderedMarketProductID returns the auction product id Robot echoed, or null
+func orderedMarketProductID(tx *client.Transaction) types.Int64 {
+	id, err := strconv.ParseInt(tx.OrderedProduct, 10, 64)
+	if err != nil {
+		return types.Int64Null()
+	}
+	return types.Int64Value(id)
+}
@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+func TestTransactionDatacenter(t *testing.T) {
+	ctx := context.Background()
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/server" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"server":{"server_number":321,"server_ip":"1.2.3.4","dc":"HEL1-DC2"}}]`))
+	})
+
+	number := 321
+	cases := []struct {
+		name string
+		tx   client.Transaction
+		want string
+	}{
+		{"from transaction", client.Transaction{ID: "B1", DC: "FSN1-DC14", ServerNumber: &number}, "FSN1-DC14"},
+		{"from server", client.Transaction{ID: "B2", ServerNumber: &number}, "HEL1-DC2"},
+		{"no server yet", client.Transaction{ID: "B3"}, ""},
+	}
+	for _, tc := range cases {
+		got := transactionDatacenter(ctx, pd, &tc.tx)
+		if got.ValueString() != tc.want || got.IsNull() != (tc.want == "") {
+			t.Errorf("%s: datacenter = %s, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -1,9 +1,35 @@
 package provider
 
-// postinstallScript is the comprehensive post-install script for LUKS encryption setup
+// postinstallFirstRunScript runs on the first boot of the installed OS (network, K3S prerequisites),
+// rendered as a text/template with PostInstallTemplateData
 const postinstallFirstRunScript = `#!/bin/bash
 
-LOCAL_IP="LOCALIPADDRESSREPLACEME"
+LOCAL_IP="{{.LocalIP}}"
+{{- if .Hostname}}
+
+# Set the hostname explicitly; installimage only writes it on a full install
+echo "Setting hostname to {{.Hostname}}..."
+hostnamectl set-hostname "{{.Hostname}}" || echo "{{.Hostname}}" > /etc/hostname
+grep -qw "{{.Hostname}}" /etc/hosts || echo "127.0.1.1 {{.Hostname}}" >> /etc/hosts
+{{- end}}
+{{- if or .APTProxy .APTHTTPSProxy}}
+
+# Send apt through the caching proxy, before anything below installs packages
+echo "Configuring apt proxy..."
+mkdir -p /etc/apt/apt.conf.d
+cat > /etc/apt/apt.conf.d/01proxy << 'APT_PROXY_EOF'
+{{- if .APTProxy}}
+Acquire::http::Proxy "{{.APTProxy}}";
+{{- end}}
+{{- if .APTHTTPSProxy}}
+Acquire::https::Proxy "{{.APTHTTPSProxy}}";
+{{- end}}
+APT_PROXY_EOF
+{{- else}}
+
+# No apt proxy configured; drop one left by an earlier configuration
+rm -f /etc/apt/apt.conf.d/01proxy
+{{- end}}
 
 # Verify unused disks remain wiped and create udev rules to prevent mounting
 echo "Checking for wiped disks and creating safeguards..."
@@ -52,12 +78,24 @@ fi
 if [ -n "$LOCAL_IP" ] && [ "$LOCAL_IP" != "" ]; then
     echo "Configuring local IP address: $LOCAL_IP"
 
+{{- if .NetworkInterface}}
+
+    # network_interface is set, don't guess
+    DEFAULT_IFACE="{{.NetworkInterface}}"
+    if ! ip link show "$DEFAULT_IFACE" >/dev/null 2>&1; then
+        echo "ERROR: network_interface $DEFAULT_IFACE does not exist; interfaces:"
+        ip -o link show | awk -F': ' '{print "  " $2}'
+        exit 1
+    fi
+{{- else}}
+
     # Get default interface
     DEFAULT_IFACE=$(ip route | grep default | awk '{print $5}' | head -1)
     if [ -z "$DEFAULT_IFACE" ]; then
         echo "Warning: Could not determine default interface"
         DEFAULT_IFACE="eth0"  # fallback
     fi
+{{- end}}
     echo "Using default interface: $DEFAULT_IFACE"
 
     # Wait for default interface to be fully up
@@ -71,69 +109,203 @@ if [ -n "$LOCAL_IP" ] && [ "$LOCAL_IP" != "" ]; then
         sleep 1
     done
 
-    # Create netplan configuration with optimized settings
-    mkdir -p /etc/netplan
-    cat > /etc/netplan/50-local-ip.yaml << EOF
-network:
-  version: 2
-  ethernets:
-    ${DEFAULT_IFACE}:
-      mtu: 1500
-      optional: false
-  vlans:
-    ${DEFAULT_IFACE}.4001:
-      id: 4001
-      link: ${DEFAULT_IFACE}
-      mtu: 1400
-      addresses:
-        - ${LOCAL_IP}/24
-      routes:
-        - to: "10.0.0.0/16"
-          via: "10.1.0.1"
-          metric: 100
-      optional: false
-      accept-ra: false
+    # Pick the network backend: netplan (Ubuntu), ifupdown (Debian) or systemd-networkd
+    NETWORK_BACKEND="{{.NetworkBackend}}"
+    if [ -z "$NETWORK_BACKEND" ]; then
+        if [ -x /usr/sbin/netplan ]; then
+            NETWORK_BACKEND="netplan"
+        elif [ -f /etc/network/interfaces ] && command -v ifup >/dev/null 2>&1; then
+            NETWORK_BACKEND="ifupdown"
+        else
+            echo "ERROR: cannot configure the private network: neither netplan (/usr/sbin/netplan) nor ifupdown (/etc/network/interfaces) is usable; set network_backend"
+            exit 1
+        fi
+    fi
+    echo "Using network backend: $NETWORK_BACKEND"
+
+    PARENT_KIND="ethernets"
+{{- with .Bond}}
+
+    # Bond the public NICs (network_bonding) and move the public addresses to bond0
+    BOND_IFACES="{{.Interfaces}}"
+    BOND_PRIMARY="{{.Primary}}"
+    if [ -z "$BOND_IFACES" ]; then
+        # Auto-detect: every physical NIC with a link
+        for nic in $(ls -d /sys/class/net/*/device 2>/dev/null | cut -d/ -f5); do
+            ip link set "$nic" up 2>/dev/null || true
+        done
+        sleep 5
+        for nic in $(ls -d /sys/class/net/*/device 2>/dev/null | cut -d/ -f5); do
+            if [ "$(cat "/sys/class/net/$nic/carrier" 2>/dev/null)" = "1" ]; then
+                BOND_IFACES="$BOND_IFACES $nic"
+            fi
+        done
+        BOND_IFACES=$(echo $BOND_IFACES)
+    fi
+    set -- $BOND_IFACES
+    if [ "$NETWORK_BACKEND" != "netplan" ]; then
+        echo "⚠ WARNING: bonding is only supported with netplan, not $NETWORK_BACKEND; skipping bonding and using $DEFAULT_IFACE"
+    elif [ $# -lt 2 ]; then
+        echo "⚠ WARNING: bonding needs at least two cabled NICs, found: ${BOND_IFACES:-none}; skipping bonding and using $DEFAULT_IFACE"
+    else
+        echo "Bonding $BOND_IFACES into bond0 (mode {{.Mode}})"
+{{- if or (eq .Mode "active-backup") (eq .Mode "balance-tlb") (eq .Mode "balance-alb")}}
+        if [ -z "$BOND_PRIMARY" ]; then
+            BOND_PRIMARY="$DEFAULT_IFACE"
+        fi
+{{- end}}
+        BOND_MAC=$(cat "/sys/class/net/$DEFAULT_IFACE/address")
+        BOND_ADDRS=$(ip -o addr show dev "$DEFAULT_IFACE" scope global | awk '{print "        - " $4}')
+        GATEWAY4=$(ip -4 route show default | awk '{print $3}' | head -1)
+        GATEWAY6=$(ip -6 route show default | awk '{print $3}' | head -1)
+        NAMESERVERS=$(awk '/^nameserver/ && $2 !~ /^127\./ {print "          - " $2}' /run/systemd/resolve/resolv.conf /etc/resolv.conf 2>/dev/null | sort -u)
+
+        # The installimage config binds the public IP to $DEFAULT_IFACE; keep a copy but stop netplan from reading it
+        for f in /etc/netplan/*.yaml; do
+            if [ -f "$f" ] && grep -q "$DEFAULT_IFACE" "$f"; then
+                mv "$f" "$f.before-bond"
+            fi
+        done
+
+        mkdir -p /etc/netplan
+        {
+            echo "network:"
+            echo "  version: 2"
+            echo "  ethernets:"
+            for nic in $BOND_IFACES; do
+                echo "    $nic:"
+                echo "      dhcp4: false"
+                echo "      dhcp6: false"
+            done
+            echo "  bonds:"
+            echo "    bond0:"
+            echo "      interfaces: [$(echo $BOND_IFACES | sed 's/ /, /g')]"
+            echo "      macaddress: $BOND_MAC"
+            echo "      addresses:"
+            echo "$BOND_ADDRS"
+            echo "      routes:"
+            [ -n "$GATEWAY4" ] && printf '        - to: default\n          via: %s\n          on-link: true\n' "$GATEWAY4"
+            [ -n "$GATEWAY6" ] && printf '        - to: default\n          via: %s\n' "$GATEWAY6"
+            if [ -n "$NAMESERVERS" ]; then
+                echo "      nameservers:"
+                echo "        addresses:"
+                echo "$NAMESERVERS"
+            fi
+            echo "      parameters:"
+            echo "        mode: {{.Mode}}"
+            echo "        mii-monitor-interval: 100"
+{{- if eq .Mode "802.3ad"}}
+            echo "        lacp-rate: fast"
+            echo "        transmit-hash-policy: layer3+4"
+{{- end}}
+            if [ -n "$BOND_PRIMARY" ]; then
+                echo "        primary: $BOND_PRIMARY"
+            fi
+        } > /etc/netplan/40-bond.yaml
+        chmod 600 /etc/netplan/40-bond.yaml
+
+        DEFAULT_IFACE="bond0"
+        PARENT_KIND="bonds"
+        echo "Using bond interface: $DEFAULT_IFACE"
+    fi
+{{- end}}
+    echo "HROBOT_NETWORK_INTERFACE=$DEFAULT_IFACE"
+    VLAN_IFACE="{{.PrivateVLANInterface}}"
+
+    case "$NETWORK_BACKEND" in
+    netplan)
+        # Create netplan configuration with optimized settings
+        mkdir -p /etc/netplan
+        cat > /etc/netplan/50-local-ip.yaml << EOF
+{{template "netplan" .}}
 EOF
 
-    echo "Netplan configuration created"
+        echo "Netplan configuration created"
 
-    # Generate and apply netplan with retry logic
-    echo "Applying netplan configuration..."
+        # Generate and apply netplan with retry logic
+        echo "Applying netplan configuration..."
 
-    # First, generate the configuration
-    if ! netplan generate; then
-        echo "ERROR: netplan generate failed"
-        exit 1
-    fi
+        # First, generate the configuration
+        if ! netplan generate; then
+            echo "ERROR: netplan generate failed"
+            exit 1
+        fi
 
-    # Apply with timeout and retry
-    APPLY_RETRIES=3
-    APPLY_SUCCESS=false
-    for i in $(seq 1 $APPLY_RETRIES); do
-        echo "Applying netplan (attempt $i/$APPLY_RETRIES)..."
-        if timeout 30 netplan apply; then
-            APPLY_SUCCESS=true
-            echo "✓ Netplan applied successfully"
-            break
-        else
-            echo "⚠ Netplan apply failed or timed out (attempt $i/$APPLY_RETRIES)"
-            sleep 5
+        # Apply with timeout and retry
+        APPLY_RETRIES=3
+        APPLY_SUCCESS=false
+        for i in $(seq 1 $APPLY_RETRIES); do
+            echo "Applying netplan (attempt $i/$APPLY_RETRIES)..."
+            if timeout 30 netplan apply; then
+                APPLY_SUCCESS=true
+                echo "✓ Netplan applied successfully"
+                break
+            else
+                echo "⚠ Netplan apply failed or timed out (attempt $i/$APPLY_RETRIES)"
+                sleep 5
+            fi
+        done
+
+        if [ "$APPLY_SUCCESS" != "true" ]; then
+            echo "ERROR: Failed to apply netplan after $APPLY_RETRIES attempts"
+            exit 1
         fi
-    done
+        ;;
+    ifupdown)
+        mkdir -p /etc/network/interfaces.d
+        if ! grep -qE '^\s*(source|source-directory)\s+(/etc/network/)?interfaces\.d' /etc/network/interfaces; then
+            echo "source /etc/network/interfaces.d/*" >> /etc/network/interfaces
+        fi
+        cat > /etc/network/interfaces.d/50-local-ip << EOF
+{{template "ifupdown" .}}
+EOF
+        echo "ifupdown configuration created"
 
-    if [ "$APPLY_SUCCESS" != "true" ]; then
-        echo "ERROR: Failed to apply netplan after $APPLY_RETRIES attempts"
+        for VLAN in $(sed -n 's/^auto //p' /etc/network/interfaces.d/50-local-ip); do
+            ifdown "$VLAN" 2>/dev/null || true
+            if ! ifup "$VLAN"; then
+                echo "ERROR: ifup $VLAN failed"
+                exit 1
+            fi
+        done
+        ;;
+    systemd-networkd)
+        PARENT_NETWORK=$(networkctl status "$DEFAULT_IFACE" 2>/dev/null | sed -n 's/^ *Network File: //p')
+        if [ -z "$PARENT_NETWORK" ] || [ "$PARENT_NETWORK" = "n/a" ]; then
+            echo "ERROR: $DEFAULT_IFACE is not managed by systemd-networkd"
+            exit 1
+        fi
+        mkdir -p "/etc/systemd/network/$(basename "$PARENT_NETWORK").d"
+        cat > "/etc/systemd/network/$(basename "$PARENT_NETWORK").d/50-local-ip.conf" << EOF
+{{template "networkd-parent" .}}
+EOF
+{{- range .NetworkVLANs}}
+        cat > /etc/systemd/network/50-vlan{{.ID}}.netdev << EOF
+{{template "networkd-netdev" .}}
+EOF
+        cat > /etc/systemd/network/50-vlan{{.ID}}.network << EOF
+{{template "networkd-network" .}}
+EOF
+{{- end}}
+        echo "systemd-networkd configuration created"
+
+        networkctl reload
+        networkctl reconfigure "$DEFAULT_IFACE"
+        ;;
+    *)
+        echo "ERROR: unsupported network backend: $NETWORK_BACKEND"
         exit 1
-    fi
+        ;;
+    esac
 
     # Wait for VLAN interface to come up
-    echo "Waiting for VLAN interface ${DEFAULT_IFACE}.4001 to be ready..."
+    echo "Waiting for VLAN interface $VLAN_IFACE to be ready..."
     VLAN_READY=false
     for i in {1..60}; do
-        if ip link show "${DEFAULT_IFACE}.4001" 2>/dev/null | grep -q "state UP"; then
-            VLAN_IP=$(ip addr show "${DEFAULT_IFACE}.4001" | grep "inet " | awk '{print $2}')
+        if ip link show "$VLAN_IFACE" 2>/dev/null | grep -q "state UP"; then
+            VLAN_IP=$(ip addr show "$VLAN_IFACE" | grep "inet " | awk '{print $2}')
             if [ -n "$VLAN_IP" ]; then
-                echo "✓ VLAN interface ${DEFAULT_IFACE}.4001 is up with IP: $VLAN_IP"
+                echo "✓ VLAN interface $VLAN_IFACE is up with IP: $VLAN_IP"
                 VLAN_READY=true
                 break
             fi
@@ -154,7 +326,7 @@ EOF
         echo "Verifying connectivity to gateway 10.1.0.1..."
         PING_SUCCESS=false
         for i in {1..30}; do
-            if ping -c 1 -W 2 -I "${DEFAULT_IFACE}.4001" 10.1.0.1 >/dev/null 2>&1; then
+            if ping -c 1 -W 2 -I "$VLAN_IFACE" 10.1.0.1 >/dev/null 2>&1; then
                 echo "✓ Successfully reached gateway 10.1.0.1"
                 PING_SUCCESS=true
                 break
@@ -180,194 +352,17 @@ EOF
         echo "Announcing presence on VLAN network..."
 
         # Use arping to send gratuitous ARP announcements
-        arping -U -c 3 -I "${DEFAULT_IFACE}.4001" 10.1.0.1 >/dev/null 2>&1 || true
+        arping -U -c 3 -I "$VLAN_IFACE" 10.1.0.1 >/dev/null 2>&1 || true
 
         # Try to contact the gateway with regular pings
         for i in {1..3}; do
-            ping -c 1 -W 1 -I "${DEFAULT_IFACE}.4001" 10.1.0.1 >/dev/null 2>&1 || true
+            ping -c 1 -W 1 -I "$VLAN_IFACE" 10.1.0.1 >/dev/null 2>&1 || true
             sleep 1
         done
 
         echo "✓ Network announcement completed"
 
-        # Create ARP keepalive service to prevent gateway ARP expiration
-        echo "Setting up ARP keepalive service for gateway stability..."
-
-        # Create the keepalive script
-        cat > /usr/local/bin/vlan-arp-keepalive.sh << 'SCRIPT_EOF'
-#!/bin/bash
-#
-# VLAN ARP Keepalive Script
-# Maintains gateway ARP entry and monitors connectivity
-#
-
-GATEWAY_IP="10.1.0.1"
-VLAN_IFACE="$1"
-TEST_IP="${2:-10.0.0.2}"  # Optional test IP for connectivity monitoring
-
-# Validate parameters
-if [ -z "$VLAN_IFACE" ]; then
-    echo "ERROR: Missing required parameters"
-    echo "Usage: $0 <vlan_interface> [test_ip]"
-    exit 1
-fi
-
-echo "VLAN ARP Keepalive starting"
-echo "Gateway: $GATEWAY_IP"
-echo "Interface: $VLAN_IFACE"
-echo "Test IP: $TEST_IP"
-
-# Check if arping is available
-if ! command -v arping >/dev/null 2>&1; then
-    echo "ERROR: arping is not installed"
-    echo "Install with: apt-get install -y arping"
-    exit 1
-fi
-
-# Track failures for alerting
-CONSECUTIVE_FAILURES=0
-MAX_FAILURES_BEFORE_ALERT=3
-
-# Function to log with timestamp
-log() {
-    echo "[$(date '+%Y-%m-%d %H:%M:%S')] $*"
-}
-
-# Function to refresh ARP entry using arping
-refresh_arp() {
-    # Send gratuitous ARP requests to keep the gateway entry alive
-    # -U: unsolicited ARP mode (gratuitous ARP)
-    # -c 1: send 1 packet
-    # -I: interface
-    # -q: quiet output
-    if arping -U -c 1 -I "$VLAN_IFACE" "$GATEWAY_IP" >/dev/null 2>&1; then
-        return 0
-    else
-        local exit_code=$?
-        log "WARNING: Failed to send ARP request (exit code: $exit_code)"
-        return $exit_code
-    fi
-}
-
-# Function to check ARP entry state
-check_arp_state() {
-    local state=$(ip neigh show "$GATEWAY_IP" dev "$VLAN_IFACE" 2>/dev/null | awk '{print $6}')
-    echo "$state"
-}
-
-# Function to test connectivity
-test_connectivity() {
-    if ping -c 1 -W 2 -I "$VLAN_IFACE" "$TEST_IP" >/dev/null 2>&1; then
-        return 0
-    else
-        return 1
-    fi
-}
-
-# Initial state
-log "Service initialized successfully"
-ITERATION=0
-LAST_LOG_TIME=$(date +%s)
-LOG_INTERVAL=300  # Log status every 5 minutes
-
-while true; do
-    ITERATION=$((ITERATION + 1))
-    CURRENT_TIME=$(date +%s)
-
-    # Send ARP keepalive
-    if ! refresh_arp; then
-        CONSECUTIVE_FAILURES=$((CONSECUTIVE_FAILURES + 1))
-        log "ARP keepalive failed (consecutive failures: $CONSECUTIVE_FAILURES)"
-    fi
-
-    # Check ARP state after refresh
-    ARP_STATE=$(check_arp_state)
-
-    # Test connectivity every 10th iteration (every ~50 seconds)
-    CONNECTIVITY_OK=true
-    if [ $((ITERATION % 10)) -eq 0 ]; then
-        if ! test_connectivity; then
-            CONNECTIVITY_OK=false
-            CONSECUTIVE_FAILURES=$((CONSECUTIVE_FAILURES + 1))
-            log "WARNING: Connectivity test to $TEST_IP FAILED (ARP state: $ARP_STATE)"
-
-            # Try to recover by flushing and rebuilding ARP
-            log "Attempting recovery: flushing neighbor cache"
-            ip neigh flush dev "$VLAN_IFACE" 2>/dev/null || true
-            sleep 1
-            refresh_arp
-
-            # Test again
-            if test_connectivity; then
-                log "Recovery successful: connectivity restored"
-                CONSECUTIVE_FAILURES=0
-            else
-                log "Recovery failed: connectivity still down"
-            fi
-        else
-            # Connectivity OK, reset failure counter
-            if [ $CONSECUTIVE_FAILURES -gt 0 ]; then
-                log "Connectivity restored (was failing for $CONSECUTIVE_FAILURES checks)"
-            fi
-            CONSECUTIVE_FAILURES=0
-        fi
-    fi
-
-    # Periodic status logging (every 5 minutes when healthy)
-    if [ $((CURRENT_TIME - LAST_LOG_TIME)) -ge $LOG_INTERVAL ]; then
-        if [ $CONSECUTIVE_FAILURES -eq 0 ]; then
-            log "Status: healthy (ARP state: $ARP_STATE, iterations: $ITERATION)"
-        fi
-        LAST_LOG_TIME=$CURRENT_TIME
-    fi
-
-    # Alert on persistent failures
-    if [ $CONSECUTIVE_FAILURES -ge $MAX_FAILURES_BEFORE_ALERT ]; then
-        log "ALERT: $CONSECUTIVE_FAILURES consecutive failures detected!"
-        log "  Gateway ARP state: $ARP_STATE"
-        log "  Interface state: $(ip link show "$VLAN_IFACE" 2>/dev/null | grep -o 'state [A-Z]*' || echo 'unknown')"
-        log "  Routing table: $(ip route show | grep "$VLAN_IFACE" || echo 'no routes')"
-
-        # Reset counter to avoid log spam, but continue monitoring
-        CONSECUTIVE_FAILURES=0
-    fi
-
-    # Main loop interval
-    sleep 5
-done
-SCRIPT_EOF
-
-        chmod +x /usr/local/bin/vlan-arp-keepalive.sh
-        echo "✓ Keepalive script created"
-
-        # Create the systemd service
-        cat > /etc/systemd/system/vlan-arp-keepalive.service << EOF
-[Unit]
-Description=Keep VLAN gateway ARP entry alive
-After=network-online.target
-Wants=network-online.target
-
-[Service]
-Type=simple
-Restart=always
-RestartSec=2
-ExecStart=/usr/local/bin/vlan-arp-keepalive.sh ${DEFAULT_IFACE}.4001 10.0.0.2
-StandardOutput=journal
-StandardError=journal
-
-[Install]
-WantedBy=multi-user.target
-EOF
-
-        systemctl daemon-reload
-        systemctl enable vlan-arp-keepalive.service
-        systemctl start vlan-arp-keepalive.service
-
-        if systemctl is-active vlan-arp-keepalive.service >/dev/null 2>&1; then
-            echo "✓ ARP keepalive service started successfully"
-        else
-            echo "⚠ WARNING: ARP keepalive service may not have started correctly"
-        fi
+{{.ARPKeepalive}}
     fi
 
     echo "Local IP configuration completed"
@@ -441,5 +436,5 @@ EOF
 
 echo "✓ K3S registry mirror configured"
 
-# EXTRASCRIPTREPLACEME
+{{.ExtraScript}}
 `
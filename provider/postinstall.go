@@ -1,16 +1,21 @@
 package provider
 
-// postinstallScript is the comprehensive post-install script for LUKS encryption setup
+// postinstallScript is the comprehensive post-install script for LUKS encryption setup,
+// rendered as a text/template with PostInstallTemplateData
 const postinstallScript = `#!/bin/bash
 
 # Hetzner Post-install Script for Auto-unlocking Encrypted Drives (FIXED)
 # This script sets up automatic LUKS decryption during boot
 set -e
 
-CRYPT_PASSWORD="SECRETPASSWORDREPLACEME"
+# Marks the install as unfinished until the provider completes the first run (setupMarker)
+mkdir -p /var/lib/hrobot
+touch /var/lib/hrobot/setup-incomplete
+
+CRYPT_PASSWORD={{shellQuote .CryptPassword}}
 KEYFILE_PATH="/etc/luks-keys/boot.key"
 KEYFILE_DIR="/etc/luks-keys"
-UNUSED_DISKS="UNUSEDDISKSREPLACEME"
+UNUSED_DISKS="{{.UnusedDisks}}"
 
 echo "Starting Hetzner auto-unlock setup..."
 
@@ -176,11 +181,9 @@ cryptsetup luksDump "$LUKS_DEVICE" | grep -A10 "Keyslots:" || echo "Failed to sh
 # We trust that the password in CRYPT_PASSWORD is correct (it was used during installation)
 echo "Adding key file to LUKS device..."
 
-TEMP_PASS_FILE=$(mktemp)
-echo "$CRYPT_PASSWORD" > "$TEMP_PASS_FILE"
-chmod 600 "$TEMP_PASS_FILE"
-
-if cryptsetup luksAddKey "$LUKS_DEVICE" "$KEYFILE_PATH" --verbose < "$TEMP_PASS_FILE"; then
+# The passphrase is fed through a pipe from the printf builtin so it never lands on disk
+# and never shows up in the process list
+if printf '%s\n' "$CRYPT_PASSWORD" | cryptsetup luksAddKey "$LUKS_DEVICE" "$KEYFILE_PATH" --verbose; then
     echo "✓ Key file successfully added to LUKS device"
     KEY_ADDED=true
 else
@@ -201,16 +204,10 @@ else
     echo "Key file info:"
     ls -la "$KEYFILE_PATH"
 
-    echo ""
-    echo "Checking if password file is readable:"
-    ls -la "$TEMP_PASS_FILE"
-
-    echo ""
-    echo "Password length: $(wc -c < "$TEMP_PASS_FILE") bytes"
 fi
 
-# Clean up temp file
-rm -f "$TEMP_PASS_FILE"
+# The passphrase is not needed past this point
+unset CRYPT_PASSWORD
 
 if [ "$KEY_ADDED" != "true" ]; then
     exit 1
@@ -12,12 +12,15 @@ import (
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/mokto/terraform-provider-hrobot/internal/client"
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
 )
 
 type hrobotProvider struct {
@@ -26,10 +29,20 @@ type hrobotProvider struct {
 
 // ProviderData holds both client and cache manager for resources
 type ProviderData struct {
-	Client       *client.Client
-	CacheManager *client.CacheManager
-	UsedIPs      map[string]bool // Track assigned private IPs (10.1.0.x)
-	IPMutex      sync.Mutex      // Protect IP assignment from race conditions
+	Client           *client.Client
+	CacheManager     *client.CacheManager
+	TransactionCache *TransactionCache
+	SSHAuth          sshx.Auth       // How resources log in over SSH unless they set their own ssh_auth
+	SSHAgentSocket   string          // SSH agent socket for agent auth, empty for SSH_AUTH_SOCK
+	PollInterval     time.Duration   // Base wait between Robot status polls
+	UsedIPs          map[string]bool // Track assigned private IPs (10.1.0.x)
+	IPMutex          sync.Mutex      // Protect IP assignment from race conditions
+
+	PrivateIPPools map[string]types.Map // Allocations of each hrobot_private_ip_pool planned or read so far, by name
+
+	AllowMutations bool // hrobot_api_call may send methods other than GET
+
+	AllowReinstallWithoutVersionChange bool // hrobot_configuration reinstalls on every apply, for development
 }
 
 func New(version string) func() provider.Provider {
@@ -41,6 +54,22 @@ type providerConfig struct {
 	Password       types.String `tfsdk:"password"`
 	BaseURL        types.String `tfsdk:"base_url"`
 	TimeoutSeconds types.Int64  `tfsdk:"timeout_seconds"`
+	CacheDir       types.String `tfsdk:"cache_dir"`
+	DisableCache   types.Bool   `tfsdk:"disable_cache"`
+
+	ValidateCredentials types.Bool  `tfsdk:"validate_credentials"`
+	PollIntervalSeconds types.Int64 `tfsdk:"poll_interval_seconds"`
+	MaxRetries          types.Int64 `tfsdk:"max_retries"`
+	RetryStatusCodes    types.List  `tfsdk:"retry_status_codes"`
+
+	SSHAuth        types.Object `tfsdk:"ssh_auth"`
+	SSHAgentSocket types.String `tfsdk:"ssh_agent_socket"`
+
+	AllowMutations types.Bool `tfsdk:"allow_mutations"`
+
+	AllowReinstallWithoutVersionChange types.Bool `tfsdk:"allow_reinstall_without_version_change"`
+
+	RecordAPIDir types.String `tfsdk:"record_api_dir"`
 }
 
 func (p *hrobotProvider) Metadata(_ context.Context, _ provider.MetadataRequest, resp *provider.MetadataResponse) {
@@ -62,14 +91,56 @@ func (p *hrobotProvider) Schema(_ context.Context, _ provider.SchemaRequest, res
 			},
 			"base_url": schema.StringAttribute{
 				Optional:    true,
-				Description: "Robot base URL.",
+				Description: "Robot base URL, http(s) (or HROBOT_BASE_URL; default: " + defaultBaseURL + ").",
 				// Computed:    true,
 			},
 			"timeout_seconds": schema.Int64Attribute{
 				Optional:    true,
-				Description: "HTTP timeout seconds.",
+				Description: "HTTP timeout seconds (or HROBOT_TIMEOUT_SECONDS; default: 30).",
 				// Computed:    true,
 			},
+			"cache_dir": schema.StringAttribute{
+				Optional:    true,
+				Description: "Directory for the order transaction cache file (or HROBOT_CACHE_DIR; default: .cache in the working directory). Entries are kept per base_url and username.",
+			},
+			"disable_cache": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Don't cache order transactions, in memory or in the cache file; every read asks Robot (default: false).",
+			},
+			"poll_interval_seconds": schema.Int64Attribute{
+				Optional:    true,
+				Description: "Base interval between Robot status polls, doubled after each attempt up to 10x (default: 30).",
+			},
+			"max_retries": schema.Int64Attribute{
+				Optional:    true,
+				Description: "How often a failed Robot call is retried, with exponential backoff (default: 3, 0 disables retries).",
+			},
+			"retry_status_codes": schema.ListAttribute{
+				Optional:    true,
+				ElementType: types.Int64Type,
+				Description: "Robot response codes that are retried (default: [429, 500, 502, 503, 504]). Retry-After is honored for 429. POST calls such as orders are only retried on 429 and 503.",
+			},
+			"ssh_auth": providerSSHAuthAttribute(),
+			"ssh_agent_socket": schema.StringAttribute{
+				Optional:    true,
+				Description: "Path of the SSH agent socket for agent auth, for CI systems that put it somewhere else (or HROBOT_SSH_AGENT_SOCKET; default: SSH_AUTH_SOCK).",
+			},
+			"allow_mutations": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Let hrobot_api_call send methods other than GET, which can change or delete things in Robot (default: false).",
+			},
+			"allow_reinstall_without_version_change": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Reinstall every hrobot_configuration on each apply, even when its version and configuration are unchanged. For development and testing only; never set it in production (default: false).",
+			},
+			"record_api_dir": schema.StringAttribute{
+				Optional:    true,
+				Description: "Write every Robot request and response to this directory as numbered JSON files, to attach to bug reports. Credentials are never written and password, secret and token fields are redacted.",
+			},
+			"validate_credentials": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Probe the Robot webservice (GET /server) during configuration so bad credentials or missing permissions fail before any resource is touched. The result primes the server cache, so it costs no extra API call.",
+			},
 		},
 	}
 }
@@ -88,26 +159,99 @@ func (p *hrobotProvider) Configure(ctx context.Context, req provider.ConfigureRe
 		resp.Diagnostics.AddError("Missing credentials", "Set username/password or HROBOT_USERNAME/HROBOT_PASSWORD")
 		return
 	}
-	base := cfg.BaseURL.ValueString()
-	if base == "" {
-		base = "https://robot-ws.your-server.de"
+	base, err := resolveBaseURL(cfg.BaseURL.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("base_url"), "Invalid base_url", err.Error())
+		return
 	}
-	timeout := time.Duration(30) * time.Second
-	if !cfg.TimeoutSeconds.IsNull() && !cfg.TimeoutSeconds.IsUnknown() && cfg.TimeoutSeconds.ValueInt64() > 0 {
-		timeout = time.Duration(cfg.TimeoutSeconds.ValueInt64()) * time.Second
+	timeout, err := resolveTimeout(cfg.TimeoutSeconds.ValueInt64())
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("timeout_seconds"), "Invalid timeout_seconds", err.Error())
+		return
+	}
+	transactionCacheFile, err := resolveCacheFile(cfg.CacheDir.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("cache_dir"), "Invalid cache_dir", err.Error())
+		return
+	}
+
+	clientCfg := client.ClientConfig{MaxRetries: 3, RetryStatusCodes: client.DefaultRetryStatusCodes}
+	if !cfg.MaxRetries.IsNull() && !cfg.MaxRetries.IsUnknown() {
+		if cfg.MaxRetries.ValueInt64() < 0 {
+			resp.Diagnostics.AddAttributeError(path.Root("max_retries"), "Invalid max_retries", "max_retries must not be negative")
+			return
+		}
+		clientCfg.MaxRetries = int(cfg.MaxRetries.ValueInt64())
+	}
+	if !cfg.RetryStatusCodes.IsNull() && !cfg.RetryStatusCodes.IsUnknown() {
+		var codes []int64
+		resp.Diagnostics.Append(cfg.RetryStatusCodes.ElementsAs(ctx, &codes, false)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+		clientCfg.RetryStatusCodes = make([]int, 0, len(codes))
+		for _, code := range codes {
+			clientCfg.RetryStatusCodes = append(clientCfg.RetryStatusCodes, int(code))
+		}
+	}
+
+	clientCfg.RecordDir = cfg.RecordAPIDir.ValueString()
+
+	agentSocket := resolveAgentSocket(cfg.SSHAgentSocket.ValueString())
+	sshAuth := sshx.AuthFromAgent(agentSocket)
+	if !cfg.SSHAuth.IsNull() && !cfg.SSHAuth.IsUnknown() {
+		var m sshAuthModel
+		resp.Diagnostics.Append(cfg.SSHAuth.As(ctx, &m, basetypes.ObjectAsOptions{})...)
+		validateSSHAuth(&resp.Diagnostics, path.Root("ssh_auth"), m)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+		var err error
+		if sshAuth, err = buildSSHAuth(m, agentSocket); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("ssh_auth"), "SSH auth unavailable", err.Error())
+			return
+		}
 	}
 
 	httpClient := &http.Client{Timeout: timeout}
-	c := client.New(base, username, password, httpClient)
+	c := client.New(base, username, password, httpClient, clientCfg)
 	cacheManager := client.NewCacheManager()
 
+	if cfg.ValidateCredentials.ValueBool() {
+		if _, err := cacheManager.GetServers(c); err != nil && !client.IsNotFound(err) {
+			if client.IsUnauthorized(err) {
+				resp.Diagnostics.AddError("Invalid Robot credentials", "The Robot webservice rejected the configured username/password. Check that you are using the webservice user (not your Robot login).\n\n"+err.Error())
+				return
+			}
+			resp.Diagnostics.AddError("Robot credential check failed", robotErrorDetail(err, "list servers", "Server"))
+			return
+		}
+	}
+
 	// Initialize UsedIPs by scanning the current Terraform state
 	usedIPs := scanStateForUsedIPs(ctx)
 
+	pollInterval := 30 * time.Second
+	if !cfg.PollIntervalSeconds.IsNull() && !cfg.PollIntervalSeconds.IsUnknown() && cfg.PollIntervalSeconds.ValueInt64() > 0 {
+		pollInterval = time.Duration(cfg.PollIntervalSeconds.ValueInt64()) * time.Second
+	}
+
+	transactionCache := NewDisabledTransactionCache()
+	if !cfg.DisableCache.ValueBool() {
+		transactionCache = NewTransactionCache(transactionCacheFile, transactionCacheNamespace(base, username))
+	}
+
 	providerData := &ProviderData{
-		Client:       c,
-		CacheManager: cacheManager,
-		UsedIPs:      usedIPs,
+		Client:           c,
+		CacheManager:     cacheManager,
+		TransactionCache: transactionCache,
+		SSHAuth:          sshAuth,
+		SSHAgentSocket:   agentSocket,
+		PollInterval:     pollInterval,
+		UsedIPs:          usedIPs,
+		AllowMutations:   cfg.AllowMutations.ValueBool(),
+
+		AllowReinstallWithoutVersionChange: cfg.AllowReinstallWithoutVersionChange.ValueBool(),
 	}
 
 	tflog.Info(ctx, "Configured hrobot provider", map[string]interface{}{"base_url": base})
@@ -121,12 +265,29 @@ func (p *hrobotProvider) Resources(_ context.Context) []func() resource.Resource
 		NewResourceServerAuctionOrder,
 		NewResourceConfiguration,
 		NewResourceVSwitch,
+		NewResourceFirewallTemplate,
+		NewResourcePrivateIPPool,
+		NewResourcePrivateIP,
+		NewResourceAPICall,
 	}
 }
 
 func (p *hrobotProvider) DataSources(_ context.Context) []func() datasource.DataSource {
 	return []func() datasource.DataSource{
 		NewDataServers,
+		NewDataServer,
+		NewDataOrderTransactions,
+		NewDataServerHardware,
+	}
+}
+
+// PollOptions returns the backoff settings resources use when waiting on Robot
+func (pd *ProviderData) PollOptions(maxElapsed time.Duration) client.PollOptions {
+	return client.PollOptions{
+		Interval:    pd.PollInterval,
+		MaxInterval: 10 * pd.PollInterval,
+		MaxElapsed:  maxElapsed,
+		Jitter:      0.1,
 	}
 }
 
@@ -156,6 +317,13 @@ func (pd *ProviderData) GetNextAvailableIP() (string, error) {
 	return selectedIP, nil
 }
 
+// ReserveIP marks an IP that was set explicitly as used, so GetNextAvailableIP skips it
+func (pd *ProviderData) ReserveIP(ip string) {
+	pd.IPMutex.Lock()
+	defer pd.IPMutex.Unlock()
+	pd.UsedIPs[ip] = true
+}
+
 // ReleaseIP marks an IP as available for reuse
 func (pd *ProviderData) ReleaseIP(ip string) {
 	pd.IPMutex.Lock()
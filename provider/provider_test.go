@@ -6,11 +6,17 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/knownvalue"
+	"github.com/hashicorp/terraform-plugin-testing/plancheck"
+	"github.com/hashicorp/terraform-plugin-testing/tfjsonpath"
 
 	providerpkg "github.com/mokto/terraform-provider-hrobot/provider"
 )
@@ -94,6 +100,11 @@ func newRobotMockServer(t *testing.T) *httptest.Server {
 	return httptest.NewServer(mux)
 }
 
+// emptyPlanAfterApply asserts that planning again right after an apply shows no changes
+func emptyPlanAfterApply() resource.ConfigPlanChecks {
+	return resource.ConfigPlanChecks{PostApplyPostRefresh: []plancheck.PlanCheck{plancheck.ExpectEmptyPlan()}}
+}
+
 func TestAcc_ServerOrder_Basic(t *testing.T) {
 	ts := newRobotMockServer(t)
 	defer ts.Close()
@@ -117,6 +128,7 @@ resource "hrobot_server_order" "ex101" {
 					resource.TestCheckResourceAttr("hrobot_server_order.ex101", "transaction_id", "txn-acc"),
 					resource.TestCheckResourceAttr("hrobot_server_order.ex101", "status", "in process"),
 				),
+				ConfigPlanChecks: emptyPlanAfterApply(),
 			},
 		},
 	})
@@ -225,9 +237,331 @@ resource "hrobot_server_order" "test" {
 	}
 }
 
+func TestAcc_ServerAuctionOrder_Full(t *testing.T) {
+	var lookups atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/order/server_market/transaction":
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if r.Form.Get("product_id") == "" || r.Form.Get("product_id") == "0" {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"error": map[string]any{"status": 400, "code": "INVALID_INPUT", "message": "invalid input", "missing": []string{"product_id"}},
+				})
+				return
+			}
+			id := "txn-auction"
+			if r.Form.Get("test") == "true" {
+				id = "txn-auction-dry"
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"transaction": map[string]any{"id": id, "status": "in process", "product": map[string]any{"id": 2783507}},
+			})
+		case r.URL.Path == "/order/server_market/transaction/txn-auction":
+			lookups.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"transaction": map[string]any{
+					"id":            "txn-auction",
+					"status":        "ready",
+					"server_number": 222222,
+					"server_ip":     "198.51.100.30",
+				},
+			})
+		case r.URL.Path == "/order/server_market/transaction/txn-auction-dry":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"transaction": map[string]any{"id": "txn-auction-dry", "status": "in process"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	config := func(body string) string {
+		return fmt.Sprintf(`
+provider "hrobot" {
+  username = "u"
+  password = "p"
+  base_url = "%s"
+}
+%s
+`, ts.URL, body)
+	}
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testProviderFactories(),
+		Steps: []resource.TestStep{
+			{
+				Config: config(`
+resource "hrobot_server_auction_order" "test" {
+  product_id = 2783507
+}
+`),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("hrobot_server_auction_order.test", "transaction_id", "txn-auction"),
+					resource.TestCheckResourceAttr("hrobot_server_auction_order.test", "status", "in process"),
+				),
+				ConfigPlanChecks: emptyPlanAfterApply(),
+			},
+			{
+				// Second apply refreshes the "in process" transaction and picks up the server
+				Config: config(`
+resource "hrobot_server_auction_order" "test" {
+  product_id = 2783507
+}
+`),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("hrobot_server_auction_order.test", "status", "ready"),
+					resource.TestCheckResourceAttr("hrobot_server_auction_order.test", "server_number", "222222"),
+					resource.TestCheckResourceAttr("hrobot_server_auction_order.test", "server_ip", "198.51.100.30"),
+				),
+			},
+			{
+				// Dry-run orders get their own transaction and leave the real order untouched
+				Config: config(`
+resource "hrobot_server_auction_order" "test" {
+  product_id = 2783507
+}
+
+resource "hrobot_server_auction_order" "dry" {
+  product_id = 2783507
+  test       = true
+}
+`),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("hrobot_server_auction_order.dry", "transaction_id", "txn-auction-dry"),
+					resource.TestCheckResourceAttr("hrobot_server_auction_order.dry", "status", "in process"),
+					resource.TestCheckResourceAttr("hrobot_server_auction_order.test", "transaction_id", "txn-auction"),
+					resource.TestCheckResourceAttr("hrobot_server_auction_order.test", "status", "ready"),
+				),
+			},
+			{
+				Config: config(`
+resource "hrobot_server_auction_order" "invalid" {
+  product_id = 0
+}
+`),
+				ExpectError: regexp.MustCompile(`INVALID_INPUT`),
+			},
+		},
+	})
+
+	if lookups.Load() == 0 {
+		t.Errorf("expected the ready transaction to be fetched at least once")
+	}
+}
+
 // Test removed - data source no longer exists
 
 // Data source caching test removed - data source no longer exists
 
 // keep a reference so linters don't complain about unused imports in some setups
 var _ = context.Background()
+
+func TestAcc_VSwitch_EmptyPlan(t *testing.T) {
+	vswitch := map[string]any{"id": 42, "vlan": 4000, "name": "internal", "cancelled": false, "server": []any{}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && (r.URL.Path == "/vswitch" || r.URL.Path == "/vswitch/42"):
+			_ = r.ParseForm()
+			vswitch["name"] = r.Form.Get("name")
+			if r.URL.Path == "/vswitch" {
+				w.WriteHeader(http.StatusCreated)
+			}
+			_ = json.NewEncoder(w).Encode(vswitch)
+		case r.Method == http.MethodGet && r.URL.Path == "/vswitch/42":
+			_ = json.NewEncoder(w).Encode(vswitch)
+		case r.Method == http.MethodDelete && r.URL.Path == "/vswitch/42":
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	config := func(name string) string {
+		return fmt.Sprintf(`
+provider "hrobot" {
+  username = "u"
+  password = "p"
+  base_url = "%s"
+}
+
+resource "hrobot_vswitch" "test" {
+  vlan = 4000
+  name = %q
+}
+`, ts.URL, name)
+	}
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testProviderFactories(),
+		Steps: []resource.TestStep{
+			{
+				Config:           config("internal"),
+				Check:            resource.TestCheckResourceAttr("hrobot_vswitch.test", "id", "42"),
+				ConfigPlanChecks: emptyPlanAfterApply(),
+			},
+			{
+				// A rename keeps the known id instead of planning it as unknown
+				Config: config("renamed"),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply:             []plancheck.PlanCheck{plancheck.ExpectKnownValue("hrobot_vswitch.test", tfjsonpath.New("id"), knownvalue.Int64Exact(42))},
+					PostApplyPostRefresh: []plancheck.PlanCheck{plancheck.ExpectEmptyPlan()},
+				},
+			},
+		},
+	})
+}
+
+func TestAcc_VSwitch_Description(t *testing.T) {
+	for _, supported := range []bool{true, false} {
+		t.Run(fmt.Sprintf("robot returns description %v", supported), func(t *testing.T) {
+			vswitch := map[string]any{"id": 42, "vlan": 4000, "name": "internal", "cancelled": false, "server": []any{}}
+			var sent []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPost && (r.URL.Path == "/vswitch" || r.URL.Path == "/vswitch/42"):
+					_ = r.ParseForm()
+					if d, ok := r.PostForm["description"]; ok {
+						sent = append(sent, d[0])
+						if supported {
+							vswitch["description"] = d[0]
+						}
+					}
+					if r.URL.Path == "/vswitch" {
+						w.WriteHeader(http.StatusCreated)
+					}
+					_ = json.NewEncoder(w).Encode(vswitch)
+				case r.Method == http.MethodGet && r.URL.Path == "/vswitch/42":
+					_ = json.NewEncoder(w).Encode(vswitch)
+				case r.Method == http.MethodDelete && r.URL.Path == "/vswitch/42":
+					w.WriteHeader(http.StatusOK)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer ts.Close()
+
+			config := func(description string) string {
+				attr := ""
+				if description != "" {
+					attr = fmt.Sprintf("description = %q", description)
+				}
+				return fmt.Sprintf(`
+provider "hrobot" {
+  username = "u"
+  password = "p"
+  base_url = "%s"
+}
+
+resource "hrobot_vswitch" "test" {
+  vlan = 4000
+  name = "internal"
+  %s
+}
+`, ts.URL, attr)
+			}
+
+			resource.Test(t, resource.TestCase{
+				ProtoV6ProviderFactories: testProviderFactories(),
+				Steps: []resource.TestStep{
+					{
+						Config:           config("k3s nodes"),
+						Check:            resource.TestCheckResourceAttr("hrobot_vswitch.test", "description", "k3s nodes"),
+						ConfigPlanChecks: emptyPlanAfterApply(),
+					},
+					{
+						Config:           config("k3s and storage"),
+						Check:            resource.TestCheckResourceAttr("hrobot_vswitch.test", "description", "k3s and storage"),
+						ConfigPlanChecks: emptyPlanAfterApply(),
+					},
+					{
+						Config:           config(""),
+						Check:            resource.TestCheckNoResourceAttr("hrobot_vswitch.test", "description"),
+						ConfigPlanChecks: emptyPlanAfterApply(),
+					},
+				},
+			})
+			if want := "k3s nodes,k3s and storage,"; strings.Join(sent, ",") != want {
+				t.Errorf("sent descriptions %q, want %q", strings.Join(sent, ","), want)
+			}
+		})
+	}
+}
+
+func TestAcc_FirewallTemplate_EmptyPlan(t *testing.T) {
+	var template map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && (r.URL.Path == "/firewall/template" || r.URL.Path == "/firewall/template/7"):
+			_ = r.ParseForm()
+			var input []map[string]any
+			for i := 0; r.Form.Get(fmt.Sprintf("rules[input][%d][action]", i)) != ""; i++ {
+				rule := map[string]any{"ip_version": nil, "name": nil, "dst_ip": nil, "src_ip": nil, "dst_port": nil, "src_port": nil, "protocol": nil, "tcp_flags": nil}
+				for field := range rule {
+					if v := r.Form.Get(fmt.Sprintf("rules[input][%d][%s]", i, field)); v != "" {
+						rule[field] = v
+					}
+				}
+				rule["action"] = r.Form.Get(fmt.Sprintf("rules[input][%d][action]", i))
+				input = append(input, rule)
+			}
+			template = map[string]any{
+				"id": 7, "name": r.Form.Get("name"),
+				"filter_ipv6": r.Form.Get("filter_ipv6") == "true", "whitelist_hos": r.Form.Get("whitelist_hos") == "true", "is_default": r.Form.Get("is_default") == "true",
+				"rules": map[string]any{"input": input, "output": []any{}},
+			}
+			if r.URL.Path == "/firewall/template" {
+				w.WriteHeader(http.StatusCreated)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"firewall_template": template})
+		case r.Method == http.MethodGet && r.URL.Path == "/firewall/template/7":
+			_ = json.NewEncoder(w).Encode(map[string]any{"firewall_template": template})
+		case r.Method == http.MethodDelete && r.URL.Path == "/firewall/template/7":
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	config := func(port string) string {
+		return fmt.Sprintf(`
+provider "hrobot" {
+  username = "u"
+  password = "p"
+  base_url = "%s"
+}
+
+resource "hrobot_firewall_template" "test" {
+  name = "base"
+  rules = {
+    input = [{ name = "ssh", dst_port = %q, protocol = "tcp", action = "accept" }]
+  }
+}
+`, ts.URL, port)
+	}
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testProviderFactories(),
+		Steps: []resource.TestStep{
+			{
+				Config:           config("22"),
+				Check:            resource.TestCheckResourceAttr("hrobot_firewall_template.test", "id", "7"),
+				ConfigPlanChecks: emptyPlanAfterApply(),
+			},
+			{
+				Config: config("2222"),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply:             []plancheck.PlanCheck{plancheck.ExpectKnownValue("hrobot_firewall_template.test", tfjsonpath.New("id"), knownvalue.Int64Exact(7))},
+					PostApplyPostRefresh: []plancheck.PlanCheck{plancheck.ExpectEmptyPlan()},
+				},
+			},
+		},
+	})
+}
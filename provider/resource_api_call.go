@@ -0,0 +1,221 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/mapplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// apiCallPath is a Robot API path, e.g. /server/123/cancellation; the base URL is prepended
+var apiCallPath = regexp.MustCompile(`^/[A-Za-z0-9._~/%:@+,-]*(\?[A-Za-z0-9._~%=&+,-]*)?$`)
+
+// apiCallMethods are the methods hrobot_api_call sends; all but GET need allow_mutations
+var apiCallMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+type apiCallResource struct {
+	providerData *ProviderData
+}
+
+type apiCallModel struct {
+	ID           types.String `tfsdk:"id"`
+	Method       types.String `tfsdk:"method"`
+	Path         types.String `tfsdk:"path"`
+	Form         types.Map    `tfsdk:"form"`
+	Triggers     types.Map    `tfsdk:"triggers"`
+	Status       types.Int64  `tfsdk:"status"`
+	ResponseBody types.String `tfsdk:"response_body"`
+}
+
+func NewResourceAPICall() resource.Resource {
+	return &apiCallResource{}
+}
+
+func (r *apiCallResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_api_call"
+}
+
+func (r *apiCallResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	replace := []planmodifier.String{stringplanmodifier.RequiresReplace()}
+	resp.Schema = rschema.Schema{
+		Description: "Sends one raw request to the Robot webservice when created, for endpoints this provider doesn't model. Changing any argument sends it again; destroying it only removes it from state. Methods other than GET need allow_mutations on the provider.",
+		Attributes: map[string]rschema.Attribute{
+			"id": rschema.StringAttribute{
+				Computed:      true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"method": rschema.StringAttribute{
+				Optional:      true,
+				Description:   "HTTP method: GET, POST, PUT or DELETE (default: GET)",
+				PlanModifiers: replace,
+			},
+			"path": rschema.StringAttribute{
+				Required:      true,
+				Description:   "API path relative to the base URL (e.g., /server/123/cancellation)",
+				PlanModifiers: replace,
+			},
+			"form": rschema.MapAttribute{
+				Optional:      true,
+				Sensitive:     true,
+				ElementType:   types.StringType,
+				Description:   "Form fields sent as the request body; a key ending in [] is sent once per comma-separated value",
+				PlanModifiers: []planmodifier.Map{mapplanmodifier.RequiresReplace()},
+			},
+			"triggers": rschema.MapAttribute{
+				Optional:      true,
+				ElementType:   types.StringType,
+				Description:   "Arbitrary map of values that send the request again when any of them changes",
+				PlanModifiers: []planmodifier.Map{mapplanmodifier.RequiresReplace()},
+			},
+			"status": rschema.Int64Attribute{
+				Computed:      true,
+				Description:   "HTTP status code of the response",
+				PlanModifiers: []planmodifier.Int64{int64planmodifier.UseStateForUnknown()},
+			},
+			"response_body": rschema.StringAttribute{
+				Computed:      true,
+				Sensitive:     true,
+				Description:   "Response body, usually JSON (use jsondecode); sensitive since Robot returns passwords on some endpoints",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+		},
+	}
+}
+
+func (r *apiCallResource) Configure(_ context.Context, req resource.ConfigureRequest, _ *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	r.providerData = req.ProviderData.(*ProviderData)
+}
+
+func (r *apiCallResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var config apiCallModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if m := config.Method; !m.IsNull() && !m.IsUnknown() && !containsString(apiCallMethods, m.ValueString()) {
+		resp.Diagnostics.AddAttributeError(path.Root("method"), "Invalid method",
+			fmt.Sprintf("method must be one of %s, got %q.", strings.Join(apiCallMethods, ", "), m.ValueString()))
+	}
+	if p := config.Path; !p.IsNull() && !p.IsUnknown() && (!apiCallPath.MatchString(p.ValueString()) || strings.Contains(p.ValueString(), "..")) {
+		resp.Diagnostics.AddAttributeError(path.Root("path"), "Invalid path",
+			fmt.Sprintf("path must be an API path starting with / (e.g., /server/123), got %q.", p.ValueString()))
+	}
+}
+
+// ModifyPlan refuses methods other than GET without allow_mutations, so the plan fails instead
+// of the apply
+func (r *apiCallResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() || r.providerData == nil {
+		return
+	}
+	var plan apiCallModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() || plan.Method.IsUnknown() {
+		return
+	}
+	r.checkMutationAllowed(&resp.Diagnostics, plan)
+}
+
+// apiCallMethod returns the method to send, GET unless method is set
+func apiCallMethod(m apiCallModel) string {
+	if !m.Method.IsNull() && !m.Method.IsUnknown() && m.Method.ValueString() != "" {
+		return m.Method.ValueString()
+	}
+	return http.MethodGet
+}
+
+// checkMutationAllowed reports an error when the call isn't a GET and the provider doesn't set
+// allow_mutations
+func (r *apiCallResource) checkMutationAllowed(diags *diag.Diagnostics, m apiCallModel) {
+	if method := apiCallMethod(m); method != http.MethodGet && !r.providerData.AllowMutations {
+		diags.AddAttributeError(path.Root("method"), "Mutating API call not allowed",
+			fmt.Sprintf("%s %s could change or delete things in Robot. Set allow_mutations = true on the provider to send methods other than GET.", method, m.Path.ValueString()))
+	}
+}
+
+func (r *apiCallResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan apiCallModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	method := apiCallMethod(plan)
+	r.checkMutationAllowed(&resp.Diagnostics, plan)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	form := apiCallForm(ctx, plan.Form)
+	tflog.Info(ctx, "Sending raw Robot API call", map[string]interface{}{
+		"method": method,
+		"path":   plan.Path.ValueString(),
+		"fields": len(form),
+	})
+	status, body, err := r.providerData.Client.Call(method, plan.Path.ValueString(), form)
+	if err != nil {
+		resp.Diagnostics.AddError("Robot API call failed", fmt.Sprintf("%s %s: %s", method, plan.Path.ValueString(), err.Error()))
+		return
+	}
+
+	plan.ID = types.StringValue(fmt.Sprintf("%s %s %d", method, plan.Path.ValueString(), time.Now().Unix()))
+	plan.Status = types.Int64Value(int64(status))
+	plan.ResponseBody = types.StringValue(string(body))
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *apiCallResource) Read(_ context.Context, _ resource.ReadRequest, _ *resource.ReadResponse) {
+	// One-shot: the response is kept as it was when the call was sent
+}
+
+func (r *apiCallResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	// Every argument requires replacement, so there is nothing to update in place
+	var plan apiCallModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *apiCallResource) Delete(_ context.Context, _ resource.DeleteRequest, _ *resource.DeleteResponse) {
+	// Nothing to undo in Robot; the resource is just removed from state
+}
+
+// apiCallForm converts form to the request body; nil sends none. Keys ending in [] are Robot's
+// array fields and get one value per comma-separated entry
+func apiCallForm(ctx context.Context, form types.Map) url.Values {
+	if form.IsNull() || form.IsUnknown() {
+		return nil
+	}
+	var fields map[string]string
+	form.ElementsAs(ctx, &fields, false)
+	values := url.Values{}
+	for key, value := range fields {
+		if strings.HasSuffix(key, "[]") {
+			for _, v := range strings.Split(value, ",") {
+				values.Add(key, strings.TrimSpace(v))
+			}
+			continue
+		}
+		values.Set(key, value)
+	}
+	return values
+}
@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestAPICall(t *testing.T) {
+	var posted url.Values
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/traffic":
+			_, _ = w.Write([]byte(`{"traffic":{}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/wol/111":
+			posted = r.PostForm
+			_, _ = w.Write([]byte(`{"wol":{"server_number":111}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	ctx := context.Background()
+	res := &apiCallResource{providerData: pd}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	values := func(method, path string, form map[string]tftypes.Value) tftypes.Value {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["method"] = tftypes.NewValue(tftypes.String, method)
+		vals["path"] = tftypes.NewValue(tftypes.String, path)
+		if form != nil {
+			vals["form"] = tftypes.NewValue(tftypes.Map{ElementType: tftypes.String}, form)
+		}
+		return tftypes.NewValue(objType, vals)
+	}
+	create := func(raw tftypes.Value) (apiCallModel, diag.Diagnostics) {
+		resp := resource.CreateResponse{State: tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, nil)}}
+		res.Create(ctx, resource.CreateRequest{Plan: tfsdk.Plan{Schema: schemaResp.Schema, Raw: raw}}, &resp)
+		var state apiCallModel
+		if !resp.Diagnostics.HasError() {
+			resp.Diagnostics.Append(resp.State.Get(ctx, &state)...)
+		}
+		return state, resp.Diagnostics
+	}
+
+	state, diags := create(values(http.MethodGet, "/traffic", nil))
+	if diags.HasError() || state.Status.ValueInt64() != 200 || state.ResponseBody.ValueString() != `{"traffic":{}}` {
+		t.Fatalf("unexpected GET result: %+v %v", state, diags)
+	}
+
+	wol := values(http.MethodPost, "/wol/111", map[string]tftypes.Value{
+		"note":             tftypes.NewValue(tftypes.String, "hi"),
+		"authorized_key[]": tftypes.NewValue(tftypes.String, "aa, bb"),
+	})
+	plan := func(raw tftypes.Value) diag.Diagnostics {
+		p := tfsdk.Plan{Schema: schemaResp.Schema, Raw: raw}
+		resp := resource.ModifyPlanResponse{Plan: p}
+		res.ModifyPlan(ctx, resource.ModifyPlanRequest{Plan: p, State: tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, nil)}}, &resp)
+		return resp.Diagnostics
+	}
+	if diags := plan(wol); !diags.HasError() || diags.Errors()[0].Summary() != "Mutating API call not allowed" {
+		t.Fatalf("expected POST to fail the plan without allow_mutations, got %v", diags)
+	}
+	if diags := plan(values(http.MethodGet, "/traffic", nil)); diags.HasError() {
+		t.Fatalf("expected GET to be planned, got %v", diags)
+	}
+	if _, diags := create(wol); !diags.HasError() || posted != nil {
+		t.Fatalf("expected POST to be refused without allow_mutations, got %v", diags)
+	}
+	pd.AllowMutations = true
+	if diags := plan(wol); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if _, diags := create(wol); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if posted.Get("note") != "hi" || strings.Join(posted["authorized_key[]"], " ") != "aa bb" {
+		t.Fatalf("unexpected form: %v", posted)
+	}
+
+	for _, c := range []struct{ method, path string }{{"PATCH", "/server"}, {"GET", "https://evil.example/server"}, {"GET", "/server/../key"}, {"GET", "server"}} {
+		var resp resource.ValidateConfigResponse
+		res.ValidateConfig(ctx, resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: values(c.method, c.path, nil)}}, &resp)
+		if !resp.Diagnostics.HasError() {
+			t.Fatalf("expected %s %s to be rejected", c.method, c.path)
+		}
+	}
+}
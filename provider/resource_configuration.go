@@ -5,12 +5,27 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"net"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/boolplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+	"github.com/mokto/terraform-provider-hrobot/internal/tfutil"
 )
 
 type nodeLabelModel struct {
@@ -18,26 +33,109 @@ type nodeLabelModel struct {
 	Value types.String `tfsdk:"value"`
 }
 
-type configurationResource struct{ providerData *ProviderData }
+type configurationResource struct {
+	providerData *ProviderData
+	ssh          *configurationSSH // replaces the SSH steps of Read and Update in tests; nil runs the real ones
+}
+
+// configurationSSH holds the steps of Read and Update that log in to the server
+type configurationSSH struct {
+	configure   func(fp []string, ip string, plan *configurationModel, ctx context.Context) *ConfigureError
+	reboot      func(ctx context.Context, plan configurationModel) (string, string)
+	keepalive   func(ctx context.Context, plan configurationModel) (string, string)
+	setupMarker func(ctx context.Context, m configurationModel) (bool, error)
+}
+
+// sshSteps returns the SSH steps Read and Update run
+func (r *configurationResource) sshSteps() configurationSSH {
+	if r.ssh != nil {
+		return *r.ssh
+	}
+	return configurationSSH{configure: r.configure, reboot: r.rebootServer, keepalive: r.applyARPKeepalive, setupMarker: r.readSetupMarker}
+}
+
+const (
+	installModeFull          = "full"
+	installModeConfigureOnly = "configure_only"
+
+	destroyBehaviorNone   = "none"
+	destroyBehaviorRename = "rename"
+	destroyBehaviorCancel = "cancel"
+
+	networkBackendNetplan  = "netplan"
+	networkBackendIfupdown = "ifupdown"
+	networkBackendNetworkd = "systemd-networkd"
+)
 
 type configurationModel struct {
-	ID           types.String `tfsdk:"id"`
-	ServerNumber types.Int64  `tfsdk:"server_number"`
-	ServerIP     types.String `tfsdk:"server_ip"`
-	Name         types.String `tfsdk:"name"`
-	ServerName   types.String `tfsdk:"server_name"`
-	RobotName    types.String `tfsdk:"robot_name"`
-	Description  types.String `tfsdk:"description"`
-	VSwitchID    types.Int64  `tfsdk:"vswitch_id"`
-	Version      types.Int64  `tfsdk:"version"`
-	LocalIP      types.String `tfsdk:"local_ip"` // Now computed, automatically assigned
-	RaidLevel    types.Int64  `tfsdk:"raid_level"`
+	ID                       types.String `tfsdk:"id"`
+	ServerNumber             types.Int64  `tfsdk:"server_number"`
+	ServerIP                 types.String `tfsdk:"server_ip"`
+	Name                     types.String `tfsdk:"name"`
+	ServerName               types.String `tfsdk:"server_name"`
+	Hostname                 types.String `tfsdk:"hostname"`
+	RobotName                types.String `tfsdk:"robot_name"`
+	ManageRobotName          types.Bool   `tfsdk:"manage_robot_name"`
+	RobotNameTemplate        types.String `tfsdk:"robot_name_template"`
+	DestroyBehavior          types.String `tfsdk:"destroy_behavior"`
+	CancellationDate         types.String `tfsdk:"cancellation_date"`
+	EarliestCancellationDate types.String `tfsdk:"earliest_cancellation_date"`
+	ServerProduct            types.String `tfsdk:"server_product"`
+	ServerLocation           types.String `tfsdk:"server_location"`
+	ServerDatacenter         types.String `tfsdk:"server_datacenter"`
+	Description              types.String `tfsdk:"description"`
+	VSwitchID                types.Int64  `tfsdk:"vswitch_id"`
+	VSwitchName              types.String `tfsdk:"vswitch_name"`
+	VSwitchIDs               types.List   `tfsdk:"vswitch_ids"`
+	VSwitches                types.List   `tfsdk:"vswitches"`
+	NetworkBonding           types.Object `tfsdk:"network_bonding"`
+	NetworkInterface         types.String `tfsdk:"network_interface"`
+	NetworkInterfaceComputed types.String `tfsdk:"network_interface_computed"`
+	VLANMTU                  types.Int64  `tfsdk:"vlan_mtu"`
+	VLANInterfaceName        types.String `tfsdk:"vlan_interface_name"`
+	VLANInterfaceComputed    types.String `tfsdk:"vlan_interface_computed"`
+	ParentMTU                types.Int64  `tfsdk:"parent_mtu"`
+	Routes                   types.List   `tfsdk:"routes"`
+	ARPKeepalive             types.Object `tfsdk:"arp_keepalive"`
+	NetworkBackend           types.String `tfsdk:"network_backend"`
+	APTProxy                 types.String `tfsdk:"apt_proxy"`
+	APTHTTPSProxy            types.String `tfsdk:"apt_https_proxy"`
+	Version                  types.Int64  `tfsdk:"version"`
+	InstallMode              types.String `tfsdk:"install_mode"`
+	Triggers                 types.Map    `tfsdk:"triggers"`
+	LocalIP                  types.String `tfsdk:"local_ip"` // Assigned automatically unless set, e.g. from hrobot_private_ip
+	RaidLevel                types.Int64  `tfsdk:"raid_level"`
 
 	// Autosetup parameters
 	Arch           types.String `tfsdk:"arch"`
 	CryptPassword  types.String `tfsdk:"cryptpassword"`
 	NoUEFI         types.Bool   `tfsdk:"no_uefi"`
 	FilesystemType types.String `tfsdk:"filesystem_type"`
+	DiskConfig     types.Object `tfsdk:"disk_config"`
+
+	AutosetupOverride types.String `tfsdk:"autosetup_override"`
+	InstallimagePath  types.String `tfsdk:"installimage_path"`
+	ImageBasePath     types.String `tfsdk:"image_base_path"`
+	ImageChecksum     types.String `tfsdk:"image_checksum"`
+
+	PreInstallCommands types.List  `tfsdk:"pre_install_commands"`
+	BootDelay          types.Int64 `tfsdk:"boot_delay_seconds"`
+	RebootTimeout      types.Int64 `tfsdk:"reboot_timeout_minutes"`
+
+	// Hardware detected in the rescue system
+	DetectedDrives types.List   `tfsdk:"detected_drives"`
+	CPUModel       types.String `tfsdk:"cpu_model"`
+	MemoryGB       types.Int64  `tfsdk:"memory_gb"`
+	NICNames       types.List   `tfsdk:"nic_names"`
+
+	// Rescue system access
+	RescuePassword types.String `tfsdk:"rescue_password"`
+	RescueActive   types.Bool   `tfsdk:"rescue_active"`
+
+	SetupComplete          types.Bool  `tfsdk:"setup_complete"`
+	LUKSAutoUnlockVerified types.Bool  `tfsdk:"luks_auto_unlock_verified"`
+	BootSeconds            types.Int64 `tfsdk:"boot_seconds"`
+	ServicesHealthy        types.Bool  `tfsdk:"services_healthy"`
 
 	// K3S parameters
 	K3SToken   types.String `tfsdk:"k3s_token"`
@@ -45,11 +143,36 @@ type configurationModel struct {
 	NodeLabels types.List   `tfsdk:"node_labels"`
 	Taints     types.List   `tfsdk:"taints"`
 	CPUManager types.Bool   `tfsdk:"cpu_manager"`
+	K3S        types.Object `tfsdk:"k3s"`
+
+	InstalledK3SVersion types.String `tfsdk:"installed_k3s_version"`
+
+	WaitForK3SReady           types.String `tfsdk:"wait_for_k3s_ready"`
+	DependsOnServerConfigured types.List   `tfsdk:"depends_on_server_configured"`
+
+	// Ansible parameters
+	AnsibleRepo      types.String `tfsdk:"ansible_repo"`
+	AnsiblePlaybook  types.String `tfsdk:"ansible_playbook"`
+	AnsibleExtraVars types.Map    `tfsdk:"ansible_extra_vars"`
+	AnsibleRunStage  types.String `tfsdk:"ansible_run_stage"`
+
+	UserData       types.String `tfsdk:"user_data"`
+	UserDataFormat types.String `tfsdk:"user_data_format"`
 
 	// Docker parameters
 	InstallDocker types.Bool `tfsdk:"install_docker"`
 
-	RescueKeyFPs types.List `tfsdk:"rescue_authorized_key_fingerprints"`
+	ForceReboot        types.Bool `tfsdk:"force_reboot"`
+	VerifyReboot       types.Bool `tfsdk:"verify_reboot"`
+	WaitForManualReset types.Bool `tfsdk:"wait_for_manual_reset"`
+
+	ExtraScripts types.List `tfsdk:"extra_scripts"`
+
+	RescueKeyFPs       types.List   `tfsdk:"rescue_authorized_key_fingerprints"`
+	UseEphemeralSSHKey types.Bool   `tfsdk:"use_ephemeral_ssh_key"`
+	SSHAuth            types.Object `tfsdk:"ssh_auth"`
+
+	Outputs types.Map `tfsdk:"outputs"`
 }
 
 // generateNameHash generates a 6-character alphanumeric hash based on name, server number, and version
@@ -65,12 +188,117 @@ func generateNameHash(name string, serverNumber int64, version int64) (string, e
 	return hash, nil
 }
 
+// destroyBehavior returns what Delete does to the server in Robot (default rename)
+func destroyBehavior(m configurationModel) string {
+	if m.DestroyBehavior.IsNull() || m.DestroyBehavior.IsUnknown() || m.DestroyBehavior.ValueString() == "" {
+		return destroyBehaviorRename
+	}
+	return m.DestroyBehavior.ValueString()
+}
+
+// serverNumberUnknownDetail explains a null server_number, which is usually the output of an order
+// that is still in process
+const serverNumberUnknownDetail = "server_number is not yet known: the referenced order has not completed. Set wait_for_ready on the hrobot_server_order, or apply again later once the server is ready."
+
+// manageRobotName reports whether the provider owns the server name in Robot (default true)
+func manageRobotName(m configurationModel) bool {
+	return m.ManageRobotName.IsNull() || m.ManageRobotName.IsUnknown() || m.ManageRobotName.ValueBool()
+}
+
+// currentRobotName returns the server name as currently set in Robot
+func (r *configurationResource) currentRobotName(serverNumber int64) (types.String, error) {
+	server, err := r.providerData.CacheManager.GetServer(r.providerData.Client, int(serverNumber))
+	if err != nil {
+		return types.StringNull(), err
+	}
+	return types.StringValue(server.ServerName), nil
+}
+
+// versionPlanModifier rejects lowering version. Users tend to decrement it to "undo" a reinstall,
+// but any change triggers a new full install.
+type versionPlanModifier struct{}
+
+func (versionPlanModifier) Description(context.Context) string {
+	return "version may only increase"
+}
+
+func (m versionPlanModifier) MarkdownDescription(ctx context.Context) string {
+	return m.Description(ctx)
+}
+
+func (versionPlanModifier) PlanModifyInt64(ctx context.Context, req planmodifier.Int64Request, resp *planmodifier.Int64Response) {
+	if req.StateValue.IsNull() || req.PlanValue.IsNull() || req.PlanValue.IsUnknown() {
+		return
+	}
+	if current, planned := req.StateValue.ValueInt64(), req.PlanValue.ValueInt64(); planned < current {
+		resp.Diagnostics.AddAttributeError(req.Path, "Invalid version",
+			fmt.Sprintf("version may only increase; to avoid reinstall, keep the current value (%d instead of %d).", current, planned))
+	}
+}
+
 // computeNames generates server_name and robot_name from base name and hash
 func computeNames(name string, hash string) (string, string) {
 	computedName := fmt.Sprintf("%s-%s", name, hash)
 	return computedName, computedName
 }
 
+// RobotNameTemplateData is the data available to robot_name_template
+type RobotNameTemplateData struct {
+	Name     string // base name
+	Hash     string // 6-character id shared with server_name
+	Location string // Robot location, e.g. FSN1
+}
+
+// renderRobotName executes robot_name_template. Unknown fields such as {{.Foo}} are an error.
+func renderRobotName(tmpl string, data RobotNameTemplateData) (string, error) {
+	t, err := template.New("robot_name").Option("missingkey=error").Funcs(template.FuncMap{
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(b.String())
+	if name == "" {
+		return "", fmt.Errorf("template rendered an empty name")
+	}
+	return name, nil
+}
+
+// nameHash returns the id suffix of a computed server_name
+func nameHash(serverName, name string) string {
+	return strings.TrimPrefix(serverName, name+"-")
+}
+
+// applyRobotNameTemplate sets plan.RobotName from robot_name_template, if configured
+func (r *configurationResource) applyRobotNameTemplate(diags *diag.Diagnostics, plan *configurationModel) {
+	if plan.RobotNameTemplate.IsNull() || plan.RobotNameTemplate.IsUnknown() {
+		return
+	}
+	data := RobotNameTemplateData{
+		Name: plan.Name.ValueString(),
+		Hash: nameHash(plan.ServerName.ValueString(), plan.Name.ValueString()),
+	}
+	if strings.Contains(plan.RobotNameTemplate.ValueString(), ".Location") {
+		server, err := r.providerData.CacheManager.GetServer(r.providerData.Client, int(plan.ServerNumber.ValueInt64()))
+		if err != nil {
+			diags.AddError("read server failed", robotErrorDetail(err, "read servers", "Server"))
+			return
+		}
+		data.Location = server.Location
+	}
+	robotName, err := renderRobotName(plan.RobotNameTemplate.ValueString(), data)
+	if err != nil {
+		diags.AddAttributeError(path.Root("robot_name_template"), "Invalid robot_name_template", err.Error())
+		return
+	}
+	plan.RobotName = types.StringValue(robotName)
+}
+
 func NewResourceConfiguration() resource.Resource { return &configurationResource{} }
 
 func (r *configurationResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -81,44 +309,321 @@ func (r *configurationResource) Schema(_ context.Context, _ resource.SchemaReque
 	resp.Schema = rschema.Schema{
 		Description: "Manages Hetzner Robot server configuration including server naming, OS installation, and post-install setup.",
 		Attributes: map[string]rschema.Attribute{
-			"server_number": rschema.Int64Attribute{Required: true, Description: "Robot server number"},
-			"server_ip":     rschema.StringAttribute{Required: true, Description: "The server's IP address"},
-			"name":          rschema.StringAttribute{Required: true, Description: "Base name for the server (server_name and robot_name will be computed as name-{6-char-id})"},
-			"server_name":   rschema.StringAttribute{Computed: true, Description: "Computed server name in format: name-{6-char-id} (used as hostname in autosetup)"},
-			"robot_name":    rschema.StringAttribute{Computed: true, Description: "Computed robot name in format: name-{6-char-id} (used in Hetzner Robot interface)"},
-			"description":   rschema.StringAttribute{Optional: true, Description: "Custom description for the server"},
-			"vswitch_id":    rschema.Int64Attribute{Optional: true, Description: "ID of the vSwitch to connect the server to"},
-			"version":       rschema.Int64Attribute{Optional: true, Description: "Version of the node, will trigger rescue + full install on each change"},
-			"local_ip":      rschema.StringAttribute{Computed: true, Description: "Automatically assigned local IP address for private network configuration (10.1.0.2-10.1.0.127)"},
-			"raid_level":    rschema.Int64Attribute{Optional: true, Description: "RAID level for software RAID configuration (default: 1)"},
-
-			// Autosetup parameters
-			"arch":            rschema.StringAttribute{Required: true, Description: "Architecture for the OS image (arm64 or amd64)"},
-			"cryptpassword":   rschema.StringAttribute{Required: true, Sensitive: true, Description: "Password for disk encryption (used in autosetup)"},
-			"no_uefi":         rschema.BoolAttribute{Optional: true, Description: "If true, removes the UEFI boot partition from the disk partitioning scheme"},
-			"filesystem_type": rschema.StringAttribute{Optional: true, Description: "Filesystem type for root partition (default: ext4)"},
-
-			// K3S parameters
-			"k3s_token": rschema.StringAttribute{Required: true, Sensitive: true, Description: "K3S token for joining the cluster"},
-			"k3s_url":   rschema.StringAttribute{Required: true, Description: "K3S server URL (e.g., https://master-ip:6443)"},
-			"node_labels": rschema.ListNestedAttribute{
+			"server_number": rschema.Int64Attribute{
+				Optional:      true,
+				Description:   "Robot server number. It must be set; the schema only marks it optional so that an unknown value from an hrobot_server_order in the same configuration can be planned. A null value, which an order that has not completed yet returns, is rejected at validation with a server_number is not yet known error. Changing it replaces the resource, as all configuration is tied to one server",
+				PlanModifiers: []planmodifier.Int64{int64planmodifier.RequiresReplace()},
+			},
+			"server_ip": rschema.StringAttribute{
+				Optional:      true,
+				Computed:      true,
+				Description:   "The server's IP address. Looked up in Robot when omitted; when set, it must match the server's address in Robot",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"name":        rschema.StringAttribute{Required: true, Description: "Base name for the server (server_name and robot_name will be computed as name-{6-char-id})"},
+			"server_name": rschema.StringAttribute{Computed: true, Description: "Computed server name in format: name-{6-char-id} (used as hostname in autosetup unless hostname is set)"},
+			"robot_name":  rschema.StringAttribute{Computed: true, Description: "Computed robot name in format: name-{6-char-id}, or rendered from robot_name_template (used in Hetzner Robot interface), or the current Robot name when manage_robot_name is false"},
+			"hostname": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Hostname of the installed OS, e.g. \"worker-1\", instead of server_name. Set in autosetup and with hostnamectl on first run; changes apply on the next install (default: server_name)",
+			},
+			"robot_name_template": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Go text/template for robot_name, e.g. \"prod-{{.Name}}-{{.Hash}}-{{lower .Location}}\". Available fields: .Name (base name), .Hash (6-char id), .Location (Robot location); functions: lower, upper. Does not affect server_name (default: name-{6-char-id})",
+			},
+			"destroy_behavior": rschema.StringAttribute{
+				Optional:    true,
+				Description: "What destroy does to the server in Robot: none (only release the local IP), rename (remove it from its vSwitches and rename it to 'cancelled', nothing is cancelled) or cancel (remove it from its vSwitches and cancel it) (default: rename)",
+			},
+			"cancellation_date": rschema.StringAttribute{
 				Optional:    true,
-				Description: "List of node labels to apply to this K3S node",
+				Description: "Cancellation date (yyyy-mm-dd or 'now') used when destroy_behavior is cancel (default: end of the billing period)",
+			},
+			"earliest_cancellation_date": rschema.StringAttribute{
+				Computed:    true,
+				Description: "Earliest date the server can be cancelled on (yyyy-mm-dd), used by destroy_behavior = cancel when cancellation_date is unset",
+			},
+			"server_product": rschema.StringAttribute{
+				Computed:      true,
+				Description:   "Product of the server as reported by Robot (e.g., EX101), read when the server is configured",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"server_location": rschema.StringAttribute{
+				Computed:      true,
+				Description:   "Location of the server as reported by Robot (e.g., FSN1), read when the server is configured; useful for auction servers, whose location is only known once ordered",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"server_datacenter": rschema.StringAttribute{
+				Computed:      true,
+				Description:   "Datacenter of the server as reported by Robot (e.g., FSN1-DC14), read before the configuration; the k3s block labels the node with it",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"manage_robot_name": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Rename the server in Robot to robot_name, and to 'cancelled' on destroy. Set to false to keep names managed by other tooling (default: true)",
+			},
+			"description": rschema.StringAttribute{Optional: true, Description: "Custom description for the server, stored as a note on the server in Robot where supported"},
+			"vswitch_id": rschema.Int64Attribute{
+				Optional:           true,
+				Computed:           true,
+				Description:        "ID of the vSwitch to connect the server to",
+				DeprecationMessage: "Use vswitch_ids or vswitches instead; vswitch_id is kept as a single-vSwitch alias.",
+			},
+			"vswitch_ids": rschema.ListAttribute{
+				Optional:    true,
+				ElementType: types.Int64Type,
+				Description: "IDs of vSwitches to connect the server to without configuring a VLAN interface for them (use vswitches for that); conflicts with vswitches",
+			},
+			"vswitches": rschema.ListNestedAttribute{
+				Optional:    true,
+				Description: "vSwitches to connect the server to. Entries on the private VLAN 4001 only attach the server (it is configured with local_ip); every other entry gets its own VLAN interface",
 				NestedObject: rschema.NestedAttributeObject{
 					Attributes: map[string]rschema.Attribute{
-						"name":  rschema.StringAttribute{Required: true, Description: "Label name"},
-						"value": rschema.StringAttribute{Required: true, Description: "Label value"},
+						"vswitch_id": rschema.Int64Attribute{Required: true, Description: "ID of the vSwitch"},
+						"vlan":       rschema.Int64Attribute{Required: true, Description: "VLAN ID of the vSwitch (4000-4091)"},
+						"local_ip":   rschema.StringAttribute{Optional: true, Description: "Address for the VLAN interface in CIDR notation (e.g., 10.2.0.5/24); a bare IP gets /24"},
+						"mtu":        rschema.Int64Attribute{Optional: true, Description: "MTU of the VLAN interface (default: 1400)"},
 					},
 				},
 			},
-			"taints": rschema.ListAttribute{
+			"network_bonding": networkBondingAttribute(),
+			"network_interface": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Public NIC the VLAN interfaces are attached to (e.g. enp9s0), for servers where the interface with the default route is the wrong one; unset to use that interface. Cannot be combined with network_bonding, whose VLANs use bond0. Takes effect on the next install",
+			},
+			"network_interface_computed": rschema.StringAttribute{
+				Computed:    true,
+				Description: "Interface the VLAN interfaces were attached to by the last install (network_interface, the detected default interface or bond0); null when no private network was configured",
+			},
+			"vlan_mtu": rschema.Int64Attribute{Optional: true, Description: "MTU of the private VLAN 4001 interface, at most parent_mtu (default: 1400)"},
+			"vlan_interface_name": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Fixed name of the private VLAN 4001 interface, up to 15 characters (e.g. vlan4001). Unset, it is named after its parent (e.g. enp9s0.4001). Takes effect on the next install",
+			},
+			"vlan_interface_computed": rschema.StringAttribute{
+				Computed:    true,
+				Description: "Name of the private VLAN 4001 interface found with `ip link show type vlan` after the last install; null when none was found",
+			},
+			"parent_mtu": rschema.Int64Attribute{Optional: true, Description: "MTU of the interface the VLANs are attached to (default: 1500)"},
+			"routes": rschema.ListNestedAttribute{
+				Optional:    true,
+				Description: "Static routes on the private VLAN 4001 interface; set to [] for none (default: 10.0.0.0/16 via 10.1.0.1 metric 100)",
+				NestedObject: rschema.NestedAttributeObject{
+					Attributes: map[string]rschema.Attribute{
+						"to":     rschema.StringAttribute{Required: true, Description: "Destination in CIDR notation, or \"default\""},
+						"via":    rschema.StringAttribute{Required: true, Description: "Gateway, inside the private subnet 10.1.0.0/24"},
+						"metric": rschema.Int64Attribute{Optional: true, Description: "Route metric (default: 100)"},
+					},
+				},
+			},
+			"arp_keepalive": arpKeepaliveAttribute(),
+			"network_backend": rschema.StringAttribute{
+				Optional:    true,
+				Description: "How the first-run script configures the private network: netplan, ifupdown or systemd-networkd (default: auto-detected on the server, netplan if /usr/sbin/netplan exists, else ifupdown if /etc/network/interfaces does)",
+			},
+			"apt_proxy": rschema.StringAttribute{
+				Optional:    true,
+				Description: "URL of a caching proxy for apt over HTTP (e.g., http://apt-cache.internal:3142 for Apt-Cacher NG), written to " + aptProxyFile + " by the first-run script; removing it removes the file on the next configuration",
+			},
+			"apt_https_proxy": rschema.StringAttribute{
+				Optional:    true,
+				Description: "URL of the proxy apt uses for HTTPS repositories, written next to apt_proxy",
+			},
+			"vswitch_name": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Name of the vSwitch to connect the server to, as an alternative to vswitch_id; must match exactly one vSwitch",
+			},
+			"install_mode": rschema.StringAttribute{
+				Optional:    true,
+				Description: "full (default) boots the rescue system and reinstalls the OS; configure_only skips the install and runs the first-run network setup and K3S install on the existing OS over SSH (agent auth)",
+			},
+			"version": rschema.Int64Attribute{
+				Optional:      true,
+				Description:   "Version of the node, will trigger rescue + full install on each change. This is the intended way to reinstall while keeping the same server (changing arch or cryptpassword replaces the resource instead). It may only increase: lowering it does not undo a reinstall but triggers another one, so it is rejected at plan time",
+				PlanModifiers: []planmodifier.Int64{versionPlanModifier{}},
+			},
+			"triggers": rschema.MapAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Arbitrary map of values that trigger rescue + full install when any of them changes, like null_resource triggers (e.g., a rotated K3S token)",
+			},
+			"local_ip": rschema.StringAttribute{
+				Optional:    true,
+				Computed:    true,
+				Description: "Local IP address for the private network configuration, e.g. the address of a hrobot_private_ip; a /24 is configured around it. Assigned automatically from 10.1.0.2-10.1.0.127 when not set. Changing it needs a reinstall (version bump)",
+			},
+			"raid_level": rschema.Int64Attribute{
+				Optional:           true,
+				Description:        "RAID level for software RAID configuration (default: 1). " + diskConfigMigration,
+				DeprecationMessage: "Use disk_config.raid_level instead.",
+			},
+
+			// Autosetup parameters
+			"arch": rschema.StringAttribute{
+				Optional:           true,
+				Computed:           true,
+				Description:        "Architecture for the OS image (arm64 or amd64); when neither it nor disk_config.arch is set, the arch detected from uname -m in the rescue system. Changing it replaces the resource. " + diskConfigMigration,
+				DeprecationMessage: "Use disk_config.arch instead.",
+				PlanModifiers:      []planmodifier.String{stringplanmodifier.UseStateForUnknown(), archReplace},
+			},
+			"cryptpassword": rschema.StringAttribute{
+				Optional:      true,
+				Sensitive:     true,
+				Description:   "Password for disk encryption (used in autosetup); required when install_mode is full. Printable ASCII without spaces only, since installimage reads it up to the first whitespace. Changing it replaces the resource",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.RequiresReplace()},
+			},
+			"no_uefi": rschema.BoolAttribute{
+				Optional:           true,
+				Description:        "If true, removes the UEFI boot partition from the disk partitioning scheme. " + diskConfigMigration,
+				DeprecationMessage: "Use disk_config.no_uefi instead.",
+			},
+			"filesystem_type": rschema.StringAttribute{
+				Optional:           true,
+				Description:        "Filesystem type for root partition (default: ext4). " + diskConfigMigration,
+				DeprecationMessage: "Use disk_config.filesystem instead.",
+			},
+			"disk_config": diskConfigAttribute(),
+			"autosetup_override": rschema.StringAttribute{
+				Optional:    true,
+				Sensitive:   true,
+				Description: "installimage autosetup content uploaded verbatim instead of the generated one. Unused disks are not wiped and the root filesystem must still be LUKS encrypted with cryptpassword. Conflicts with disk_config and the deprecated arch, raid_level, no_uefi and filesystem_type",
+			},
+			"image_base_path": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Directory or http(s) URL installimage takes the Ubuntu-2404-noble-<arch>-base.tar.gz image from, e.g. a private mirror (default: " + defaultImageBasePath + ")",
+			},
+			"image_checksum": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Checksum of an image downloaded over HTTP as <type>:<hex digest>, type md5, sha1, sha256 or sha512 (e.g. sha256:9f86...)",
+			},
+			"installimage_path": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Path of the installimage binary in the rescue system (default: " + defaultInstallimagePath + ")",
+			},
+			"boot_delay_seconds": rschema.Int64Attribute{
+				Optional:    true,
+				Description: fmt.Sprintf("Seconds to wait after each reboot before polling SSH, so the server has gone down first; %d-%d (default: %d)", minBootDelaySeconds, maxBootDelaySeconds, defaultBootDelaySeconds),
+			},
+			"reboot_timeout_minutes": rschema.Int64Attribute{
+				Optional:    true,
+				Description: fmt.Sprintf("Minutes to wait for SSH to come back after each reboot of the installed OS; %d-%d (default: %d)", minRebootTimeoutMinutes, maxRebootTimeoutMinutes, defaultRebootTimeoutMinutes),
+			},
+			"pre_install_commands": rschema.ListAttribute{
 				Optional:    true,
 				ElementType: types.StringType,
-				Description: "List of taints to apply to this K3S node (e.g., 'localstorage=true:NoSchedule')",
+				Description: "Shell commands run in order in the rescue system before the disks are detected and installimage runs (e.g., modprobe, temporary routes); the first failing command stops the install. Not run with install_mode = configure_only",
+			},
+			"detected_drives": rschema.ListNestedAttribute{
+				Computed:     true,
+				Description:  "Disks found in the rescue system during the last install, largest first (e.g., for templating autosetup_override)",
+				NestedObject: rschema.NestedAttributeObject{Attributes: detectedDriveAttributes()},
+			},
+			"cpu_model": rschema.StringAttribute{Computed: true, Description: "CPU model found in the rescue system during the last install"},
+			"memory_gb": rschema.Int64Attribute{Computed: true, Description: "Installed memory in GiB found in the rescue system during the last install"},
+			"nic_names": rschema.ListAttribute{
+				Computed:    true,
+				ElementType: types.StringType,
+				Description: "Physical network interfaces found in the rescue system during the last install",
+			},
+			"rescue_password": rschema.StringAttribute{
+				Computed:    true,
+				Sensitive:   true,
+				Description: "Root password of the rescue system, kept only when a configuration fails after activating it so the server can be inspected by hand; null after a successful run",
+			},
+			"rescue_active": rschema.BoolAttribute{
+				Computed:    true,
+				Description: "Whether the rescue system is activated for the next boot, as Robot reports it",
+			},
+			"setup_complete": rschema.BoolAttribute{
+				Computed:      true,
+				Description:   "Whether the last configuration ran to completion; false after a failed run, or when refresh finds an install whose first run was interrupted, and the next apply configures the server again",
+				PlanModifiers: []planmodifier.Bool{boolplanmodifier.UseStateForUnknown()},
+			},
+			"luks_auto_unlock_verified": rschema.BoolAttribute{
+				Computed:    true,
+				Description: "Whether the LUKS auto-unlock was checked after the final reboot of the install: keyfile in the initramfs, two keyslots and a healthy crypt unit. A failed check fails the apply; null when install_mode is configure_only",
+			},
+			"boot_seconds": rschema.Int64Attribute{
+				Computed:    true,
+				Description: "Seconds from the reboot of verify_reboot until SSH answered again; null without verify_reboot",
+			},
+			"services_healthy": rschema.BoolAttribute{
+				Computed:    true,
+				Description: "Whether K3S (k3s or k3s-agent, when installed) was active and local_ip present after the reboot of verify_reboot; null without verify_reboot",
+			},
+
+			// K3S parameters
+			"k3s_token": rschema.StringAttribute{
+				Optional:           true,
+				Sensitive:          true,
+				Description:        "K3S token for joining the cluster. " + k3sMigration,
+				DeprecationMessage: "Use k3s.token instead.",
+			},
+			"k3s_url": rschema.StringAttribute{
+				Optional:           true,
+				Description:        "K3S server URL (e.g., https://master-ip:6443). " + k3sMigration,
+				DeprecationMessage: "Use k3s.url instead.",
+			},
+			"node_labels": rschema.ListNestedAttribute{
+				Optional:           true,
+				Description:        "List of node labels to apply to this K3S node. " + k3sMigration,
+				DeprecationMessage: "Use k3s.node_labels instead.",
+				NestedObject:       nodeLabelsAttribute().NestedObject,
+			},
+			"taints": rschema.ListAttribute{
+				Optional:           true,
+				ElementType:        types.StringType,
+				Description:        "List of taints to apply to this K3S node (e.g., 'localstorage=true:NoSchedule'). " + k3sMigration,
+				DeprecationMessage: "Use k3s.taints instead.",
 			},
 			"cpu_manager": rschema.BoolAttribute{
+				Optional:           true,
+				Description:        "Enable CPU manager with static policy and resource reservations (cpu-manager-policy=static, system-reserved=cpu=1, kube-reserved=cpu=1). " + k3sMigration,
+				DeprecationMessage: "Use k3s.cpu_manager instead.",
+			},
+			"k3s": k3sAttribute(),
+			"installed_k3s_version": rschema.StringAttribute{
+				Computed:    true,
+				Description: "K3S version reported by `k3s --version` after the last install (e.g., v1.30.2+k3s1); null when K3S is not installed or the version could not be read",
+			},
+			"wait_for_k3s_ready": rschema.StringAttribute{
+				Optional:    true,
+				Description: "K3S API URL (e.g., https://10.0.0.2:6443) polled from the server after first boot until it responds, before K3S is installed; use it so workers wait for the master configured alongside them (up to 30 minutes)",
+			},
+			"depends_on_server_configured": rschema.ListAttribute{
+				Optional:    true,
+				ElementType: types.Int64Type,
+				Description: "Server numbers this configuration expects to be configured first, e.g. the K3S master. Documents intent only: ordering still comes from depends_on, and wait_for_k3s_ready does the actual waiting",
+			},
+
+			// Ansible parameters
+			"ansible_repo": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Git repository ansible-pull checks out on the server after the first boot, once the network is up (e.g., https://github.com/org/infra.git). Ansible is installed when missing; a failed run fails the apply with the end of its output",
+			},
+			"ansible_playbook": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Playbook to run, relative to the repository (default: " + defaultAnsiblePlaybook + ")",
+			},
+			"ansible_extra_vars": rschema.MapAttribute{
+				Optional:    true,
+				Sensitive:   true,
+				ElementType: types.StringType,
+				Description: "Extra variables for the playbook. They are written to a file only root can read for the run and removed afterwards, never put on the command line",
+			},
+			"ansible_run_stage": rschema.StringAttribute{
 				Optional:    true,
-				Description: "Enable CPU manager with static policy and resource reservations (cpu-manager-policy=static, system-reserved=cpu=1, kube-reserved=cpu=1)",
+				Description: "Run ansible-pull before_k3s or after_k3s (default: after_k3s)",
+			},
+
+			"user_data": rschema.StringAttribute{
+				Optional:    true,
+				Sensitive:   true,
+				Description: fmt.Sprintf("cloud-init user data (write_files, runcmd, users, ...) run on the first boot after the install. cloud-init is installed when missing and reads it from a root-only NoCloud seed; it does not manage the network. At most %d bytes", maxUserDataBytes),
+			},
+			"user_data_format": rschema.StringAttribute{
+				Optional:    true,
+				Description: "Format of user_data: cloud-config or shell (a script). The #cloud-config or #!/bin/bash header is added when missing (default: cloud-config)",
 			},
 
 			// Docker parameters
@@ -126,17 +631,210 @@ func (r *configurationResource) Schema(_ context.Context, _ resource.SchemaReque
 				Optional:    true,
 				Description: "Install Docker Engine and Docker Compose during provisioning (default: false)",
 			},
+			"force_reboot": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Reboot the server over SSH, without reinstalling, when this changes from false to true on update; set it back to false afterwards, which does nothing (default: false)",
+			},
+			"wait_for_manual_reset": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "For servers Robot offers neither a hardware nor a software reset for (older auction servers without IPMI): instead of failing with instructions to order a manual power cycle, wait up to 2 hours for one and continue once the rescue system answers over SSH (default: false)",
+			},
+			"verify_reboot": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "After the configuration, reboot once more unattended and check that the server comes back within reboot_timeout_minutes with K3S active and local_ip present; a failed check fails the apply (default: false)",
+			},
+			"extra_scripts": rschema.ListAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Bash scripts run in order at the end of the first-run script, after the network and Docker setup and before K3S is installed. Each runs in its own subshell; a failing script is logged and the next one still runs",
+			},
 
 			"rescue_authorized_key_fingerprints": rschema.ListAttribute{
-				Required:    true,
+				Optional:    true,
 				ElementType: types.StringType,
-				Description: "SSH key fingerprints for rescue mode access",
+				Description: "SSH key fingerprints for rescue mode access (the provider logs in with one of these keys, see ssh_auth); required unless use_ephemeral_ssh_key is true. Changing it on an installed server rewrites root's authorized_keys (and the dropbear unlock keys) over SSH without a reinstall; at least one new key must be one the provider logs in with",
+			},
+			"ssh_auth": sshAuthAttribute(),
+			"use_ephemeral_ssh_key": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Generate a throwaway Ed25519 key for each configuration run instead of using rescue_authorized_key_fingerprints and the SSH agent. The key is removed from Robot afterwards; its public half stays in the installed OS's authorized_keys (default: false)",
 			},
-			"id": rschema.StringAttribute{Computed: true},
+			"outputs": rschema.MapAttribute{
+				Computed:    true,
+				ElementType: types.StringType,
+				Description: "Values printed by the provisioning scripts as HROBOT_OUTPUT_<KEY>=<value> lines, keyed by <KEY>",
+			},
+			"id": rschema.StringAttribute{Computed: true, PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()}},
 		},
 	}
 }
 
+func (r *configurationResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var config configurationModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if config.ServerNumber.IsNull() {
+		resp.Diagnostics.AddAttributeError(path.Root("server_number"), "server_number is not yet known", serverNumberUnknownDetail)
+	}
+	validateVSwitches(ctx, &resp.Diagnostics, config)
+	if !config.InstallimagePath.IsNull() && !config.InstallimagePath.IsUnknown() && !installimagePathPattern.MatchString(config.InstallimagePath.ValueString()) {
+		resp.Diagnostics.AddAttributeError(path.Root("installimage_path"), "Invalid installimage_path",
+			fmt.Sprintf("installimage_path must be an absolute path of letters, digits and . _ + - / only, got %q.", config.InstallimagePath.ValueString()))
+	}
+	if !config.Hostname.IsNull() && !config.Hostname.IsUnknown() && !hostnamePattern.MatchString(config.Hostname.ValueString()) {
+		resp.Diagnostics.AddAttributeError(path.Root("hostname"), "Invalid hostname",
+			fmt.Sprintf("hostname must be dot-separated labels of letters, digits and hyphens (at most 63 characters each, not starting or ending with a hyphen), got %q.", config.Hostname.ValueString()))
+	}
+	if !config.RebootTimeout.IsNull() && !config.RebootTimeout.IsUnknown() {
+		if m := config.RebootTimeout.ValueInt64(); m < minRebootTimeoutMinutes || m > maxRebootTimeoutMinutes {
+			resp.Diagnostics.AddAttributeError(path.Root("reboot_timeout_minutes"), "Invalid reboot_timeout_minutes",
+				fmt.Sprintf("reboot_timeout_minutes must be between %d and %d, got %d.", minRebootTimeoutMinutes, maxRebootTimeoutMinutes, m))
+		}
+	}
+	if !config.BootDelay.IsNull() && !config.BootDelay.IsUnknown() {
+		if d := config.BootDelay.ValueInt64(); d < minBootDelaySeconds || d > maxBootDelaySeconds {
+			resp.Diagnostics.AddAttributeError(path.Root("boot_delay_seconds"), "Invalid boot_delay_seconds",
+				fmt.Sprintf("boot_delay_seconds must be between %d and %d, got %d.", minBootDelaySeconds, maxBootDelaySeconds, d))
+		}
+	}
+	validateDiskConfig(ctx, &resp.Diagnostics, config)
+	validateImage(&resp.Diagnostics, config)
+	validateK3S(ctx, &resp.Diagnostics, config)
+	validateAnsible(ctx, &resp.Diagnostics, config)
+	validateUserData(&resp.Diagnostics, config)
+	validateNetworkBonding(ctx, &resp.Diagnostics, config)
+	validateNetworkInterface(&resp.Diagnostics, config)
+	validatePrivateNetwork(ctx, &resp.Diagnostics, config)
+	validateARPKeepalive(ctx, &resp.Diagnostics, config)
+	if !config.SSHAuth.IsNull() && !config.SSHAuth.IsUnknown() {
+		var auth sshAuthModel
+		config.SSHAuth.As(ctx, &auth, basetypes.ObjectAsOptions{UnhandledUnknownAsEmpty: true})
+		validateSSHAuth(&resp.Diagnostics, path.Root("ssh_auth"), auth)
+		if !config.UseEphemeralSSHKey.IsUnknown() && config.UseEphemeralSSHKey.ValueBool() {
+			resp.Diagnostics.AddAttributeError(path.Root("ssh_auth"), "Conflicting SSH settings",
+				"ssh_auth cannot be combined with use_ephemeral_ssh_key, which logs in with its own throwaway key.")
+		}
+	}
+
+	if !config.NetworkBackend.IsNull() && !config.NetworkBackend.IsUnknown() {
+		switch b := config.NetworkBackend.ValueString(); b {
+		case networkBackendNetplan:
+		case networkBackendIfupdown, networkBackendNetworkd:
+			if !config.NetworkBonding.IsNull() {
+				resp.Diagnostics.AddAttributeError(path.Root("network_bonding"), "Conflicting network settings",
+					fmt.Sprintf("network_bonding is only supported with the %s network backend, not %s.", networkBackendNetplan, b))
+			}
+		default:
+			resp.Diagnostics.AddAttributeError(path.Root("network_backend"), "Invalid network_backend",
+				fmt.Sprintf("network_backend must be %q, %q or %q, got %q.", networkBackendNetplan, networkBackendIfupdown, networkBackendNetworkd, b))
+		}
+	}
+
+	validateAPTProxies(&resp.Diagnostics, config)
+
+	if !config.RobotNameTemplate.IsNull() && !config.RobotNameTemplate.IsUnknown() {
+		sample := RobotNameTemplateData{Name: "name", Hash: "000000", Location: "FSN1"}
+		if _, err := renderRobotName(config.RobotNameTemplate.ValueString(), sample); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("robot_name_template"), "Invalid robot_name_template", err.Error())
+		}
+		if !config.ManageRobotName.IsNull() && !config.ManageRobotName.IsUnknown() && !config.ManageRobotName.ValueBool() {
+			resp.Diagnostics.AddAttributeError(path.Root("robot_name_template"), "Unused robot_name_template",
+				"robot_name_template has no effect when manage_robot_name is false.")
+		}
+	}
+
+	if !config.DestroyBehavior.IsNull() && !config.DestroyBehavior.IsUnknown() {
+		switch b := config.DestroyBehavior.ValueString(); b {
+		case destroyBehaviorNone, destroyBehaviorCancel:
+		case destroyBehaviorRename:
+			if !config.ManageRobotName.IsNull() && !config.ManageRobotName.IsUnknown() && !config.ManageRobotName.ValueBool() {
+				resp.Diagnostics.AddAttributeError(path.Root("destroy_behavior"), "Conflicting destroy_behavior",
+					"destroy_behavior cannot be rename when manage_robot_name is false.")
+			}
+		default:
+			resp.Diagnostics.AddAttributeError(path.Root("destroy_behavior"), "Invalid destroy_behavior",
+				fmt.Sprintf("destroy_behavior must be %q, %q or %q, got %q.", destroyBehaviorNone, destroyBehaviorRename, destroyBehaviorCancel, b))
+		}
+	}
+	if !config.CancellationDate.IsNull() && !config.DestroyBehavior.IsUnknown() && config.DestroyBehavior.ValueString() != destroyBehaviorCancel {
+		resp.Diagnostics.AddAttributeError(path.Root("cancellation_date"), "Unused cancellation_date",
+			"cancellation_date only applies when destroy_behavior is cancel.")
+	}
+
+	if config.InstallMode.IsUnknown() {
+		return
+	}
+	switch config.InstallMode.ValueString() {
+	case "", installModeFull:
+	case installModeConfigureOnly:
+		// Nothing is installed, so only the first-run phase over the OS's own SSH applies
+		onlyForFull := map[string]attr.Value{
+			"cryptpassword":         config.CryptPassword,
+			"autosetup_override":    config.AutosetupOverride,
+			"use_ephemeral_ssh_key": config.UseEphemeralSSHKey,
+			"installimage_path":     config.InstallimagePath,
+			"image_base_path":       config.ImageBasePath,
+			"image_checksum":        config.ImageChecksum,
+		}
+		for name, v := range onlyForFull {
+			if !v.IsNull() {
+				resp.Diagnostics.AddAttributeError(path.Root(name), "Conflicting install_mode settings",
+					fmt.Sprintf("%s cannot be set when install_mode is %q, the OS is not reinstalled.", name, installModeConfigureOnly))
+			}
+		}
+		if !config.ServerIP.IsNull() && !config.ServerIP.IsUnknown() && net.ParseIP(config.ServerIP.ValueString()) == nil {
+			resp.Diagnostics.AddAttributeError(path.Root("server_ip"), "Invalid server_ip",
+				fmt.Sprintf("server_ip must be the address of the installed OS when install_mode is %q, got %q.", installModeConfigureOnly, config.ServerIP.ValueString()))
+		}
+		return
+	default:
+		resp.Diagnostics.AddAttributeError(path.Root("install_mode"), "Invalid install_mode",
+			fmt.Sprintf("install_mode must be %q or %q, got %q.", installModeFull, installModeConfigureOnly, config.InstallMode.ValueString()))
+		return
+	}
+
+	if config.CryptPassword.IsNull() {
+		resp.Diagnostics.AddAttributeError(path.Root("cryptpassword"), "Missing cryptpassword",
+			fmt.Sprintf("cryptpassword is required when install_mode is %q.", installModeFull))
+	} else if !config.CryptPassword.IsUnknown() && !cryptPasswordPattern.MatchString(config.CryptPassword.ValueString()) {
+		// The value is sensitive, so the error doesn't repeat it
+		resp.Diagnostics.AddAttributeError(path.Root("cryptpassword"), "Invalid cryptpassword",
+			"cryptpassword may only contain printable ASCII characters other than space (! to ~): installimage reads it from setup.conf up to the first whitespace, so spaces, tabs, newlines and other control characters would truncate or break the passphrase.")
+	}
+
+	if config.RescueKeyFPs.IsNull() && !config.UseEphemeralSSHKey.IsUnknown() && !config.UseEphemeralSSHKey.ValueBool() {
+		resp.Diagnostics.AddAttributeError(path.Root("rescue_authorized_key_fingerprints"), "Missing SSH keys",
+			"rescue_authorized_key_fingerprints is required unless use_ephemeral_ssh_key is true.")
+	}
+
+	if config.AutosetupOverride.IsUnknown() {
+		return
+	}
+
+	if config.AutosetupOverride.IsNull() {
+		return
+	}
+
+	conflicting := map[string]attr.Value{
+		"disk_config":     config.DiskConfig,
+		"image_base_path": config.ImageBasePath,
+		"image_checksum":  config.ImageChecksum,
+		"arch":            config.Arch,
+		"raid_level":      config.RaidLevel,
+		"no_uefi":         config.NoUEFI,
+		"filesystem_type": config.FilesystemType,
+	}
+	for name, v := range conflicting {
+		if !v.IsNull() {
+			resp.Diagnostics.AddAttributeError(path.Root(name), "Conflicting autosetup settings",
+				fmt.Sprintf("%s cannot be combined with autosetup_override, which replaces the generated autosetup content entirely.", name))
+		}
+	}
+}
+
 func (r *configurationResource) Configure(_ context.Context, req resource.ConfigureRequest, _ *resource.ConfigureResponse) {
 	if req.ProviderData == nil {
 		return
@@ -151,13 +849,43 @@ func (r *configurationResource) Create(ctx context.Context, req resource.CreateR
 		return
 	}
 
-	fp := mustStringSliceCreate(ctx, resp, plan.RescueKeyFPs)
+	// Unknown values are planned fine, but nothing can be configured without a server
+	if plan.ServerNumber.IsNull() || plan.ServerNumber.IsUnknown() {
+		resp.Diagnostics.AddAttributeError(path.Root("server_number"), "server_number is not yet known", serverNumberUnknownDetail)
+		return
+	}
+
+	fp := tfutil.ElementsAsStrings(ctx, &resp.Diagnostics, plan.RescueKeyFPs)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	r.resolveServerIP(ctx, &resp.Diagnostics, &plan, !plan.ServerIP.IsNull() && !plan.ServerIP.IsUnknown())
+	if resp.Diagnostics.HasError() {
+		return
+	}
 	ip := plan.ServerIP.ValueString()
 
+	// Validate the vSwitch before anything is changed on the server
+	r.resolveVSwitch(ctx, &resp.Diagnostics, &plan)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Without a configured arch, configure detects it in the rescue system
+	if plan.Arch.IsUnknown() {
+		plan.Arch = types.StringNull()
+	}
+
+	if plan.InstallMode.ValueString() == installModeConfigureOnly {
+		// There is no rescue system to fall back on, the installed OS must already accept SSH
+		if err := checkSSHReachable(net.JoinHostPort(ip, "22"), 30*time.Second); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("server_ip"), "Server not reachable over SSH",
+				fmt.Sprintf("install_mode is %q but %s does not accept SSH connections: %v", installModeConfigureOnly, ip, err))
+			return
+		}
+	}
+
 	// Generate hash for computed names
 	version := int64(1) // Default version for new resources
 	if !plan.Version.IsNull() && !plan.Version.IsUnknown() {
@@ -174,75 +902,161 @@ func (r *configurationResource) Create(ctx context.Context, req resource.CreateR
 	serverName, robotName := computeNames(plan.Name.ValueString(), nameHash)
 	plan.ServerName = types.StringValue(serverName)
 	plan.RobotName = types.StringValue(robotName)
-
-	// Automatically assign a private IP
-	localIP, err := r.providerData.GetNextAvailableIP()
-	if err != nil {
-		resp.Diagnostics.AddError("IP assignment failed", err.Error())
+	r.applyRobotNameTemplate(&resp.Diagnostics, &plan)
+	if resp.Diagnostics.HasError() {
 		return
 	}
-	plan.LocalIP = types.StringValue(localIP)
-
-	tflog.Info(ctx, "assigned private IP", map[string]interface{}{
-		"server_number": plan.ServerNumber.ValueInt64(),
-		"local_ip":      localIP,
-	})
-
-	// Set computed robot name in Hetzner Robot interface
-	tflog.Info(ctx, "setting computed server name in Robot interface", map[string]interface{}{
-		"server_number": plan.ServerNumber.ValueInt64(),
-		"robot_name":    plan.RobotName.ValueString(),
-		"server_name":   plan.ServerName.ValueString(),
-	})
 
-	err = r.providerData.Client.SetServerName(int(plan.ServerNumber.ValueInt64()), plan.RobotName.ValueString())
-	if err != nil {
-		resp.Diagnostics.AddError("set server name failed", err.Error())
-		return
+	// Automatically assign a private IP unless one is configured
+	if plan.LocalIP.IsUnknown() {
+		localIP, err := r.providerData.GetNextAvailableIP()
+		if err != nil {
+			resp.Diagnostics.AddError("IP assignment failed", err.Error())
+			return
+		}
+		plan.LocalIP = types.StringValue(localIP)
+	} else {
+		r.providerData.ReserveIP(plan.LocalIP.ValueString())
 	}
-	tflog.Info(ctx, "computed server name set successfully in Robot interface", map[string]interface{}{
+
+	tflog.Info(ctx, "assigned private IP", map[string]interface{}{
 		"server_number": plan.ServerNumber.ValueInt64(),
-		"robot_name":    plan.RobotName.ValueString(),
+		"local_ip":      plan.LocalIP.ValueString(),
 	})
-	//
-	//
-	// Add server to vswitch if provided
-	if !plan.VSwitchID.IsNull() && !plan.VSwitchID.IsUnknown() {
-		serverIP := plan.ServerIP.ValueString()
 
-		tflog.Info(ctx, "adding server to vswitch", map[string]interface{}{
+	if manageRobotName(plan) {
+		// Set computed robot name in Hetzner Robot interface
+		tflog.Info(ctx, "setting computed server name in Robot interface", map[string]interface{}{
 			"server_number": plan.ServerNumber.ValueInt64(),
-			"server_ip":     serverIP,
-			"vswitch_id":    plan.VSwitchID.ValueInt64(),
+			"robot_name":    plan.RobotName.ValueString(),
+			"server_name":   plan.ServerName.ValueString(),
 		})
 
-		err := r.providerData.Client.AddServerToVSwitch(int(plan.VSwitchID.ValueInt64()), serverIP)
+		_, err = retryRateLimited(ctx, "set server name", func() (struct{}, error) {
+			return struct{}{}, r.providerData.Client.SetServerName(int(plan.ServerNumber.ValueInt64()), plan.RobotName.ValueString())
+		})
 		if err != nil {
-			resp.Diagnostics.AddError("add server to vswitch failed", err.Error())
+			resp.Diagnostics.AddError("set server name failed", robotErrorDetail(err, "rename servers", "Server"))
 			return
 		}
-
-		tflog.Info(ctx, "server added to vswitch successfully", map[string]interface{}{
+		r.providerData.CacheManager.InvalidateServer(int(plan.ServerNumber.ValueInt64()))
+		tflog.Info(ctx, "computed server name set successfully in Robot interface", map[string]interface{}{
 			"server_number": plan.ServerNumber.ValueInt64(),
-			"server_ip":     serverIP,
-			"vswitch_id":    plan.VSwitchID.ValueInt64(),
+			"robot_name":    plan.RobotName.ValueString(),
 		})
+	} else {
+		robotName, err := r.currentRobotName(plan.ServerNumber.ValueInt64())
+		if err != nil {
+			resp.Diagnostics.AddError("read server name failed", robotErrorDetail(err, "read servers", "Server"))
+			return
+		}
+		plan.RobotName = robotName
 	}
+	//
+	//
+	// Add server to the vswitches if provided
+	r.syncVSwitches(ctx, &resp.Diagnostics, plan.ServerNumber.ValueInt64(), plan.ServerIP.ValueString(), nil, attachedVSwitchIDs(ctx, plan))
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !plan.Description.IsNull() && !plan.Description.IsUnknown() {
+		r.setDescription(ctx, &resp.Diagnostics, plan)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	r.refreshCancellationDate(ctx, &plan)
+	r.refreshServerDetails(ctx, &plan)
 
 	// Configure
-	err_summary, err_detail := r.configure(fp, ip, plan, ctx)
-	if err_summary != "" {
-		resp.Diagnostics.AddError(err_summary, err_detail)
+	plan.SetupComplete = types.BoolValue(false)
+	if cerr := retryConfigure(ctx, &resp.Diagnostics, func() *ConfigureError { return r.configure(fp, ip, &plan, ctx) }); cerr != nil {
+		resp.Diagnostics.AddError(cerr.Summary, cerr.Detail)
+		keepRescueAccess(ctx, &resp.State, &resp.Diagnostics, plan)
 		return
 	}
+	warnInstalledK3SVersion(ctx, &resp.Diagnostics, plan)
+	plan.SetupComplete = types.BoolValue(true)
+	plan.RescuePassword = types.StringNull()
+	r.refreshRescueActive(ctx, &plan)
+	r.checkVSwitchLocations(ctx, &resp.Diagnostics, plan)
 
 	state := plan
 	state.ID = types.StringValue(fmt.Sprintf("configuration-%d", time.Now().Unix()))
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
 }
 
-func (r *configurationResource) Read(_ context.Context, _ resource.ReadRequest, _ *resource.ReadResponse) {
-	// Configuration is a one-shot action, no state to read
+func (r *configurationResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	// Configuration is a one-shot action; only the server note, the earliest cancellation date
+	// (and the Robot name when it is not managed) are read back from Robot
+	var state configurationModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() || r.providerData == nil {
+		return
+	}
+
+	changed := false
+	if !manageRobotName(state) {
+		if robotName, err := r.currentRobotName(state.ServerNumber.ValueInt64()); err == nil && !robotName.Equal(state.RobotName) {
+			state.RobotName = robotName
+			changed = true
+		}
+	}
+
+	earliest := state.EarliestCancellationDate
+	r.refreshCancellationDate(ctx, &state)
+	changed = changed || !earliest.Equal(state.EarliestCancellationDate)
+
+	product, location, datacenter := state.ServerProduct, state.ServerLocation, state.ServerDatacenter
+	r.refreshServerDetails(ctx, &state)
+	changed = changed || !product.Equal(state.ServerProduct) || !location.Equal(state.ServerLocation) || !datacenter.Equal(state.ServerDatacenter)
+
+	rescueActive := state.RescueActive
+	r.refreshRescueActive(ctx, &state)
+	changed = changed || !rescueActive.Equal(state.RescueActive)
+
+	setupComplete := state.SetupComplete
+	r.refreshSetupComplete(ctx, &state)
+	changed = changed || !setupComplete.Equal(state.SetupComplete)
+
+	if !state.Description.IsNull() {
+		server, err := r.providerData.CacheManager.GetServer(r.providerData.Client, int(state.ServerNumber.ValueInt64()))
+		if err != nil {
+			tflog.Warn(ctx, "failed to read server description", map[string]interface{}{
+				"server_number": state.ServerNumber.ValueInt64(),
+				"error":         err.Error(),
+			})
+		} else if server.Comment != "" && server.Comment != state.Description.ValueString() {
+			// Robot only returns the note where it supports one; keep the configured value otherwise
+			state.Description = types.StringValue(server.Comment)
+			changed = true
+		}
+	}
+
+	if changed {
+		resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+	}
+}
+
+// setDescription stores the description as a note on the server in Robot, downgrading to a
+// warning when Robot doesn't accept server notes
+func (r *configurationResource) setDescription(ctx context.Context, diags *diag.Diagnostics, plan configurationModel) {
+	err := r.providerData.Client.SetServerDescription(int(plan.ServerNumber.ValueInt64()), plan.Description.ValueString())
+	if err == nil {
+		r.providerData.CacheManager.InvalidateServer(int(plan.ServerNumber.ValueInt64()))
+		tflog.Info(ctx, "server description set in Robot interface", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+		})
+		return
+	}
+	if client.IsInvalidInput(err) {
+		diags.AddWarning("Server description not supported",
+			fmt.Sprintf("Robot did not accept a note for server %d, the description is only kept in state: %s", plan.ServerNumber.ValueInt64(), err))
+		return
+	}
+	diags.AddError("set server description failed", robotErrorDetail(err, "edit servers", "Server"))
 }
 
 func (r *configurationResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
@@ -258,11 +1072,49 @@ func (r *configurationResource) Update(ctx context.Context, req resource.UpdateR
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	if plan.Arch.IsUnknown() {
+		plan.Arch = currentState.Arch
+	}
 
-	// Preserve local_ip from current state - it should never change once assigned
-	if !currentState.LocalIP.IsNull() && !currentState.LocalIP.IsUnknown() {
+	// An omitted server_ip is planned from state, look it up again in case Robot moved the server.
+	// The cached server list is fresh enough unless the server is about to be reinstalled
+	var configuredIP types.String
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("server_ip"), &configuredIP)...)
+	reinstall := (!plan.Version.IsNull() && !plan.Version.Equal(currentState.Version)) || !stringMapsEqual(ctx, plan.Triggers, currentState.Triggers) || r.reinstallUnchanged()
+	if reinstall && configuredIP.IsNull() {
+		r.providerData.CacheManager.InvalidateServer(int(plan.ServerNumber.ValueInt64()))
+	}
+	r.resolveServerIP(ctx, &resp.Diagnostics, &plan, !configuredIP.IsNull())
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !plan.Description.IsNull() && !plan.Description.IsUnknown() && !plan.Description.Equal(currentState.Description) {
+		r.setDescription(ctx, &resp.Diagnostics, plan)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	// Preserve local_ip from current state - an assigned one never changes, a configured one only
+	// with a reinstall (checked below)
+	var configuredLocalIP types.String
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("local_ip"), &configuredLocalIP)...)
+	if configuredLocalIP.IsNull() && !currentState.LocalIP.IsNull() && !currentState.LocalIP.IsUnknown() {
 		plan.LocalIP = currentState.LocalIP
 	}
+	if !configuredLocalIP.IsNull() && !configuredLocalIP.IsUnknown() && !configuredLocalIP.Equal(currentState.LocalIP) && !reinstall {
+		resp.Diagnostics.AddAttributeError(path.Root("local_ip"), "local_ip changed without a reinstall",
+			fmt.Sprintf("local_ip is written to the network config when the server is configured, so changing it from %s to %s needs a reinstall: bump version in the same apply.", currentState.LocalIP.ValueString(), configuredLocalIP.ValueString()))
+		return
+	}
+
+	plan.EarliestCancellationDate = currentState.EarliestCancellationDate
+	r.refreshCancellationDate(ctx, &plan)
+	plan.ServerProduct = currentState.ServerProduct
+	plan.ServerLocation = currentState.ServerLocation
+	plan.ServerDatacenter = currentState.ServerDatacenter
+	r.refreshServerDetails(ctx, &plan)
 
 	// Check if name or version changed - if so, regenerate the hash and names
 	nameChanged := !currentState.Name.IsNull() && plan.Name.ValueString() != currentState.Name.ValueString()
@@ -290,15 +1142,33 @@ func (r *configurationResource) Update(ctx context.Context, req resource.UpdateR
 		// Preserve existing computed names if name and version didn't change
 		plan.ServerName = currentState.ServerName
 		plan.RobotName = currentState.RobotName
+		if plan.RobotNameTemplate.IsNull() && !currentState.RobotNameTemplate.IsNull() {
+			// Template removed: back to name-{6-char-id}
+			_, robotName := computeNames(plan.Name.ValueString(), nameHash(plan.ServerName.ValueString(), plan.Name.ValueString()))
+			plan.RobotName = types.StringValue(robotName)
+		}
+	}
+	r.applyRobotNameTemplate(&resp.Diagnostics, &plan)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
-	// Update server name in Robot interface
-	if !plan.RobotName.IsNull() && !plan.RobotName.IsUnknown() {
+	if !manageRobotName(plan) {
+		// Leave the Robot name alone and mirror whatever it currently is
+		robotName, err := r.currentRobotName(plan.ServerNumber.ValueInt64())
+		if err != nil {
+			resp.Diagnostics.AddError("read server name failed", robotErrorDetail(err, "read servers", "Server"))
+			return
+		}
+		plan.RobotName = robotName
+	} else if !plan.RobotName.IsNull() && !plan.RobotName.IsUnknown() {
+		// Update server name in Robot interface
 		err := r.providerData.Client.SetServerName(int(plan.ServerNumber.ValueInt64()), plan.RobotName.ValueString())
 		if err != nil {
-			resp.Diagnostics.AddError("update server name failed", err.Error())
+			resp.Diagnostics.AddError("update server name failed", robotErrorDetail(err, "rename servers", "Server"))
 			return
 		}
+		r.providerData.CacheManager.InvalidateServer(int(plan.ServerNumber.ValueInt64()))
 		tflog.Info(ctx, "updated computed server name in Robot interface", map[string]interface{}{
 			"server_number": plan.ServerNumber.ValueInt64(),
 			"robot_name":    plan.RobotName.ValueString(),
@@ -306,30 +1176,47 @@ func (r *configurationResource) Update(ctx context.Context, req resource.UpdateR
 		})
 	}
 
-	// Check if vswitch changed and update it
-	if !plan.VSwitchID.IsNull() && !plan.VSwitchID.IsUnknown() {
-		// Get current server IP from state
-		var state configurationModel
-		resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	r.resolveVSwitch(ctx, &resp.Diagnostics, &plan)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Check if the vswitches changed and update them, using the server IP from state
+	if !currentState.ServerIP.IsNull() && !currentState.ServerIP.IsUnknown() {
+		r.syncVSwitches(ctx, &resp.Diagnostics, plan.ServerNumber.ValueInt64(), currentState.ServerIP.ValueString(),
+			attachedVSwitchIDs(ctx, currentState), attachedVSwitchIDs(ctx, plan))
 		if resp.Diagnostics.HasError() {
 			return
 		}
+	}
 
-		if !state.ServerIP.IsNull() && !state.ServerIP.IsUnknown() {
-			err := r.providerData.Client.AddServerToVSwitch(int(plan.VSwitchID.ValueInt64()), state.ServerIP.ValueString())
-			if err != nil {
-				resp.Diagnostics.AddError("update server vswitch failed", err.Error())
-				return
-			}
-			tflog.Info(ctx, "updated server vswitch", map[string]interface{}{
-				"server_number": plan.ServerNumber.ValueInt64(),
-				"server_ip":     state.ServerIP.ValueString(),
-				"vswitch_id":    plan.VSwitchID.ValueInt64(),
-			})
-		}
+	triggersChanged := !stringMapsEqual(ctx, plan.Triggers, currentState.Triggers)
+	if triggersChanged {
+		tflog.Info(ctx, "triggers changed, reconfiguring server", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+		})
+	}
+	resumeSetup := setupIncomplete(currentState)
+	if resumeSetup {
+		tflog.Info(ctx, "previous setup did not complete, reconfiguring server", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+		})
+	}
+	reinstallUnchanged := r.reinstallUnchanged()
+	if reinstallUnchanged {
+		tflog.Warn(ctx, "allow_reinstall_without_version_change is set, reinstalling server", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+		})
 	}
 
-	if !plan.Version.IsNull() && !plan.Version.IsUnknown() {
+	// A changed key list is written to the installed OS over SSH instead of reinstalling
+	if rotateKeysInPlace(plan, currentState, versionChanged || triggersChanged || resumeSetup || reinstallUnchanged) {
+		summary, detail := r.rotateAuthorizedKeys(ctx, tfutil.ElementsAsStrings(ctx, &resp.Diagnostics, plan.RescueKeyFPs), plan)
+		if summary != "" {
+			resp.Diagnostics.AddError(summary, detail)
+			return
+		}
+	} else if versionChanged || triggersChanged || resumeSetup || reinstallUnchanged {
 		// Get current state to preserve or release IP
 		var versionCurrentState configurationModel
 		resp.Diagnostics.Append(req.State.Get(ctx, &versionCurrentState)...)
@@ -338,7 +1225,9 @@ func (r *configurationResource) Update(ctx context.Context, req resource.UpdateR
 		}
 
 		// Preserve the existing IP assignment for version changes
-		if !versionCurrentState.LocalIP.IsNull() && !versionCurrentState.LocalIP.IsUnknown() && versionCurrentState.LocalIP.ValueString() != "" {
+		if !configuredLocalIP.IsNull() {
+			r.providerData.ReserveIP(plan.LocalIP.ValueString())
+		} else if !versionCurrentState.LocalIP.IsNull() && !versionCurrentState.LocalIP.IsUnknown() && versionCurrentState.LocalIP.ValueString() != "" {
 			plan.LocalIP = versionCurrentState.LocalIP
 		} else {
 			// Assign new IP if none exists
@@ -350,14 +1239,24 @@ func (r *configurationResource) Update(ctx context.Context, req resource.UpdateR
 			plan.LocalIP = types.StringValue(localIP)
 		}
 
-		summary, err_detail := r.configure(mustStringSliceUpdate(ctx, resp, plan.RescueKeyFPs), plan.ServerIP.ValueString(), plan, ctx)
-		if summary != "" {
-			resp.Diagnostics.AddError(summary, err_detail)
+		fp := tfutil.ElementsAsStrings(ctx, &resp.Diagnostics, plan.RescueKeyFPs)
+		configure := r.sshSteps().configure
+		if cerr := retryConfigure(ctx, &resp.Diagnostics, func() *ConfigureError { return configure(fp, plan.ServerIP.ValueString(), &plan, ctx) }); cerr != nil {
+			resp.Diagnostics.AddError(cerr.Summary, cerr.Detail)
+			resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("setup_complete"), types.BoolValue(false))...)
+			keepRescueAccess(ctx, &resp.State, &resp.Diagnostics, plan)
 			return
 		}
-		tflog.Info(ctx, "reconfigured server due to version change", map[string]interface{}{
-			"server_number": plan.ServerNumber.ValueInt64(),
-			"version":       plan.Version.ValueInt64(),
+		warnInstalledK3SVersion(ctx, &resp.Diagnostics, plan)
+		plan.SetupComplete = types.BoolValue(true)
+		plan.RescuePassword = types.StringNull()
+		r.refreshRescueActive(ctx, &plan)
+		tflog.Info(ctx, "reconfigured server due to version or trigger change", map[string]interface{}{
+			"server_number":    plan.ServerNumber.ValueInt64(),
+			"version":          plan.Version.ValueInt64(),
+			"triggers_changed": triggersChanged,
+			"resumed_setup":    resumeSetup,
+			"reinstall":        reinstallUnchanged,
 		})
 
 		// Update state with the new plan values, preserving ID from current state
@@ -373,9 +1272,74 @@ func (r *configurationResource) Update(ctx context.Context, req resource.UpdateR
 		return
 	}
 
+	// The ARP keepalive service is rewritten in place, no reinstall needed
+	if arpKeepaliveData(ctx, plan) != arpKeepaliveData(ctx, currentState) && !plan.LocalIP.IsNull() {
+		summary, detail := r.sshSteps().keepalive(ctx, plan)
+		if summary != "" {
+			resp.Diagnostics.AddError(summary, detail)
+			return
+		}
+	}
+
+	if forceRebootRequested(plan, currentState) {
+		summary, detail := r.sshSteps().reboot(ctx, plan)
+		if summary != "" {
+			resp.Diagnostics.AddError(summary, detail)
+			return
+		}
+	}
+
 	// For other changes that don't require reconfiguration, update the state, preserving ID
 	state := plan
 	state.ID = currentState.ID // Preserve existing ID
+	state.Outputs = currentState.Outputs
+	if state.Outputs.IsNull() || state.Outputs.IsUnknown() {
+		// States written before outputs existed have no value to carry over
+		state.Outputs = types.MapValueMust(types.StringType, map[string]attr.Value{})
+	}
+	state.DetectedDrives = currentState.DetectedDrives
+	if state.DetectedDrives.IsNull() || state.DetectedDrives.IsUnknown() {
+		state.DetectedDrives = types.ListValueMust(types.ObjectType{AttrTypes: detectedDriveAttrTypes}, []attr.Value{})
+	}
+	state.RescuePassword = currentState.RescuePassword
+	if state.RescuePassword.IsUnknown() {
+		state.RescuePassword = types.StringNull()
+	}
+	state.RescueActive = currentState.RescueActive
+	if state.RescueActive.IsUnknown() {
+		state.RescueActive = types.BoolNull()
+	}
+	state.SetupComplete = currentState.SetupComplete
+	state.LUKSAutoUnlockVerified = currentState.LUKSAutoUnlockVerified
+	if state.LUKSAutoUnlockVerified.IsUnknown() {
+		state.LUKSAutoUnlockVerified = types.BoolNull()
+	}
+	state.BootSeconds = currentState.BootSeconds
+	if state.BootSeconds.IsUnknown() {
+		state.BootSeconds = types.Int64Null()
+	}
+	state.ServicesHealthy = currentState.ServicesHealthy
+	if state.ServicesHealthy.IsUnknown() {
+		state.ServicesHealthy = types.BoolNull()
+	}
+	state.InstalledK3SVersion = currentState.InstalledK3SVersion
+	if state.InstalledK3SVersion.IsUnknown() {
+		state.InstalledK3SVersion = types.StringNull()
+	}
+	state.CPUModel = currentState.CPUModel
+	state.MemoryGB = currentState.MemoryGB
+	state.VLANInterfaceComputed = currentState.VLANInterfaceComputed
+	if state.VLANInterfaceComputed.IsUnknown() {
+		state.VLANInterfaceComputed = types.StringNull()
+	}
+	state.NetworkInterfaceComputed = currentState.NetworkInterfaceComputed
+	if state.NetworkInterfaceComputed.IsUnknown() {
+		state.NetworkInterfaceComputed = types.StringNull()
+	}
+	state.NICNames = currentState.NICNames
+	if state.NICNames.IsNull() || state.NICNames.IsUnknown() {
+		state.NICNames = types.ListValueMust(types.StringType, []attr.Value{})
+	}
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
 
 	// Note: Some changes may require recreation (taint/recreate)
@@ -384,6 +1348,194 @@ func (r *configurationResource) Update(ctx context.Context, req resource.UpdateR
 	}
 }
 
+// cancelServer cancels the server in Robot on cancelDate, or on the earliest cancellation date
+// when cancelDate is empty. A server that is already cancelled is left as is.
+func (r *configurationResource) cancelServer(ctx context.Context, diags *diag.Diagnostics, serverNumber int, cancelDate string) {
+	if cancelDate == "" {
+		cancellation, err := r.providerData.Client.GetCancellation(serverNumber)
+		if err != nil {
+			diags.AddError("get cancellation failed", robotErrorDetail(err, "cancel servers", "Server"))
+			return
+		}
+		if cancellation.Cancelled {
+			diags.AddWarning("Server already cancelled",
+				fmt.Sprintf("Server %d was already cancelled in Robot effective %s.", serverNumber, cancellation.CancellationDate))
+			return
+		}
+		cancelDate = cancellation.EarliestCancellationDate
+	}
+
+	if err := r.providerData.Client.CancelServer(serverNumber, cancelDate); err != nil {
+		diags.AddError("cancel server failed", robotErrorDetail(err, "cancel servers", "Server"))
+		return
+	}
+	r.providerData.CacheManager.Invalidate()
+	tflog.Info(ctx, "server cancelled in Robot", map[string]interface{}{
+		"server_number":     serverNumber,
+		"cancellation_date": cancelDate,
+	})
+	diags.AddWarning("Server cancelled",
+		fmt.Sprintf("Server %d has been cancelled in Robot effective %s. The cancellation can be withdrawn in the Robot interface until then.", serverNumber, cancelDate))
+}
+
+// refreshCancellationDate sets earliest_cancellation_date from Robot. The value is informational,
+// so a failed lookup keeps the previous value (null on create) instead of failing the apply.
+func (r *configurationResource) refreshCancellationDate(ctx context.Context, m *configurationModel) {
+	if m.EarliestCancellationDate.IsUnknown() {
+		m.EarliestCancellationDate = types.StringNull()
+	}
+	cancellation, err := r.providerData.Client.GetCancellation(int(m.ServerNumber.ValueInt64()))
+	if err != nil {
+		tflog.Warn(ctx, "failed to read earliest cancellation date", map[string]interface{}{
+			"server_number": m.ServerNumber.ValueInt64(),
+			"error":         err.Error(),
+		})
+		return
+	}
+	m.EarliestCancellationDate = types.StringValue(cancellation.EarliestCancellationDate)
+}
+
+// resolveServerIP takes server_ip from Robot when it is not configured, and otherwise checks that
+// it is the server's address in Robot so the provider doesn't SSH into another machine
+func (r *configurationResource) resolveServerIP(ctx context.Context, diags *diag.Diagnostics, plan *configurationModel, configured bool) {
+	server, err := r.providerData.CacheManager.GetServer(r.providerData.Client, int(plan.ServerNumber.ValueInt64()))
+	if err != nil {
+		if !configured {
+			diags.AddAttributeError(path.Root("server_ip"), "server_ip lookup failed",
+				fmt.Sprintf("server_ip is not set and could not be read from Robot: %s", robotErrorDetail(err, "read servers", "Server")))
+			return
+		}
+		tflog.Warn(ctx, "failed to read server to check server_ip", map[string]interface{}{
+			"server_number": plan.ServerNumber.ValueInt64(),
+			"error":         err.Error(),
+		})
+		return
+	}
+
+	if !configured {
+		if server.ServerIP == "" {
+			diags.AddAttributeError(path.Root("server_ip"), "server_ip lookup failed",
+				fmt.Sprintf("Robot reports no IP address for server %d yet; set server_ip or wait until the server is ready.", plan.ServerNumber.ValueInt64()))
+			return
+		}
+		if !plan.ServerIP.IsNull() && !plan.ServerIP.IsUnknown() && plan.ServerIP.ValueString() != server.ServerIP {
+			tflog.Warn(ctx, "server IP changed in Robot", map[string]interface{}{
+				"server_number": plan.ServerNumber.ValueInt64(),
+				"previous_ip":   plan.ServerIP.ValueString(),
+				"server_ip":     server.ServerIP,
+			})
+		}
+		plan.ServerIP = types.StringValue(server.ServerIP)
+		return
+	}
+
+	ip := plan.ServerIP.ValueString()
+	if ip == server.ServerIP {
+		return
+	}
+	diags.AddAttributeError(path.Root("server_ip"), "server_ip does not match Robot",
+		fmt.Sprintf("server_ip is %s, but Robot reports %s for server %d. The server may have been moved to another address; update server_ip, or omit it to use the address from Robot.",
+			ip, server.ServerIP, plan.ServerNumber.ValueInt64()))
+}
+
+// refreshServerDetails sets server_product, server_location and server_datacenter from Robot. Like
+// earliest_cancellation_date they are informational, so a failed lookup keeps the previous values
+func (r *configurationResource) refreshServerDetails(ctx context.Context, m *configurationModel) {
+	if m.ServerProduct.IsUnknown() {
+		m.ServerProduct = types.StringNull()
+	}
+	if m.ServerLocation.IsUnknown() {
+		m.ServerLocation = types.StringNull()
+	}
+	if m.ServerDatacenter.IsUnknown() {
+		m.ServerDatacenter = types.StringNull()
+	}
+	server, err := r.providerData.CacheManager.GetServer(r.providerData.Client, int(m.ServerNumber.ValueInt64()))
+	if err != nil {
+		tflog.Warn(ctx, "failed to read server details", map[string]interface{}{
+			"server_number": m.ServerNumber.ValueInt64(),
+			"error":         err.Error(),
+		})
+		return
+	}
+	if server.Product != "" {
+		m.ServerProduct = types.StringValue(server.Product)
+	}
+	if server.Location != "" {
+		m.ServerLocation = types.StringValue(server.Location)
+	}
+	if server.DC != "" {
+		m.ServerDatacenter = types.StringValue(server.DC)
+	}
+}
+
+// refreshRescueActive sets rescue_active from Robot, leaving it as it was when Robot can't be read
+func (r *configurationResource) refreshRescueActive(ctx context.Context, m *configurationModel) {
+	if m.RescueActive.IsUnknown() {
+		m.RescueActive = types.BoolNull()
+	}
+	rescue, err := r.providerData.Client.GetRescue(int(m.ServerNumber.ValueInt64()))
+	if err != nil {
+		tflog.Warn(ctx, "failed to read rescue status", map[string]interface{}{
+			"server_number": m.ServerNumber.ValueInt64(),
+			"error":         err.Error(),
+		})
+		return
+	}
+	m.RescueActive = types.BoolValue(rescue.Active)
+}
+
+// keepRescueAccess saves the rescue password of a failed configuration in state, so the operator
+// can log in to the rescue system and inspect the server. A failed create is saved too, as a
+// tainted resource; a failed update keeps the previous state with the password added
+func keepRescueAccess(ctx context.Context, state *tfsdk.State, diags *diag.Diagnostics, plan configurationModel) {
+	if plan.RescuePassword.IsNull() || plan.RescuePassword.IsUnknown() {
+		return
+	}
+	if state.Raw.IsNull() {
+		plan.ID = types.StringValue(fmt.Sprintf("configuration-%d", time.Now().Unix()))
+		diags.Append(state.Set(ctx, &plan)...)
+		// Whatever the failed run didn't get to compute is stored as null
+		raw, err := tftypes.Transform(state.Raw, func(_ *tftypes.AttributePath, v tftypes.Value) (tftypes.Value, error) {
+			if !v.IsKnown() {
+				return tftypes.NewValue(v.Type(), nil), nil
+			}
+			return v, nil
+		})
+		if err != nil {
+			diags.AddError("save rescue password failed", err.Error())
+			return
+		}
+		state.Raw = raw
+	} else {
+		diags.Append(state.SetAttribute(ctx, path.Root("rescue_password"), plan.RescuePassword)...)
+	}
+	diags.Append(state.SetAttribute(ctx, path.Root("rescue_active"), types.BoolValue(true))...)
+	diags.AddWarning("Rescue system left active",
+		"The configuration failed after the rescue system was activated. Log in as root with the password in rescue_password (e.g. terraform state show) to inspect the server.")
+}
+
+// stringMapsEqual compares two string maps, treating null (e.g. states written before the
+// attribute existed) the same as empty so upgrading the provider doesn't trigger a reinstall.
+func stringMapsEqual(ctx context.Context, a, b types.Map) bool {
+	var am, bm map[string]string
+	if !a.IsNull() && !a.IsUnknown() {
+		a.ElementsAs(ctx, &am, false)
+	}
+	if !b.IsNull() && !b.IsUnknown() {
+		b.ElementsAs(ctx, &bm, false)
+	}
+	if len(am) != len(bm) {
+		return false
+	}
+	for k, v := range am {
+		if bv, ok := bm[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
 func (r *configurationResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
 	var state configurationModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
@@ -400,12 +1552,44 @@ func (r *configurationResource) Delete(ctx context.Context, req resource.DeleteR
 		})
 	}
 
-	// If we have a server number, schedule cancellation at the end of billing period
 	if !state.ServerNumber.IsNull() && !state.ServerNumber.IsUnknown() {
 		serverNumber := int(state.ServerNumber.ValueInt64())
 
-		r.providerData.Client.SetServerName(serverNumber, "cancelled")
+		if destroyBehavior(state) != destroyBehaviorNone && !state.ServerIP.IsNull() {
+			// Detach from every vSwitch first so Robot doesn't keep the server on them
+			r.syncVSwitches(ctx, &resp.Diagnostics, state.ServerNumber.ValueInt64(), state.ServerIP.ValueString(), attachedVSwitchIDs(ctx, state), nil)
+			if resp.Diagnostics.HasError() {
+				return
+			}
+		}
 
+		switch destroyBehavior(state) {
+		case destroyBehaviorCancel:
+			r.cancelServer(ctx, &resp.Diagnostics, serverNumber, state.CancellationDate.ValueString())
+			if resp.Diagnostics.HasError() {
+				return
+			}
+		case destroyBehaviorRename:
+			if !manageRobotName(state) {
+				resp.Diagnostics.AddWarning("Server left untouched",
+					fmt.Sprintf("Server %d has been removed from Terraform state only and not renamed, as manage_robot_name is false; it keeps running and billing in Robot.", serverNumber))
+				break
+			}
+			if err := r.providerData.Client.SetServerName(serverNumber, "cancelled"); err != nil {
+				tflog.Warn(ctx, "failed to rename server on destroy", map[string]interface{}{
+					"server_number": serverNumber,
+					"error":         err.Error(),
+				})
+				resp.Diagnostics.AddWarning("Server rename failed",
+					fmt.Sprintf("Server %d has been removed from Terraform state, but renaming it to \"cancelled\" in Robot failed: %s. It keeps running and billing; cancel it manually in the Robot interface.", serverNumber, err))
+				break
+			}
+			resp.Diagnostics.AddWarning("Server renamed, not cancelled",
+				fmt.Sprintf("Server %d has only been renamed to \"cancelled\" in Robot and keeps running and billing. Cancel it manually in the Robot interface, or set destroy_behavior = \"cancel\".", serverNumber))
+		default:
+			resp.Diagnostics.AddWarning("Server left untouched",
+				fmt.Sprintf("Server %d has been removed from Terraform state only; it keeps running and billing in Robot.", serverNumber))
+		}
 	} else {
 		// No server number available, just remove from state
 		tflog.Info(ctx, "Removing configuration from state (no server number available)")
@@ -0,0 +1,590 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestStringMapsEqual(t *testing.T) {
+	ctx := context.Background()
+	mk := func(m map[string]string) types.Map {
+		v, diags := types.MapValueFrom(ctx, types.StringType, m)
+		if diags.HasError() {
+			t.Fatalf("build map: %v", diags)
+		}
+		return v
+	}
+
+	null := types.MapNull(types.StringType)
+	if !stringMapsEqual(ctx, null, mk(map[string]string{})) {
+		t.Fatalf("null and empty triggers must compare equal")
+	}
+	if !stringMapsEqual(ctx, mk(map[string]string{"token": "a"}), mk(map[string]string{"token": "a"})) {
+		t.Fatalf("identical triggers must compare equal")
+	}
+	if stringMapsEqual(ctx, mk(map[string]string{"token": "a"}), mk(map[string]string{"token": "b"})) {
+		t.Fatalf("changed trigger value must be detected")
+	}
+	if stringMapsEqual(ctx, null, mk(map[string]string{"token": "a"})) {
+		t.Fatalf("added trigger must be detected")
+	}
+}
+
+func TestConfigurationReplaceAttributes(t *testing.T) {
+	var resp resource.SchemaResponse
+	(&configurationResource{}).Schema(context.Background(), resource.SchemaRequest{}, &resp)
+
+	requiresReplace := func(mods []string) bool {
+		for _, m := range mods {
+			if strings.Contains(m, "destroy and recreate") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for name, attribute := range resp.Schema.Attributes {
+		var mods []string
+		switch a := attribute.(type) {
+		case rschema.Int64Attribute:
+			for _, m := range a.PlanModifiers {
+				mods = append(mods, m.Description(context.Background()))
+			}
+		case rschema.StringAttribute:
+			for _, m := range a.PlanModifiers {
+				mods = append(mods, m.Description(context.Background()))
+			}
+		}
+		want := name == "server_number" || name == "arch" || name == "cryptpassword"
+		if got := requiresReplace(mods); got != want {
+			t.Errorf("%s: requires replace = %v, want %v (%v)", name, got, want, mods)
+		}
+	}
+}
+
+func TestRenderRobotName(t *testing.T) {
+	data := RobotNameTemplateData{Name: "worker-3", Hash: "a1b2c3", Location: "FSN1"}
+	tests := []struct {
+		tmpl    string
+		want    string
+		wantErr string
+	}{
+		{tmpl: "{{.Name}}-{{.Hash}}", want: "worker-3-a1b2c3"},
+		{tmpl: "prod-k3s-{{.Name}}-{{lower .Location}}", want: "prod-k3s-worker-3-fsn1"},
+		{tmpl: "{{upper .Name}}", want: "WORKER-3"},
+		{tmpl: "static-name", want: "static-name"},
+		{tmpl: "{{.Name}}-{{.Datacenter}}", wantErr: "Datacenter"},
+		{tmpl: "{{.name}}", wantErr: "name"},
+		{tmpl: "{{.Name", wantErr: "unclosed action"},
+		{tmpl: "{{nosuchfunc .Name}}", wantErr: "nosuchfunc"},
+		{tmpl: "  ", wantErr: "empty name"},
+	}
+	for _, tt := range tests {
+		got, err := renderRobotName(tt.tmpl, data)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: expected error containing %q, got %q, %v", tt.tmpl, tt.wantErr, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q: expected %q, got %q, %v", tt.tmpl, tt.want, got, err)
+		}
+	}
+}
+
+func TestNameHash(t *testing.T) {
+	if got := nameHash("web-01-a1b2c3", "web-01"); got != "a1b2c3" {
+		t.Fatalf("expected a1b2c3, got %q", got)
+	}
+}
+
+func TestCancelServerUsesEarliestDate(t *testing.T) {
+	cancelled := false
+	var posted []string
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/server/111/cancellation" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodPost {
+			r.ParseForm()
+			posted = append(posted, r.PostForm.Get("cancellation_date"))
+			cancelled = true
+		}
+		fmt.Fprintf(w, `{"cancellation":{"server_number":111,"earliest_cancellation_date":"2026-11-30","cancelled":%t,"cancellation_date":"2026-11-30"}}`, cancelled)
+	})
+	res := &configurationResource{providerData: pd}
+
+	var diags diag.Diagnostics
+	res.cancelServer(context.Background(), &diags, 111, "")
+	if diags.HasError() {
+		t.Fatalf("cancelServer: %v", diags)
+	}
+	if len(posted) != 1 || posted[0] != "2026-11-30" {
+		t.Fatalf("expected one cancellation on the earliest date, got %v", posted)
+	}
+	if len(diags.Warnings()) != 1 || !strings.Contains(diags.Warnings()[0].Detail(), "2026-11-30") {
+		t.Fatalf("expected a warning with the cancellation date, got %v", diags)
+	}
+
+	// Destroying again must not cancel twice
+	diags = nil
+	res.cancelServer(context.Background(), &diags, 111, "")
+	if diags.HasError() || len(posted) != 1 || diags.Warnings()[0].Summary() != "Server already cancelled" {
+		t.Fatalf("expected the existing cancellation to be kept, got %v (posts %v)", diags, posted)
+	}
+
+	m := configurationModel{ServerNumber: types.Int64Value(111), EarliestCancellationDate: types.StringUnknown()}
+	res.refreshCancellationDate(context.Background(), &m)
+	if m.EarliestCancellationDate.ValueString() != "2026-11-30" {
+		t.Fatalf("unexpected earliest_cancellation_date: %v", m.EarliestCancellationDate)
+	}
+	m = configurationModel{ServerNumber: types.Int64Value(222), EarliestCancellationDate: types.StringUnknown()}
+	res.refreshCancellationDate(context.Background(), &m)
+	if !m.EarliestCancellationDate.IsNull() {
+		t.Fatalf("expected a failed lookup to leave earliest_cancellation_date null, got %v", m.EarliestCancellationDate)
+	}
+}
+
+func TestDeleteWarnings(t *testing.T) {
+	renameFails := false
+	var renames int
+	res := &configurationResource{providerData: testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/server/111" {
+			renames++
+			if renameFails {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"error":{"status":403,"code":"NOT_ALLOWED","message":"not allowed"}}`))
+				return
+			}
+		}
+		testServer111(w, r)
+	})}
+	schema, _ := configurationType(t)
+	del := func(attrs map[string]tftypes.Value) diag.Diagnostics {
+		t.Helper()
+		state := tfsdk.State{Schema: schema, Raw: configurationRaw(t, attrs)}
+		resp := resource.DeleteResponse{State: state}
+		res.Delete(context.Background(), resource.DeleteRequest{State: state}, &resp)
+		return resp.Diagnostics
+	}
+
+	for _, tc := range []struct {
+		name        string
+		attrs       map[string]tftypes.Value
+		renameFails bool
+		wantRenames int
+		want        string
+	}{
+		{"rename", nil, false, 1, "Server renamed, not cancelled"},
+		{"rename fails", nil, true, 1, "Server rename failed"},
+		{"name not managed", map[string]tftypes.Value{"manage_robot_name": tftypes.NewValue(tftypes.Bool, false)}, false, 0, "Server left untouched"},
+	} {
+		renames, renameFails = 0, tc.renameFails
+		diags := del(tc.attrs)
+		if diags.HasError() || len(diags) != 1 || diags[0].Summary() != tc.want || renames != tc.wantRenames {
+			t.Errorf("%s: expected %q after %d renames, got %d renames: %v", tc.name, tc.want, tc.wantRenames, renames, diags)
+		}
+	}
+}
+
+func TestConfigureOnlyRequiresServerIP(t *testing.T) {
+	ctx := context.Background()
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	validate := func(serverIP string) diag.Diagnostics {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["server_number"] = tftypes.NewValue(tftypes.Number, 111)
+		vals["server_ip"] = tftypes.NewValue(tftypes.String, serverIP)
+		vals["name"] = tftypes.NewValue(tftypes.String, "web")
+		vals["install_mode"] = tftypes.NewValue(tftypes.String, installModeConfigureOnly)
+		req := resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}
+		var resp resource.ValidateConfigResponse
+		res.ValidateConfig(ctx, req, &resp)
+		return resp.Diagnostics
+	}
+
+	if diags := validate("1.2.3.4"); diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+	diags := validate("web-01.example.com;")
+	if !diags.HasError() || diags.Errors()[0].Summary() != "Invalid server_ip" {
+		t.Fatalf("expected an invalid server_ip error, got %v", diags)
+	}
+}
+
+func TestVersionPlanModifier(t *testing.T) {
+	ctx := context.Background()
+	modify := func(state, plan types.Int64) diag.Diagnostics {
+		req := planmodifier.Int64Request{Path: path.Root("version"), StateValue: state, PlanValue: plan}
+		var resp planmodifier.Int64Response
+		versionPlanModifier{}.PlanModifyInt64(ctx, req, &resp)
+		return resp.Diagnostics
+	}
+
+	diags := modify(types.Int64Value(3), types.Int64Value(2))
+	if !diags.HasError() || !strings.Contains(diags[0].Detail(), "version may only increase; to avoid reinstall, keep the current value") {
+		t.Fatalf("expected a decrease from 3 to 2 to be rejected, got %v", diags)
+	}
+	for _, c := range [][2]types.Int64{
+		{types.Int64Value(3), types.Int64Value(4)},
+		{types.Int64Value(3), types.Int64Value(3)},
+		{types.Int64Null(), types.Int64Value(1)},
+		{types.Int64Value(3), types.Int64Null()},
+		{types.Int64Value(3), types.Int64Unknown()},
+	} {
+		if diags := modify(c[0], c[1]); diags.HasError() {
+			t.Errorf("%s -> %s: unexpected error %v", c[0], c[1], diags)
+		}
+	}
+}
+
+func TestRefreshServerDetails(t *testing.T) {
+	fail := false
+	var requests []string
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if fail || r.URL.Path != "/server" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+			{"server":{"server_number":123,"status":"ready","product":"EX101"}},
+			{"server":{"server_number":124,"status":"ready","product":"AX41"}},
+			{"server":{"server_number":125,"status":"ready","product":"AX52"}}
+		]`))
+	})
+	res := &configurationResource{providerData: pd}
+	ctx := context.Background()
+
+	// Refreshing several servers reads the server list once
+	want := map[int64]string{123: "EX101", 124: "AX41", 125: "AX52"}
+	for number, product := range want {
+		m := configurationModel{ServerNumber: types.Int64Value(number), ServerProduct: types.StringUnknown()}
+		res.refreshServerDetails(ctx, &m)
+		if m.ServerProduct.ValueString() != product {
+			t.Fatalf("server %d: expected %s, got %s", number, product, m.ServerProduct)
+		}
+	}
+	if len(requests) != 1 || requests[0] != "/server" {
+		t.Fatalf("expected a single /server call, got %v", requests)
+	}
+
+	// A failed lookup keeps the known value, and never leaves it unknown
+	fail = true
+	pd.CacheManager.Invalidate()
+	m := configurationModel{ServerNumber: types.Int64Value(123), ServerProduct: types.StringValue("EX101")}
+	res.refreshServerDetails(ctx, &m)
+	if m.ServerProduct.ValueString() != "EX101" {
+		t.Fatalf("expected the previous product to be kept, got %s", m.ServerProduct)
+	}
+	m.ServerProduct = types.StringUnknown()
+	res.refreshServerDetails(ctx, &m)
+	if !m.ServerProduct.IsNull() {
+		t.Fatalf("expected null after a failed lookup, got %s", m.ServerProduct)
+	}
+}
+
+func TestReadDescriptionUsesServerCache(t *testing.T) {
+	comment := "cached note"
+	var ssh testSSHSteps
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		server := fmt.Sprintf(`{"server":{"server_number":111,"server_ip":"1.2.3.4","server_name":"web-a1b2c3","status":"ready","comment":%q}}`, comment)
+		switch r.URL.Path {
+		case "/server":
+			_, _ = w.Write([]byte("[" + server + "]"))
+		case "/server/111":
+			_, _ = w.Write([]byte(server))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	res := &configurationResource{providerData: pd, ssh: ssh.steps()}
+	if _, err := pd.CacheManager.GetServer(pd.Client, 111); err != nil {
+		t.Fatal(err)
+	}
+	comment = "changed since"
+
+	schema, _ := configurationType(t)
+	state := tfsdk.State{Schema: schema, Raw: configurationRaw(t, map[string]tftypes.Value{"description": tftypes.NewValue(tftypes.String, "old note")})}
+	resp := resource.ReadResponse{State: state}
+	res.Read(context.Background(), resource.ReadRequest{State: state}, &resp)
+	var description types.String
+	resp.State.GetAttribute(context.Background(), path.Root("description"), &description)
+	if resp.Diagnostics.HasError() || description.ValueString() != "cached note" {
+		t.Fatalf("expected the description from the server cache, got %s: %v", description, resp.Diagnostics)
+	}
+}
+
+func TestResolveServerIP(t *testing.T) {
+	fail := false
+	ip := "5.6.7.8"
+	var requests []string
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		server := fmt.Sprintf(`{"server":{"server_number":123,"server_ip":%q,"ip":[%[1]q],"status":"ready"}}`, ip)
+		switch {
+		case fail:
+			http.NotFound(w, r)
+		case r.URL.Path == "/server":
+			_, _ = w.Write([]byte("[" + server + "]"))
+		case r.URL.Path == "/server/123":
+			_, _ = w.Write([]byte(server))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	res := &configurationResource{providerData: pd}
+	ctx := context.Background()
+
+	// Omitted: taken from Robot, also replacing an address planned from state
+	for _, planned := range []types.String{types.StringUnknown(), types.StringValue("1.2.3.4")} {
+		var diags diag.Diagnostics
+		m := configurationModel{ServerNumber: types.Int64Value(123), ServerIP: planned}
+		res.resolveServerIP(ctx, &diags, &m, false)
+		if diags.HasError() || m.ServerIP.ValueString() != "5.6.7.8" {
+			t.Fatalf("expected the Robot address, got %s: %v", m.ServerIP, diags)
+		}
+	}
+
+	// Configured: must match
+	var diags diag.Diagnostics
+	m := configurationModel{ServerNumber: types.Int64Value(123), ServerIP: types.StringValue("5.6.7.8")}
+	res.resolveServerIP(ctx, &diags, &m, true)
+	if diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+	m.ServerIP = types.StringValue("1.2.3.4")
+	res.resolveServerIP(ctx, &diags, &m, true)
+	if !diags.HasError() || diags.Errors()[0].Summary() != "server_ip does not match Robot" ||
+		!strings.Contains(diags.Errors()[0].Detail(), "server_ip is 1.2.3.4, but Robot reports 5.6.7.8") {
+		t.Fatalf("expected a mismatch error, got %v", diags)
+	}
+
+	if strings.Join(requests, ",") != "/server" {
+		t.Fatalf("expected the lookups to share one /server call, got %v", requests)
+	}
+
+	// An invalidated server, e.g. before a reinstall, is read again
+	ip = "9.9.9.9"
+	pd.CacheManager.InvalidateServer(123)
+	m.ServerIP = types.StringUnknown()
+	res.resolveServerIP(ctx, &diags, &m, false)
+	if m.ServerIP.ValueString() != "9.9.9.9" || strings.Join(requests, ",") != "/server,/server/123" {
+		t.Fatalf("expected the server to be read again, got %s after %v", m.ServerIP, requests)
+	}
+
+	// A failed lookup only blocks when there is no address to fall back on
+	fail = true
+	pd.CacheManager.Invalidate()
+	m.ServerIP = types.StringValue("1.2.3.4")
+	diags = nil
+	res.resolveServerIP(ctx, &diags, &m, true)
+	if diags.HasError() || m.ServerIP.ValueString() != "1.2.3.4" {
+		t.Fatalf("expected the configured address to be kept, got %s: %v", m.ServerIP, diags)
+	}
+	m.ServerIP = types.StringUnknown()
+	res.resolveServerIP(ctx, &diags, &m, false)
+	if !diags.HasError() || diags.Errors()[0].Summary() != "server_ip lookup failed" {
+		t.Fatalf("expected a lookup error, got %v", diags)
+	}
+}
+
+func TestServerNumberNotYetKnown(t *testing.T) {
+	ctx := context.Background()
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	validate := func(serverNumber, serverIP tftypes.Value) diag.Diagnostics {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["server_number"] = serverNumber
+		vals["server_ip"] = serverIP
+		vals["name"] = tftypes.NewValue(tftypes.String, "web")
+		vals["install_mode"] = tftypes.NewValue(tftypes.String, installModeConfigureOnly)
+		req := resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}}
+		var resp resource.ValidateConfigResponse
+		res.ValidateConfig(ctx, req, &resp)
+		return resp.Diagnostics
+	}
+
+	// Outputs of an order planned in the same run are unknown: nothing to complain about yet
+	unknownNumber := tftypes.NewValue(tftypes.Number, tftypes.UnknownValue)
+	if diags := validate(unknownNumber, tftypes.NewValue(tftypes.String, tftypes.UnknownValue)); diags.HasError() {
+		t.Fatalf("unexpected errors for unknown inputs: %v", diags)
+	}
+	if diags := validate(unknownNumber, tftypes.NewValue(tftypes.String, nil)); diags.HasError() {
+		t.Fatalf("unexpected errors without server_ip: %v", diags)
+	}
+
+	// An order still in process reports null
+	diags := validate(tftypes.NewValue(tftypes.Number, nil), tftypes.NewValue(tftypes.String, nil))
+	if !diags.HasError() || diags.Errors()[0].Summary() != "server_number is not yet known" || !strings.Contains(diags.Errors()[0].Detail(), "wait_for_ready") {
+		t.Fatalf("expected a not yet known error, got %v", diags)
+	}
+}
+
+func TestKeepRescueAccess(t *testing.T) {
+	ctx := context.Background()
+	res := &configurationResource{}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	vals := map[string]tftypes.Value{}
+	for name, typ := range objType.AttributeTypes {
+		vals[name] = tftypes.NewValue(typ, tftypes.UnknownValue)
+	}
+	vals["server_number"] = tftypes.NewValue(tftypes.Number, 111)
+	vals["name"] = tftypes.NewValue(tftypes.String, "web")
+	planned := tfsdk.Plan{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}
+	var plan configurationModel
+	if diags := planned.Get(ctx, &plan); diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	// Nothing to keep before the rescue system was activated
+	state := tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, nil)}
+	var diags diag.Diagnostics
+	keepRescueAccess(ctx, &state, &diags, plan)
+	if !state.Raw.IsNull() || len(diags) != 0 {
+		t.Fatalf("expected no state without a rescue password, got %v", diags)
+	}
+
+	plan.RescuePassword = types.StringValue("s3cret")
+	keepRescueAccess(ctx, &state, &diags, plan)
+	if diags.HasError() || diags.WarningsCount() != 1 {
+		t.Fatalf("expected a single warning, got %v", diags)
+	}
+	if !state.Raw.IsFullyKnown() {
+		t.Fatalf("a failed create must not store unknown values: %v", state.Raw)
+	}
+	var saved configurationModel
+	if d := state.Get(ctx, &saved); d.HasError() {
+		t.Fatal(d)
+	}
+	if saved.RescuePassword.ValueString() != "s3cret" || !saved.RescueActive.ValueBool() || saved.ServerNumber.ValueInt64() != 111 || saved.ID.IsNull() {
+		t.Fatalf("unexpected state after a failed create: %+v", saved)
+	}
+
+	// A failed update keeps the previous state and only adds the password
+	for name, typ := range objType.AttributeTypes {
+		vals[name] = tftypes.NewValue(typ, nil)
+	}
+	vals["id"] = tftypes.NewValue(tftypes.String, "configuration-1")
+	vals["version"] = tftypes.NewValue(tftypes.Number, 1)
+	state = tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, vals)}
+	plan.Version = types.Int64Value(2)
+	diags = nil
+	keepRescueAccess(ctx, &state, &diags, plan)
+	if d := state.Get(ctx, &saved); d.HasError() {
+		t.Fatal(d)
+	}
+	if saved.ID.ValueString() != "configuration-1" || saved.Version.ValueInt64() != 1 || saved.RescuePassword.ValueString() != "s3cret" {
+		t.Fatalf("unexpected state after a failed update: %+v", saved)
+	}
+}
+
+// configurationType returns the Terraform type of hrobot_configuration
+func configurationType(t *testing.T) (rschema.Schema, tftypes.Object) {
+	t.Helper()
+	ctx := context.Background()
+	var schemaResp resource.SchemaResponse
+	(&configurationResource{}).Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	return schemaResp.Schema, schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+}
+
+// configurationRaw returns an installed hrobot_configuration of server 111 at 1.2.3.4 (local_ip
+// 10.1.0.5) with attrs
+// set on top; everything else is null
+func configurationRaw(t *testing.T, attrs map[string]tftypes.Value) tftypes.Value {
+	t.Helper()
+	_, objType := configurationType(t)
+	vals := map[string]tftypes.Value{}
+	for name, typ := range objType.AttributeTypes {
+		vals[name] = tftypes.NewValue(typ, nil)
+	}
+	vals["id"] = tftypes.NewValue(tftypes.String, "configuration-1")
+	vals["server_number"] = tftypes.NewValue(tftypes.Number, 111)
+	vals["server_ip"] = tftypes.NewValue(tftypes.String, "1.2.3.4")
+	vals["name"] = tftypes.NewValue(tftypes.String, "web")
+	vals["server_name"] = tftypes.NewValue(tftypes.String, "web-a1b2c3")
+	vals["local_ip"] = tftypes.NewValue(tftypes.String, "10.1.0.5")
+	for name, v := range attrs {
+		vals[name] = v
+	}
+	return tftypes.NewValue(objType, vals)
+}
+
+// testServer111 answers reads and renames of /server/111 like Robot and 404s everything else
+func testServer111(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/server/111" {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = w.Write([]byte(`{"server":{"server_number":111,"server_ip":"1.2.3.4","server_name":"web-a1b2c3","status":"ready"}}`))
+}
+
+// testSSHSteps counts the SSH steps Update runs instead of logging in to the server; Read finds
+// the setup marker when interrupted is set
+type testSSHSteps struct {
+	configured, rebooted, keepalives int
+	interrupted                      bool
+}
+
+func (s *testSSHSteps) steps() *configurationSSH {
+	return &configurationSSH{
+		configure: func(_ []string, _ string, _ *configurationModel, _ context.Context) *ConfigureError {
+			s.configured++
+			return nil
+		},
+		reboot: func(context.Context, configurationModel) (string, string) {
+			s.rebooted++
+			return "", ""
+		},
+		keepalive: func(context.Context, configurationModel) (string, string) {
+			s.keepalives++
+			return "", ""
+		},
+		setupMarker: func(context.Context, configurationModel) (bool, error) {
+			return s.interrupted, nil
+		},
+	}
+}
+
+// updateConfiguration runs Update from state to plan, with the plan as the configuration
+func updateConfiguration(t *testing.T, res *configurationResource, state, plan tftypes.Value) resource.UpdateResponse {
+	t.Helper()
+	schema, _ := configurationType(t)
+	req := resource.UpdateRequest{
+		Config: tfsdk.Config{Schema: schema, Raw: plan},
+		Plan:   tfsdk.Plan{Schema: schema, Raw: plan},
+		State:  tfsdk.State{Schema: schema, Raw: state},
+	}
+	resp := resource.UpdateResponse{State: tfsdk.State{Schema: schema, Raw: state}}
+	res.Update(context.Background(), req, &resp)
+	return resp
+}
@@ -0,0 +1,395 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+// maxFirewallRules is how many rules Robot accepts per direction
+const maxFirewallRules = 10
+
+type firewallTemplateResource struct {
+	providerData *ProviderData
+}
+
+type firewallTemplateModel struct {
+	ID           types.Int64  `tfsdk:"id"`
+	Name         types.String `tfsdk:"name"`
+	FilterIPv6   types.Bool   `tfsdk:"filter_ipv6"`
+	WhitelistHOS types.Bool   `tfsdk:"whitelist_hos"`
+	IsDefault    types.Bool   `tfsdk:"is_default"`
+	Rules        types.Object `tfsdk:"rules"`
+}
+
+type firewallRulesModel struct {
+	Input  types.List `tfsdk:"input"`
+	Output types.List `tfsdk:"output"`
+}
+
+type firewallRuleModel struct {
+	Name      types.String `tfsdk:"name"`
+	IPVersion types.String `tfsdk:"ip_version"`
+	SrcIP     types.String `tfsdk:"src_ip"`
+	DstIP     types.String `tfsdk:"dst_ip"`
+	SrcPort   types.String `tfsdk:"src_port"`
+	DstPort   types.String `tfsdk:"dst_port"`
+	Protocol  types.String `tfsdk:"protocol"`
+	TCPFlags  types.String `tfsdk:"tcp_flags"`
+	Action    types.String `tfsdk:"action"`
+}
+
+var firewallRuleType = types.ObjectType{AttrTypes: map[string]attr.Type{
+	"name":       types.StringType,
+	"ip_version": types.StringType,
+	"src_ip":     types.StringType,
+	"dst_ip":     types.StringType,
+	"src_port":   types.StringType,
+	"dst_port":   types.StringType,
+	"protocol":   types.StringType,
+	"tcp_flags":  types.StringType,
+	"action":     types.StringType,
+}}
+
+var firewallRulesType = types.ObjectType{AttrTypes: map[string]attr.Type{
+	"input":  types.ListType{ElemType: firewallRuleType},
+	"output": types.ListType{ElemType: firewallRuleType},
+}}
+
+func NewResourceFirewallTemplate() resource.Resource {
+	return &firewallTemplateResource{}
+}
+
+func (r *firewallTemplateResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_firewall_template"
+}
+
+func firewallRuleListAttribute(description string) rschema.ListNestedAttribute {
+	return rschema.ListNestedAttribute{
+		Optional:    true,
+		Description: description,
+		NestedObject: rschema.NestedAttributeObject{
+			Attributes: map[string]rschema.Attribute{
+				"name":       rschema.StringAttribute{Optional: true, Description: "Name of the rule"},
+				"ip_version": rschema.StringAttribute{Optional: true, Description: "ipv4 or ipv6 (default: both)"},
+				"src_ip":     rschema.StringAttribute{Optional: true, Description: "Source address or network in CIDR notation"},
+				"dst_ip":     rschema.StringAttribute{Optional: true, Description: "Destination address or network in CIDR notation"},
+				"src_port":   rschema.StringAttribute{Optional: true, Description: "Source port or range, e.g. \"32768-65535\""},
+				"dst_port":   rschema.StringAttribute{Optional: true, Description: "Destination port or range, e.g. \"443\""},
+				"protocol":   rschema.StringAttribute{Optional: true, Description: "tcp, udp, gre, icmp, ipip, ah or esp (default: any)"},
+				"tcp_flags":  rschema.StringAttribute{Optional: true, Description: "TCP flags to match, e.g. \"syn\" or \"ack|fin\""},
+				"action":     rschema.StringAttribute{Required: true, Description: "accept or discard"},
+			},
+		},
+	}
+}
+
+func (r *firewallTemplateResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = rschema.Schema{
+		Description: "Manages a Hetzner Robot firewall template, a reusable rule set for server firewalls.",
+		Attributes: map[string]rschema.Attribute{
+			"id": rschema.Int64Attribute{
+				Computed:      true,
+				Description:   "The unique ID of the firewall template.",
+				PlanModifiers: []planmodifier.Int64{int64planmodifier.UseStateForUnknown()},
+			},
+			"name": rschema.StringAttribute{
+				Required:    true,
+				Description: "The name of the firewall template.",
+			},
+			"filter_ipv6": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Apply the rules to IPv6 traffic as well (default: false).",
+			},
+			"whitelist_hos": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Always allow Hetzner services such as DHCP, DNS and the rescue system (default: true).",
+			},
+			"is_default": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Preselect this template for new servers in Robot (default: false).",
+			},
+			"rules": rschema.SingleNestedAttribute{
+				Optional:    true,
+				Description: "Firewall rules, evaluated in order; traffic matching no rule is discarded.",
+				Attributes: map[string]rschema.Attribute{
+					"input":  firewallRuleListAttribute(fmt.Sprintf("Rules for incoming traffic (at most %d)", maxFirewallRules)),
+					"output": firewallRuleListAttribute(fmt.Sprintf("Rules for outgoing traffic (at most %d)", maxFirewallRules)),
+				},
+			},
+		},
+	}
+}
+
+func (r *firewallTemplateResource) Configure(_ context.Context, req resource.ConfigureRequest, _ *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	r.providerData = req.ProviderData.(*ProviderData)
+}
+
+func (r *firewallTemplateResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var config firewallTemplateModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() || config.Rules.IsNull() || config.Rules.IsUnknown() {
+		return
+	}
+	var rules firewallRulesModel
+	config.Rules.As(ctx, &rules, basetypes.ObjectAsOptions{UnhandledUnknownAsEmpty: true})
+	for direction, list := range map[string]types.List{"input": rules.Input, "output": rules.Output} {
+		if list.IsNull() || list.IsUnknown() {
+			continue
+		}
+		p := path.Root("rules").AtName(direction)
+		if n := len(list.Elements()); n > maxFirewallRules {
+			resp.Diagnostics.AddAttributeError(p, "Too many firewall rules",
+				fmt.Sprintf("Robot accepts at most %d %s rules, got %d.", maxFirewallRules, direction, n))
+		}
+		var entries []firewallRuleModel
+		list.ElementsAs(ctx, &entries, false)
+		for i, e := range entries {
+			if a := e.Action; !a.IsUnknown() && a.ValueString() != "accept" && a.ValueString() != "discard" {
+				resp.Diagnostics.AddAttributeError(p.AtListIndex(i).AtName("action"), "Invalid firewall rule",
+					fmt.Sprintf("action must be \"accept\" or \"discard\", got %q.", a.ValueString()))
+			}
+			if v := e.IPVersion; !v.IsNull() && !v.IsUnknown() && v.ValueString() != "ipv4" && v.ValueString() != "ipv6" {
+				resp.Diagnostics.AddAttributeError(p.AtListIndex(i).AtName("ip_version"), "Invalid firewall rule",
+					fmt.Sprintf("ip_version must be \"ipv4\" or \"ipv6\", got %q.", v.ValueString()))
+			}
+		}
+	}
+}
+
+func (r *firewallTemplateResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan firewallTemplateModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	template, err := r.providerData.Client.CreateFirewallTemplate(firewallTemplateFromModel(ctx, plan))
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to create firewall template", robotErrorDetail(err, "manage firewalls", "Firewall"))
+		return
+	}
+
+	resp.Diagnostics.Append(plan.setFromAPI(ctx, *template)...)
+	tflog.Info(ctx, "Created firewall template", map[string]interface{}{
+		"id":   template.ID,
+		"name": template.Name,
+	})
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *firewallTemplateResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var state firewallTemplateModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if state.ID.IsNull() || state.ID.IsUnknown() {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	template, err := r.providerData.Client.GetFirewallTemplate(int(state.ID.ValueInt64()))
+	if client.IsNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read firewall template", robotErrorDetail(err, "manage firewalls", "Firewall"))
+		return
+	}
+
+	resp.Diagnostics.Append(state.setFromAPI(ctx, *template)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+func (r *firewallTemplateResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state firewallTemplateModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	template, err := r.providerData.Client.UpdateFirewallTemplate(int(state.ID.ValueInt64()), firewallTemplateFromModel(ctx, plan))
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to update firewall template", robotErrorDetail(err, "manage firewalls", "Firewall"))
+		return
+	}
+
+	resp.Diagnostics.Append(plan.setFromAPI(ctx, *template)...)
+	tflog.Info(ctx, "Updated firewall template", map[string]interface{}{
+		"id":   template.ID,
+		"name": template.Name,
+	})
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *firewallTemplateResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state firewallTemplateModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	err := r.providerData.Client.DeleteFirewallTemplate(int(state.ID.ValueInt64()))
+	if err != nil && !client.IsNotFound(err) {
+		resp.Diagnostics.AddError("Failed to delete firewall template", robotErrorDetail(err, "manage firewalls", "Firewall"))
+		return
+	}
+
+	tflog.Info(ctx, "Deleted firewall template", map[string]interface{}{
+		"id": state.ID.ValueInt64(),
+	})
+}
+
+func (r *firewallTemplateResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	id, err := strconv.Atoi(req.ID)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid firewall template ID", fmt.Sprintf("Expected integer, got: %s", req.ID))
+		return
+	}
+
+	template, err := r.providerData.Client.GetFirewallTemplate(id)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to import firewall template", robotErrorDetail(err, "manage firewalls", "Firewall"))
+		return
+	}
+
+	state := firewallTemplateModel{
+		FilterIPv6:   types.BoolNull(),
+		WhitelistHOS: types.BoolNull(),
+		IsDefault:    types.BoolNull(),
+		Rules:        types.ObjectNull(firewallRulesType.AttrTypes),
+	}
+	resp.Diagnostics.Append(state.setFromAPI(ctx, *template)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// firewallTemplateFromModel builds the template sent to Robot, applying the defaults of unset attributes
+func firewallTemplateFromModel(ctx context.Context, m firewallTemplateModel) client.FirewallTemplate {
+	t := client.FirewallTemplate{
+		Name:         m.Name.ValueString(),
+		FilterIPv6:   m.FilterIPv6.ValueBool(),
+		WhitelistHOS: m.WhitelistHOS.IsNull() || m.WhitelistHOS.IsUnknown() || m.WhitelistHOS.ValueBool(),
+		IsDefault:    m.IsDefault.ValueBool(),
+	}
+	if m.Rules.IsNull() || m.Rules.IsUnknown() {
+		return t
+	}
+	var rules firewallRulesModel
+	m.Rules.As(ctx, &rules, basetypes.ObjectAsOptions{})
+	t.Rules.Input = firewallRulesFromList(ctx, rules.Input)
+	t.Rules.Output = firewallRulesFromList(ctx, rules.Output)
+	return t
+}
+
+func firewallRulesFromList(ctx context.Context, l types.List) []client.FirewallRule {
+	if l.IsNull() || l.IsUnknown() {
+		return nil
+	}
+	var entries []firewallRuleModel
+	l.ElementsAs(ctx, &entries, false)
+	rules := make([]client.FirewallRule, len(entries))
+	for i, e := range entries {
+		rules[i] = client.FirewallRule{
+			Name:      e.Name.ValueString(),
+			IPVersion: e.IPVersion.ValueString(),
+			SrcIP:     e.SrcIP.ValueString(),
+			DstIP:     e.DstIP.ValueString(),
+			SrcPort:   e.SrcPort.ValueString(),
+			DstPort:   e.DstPort.ValueString(),
+			Protocol:  e.Protocol.ValueString(),
+			TCPFlags:  e.TCPFlags.ValueString(),
+			Action:    e.Action.ValueString(),
+		}
+	}
+	return rules
+}
+
+// setFromAPI copies a template read from Robot into the model. Unset optional attributes stay
+// null while Robot reports their default, so they don't show a diff.
+func (m *firewallTemplateModel) setFromAPI(ctx context.Context, t client.FirewallTemplate) diag.Diagnostics {
+	m.ID = types.Int64Value(int64(t.ID))
+	m.Name = types.StringValue(t.Name)
+	m.FilterIPv6 = boolOrNullDefault(m.FilterIPv6, t.FilterIPv6, false)
+	m.WhitelistHOS = boolOrNullDefault(m.WhitelistHOS, t.WhitelistHOS, true)
+	m.IsDefault = boolOrNullDefault(m.IsDefault, t.IsDefault, false)
+
+	var current firewallRulesModel
+	if m.Rules.IsNull() || m.Rules.IsUnknown() {
+		if len(t.Rules.Input) == 0 && len(t.Rules.Output) == 0 {
+			m.Rules = types.ObjectNull(firewallRulesType.AttrTypes)
+			return nil
+		}
+		current = firewallRulesModel{Input: types.ListNull(firewallRuleType), Output: types.ListNull(firewallRuleType)}
+	} else {
+		m.Rules.As(ctx, &current, basetypes.ObjectAsOptions{})
+	}
+
+	var diags diag.Diagnostics
+	input, d := firewallRuleList(ctx, current.Input, t.Rules.Input)
+	diags.Append(d...)
+	output, d := firewallRuleList(ctx, current.Output, t.Rules.Output)
+	diags.Append(d...)
+	rules, d := types.ObjectValueFrom(ctx, firewallRulesType.AttrTypes, firewallRulesModel{Input: input, Output: output})
+	diags.Append(d...)
+	m.Rules = rules
+	return diags
+}
+
+// firewallRuleList converts rules read from Robot, keeping an unset list null when Robot has none
+func firewallRuleList(ctx context.Context, current types.List, rules []client.FirewallRule) (types.List, diag.Diagnostics) {
+	if len(rules) == 0 && current.IsNull() {
+		return types.ListNull(firewallRuleType), nil
+	}
+	entries := make([]firewallRuleModel, len(rules))
+	for i, r := range rules {
+		entries[i] = firewallRuleModel{
+			Name:      stringOrNull(r.Name),
+			IPVersion: stringOrNull(r.IPVersion),
+			SrcIP:     stringOrNull(r.SrcIP),
+			DstIP:     stringOrNull(r.DstIP),
+			SrcPort:   stringOrNull(r.SrcPort),
+			DstPort:   stringOrNull(r.DstPort),
+			Protocol:  stringOrNull(r.Protocol),
+			TCPFlags:  stringOrNull(r.TCPFlags),
+			Action:    types.StringValue(r.Action),
+		}
+	}
+	return types.ListValueFrom(ctx, firewallRuleType, entries)
+}
+
+// boolOrNullDefault returns Robot's value, or null when the attribute is unset and Robot reports its default
+func boolOrNullDefault(current types.Bool, value, def bool) types.Bool {
+	if (current.IsNull() || current.IsUnknown()) && value == def {
+		return types.BoolNull()
+	}
+	return types.BoolValue(value)
+}
+
+// stringOrNull maps the empty strings Robot uses for unset fields to null
+func stringOrNull(s string) types.String {
+	if s == "" {
+		return types.StringNull()
+	}
+	return types.StringValue(s)
+}
@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+func TestFirewallTemplateModel(t *testing.T) {
+	ctx := context.Background()
+	api := client.FirewallTemplate{ID: 7, Name: "base", WhitelistHOS: true, Rules: client.FirewallRules{
+		Input: []client.FirewallRule{{Name: "ssh", DstPort: "22", Protocol: "tcp", Action: "accept"}},
+	}}
+
+	// Unset attributes stay null while Robot reports their defaults
+	m := firewallTemplateModel{
+		FilterIPv6:   types.BoolNull(),
+		WhitelistHOS: types.BoolNull(),
+		IsDefault:    types.BoolNull(),
+		Rules:        types.ObjectNull(firewallRulesType.AttrTypes),
+	}
+	if diags := m.setFromAPI(ctx, api); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if m.ID.ValueInt64() != 7 || !m.WhitelistHOS.IsNull() || !m.FilterIPv6.IsNull() {
+		t.Fatalf("unexpected model: %+v", m)
+	}
+	var rules firewallRulesModel
+	m.Rules.As(ctx, &rules, basetypes.ObjectAsOptions{})
+	if !rules.Output.IsNull() || len(rules.Input.Elements()) != 1 {
+		t.Fatalf("expected one input rule and null output rules, got %s", m.Rules)
+	}
+
+	// The model converts back to the same template, defaults included
+	got := firewallTemplateFromModel(ctx, m)
+	got.ID = api.ID
+	if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", api) {
+		t.Fatalf("round trip changed the template:\n%+v\n%+v", got, api)
+	}
+
+	api.WhitelistHOS = false
+	api.Rules = client.FirewallRules{}
+	if m.setFromAPI(ctx, api); !m.WhitelistHOS.Equal(types.BoolValue(false)) {
+		t.Fatalf("expected drift from the default to show, got %s", m.WhitelistHOS)
+	}
+	m.Rules.As(ctx, &rules, basetypes.ObjectAsOptions{})
+	if len(rules.Input.Elements()) != 0 {
+		t.Fatalf("expected rules removed in Robot to show as drift, got %s", m.Rules)
+	}
+}
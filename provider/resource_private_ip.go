@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+type privateIPResource struct {
+	providerData *ProviderData
+}
+
+type privateIPModel struct {
+	ID      types.String `tfsdk:"id"`
+	PoolID  types.String `tfsdk:"pool_id"`
+	Name    types.String `tfsdk:"name"`
+	Address types.String `tfsdk:"address"`
+}
+
+func NewResourcePrivateIP() resource.Resource {
+	return &privateIPResource{}
+}
+
+func (r *privateIPResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_private_ip"
+}
+
+func (r *privateIPResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = rschema.Schema{
+		Description: "The address a hrobot_private_ip_pool allocated to one of its members, e.g. to set local_ip on a hrobot_configuration. The pool must be in the same configuration: its allocations are only looked up while Terraform plans and applies it, so pools of another workspace or state can't be used (read their allocations attribute through terraform_remote_state instead).",
+		Attributes: map[string]rschema.Attribute{
+			"id": rschema.StringAttribute{
+				Computed:      true,
+				Description:   "<pool_id>/<name>",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"pool_id": rschema.StringAttribute{
+				Required:      true,
+				Description:   "ID of a hrobot_private_ip_pool in the same configuration",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.RequiresReplace()},
+			},
+			"name": rschema.StringAttribute{
+				Required:      true,
+				Description:   "Member of the pool to take the address of; it must be listed in the pool's members",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.RequiresReplace()},
+			},
+			"address": rschema.StringAttribute{
+				Computed:    true,
+				Description: "The allocated address",
+			},
+		},
+	}
+}
+
+func (r *privateIPResource) Configure(_ context.Context, req resource.ConfigureRequest, _ *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	r.providerData = req.ProviderData.(*ProviderData)
+}
+
+// ModifyPlan takes the address from the pool, which is planned first since pool_id refers to it
+func (r *privateIPResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() || r.providerData == nil {
+		return
+	}
+	var plan privateIPModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	address, known := r.lookup(&resp.Diagnostics, plan)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if known {
+		plan.Address = types.StringValue(address)
+	} else if !req.State.Raw.IsNull() && !plan.PoolID.IsUnknown() {
+		// The pool was not planned in this run (e.g. -refresh=false): keep the address
+		resp.Diagnostics.Append(req.State.GetAttribute(ctx, path.Root("address"), &plan.Address)...)
+	}
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, &plan)...)
+}
+
+func (r *privateIPResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan privateIPModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.resolveAddress(&resp.Diagnostics, &plan)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	plan.ID = types.StringValue(fmt.Sprintf("%s/%s", plan.PoolID.ValueString(), plan.Name.ValueString()))
+	tflog.Info(ctx, "Allocated private IP", map[string]interface{}{
+		"pool":    plan.PoolID.ValueString(),
+		"name":    plan.Name.ValueString(),
+		"address": plan.Address.ValueString(),
+	})
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *privateIPResource) Read(_ context.Context, _ resource.ReadRequest, _ *resource.ReadResponse) {
+	// The address is owned by the pool's state; ModifyPlan follows it there
+}
+
+func (r *privateIPResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan privateIPModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.resolveAddress(&resp.Diagnostics, &plan)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	tflog.Info(ctx, "Updated private IP", map[string]interface{}{
+		"pool":    plan.PoolID.ValueString(),
+		"name":    plan.Name.ValueString(),
+		"address": plan.Address.ValueString(),
+	})
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *privateIPResource) Delete(_ context.Context, _ resource.DeleteRequest, _ *resource.DeleteResponse) {
+	// The address goes back to the pool when the member is removed from its members
+}
+
+// resolveAddress fills in an address that was unknown at plan time, once the pool is applied
+func (r *privateIPResource) resolveAddress(diags *diag.Diagnostics, plan *privateIPModel) {
+	if !plan.Address.IsUnknown() {
+		return
+	}
+	address, known := r.lookup(diags, *plan)
+	if diags.HasError() {
+		return
+	}
+	if !known {
+		diags.AddError("Private IP pool not found",
+			fmt.Sprintf("hrobot_private_ip_pool %q has no allocations yet. Set pool_id from the pool's id attribute so it is applied first; pools of another workspace or state can't be used.", plan.PoolID.ValueString()))
+		return
+	}
+	plan.Address = types.StringValue(address)
+}
+
+// lookup returns the address the pool allocated to plan.Name; known is false while the pool or
+// its allocations are not known yet
+func (r *privateIPResource) lookup(diags *diag.Diagnostics, plan privateIPModel) (string, bool) {
+	if plan.PoolID.IsUnknown() || plan.Name.IsUnknown() {
+		return "", false
+	}
+	allocations, ok := r.providerData.privateIPPoolAllocations(plan.PoolID.ValueString())
+	if !ok || allocations.IsUnknown() {
+		return "", false
+	}
+	address, ok := allocations.Elements()[plan.Name.ValueString()].(types.String)
+	if !ok {
+		diags.AddAttributeError(path.Root("name"), "Not a pool member",
+			fmt.Sprintf("%q is not in the members of hrobot_private_ip_pool %q; add it there to allocate an address.", plan.Name.ValueString(), plan.PoolID.ValueString()))
+		return "", false
+	}
+	return address.ValueString(), true
+}
@@ -0,0 +1,359 @@
+package provider
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/tfutil"
+)
+
+type privateIPPoolResource struct {
+	providerData *ProviderData
+}
+
+type privateIPPoolModel struct {
+	ID          types.String `tfsdk:"id"`
+	Name        types.String `tfsdk:"name"`
+	CIDR        types.String `tfsdk:"cidr"`
+	Reserved    types.List   `tfsdk:"reserved"`
+	Members     types.Set    `tfsdk:"members"`
+	Allocations types.Map    `tfsdk:"allocations"`
+}
+
+func NewResourcePrivateIPPool() resource.Resource {
+	return &privateIPPoolResource{}
+}
+
+func (r *privateIPPoolResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_private_ip_pool"
+}
+
+func (r *privateIPPoolResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = rschema.Schema{
+		Description: "A range of private addresses handed out to named members. Allocations live in this resource's state: a member keeps its address until it is removed, and a new member gets the lowest free address.",
+		Attributes: map[string]rschema.Attribute{
+			"id": rschema.StringAttribute{
+				Computed:      true,
+				Description:   "The pool name",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"name": rschema.StringAttribute{
+				Required:      true,
+				Description:   "Name of the pool",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.RequiresReplace()},
+			},
+			"cidr": rschema.StringAttribute{
+				Required:      true,
+				Description:   "IPv4 network the addresses come from (e.g., 10.1.0.0/24); its network and broadcast addresses are never allocated",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.RequiresReplace()},
+			},
+			"reserved": rschema.ListAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Addresses that are never allocated, as single IPs or ranges (e.g., 10.1.0.1 or 10.1.0.2-10.1.0.10; 10.1.0.2-10 is short for the same range)",
+			},
+			"members": rschema.SetAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Names that get an address from the pool, e.g. one per hrobot_private_ip",
+			},
+			"allocations": rschema.MapAttribute{
+				Computed:    true,
+				ElementType: types.StringType,
+				Description: "Address of each member, keyed by member name",
+			},
+		},
+	}
+}
+
+func (r *privateIPPoolResource) Configure(_ context.Context, req resource.ConfigureRequest, _ *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	r.providerData = req.ProviderData.(*ProviderData)
+}
+
+func (r *privateIPPoolResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var config privateIPPoolModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var network *net.IPNet
+	if !config.CIDR.IsNull() && !config.CIDR.IsUnknown() {
+		var err error
+		if network, err = privateIPNetwork(config.CIDR.ValueString()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("cidr"), "Invalid cidr", err.Error())
+		}
+	}
+	if network == nil || config.Reserved.IsUnknown() {
+		return
+	}
+	for _, entry := range tfutil.ElementsAsStrings(ctx, &resp.Diagnostics, config.Reserved) {
+		if _, _, err := reservedRange(network, entry); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("reserved"), "Invalid reserved", err.Error())
+		}
+	}
+}
+
+// ModifyPlan allocates at plan time, so hrobot_private_ip and hrobot_configuration see the
+// addresses in the plan and the apply can't pick different ones
+func (r *privateIPPoolResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+	var plan privateIPPoolModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	var previous types.Map
+	if !req.State.Raw.IsNull() {
+		resp.Diagnostics.Append(req.State.GetAttribute(ctx, path.Root("allocations"), &previous)...)
+	}
+
+	plan.ID = plan.Name
+	plan.Allocations = r.allocate(ctx, &resp.Diagnostics, plan, previous)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, &plan)...)
+}
+
+func (r *privateIPPoolResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan privateIPPoolModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	plan.ID = plan.Name
+	plan.Allocations = r.allocate(ctx, &resp.Diagnostics, plan, types.MapNull(types.StringType))
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	tflog.Info(ctx, "Created private IP pool", map[string]interface{}{
+		"name":        plan.Name.ValueString(),
+		"cidr":        plan.CIDR.ValueString(),
+		"allocations": len(plan.Allocations.Elements()),
+	})
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *privateIPPoolResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	// Nothing to read back: the allocations only exist in state
+	var state privateIPPoolModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	r.providerData.registerPrivateIPPool(ctx, state.ID.ValueString(), state.Allocations)
+}
+
+func (r *privateIPPoolResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state privateIPPoolModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	plan.ID = state.ID
+	plan.Allocations = r.allocate(ctx, &resp.Diagnostics, plan, state.Allocations)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	tflog.Info(ctx, "Updated private IP pool", map[string]interface{}{
+		"name":        plan.Name.ValueString(),
+		"allocations": len(plan.Allocations.Elements()),
+	})
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *privateIPPoolResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state privateIPPoolModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	r.providerData.registerPrivateIPPool(ctx, state.ID.ValueString(), types.MapNull(types.StringType))
+	tflog.Info(ctx, "Deleted private IP pool", map[string]interface{}{"name": state.ID.ValueString()})
+}
+
+// allocate returns the allocations for plan, keeping the previous addresses of members that are
+// still valid, and registers them for hrobot_private_ip. While any input is unknown, so are they
+func (r *privateIPPoolResource) allocate(ctx context.Context, diags *diag.Diagnostics, plan privateIPPoolModel, previous types.Map) types.Map {
+	if plan.CIDR.IsUnknown() || plan.Reserved.IsUnknown() || plan.Members.IsUnknown() || previous.IsUnknown() {
+		r.providerData.registerPrivateIPPool(ctx, plan.Name.ValueString(), types.MapUnknown(types.StringType))
+		return types.MapUnknown(types.StringType)
+	}
+
+	var reserved, members []string
+	var kept map[string]string
+	if !plan.Reserved.IsNull() {
+		plan.Reserved.ElementsAs(ctx, &reserved, false)
+	}
+	if !plan.Members.IsNull() {
+		plan.Members.ElementsAs(ctx, &members, false)
+	}
+	if !previous.IsNull() {
+		previous.ElementsAs(ctx, &kept, false)
+	}
+
+	allocations, err := allocatePrivateIPs(plan.CIDR.ValueString(), reserved, members, kept)
+	if err != nil {
+		diags.AddAttributeError(path.Root("members"), "Private IP allocation failed", err.Error())
+		return types.MapUnknown(types.StringType)
+	}
+	values := make(map[string]attr.Value, len(allocations))
+	for member, address := range allocations {
+		values[member] = types.StringValue(address)
+	}
+	result := types.MapValueMust(types.StringType, values)
+	r.providerData.registerPrivateIPPool(ctx, plan.Name.ValueString(), result)
+	return result
+}
+
+// privateIPNetwork parses cidr as an IPv4 network with room for at least one host
+func privateIPNetwork(cidr string) (*net.IPNet, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil || network.IP.To4() == nil {
+		return nil, fmt.Errorf("cidr must be an IPv4 network like 10.1.0.0/24, got %q", cidr)
+	}
+	if ones, _ := network.Mask.Size(); ones > 30 {
+		return nil, fmt.Errorf("cidr must be /30 or larger to leave room for hosts, got %q", cidr)
+	}
+	return network, nil
+}
+
+// reservedRange parses a reserved entry (an IP, a-b or a-<last octet>) to the first and last
+// address it covers; both must be in network
+func reservedRange(network *net.IPNet, entry string) (uint32, uint32, error) {
+	start, end, isRange := strings.Cut(strings.TrimSpace(entry), "-")
+	if isRange && !strings.Contains(end, ".") {
+		if i := strings.LastIndex(start, "."); i >= 0 {
+			end = start[:i+1] + end
+		}
+	}
+	if !isRange {
+		end = start
+	}
+	first, last := net.ParseIP(start).To4(), net.ParseIP(end).To4()
+	if first == nil || last == nil || !network.Contains(first) || !network.Contains(last) {
+		return 0, 0, fmt.Errorf("reserved entries must be IPv4 addresses or ranges in %s, got %q", network, entry)
+	}
+	if ipToUint(first) > ipToUint(last) {
+		return 0, 0, fmt.Errorf("reserved range %q ends before it starts", entry)
+	}
+	return ipToUint(first), ipToUint(last), nil
+}
+
+// allocatePrivateIPs gives every member an address from cidr. Members keep their previous address
+// when it is still in the network and not reserved; the others get the lowest free host address,
+// in member name order, so the same inputs always give the same result
+func allocatePrivateIPs(cidr string, reserved, members []string, previous map[string]string) (map[string]string, error) {
+	network, err := privateIPNetwork(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := network.Mask.Size()
+	first := ipToUint(network.IP) + 1
+	last := ipToUint(network.IP) + uint32(1)<<(bits-ones) - 2
+
+	type span struct{ first, last uint32 }
+	var spans []span
+	for _, entry := range reserved {
+		lo, hi, err := reservedRange(network, entry)
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, span{lo, hi})
+	}
+	isReserved := func(ip uint32) bool {
+		for _, s := range spans {
+			if ip >= s.first && ip <= s.last {
+				return true
+			}
+		}
+		return false
+	}
+
+	sort.Strings(members)
+	allocations := make(map[string]string, len(members))
+	used := map[uint32]bool{}
+	var pending []string
+	for _, member := range members {
+		if ip := net.ParseIP(previous[member]).To4(); ip != nil && network.Contains(ip) &&
+			ipToUint(ip) >= first && ipToUint(ip) <= last && !isReserved(ipToUint(ip)) && !used[ipToUint(ip)] {
+			allocations[member] = ip.String()
+			used[ipToUint(ip)] = true
+			continue
+		}
+		pending = append(pending, member)
+	}
+
+	next := first
+	for _, member := range pending {
+		for next <= last && (used[next] || isReserved(next)) {
+			next++
+		}
+		if next > last {
+			return nil, fmt.Errorf("%s has no free address left for %q: %d members, the rest is reserved", cidr, member, len(members))
+		}
+		allocations[member] = uintToIP(next).String()
+		used[next] = true
+	}
+	return allocations, nil
+}
+
+func ipToUint(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uintToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// registerPrivateIPPool records the allocations of a pool for the hrobot_private_ip resources
+// planned or applied after it; a null value forgets the pool
+func (pd *ProviderData) registerPrivateIPPool(ctx context.Context, name string, allocations types.Map) {
+	if pd == nil || name == "" {
+		return
+	}
+	pd.IPMutex.Lock()
+	defer pd.IPMutex.Unlock()
+	if pd.PrivateIPPools == nil {
+		pd.PrivateIPPools = map[string]types.Map{}
+	}
+	if allocations.IsNull() {
+		delete(pd.PrivateIPPools, name)
+		return
+	}
+	pd.PrivateIPPools[name] = allocations
+	tflog.Debug(ctx, "registered private IP pool", map[string]interface{}{"name": name, "known": !allocations.IsUnknown()})
+}
+
+// privateIPPoolAllocations returns what registerPrivateIPPool recorded for a pool
+func (pd *ProviderData) privateIPPoolAllocations(name string) (types.Map, bool) {
+	pd.IPMutex.Lock()
+	defer pd.IPMutex.Unlock()
+	allocations, ok := pd.PrivateIPPools[name]
+	return allocations, ok
+}
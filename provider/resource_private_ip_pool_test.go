@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAllocatePrivateIPs(t *testing.T) {
+	reserved := []string{"10.1.0.1", "10.1.0.2-10"}
+	got, err := allocatePrivateIPs("10.1.0.0/24", reserved, []string{"web", "db", "cache"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"cache": "10.1.0.11", "db": "10.1.0.12", "web": "10.1.0.13"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected the lowest free addresses in name order, got %v", got)
+	}
+
+	// Members keep their address; a new member fills the lowest gap
+	got, err = allocatePrivateIPs("10.1.0.0/24", reserved, []string{"web", "cache", "api"}, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{"api": "10.1.0.12", "cache": "10.1.0.11", "web": "10.1.0.13"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected existing allocations to be kept, got %v", got)
+	}
+
+	// An address that became reserved is moved
+	got, err = allocatePrivateIPs("10.1.0.0/24", []string{"10.1.0.1-13"}, []string{"web"}, want)
+	if err != nil || got["web"] != "10.1.0.14" {
+		t.Fatalf("expected web to move out of the reserved range, got %v, %v", got, err)
+	}
+
+	// The network and broadcast addresses are never handed out
+	if _, err := allocatePrivateIPs("10.1.0.0/30", nil, []string{"a", "b", "c"}, nil); err == nil {
+		t.Fatalf("expected a /30 to hold only two members")
+	}
+	for _, bad := range []string{"10.1.1.5", "10.1.0.9-3", "10.1.0.2-10.1.1.2", "nope"} {
+		if _, err := allocatePrivateIPs("10.1.0.0/24", []string{bad}, nil, nil); err == nil {
+			t.Fatalf("expected reserved entry %q to be rejected", bad)
+		}
+	}
+	if _, err := allocatePrivateIPs("fd00::/64", nil, nil, nil); err == nil {
+		t.Fatalf("expected an IPv6 cidr to be rejected")
+	}
+}
@@ -0,0 +1,38 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestPrivateIPLookup(t *testing.T) {
+	ctx := context.Background()
+	pd := &ProviderData{}
+	r := &privateIPResource{providerData: pd}
+	plan := privateIPModel{PoolID: types.StringValue("internal"), Name: types.StringValue("web"), Address: types.StringUnknown()}
+
+	var diags diag.Diagnostics
+	if _, known := r.lookup(&diags, plan); known || diags.HasError() {
+		t.Fatalf("expected an unregistered pool to be unknown, got %v", diags)
+	}
+	r.resolveAddress(&diags, &plan)
+	if !diags.HasError() {
+		t.Fatalf("expected an error when the pool was never applied")
+	}
+
+	pd.registerPrivateIPPool(ctx, "internal", types.MapValueMust(types.StringType, map[string]attr.Value{"web": types.StringValue("10.1.0.11")}))
+	diags = nil
+	r.resolveAddress(&diags, &plan)
+	if diags.HasError() || plan.Address.ValueString() != "10.1.0.11" {
+		t.Fatalf("expected the pool's address, got %s, %v", plan.Address, diags)
+	}
+
+	plan.Name = types.StringValue("db")
+	if _, known := r.lookup(&diags, plan); known || !diags.HasError() {
+		t.Fatalf("expected an error for a name that is not a member")
+	}
+}
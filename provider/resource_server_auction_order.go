@@ -2,18 +2,18 @@ package provider
 
 import (
 	"context"
-	"encoding/json"
-	"os"
-	"path/filepath"
-	"sync"
+	"fmt"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/mokto/terraform-provider-hrobot/internal/client"
+	"github.com/mokto/terraform-provider-hrobot/internal/tfutil"
 )
 
 type serverAuctionOrderResource struct {
@@ -24,154 +24,35 @@ type serverAuctionOrderModel struct {
 	ID        types.String `tfsdk:"id"`
 	ProductID types.Int64  `tfsdk:"product_id"`
 	Dist      types.String `tfsdk:"dist"`
+	Lang      types.String `tfsdk:"lang"`
 	Keys      types.List   `tfsdk:"authorized_key_fingerprints"`
 	Password  types.String `tfsdk:"password"`
 	Addons    types.List   `tfsdk:"addons"`
+	AddonQty  types.List   `tfsdk:"addon_quantities"`
 	Test      types.Bool   `tfsdk:"test"`
 
+	WaitForReady       types.Bool  `tfsdk:"wait_for_ready"`
+	WaitTimeoutMinutes types.Int64 `tfsdk:"wait_timeout_minutes"`
+
 	TransactionID types.String `tfsdk:"transaction_id"`
 	Status        types.String `tfsdk:"status"`
 	ServerNumber  types.Int64  `tfsdk:"server_number"`
 	ServerIP      types.String `tfsdk:"server_ip"`
-}
-
-// Cache entry for market transaction data
-type marketTransactionCacheEntry struct {
-	transaction *client.Transaction
-	lastUpdated time.Time
-}
-
-// JSON-serializable cache entry for market transactions
-type jsonMarketCacheEntry struct {
-	Transaction *client.Transaction `json:"transaction"`
-	LastUpdated string              `json:"last_updated"`
-}
 
-// Global cache for market transaction data to avoid hitting API rate limits
-var (
-	marketTransactionCache = make(map[string]*marketTransactionCacheEntry)
-	marketCacheMutex       sync.RWMutex
-	marketCacheExpiry      = 5 * time.Minute // Cache expires after 5 minutes
-	marketCacheFile        = getMarketCacheFilePath()
-)
-
-// getMarketCacheFilePath returns the path to the market cache file in the .cache directory
-func getMarketCacheFilePath() string {
-	// Get the current working directory (should be the repository root)
-	wd, err := os.Getwd()
-	if err != nil {
-		// Fallback to temp directory if we can't get working directory
-		return filepath.Join(os.TempDir(), "terraform-provider-hrobot-market-cache.json")
-	}
-
-	// Create .cache directory if it doesn't exist
-	cacheDir := filepath.Join(wd, ".cache")
-	os.MkdirAll(cacheDir, 0755)
+	EffectiveAddons types.List `tfsdk:"effective_addons"`
 
-	return filepath.Join(cacheDir, "market-transaction-cache.json")
+	CreatedAt        types.String `tfsdk:"created_at"`
+	OrderedProductID types.Int64  `tfsdk:"ordered_product_id"`
+	Datacenter       types.String `tfsdk:"datacenter"`
 }
 
 func NewResourceServerAuctionOrder() resource.Resource {
-	// Load cache from disk on startup
-	loadMarketCacheFromDisk()
 	return &serverAuctionOrderResource{}
 }
 
-// loadMarketCacheFromDisk loads the market cache from disk
-func loadMarketCacheFromDisk() {
-	marketCacheMutex.Lock()
-	defer marketCacheMutex.Unlock()
-
-	data, err := os.ReadFile(marketCacheFile)
-	if err != nil {
-		// Cache file doesn't exist or can't be read, start with empty cache
-		return
-	}
-
-	var diskCache map[string]*jsonMarketCacheEntry
-	if err := json.Unmarshal(data, &diskCache); err != nil {
-		// Invalid cache file, start with empty cache
-		return
-	}
-
-	// Only load non-expired entries
-	now := time.Now()
-	for id, jsonEntry := range diskCache {
-		lastUpdated, err := time.Parse(time.RFC3339, jsonEntry.LastUpdated)
-		if err != nil {
-			continue // Skip invalid timestamp
-		}
-
-		if now.Sub(lastUpdated) <= marketCacheExpiry {
-			marketTransactionCache[id] = &marketTransactionCacheEntry{
-				transaction: jsonEntry.Transaction,
-				lastUpdated: lastUpdated,
-			}
-		}
-	}
-}
-
-// saveMarketCacheToDisk saves the market cache to disk
-func saveMarketCacheToDisk() {
-	marketCacheMutex.RLock()
-	defer marketCacheMutex.RUnlock()
-
-	// Convert to JSON-serializable format
-	jsonCache := make(map[string]*jsonMarketCacheEntry)
-	for id, entry := range marketTransactionCache {
-		jsonCache[id] = &jsonMarketCacheEntry{
-			Transaction: entry.transaction,
-			LastUpdated: entry.lastUpdated.Format(time.RFC3339),
-		}
-	}
-
-	data, err := json.Marshal(jsonCache)
-	if err != nil {
-		return
-	}
-
-	os.WriteFile(marketCacheFile, data, 0600)
-}
-
-// getCachedMarketTransaction retrieves market transaction from cache if available and not expired
-func getCachedMarketTransaction(id string) (*client.Transaction, bool) {
-	marketCacheMutex.RLock()
-	defer marketCacheMutex.RUnlock()
-
-	entry, exists := marketTransactionCache[id]
-	if !exists {
-		return nil, false
-	}
-
-	// Check if cache entry is expired
-	if time.Since(entry.lastUpdated) > marketCacheExpiry {
-		return nil, false
-	}
-
-	return entry.transaction, true
-}
-
-// setCachedMarketTransaction stores market transaction in cache
-func setCachedMarketTransaction(id string, transaction *client.Transaction) {
-	marketCacheMutex.Lock()
-	defer marketCacheMutex.Unlock()
-
-	marketTransactionCache[id] = &marketTransactionCacheEntry{
-		transaction: transaction,
-		lastUpdated: time.Now(),
-	}
-
-	// Save to disk asynchronously
-	go saveMarketCacheToDisk()
-}
-
 // shouldRefreshMarketTransaction determines if we need to refresh the market transaction data
 func shouldRefreshMarketTransaction(transaction *client.Transaction) bool {
-	if transaction == nil {
-		return true
-	}
-	// Only refresh if status is "in process" - other statuses are final
-	return transaction.Status == "in process"
+	return transaction == nil || !transactionFinal(transaction.Status)
 }
 
 func (r *serverAuctionOrderResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -184,6 +65,7 @@ func (r *serverAuctionOrderResource) Schema(_ context.Context, _ resource.Schema
 		Attributes: map[string]rschema.Attribute{
 			"product_id": rschema.Int64Attribute{Required: true, Description: "Auction product id (e.g., 12345)"},
 			"dist":       rschema.StringAttribute{Optional: true, Description: "Preinstall distribution label"},
+			"lang":       rschema.StringAttribute{Optional: true, Description: "Preinstall distribution language (e.g., en)"},
 			"authorized_key_fingerprints": rschema.ListAttribute{
 				Optional:    true,
 				ElementType: types.StringType,
@@ -197,13 +79,27 @@ func (r *serverAuctionOrderResource) Schema(_ context.Context, _ resource.Schema
 				Optional: true, ElementType: types.StringType,
 				Description: "Addon ids (e.g., primary_ipv4)",
 			},
-			"test": rschema.BoolAttribute{Optional: true, Description: "Dry-run order"},
+			"addon_quantities": addonQuantitiesAttribute(),
+			"test":             rschema.BoolAttribute{Optional: true, Description: "Dry-run order"},
+			"wait_for_ready": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Wait in Create until the transaction leaves \"in process\" so server_number and server_ip are known (default: false)",
+			},
+			"wait_timeout_minutes": rschema.Int64Attribute{
+				Optional:    true,
+				Description: "How long wait_for_ready waits before giving up (default: 60)",
+			},
 
-			"transaction_id": rschema.StringAttribute{Computed: true},
-			"status":         rschema.StringAttribute{Computed: true},
-			"server_number":  rschema.Int64Attribute{Computed: true},
-			"server_ip":      rschema.StringAttribute{Computed: true, Description: "The server's IP address (available when server is ready)"},
-			"id":             rschema.StringAttribute{Computed: true},
+			"transaction_id":   rschema.StringAttribute{Computed: true, PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()}},
+			"status":           rschema.StringAttribute{Computed: true},
+			"server_number":    rschema.Int64Attribute{Computed: true},
+			"server_ip":        rschema.StringAttribute{Computed: true, Description: "The server's IP address (available when server is ready)"},
+			"id":               rschema.StringAttribute{Computed: true, PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()}},
+			"effective_addons": effectiveAddonsAttribute(),
+
+			"created_at":         createdAtAttribute(),
+			"ordered_product_id": rschema.Int64Attribute{Computed: true, Description: "Auction product id as echoed by Robot in the order transaction"},
+			"datacenter":         datacenterAttribute(),
 		},
 	}
 }
@@ -222,23 +118,61 @@ func (r *serverAuctionOrderResource) Create(ctx context.Context, req resource.Cr
 		return
 	}
 
-	keys := mustStringSliceCreateAuction(ctx, resp, plan.Keys)
-	addons := mustStringSliceCreateAuction(ctx, resp, plan.Addons)
+	keys := tfutil.ElementsAsStrings(ctx, &resp.Diagnostics, plan.Keys)
+	addons := expandAddons(ctx, &resp.Diagnostics, plan.Addons, plan.AddonQty)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	tx, err := r.providerData.Client.OrderMarketServer(client.MarketOrderParams{
+	if dist := optStringAuction(plan.Dist); dist != nil {
+		dists, _, err := r.providerData.Client.GetProductDistributions(fmt.Sprintf("%d", plan.ProductID.ValueInt64()), true)
+		if err != nil {
+			resp.Diagnostics.AddError("read auction product distributions failed", robotErrorDetail(err, "order servers", "Ordering"))
+			return
+		}
+		if err := validateDist(*dist, dists); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("dist"), "invalid dist", err.Error())
+			return
+		}
+	}
+
+	params := client.MarketOrderParams{
 		ProductID: int(plan.ProductID.ValueInt64()),
+		Dist:      optStringAuction(plan.Dist),
+		Lang:      optStringAuction(plan.Lang),
+		Password:  optStringAuction(plan.Password),
 		Keys:      keys,
 		Addons:    addons,
 		Test:      !plan.Test.IsNull() && plan.Test.ValueBool(),
+	}
+	tx, err := retryRateLimited(ctx, "order market server", func() (*client.Transaction, error) {
+		return r.providerData.Client.OrderMarketServer(params)
 	})
 	if err != nil {
-		resp.Diagnostics.AddError("auction order failed", err.Error())
+		resp.Diagnostics.AddError("auction order failed", robotErrorDetail(err, "order servers", "Ordering"))
 		return
 	}
 
+	if plan.WaitForReady.ValueBool() && !plan.Test.ValueBool() {
+		timeout := 60 * time.Minute
+		if !plan.WaitTimeoutMinutes.IsNull() && !plan.WaitTimeoutMinutes.IsUnknown() && plan.WaitTimeoutMinutes.ValueInt64() > 0 {
+			timeout = time.Duration(plan.WaitTimeoutMinutes.ValueInt64()) * time.Minute
+		}
+		tflog.Info(ctx, "waiting for auction order to complete", map[string]interface{}{"transaction_id": tx.ID, "timeout": timeout.String()})
+
+		ready, err := client.Poll(ctx, r.providerData.PollOptions(timeout), func() (*client.Transaction, error) {
+			return r.providerData.Client.GetMarketOrderTransaction(tx.ID)
+		}, func(t *client.Transaction) bool { return !shouldRefreshMarketTransaction(t) })
+		if ready != nil {
+			tx = ready
+			r.providerData.TransactionCache.Set(client.TransactionTypeMarket, tx.ID, tx)
+		}
+		if err != nil {
+			// The order exists either way; keep it in state so it isn't placed twice
+			resp.Diagnostics.AddWarning("auction order not ready yet", fmt.Sprintf("Transaction %s is still %q: %v. It will be refreshed on the next plan.", tx.ID, tx.Status, err))
+		}
+	}
+
 	state := plan
 	state.ID = types.StringValue(tx.ID)
 	state.TransactionID = types.StringValue(tx.ID)
@@ -249,9 +183,15 @@ func (r *serverAuctionOrderResource) Create(ctx context.Context, req resource.Cr
 		state.ServerNumber = types.Int64Null()
 	}
 	state.ServerIP = types.StringValue(tx.ServerIP)
+	effective, diags := effectiveAddons(ctx, tx.Addons)
+	resp.Diagnostics.Append(diags...)
+	state.EffectiveAddons = effective
+	state.CreatedAt = transactionCreatedAt(tx)
+	state.OrderedProductID = orderedMarketProductID(tx)
+	state.Datacenter = transactionDatacenter(ctx, r.providerData, tx)
 
 	// Cache the transaction data
-	setCachedMarketTransaction(tx.ID, tx)
+	r.providerData.TransactionCache.Set(client.TransactionTypeMarket, tx.ID, tx)
 
 	tflog.Info(ctx, "created auction order", map[string]interface{}{"transaction_id": tx.ID})
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
@@ -269,51 +209,14 @@ func (r *serverAuctionOrderResource) Read(ctx context.Context, req resource.Read
 		return
 	}
 
-	transactionID := state.ID.ValueString()
-
-	// Try to get cached transaction first
-	cachedTx, found := getCachedMarketTransaction(transactionID)
-
-	var tx *client.Transaction
-	var err error
-
-	// Determine if we need to refresh the data
-	if found && !shouldRefreshMarketTransaction(cachedTx) {
-		// Use cached data - transaction is in final state
-		tx = cachedTx
-		tflog.Info(ctx, "Using cached market transaction data", map[string]interface{}{
-			"transaction_id": transactionID,
-			"status":         tx.Status,
-		})
-	} else {
-		// Make API call to get fresh data
-		if found {
-			tflog.Info(ctx, "Refreshing market transaction data (status is in process)", map[string]interface{}{
-				"transaction_id": transactionID,
-				"cached_status":  cachedTx.Status,
-			})
-		} else {
-			tflog.Info(ctx, "No cached data found, fetching market transaction", map[string]interface{}{
-				"transaction_id": transactionID,
-			})
-		}
-
-		tx, err = r.providerData.Client.GetMarketOrderTransaction(transactionID)
-		if client.IsNotFound(err) {
-			resp.State.RemoveResource(ctx)
-			return
-		}
-		if err != nil {
-			resp.Diagnostics.AddError("read market transaction", err.Error())
-			return
-		}
-
-		// Update cache with fresh data
-		setCachedMarketTransaction(transactionID, tx)
-		tflog.Info(ctx, "Updated market transaction cache", map[string]interface{}{
-			"transaction_id": transactionID,
-			"status":         tx.Status,
-		})
+	tx, err := readTransaction(ctx, r.providerData, client.TransactionTypeMarket, state.ID.ValueString(), r.providerData.Client.GetMarketOrderTransaction)
+	if client.IsNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("read market transaction", robotErrorDetail(err, "read order transactions", "Ordering"))
+		return
 	}
 
 	state.Status = types.StringValue(tx.Status)
@@ -323,6 +226,12 @@ func (r *serverAuctionOrderResource) Read(ctx context.Context, req resource.Read
 		state.ServerNumber = types.Int64Null()
 	}
 	state.ServerIP = types.StringValue(tx.ServerIP)
+	effective, diags := effectiveAddons(ctx, tx.Addons)
+	resp.Diagnostics.Append(diags...)
+	state.EffectiveAddons = effective
+	state.CreatedAt = transactionCreatedAt(tx)
+	state.OrderedProductID = orderedMarketProductID(tx)
+	state.Datacenter = transactionDatacenter(ctx, r.providerData, tx)
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
 }
@@ -344,16 +253,20 @@ func (r *serverAuctionOrderResource) Delete(ctx context.Context, req resource.De
 	tflog.Info(ctx, "server auction order resource deleted from state")
 }
 
-// helpers for auction orders
-func mustStringSliceCreateAuction(ctx context.Context, resp *resource.CreateResponse, l types.List) []string {
-	if l.IsNull() || l.IsUnknown() {
-		return nil
+func (r *serverAuctionOrderResource) ConfigValidators(_ context.Context) []resource.ConfigValidator {
+	return []resource.ConfigValidator{orderCredentialsValidator{}}
+}
+
+func (r *serverAuctionOrderResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var config serverAuctionOrderModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
-	var out []string
-	resp.Diagnostics.Append(l.ElementsAs(ctx, &out, false)...)
-	return out
+	validateAddonQuantities(ctx, &resp.Diagnostics, config.AddonQty)
 }
 
+// helpers for auction orders
 func optStringAuction(v types.String) *string {
 	if v.IsNull() || v.IsUnknown() {
 		return nil
@@ -2,18 +2,20 @@ package provider
 
 import (
 	"context"
-	"encoding/json"
-	"os"
-	"path/filepath"
-	"sync"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/mokto/terraform-provider-hrobot/internal/client"
+	"github.com/mokto/terraform-provider-hrobot/internal/tfutil"
 )
 
 type serverOrderResource struct {
@@ -28,151 +30,83 @@ type serverOrderModel struct {
 	Keys      types.List   `tfsdk:"authorized_key_fingerprints"`
 	Password  types.String `tfsdk:"password"`
 	Addons    types.List   `tfsdk:"addons"`
+	AddonQty  types.List   `tfsdk:"addon_quantities"`
 	Test      types.Bool   `tfsdk:"test"`
 
+	VerifyKeys         types.Bool  `tfsdk:"verify_authorized_keys"`
+	WaitForReady       types.Bool  `tfsdk:"wait_for_ready"`
+	WaitTimeoutMinutes types.Int64 `tfsdk:"wait_timeout_minutes"`
+
 	TransactionID types.String `tfsdk:"transaction_id"`
 	Status        types.String `tfsdk:"status"`
 	ServerNumber  types.Int64  `tfsdk:"server_number"`
 	ServerIP      types.String `tfsdk:"server_ip"`
-}
-
-// Cache entry for transaction data
-type transactionCacheEntry struct {
-	transaction *client.Transaction
-	lastUpdated time.Time
-}
-
-// JSON-serializable cache entry
-type jsonCacheEntry struct {
-	Transaction *client.Transaction `json:"transaction"`
-	LastUpdated string              `json:"last_updated"`
-}
-
-// Global cache for transaction data to avoid hitting API rate limits
-var (
-	transactionCache = make(map[string]*transactionCacheEntry)
-	cacheMutex       sync.RWMutex
-	cacheExpiry      = 5 * time.Minute // Cache expires after 5 minutes
-	cacheFile        = getCacheFilePath()
-)
 
-// getCacheFilePath returns the path to the cache file in the .cache directory
-func getCacheFilePath() string {
-	// Get the current working directory (should be the repository root)
-	wd, err := os.Getwd()
-	if err != nil {
-		// Fallback to temp directory if we can't get working directory
-		return filepath.Join(os.TempDir(), "terraform-provider-hrobot-cache.json")
-	}
+	EffectiveAddons types.List `tfsdk:"effective_addons"`
 
-	// Create .cache directory if it doesn't exist
-	cacheDir := filepath.Join(wd, ".cache")
-	os.MkdirAll(cacheDir, 0755)
-
-	return filepath.Join(cacheDir, "transaction-cache.json")
+	CreatedAt        types.String `tfsdk:"created_at"`
+	OrderedProductID types.String `tfsdk:"ordered_product_id"`
+	Datacenter       types.String `tfsdk:"datacenter"`
 }
 
 func NewResourceServerOrder() resource.Resource {
-	// Load cache from disk on startup
-	loadCacheFromDisk()
 	return &serverOrderResource{}
 }
 
-// loadCacheFromDisk loads the cache from disk
-func loadCacheFromDisk() {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
-
-	data, err := os.ReadFile(cacheFile)
-	if err != nil {
-		// Cache file doesn't exist or can't be read, start with empty cache
-		return
-	}
-
-	var diskCache map[string]*jsonCacheEntry
-	if err := json.Unmarshal(data, &diskCache); err != nil {
-		// Invalid cache file, start with empty cache
-		return
-	}
-
-	// Only load non-expired entries
-	now := time.Now()
-	for id, jsonEntry := range diskCache {
-		lastUpdated, err := time.Parse(time.RFC3339, jsonEntry.LastUpdated)
-		if err != nil {
-			continue // Skip invalid timestamp
-		}
-
-		if now.Sub(lastUpdated) <= cacheExpiry {
-			transactionCache[id] = &transactionCacheEntry{
-				transaction: jsonEntry.Transaction,
-				lastUpdated: lastUpdated,
-			}
-		}
-	}
+// shouldRefreshTransaction determines if we need to refresh the transaction data
+func shouldRefreshTransaction(transaction *client.Transaction) bool {
+	return transaction == nil || !transactionFinal(transaction.Status)
 }
 
-// saveCacheToDisk saves the cache to disk
-func saveCacheToDisk() {
-	cacheMutex.RLock()
-	defer cacheMutex.RUnlock()
-
-	// Convert to JSON-serializable format
-	jsonCache := make(map[string]*jsonCacheEntry)
-	for id, entry := range transactionCache {
-		jsonCache[id] = &jsonCacheEntry{
-			Transaction: entry.transaction,
-			LastUpdated: entry.lastUpdated.Format(time.RFC3339),
-		}
-	}
-
-	data, err := json.Marshal(jsonCache)
-	if err != nil {
-		return
+// transactionFinal reports whether an order transaction status is terminal. Only the documented
+// "ready" and "cancelled" are; anything else ("in process", "in progress", "incomplete", localized
+// or unknown values) is kept refreshing rather than cached forever
+func transactionFinal(status string) bool {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "ready", "cancelled", "canceled":
+		return true
 	}
-
-	os.WriteFile(cacheFile, data, 0600)
+	return false
 }
 
-// getCachedTransaction retrieves transaction from cache if available and not expired
-func getCachedTransaction(id string) (*client.Transaction, bool) {
-	cacheMutex.RLock()
-	defer cacheMutex.RUnlock()
-
-	entry, exists := transactionCache[id]
-	if !exists {
-		return nil, false
-	}
-
-	// Check if cache entry is expired
-	if time.Since(entry.lastUpdated) > cacheExpiry {
-		return nil, false
+// readTransaction returns the cached transaction once its status is final and fetches it from
+// Robot otherwise. Unlike wait_for_ready it fetches only once instead of using client.Poll: a
+// plan shouldn't block on an order Robot is still processing, the next refresh picks up the
+// new status
+func readTransaction(ctx context.Context, pd *ProviderData, txType, id string, fetch func(id string) (*client.Transaction, error)) (*client.Transaction, error) {
+	cachedTx, found := pd.TransactionCache.Get(txType, id)
+	if found && !shouldRefreshTransaction(cachedTx) {
+		tflog.Info(ctx, "Using cached transaction data", map[string]interface{}{
+			"transaction_type": txType,
+			"transaction_id":   id,
+			"status":           cachedTx.Status,
+		})
+		return cachedTx, nil
 	}
-
-	return entry.transaction, true
-}
-
-// setCachedTransaction stores transaction in cache
-func setCachedTransaction(id string, transaction *client.Transaction) {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
-
-	transactionCache[id] = &transactionCacheEntry{
-		transaction: transaction,
-		lastUpdated: time.Now(),
+	if found {
+		tflog.Info(ctx, "Refreshing transaction data (status is not final)", map[string]interface{}{
+			"transaction_type": txType,
+			"transaction_id":   id,
+			"cached_status":    cachedTx.Status,
+		})
+	} else {
+		tflog.Info(ctx, "No cached data found, fetching transaction", map[string]interface{}{
+			"transaction_type": txType,
+			"transaction_id":   id,
+		})
 	}
 
-	// Save to disk asynchronously
-	go saveCacheToDisk()
-}
-
-// shouldRefreshTransaction determines if we need to refresh the transaction data
-func shouldRefreshTransaction(transaction *client.Transaction) bool {
-	if transaction == nil {
-		return true
+	tx, err := fetch(id)
+	if err != nil {
+		return nil, err
 	}
-	// Only refresh if status is "in process" - other statuses are final
-	return transaction.Status == "in process"
+	pd.TransactionCache.Set(txType, id, tx)
+	tflog.Info(ctx, "Updated transaction cache", map[string]interface{}{
+		"transaction_type": txType,
+		"transaction_id":   id,
+		"status":           tx.Status,
+	})
+	return tx, nil
 }
 
 func (r *serverOrderResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -191,6 +125,10 @@ func (r *serverOrderResource) Schema(_ context.Context, _ resource.SchemaRequest
 				ElementType: types.StringType,
 				Description: "Authorized key fingerprints stored in Robot",
 			},
+			"verify_authorized_keys": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Check that every authorized_key_fingerprints entry exists in Robot before ordering, instead of failing on KEY_NOT_FOUND after the order request (default: true)",
+			},
 			"password": rschema.StringAttribute{
 				Optional: true, Sensitive: true,
 				Description: "Root password alternative to keys",
@@ -199,13 +137,27 @@ func (r *serverOrderResource) Schema(_ context.Context, _ resource.SchemaRequest
 				Optional: true, ElementType: types.StringType,
 				Description: "Addon ids (e.g., primary_ipv4)",
 			},
-			"test": rschema.BoolAttribute{Optional: true, Description: "Dry-run order"},
+			"addon_quantities": addonQuantitiesAttribute(),
+			"test":             rschema.BoolAttribute{Optional: true, Description: "Dry-run order"},
+			"wait_for_ready": rschema.BoolAttribute{
+				Optional:    true,
+				Description: "Wait in Create until the transaction leaves \"in process\" so server_number and server_ip are known (default: false)",
+			},
+			"wait_timeout_minutes": rschema.Int64Attribute{
+				Optional:    true,
+				Description: "How long wait_for_ready waits before giving up (default: 60)",
+			},
+
+			"transaction_id":   rschema.StringAttribute{Computed: true, PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()}},
+			"status":           rschema.StringAttribute{Computed: true},
+			"server_number":    rschema.Int64Attribute{Computed: true},
+			"server_ip":        rschema.StringAttribute{Computed: true, Description: "The server's IP address (available when server is ready)"},
+			"id":               rschema.StringAttribute{Computed: true, PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()}},
+			"effective_addons": effectiveAddonsAttribute(),
 
-			"transaction_id": rschema.StringAttribute{Computed: true},
-			"status":         rschema.StringAttribute{Computed: true},
-			"server_number":  rschema.Int64Attribute{Computed: true},
-			"server_ip":      rschema.StringAttribute{Computed: true, Description: "The server's IP address (available when server is ready)"},
-			"id":             rschema.StringAttribute{Computed: true},
+			"created_at":         createdAtAttribute(),
+			"ordered_product_id": rschema.StringAttribute{Computed: true, Description: "Product id as echoed by Robot in the order transaction"},
+			"datacenter":         datacenterAttribute(),
 		},
 	}
 }
@@ -224,13 +176,38 @@ func (r *serverOrderResource) Create(ctx context.Context, req resource.CreateReq
 		return
 	}
 
-	keys := mustStringSliceCreate(ctx, resp, plan.Keys)
-	addons := mustStringSliceCreate(ctx, resp, plan.Addons)
+	keys := tfutil.ElementsAsStrings(ctx, &resp.Diagnostics, plan.Keys)
+	addons := expandAddons(ctx, &resp.Diagnostics, plan.Addons, plan.AddonQty)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	tx, err := r.providerData.Client.OrderServer(client.OrderParams{
+	if len(keys) > 0 && (plan.VerifyKeys.IsNull() || plan.VerifyKeys.ValueBool()) {
+		stored, err := r.providerData.Client.ListSSHKeys()
+		if err != nil {
+			resp.Diagnostics.AddError("read SSH keys failed", robotErrorDetail(err, "list SSH keys", "Key"))
+			return
+		}
+		if unknown := unknownFingerprints(keys, stored); len(unknown) > 0 {
+			resp.Diagnostics.AddAttributeError(path.Root("authorized_key_fingerprints"), "unknown SSH key fingerprints",
+				fmt.Sprintf("Robot has no SSH key with fingerprint %s. Add the keys in Robot (or with an SSH key resource) before ordering, or set verify_authorized_keys = false.", strings.Join(unknown, ", ")))
+			return
+		}
+	}
+
+	if dist := optString(plan.Dist); dist != nil {
+		dists, _, err := r.providerData.Client.GetProductDistributions(plan.ProductID.ValueString(), false)
+		if err != nil {
+			resp.Diagnostics.AddError("read product distributions failed", robotErrorDetail(err, "order servers", "Ordering"))
+			return
+		}
+		if err := validateDist(*dist, dists); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("dist"), "invalid dist", err.Error())
+			return
+		}
+	}
+
+	params := client.OrderParams{
 		ProductID: plan.ProductID.ValueString(),
 		Dist:      optString(plan.Dist),
 		Location:  optString(plan.Location),
@@ -238,12 +215,35 @@ func (r *serverOrderResource) Create(ctx context.Context, req resource.CreateReq
 		Keys:      keys,
 		Addons:    addons,
 		Test:      !plan.Test.IsNull() && plan.Test.ValueBool(),
+	}
+	tx, err := retryRateLimited(ctx, "order server", func() (*client.Transaction, error) {
+		return r.providerData.Client.OrderServer(params)
 	})
 	if err != nil {
-		resp.Diagnostics.AddError("order failed", err.Error())
+		resp.Diagnostics.AddError("order failed", robotErrorDetail(err, "order servers", "Ordering"))
 		return
 	}
 
+	if plan.WaitForReady.ValueBool() && !plan.Test.ValueBool() {
+		timeout := 60 * time.Minute
+		if !plan.WaitTimeoutMinutes.IsNull() && !plan.WaitTimeoutMinutes.IsUnknown() && plan.WaitTimeoutMinutes.ValueInt64() > 0 {
+			timeout = time.Duration(plan.WaitTimeoutMinutes.ValueInt64()) * time.Minute
+		}
+		tflog.Info(ctx, "waiting for order to complete", map[string]interface{}{"transaction_id": tx.ID, "timeout": timeout.String()})
+
+		ready, err := client.Poll(ctx, r.providerData.PollOptions(timeout), func() (*client.Transaction, error) {
+			return r.providerData.Client.GetOrderTransaction(tx.ID)
+		}, func(t *client.Transaction) bool { return !shouldRefreshTransaction(t) })
+		if ready != nil {
+			tx = ready
+			r.providerData.TransactionCache.Set(client.TransactionTypeOrder, tx.ID, tx)
+		}
+		if err != nil {
+			// The order exists either way; keep it in state so it isn't placed twice
+			resp.Diagnostics.AddWarning("order not ready yet", fmt.Sprintf("Transaction %s is still %q: %v. It will be refreshed on the next plan.", tx.ID, tx.Status, err))
+		}
+	}
+
 	state := plan
 	state.ID = types.StringValue(tx.ID)
 	state.TransactionID = types.StringValue(tx.ID)
@@ -254,9 +254,15 @@ func (r *serverOrderResource) Create(ctx context.Context, req resource.CreateReq
 		state.ServerNumber = types.Int64Null()
 	}
 	state.ServerIP = types.StringValue(tx.ServerIP)
+	effective, diags := effectiveAddons(ctx, tx.Addons)
+	resp.Diagnostics.Append(diags...)
+	state.EffectiveAddons = effective
+	state.CreatedAt = transactionCreatedAt(tx)
+	state.OrderedProductID = orderedProductID(tx)
+	state.Datacenter = transactionDatacenter(ctx, r.providerData, tx)
 
 	// Cache the transaction data
-	setCachedTransaction(tx.ID, tx)
+	r.providerData.TransactionCache.Set(client.TransactionTypeOrder, tx.ID, tx)
 
 	tflog.Info(ctx, "created order", map[string]interface{}{"transaction_id": tx.ID})
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
@@ -274,51 +280,14 @@ func (r *serverOrderResource) Read(ctx context.Context, req resource.ReadRequest
 		return
 	}
 
-	transactionID := state.ID.ValueString()
-
-	// Try to get cached transaction first
-	cachedTx, found := getCachedTransaction(transactionID)
-
-	var tx *client.Transaction
-	var err error
-
-	// Determine if we need to refresh the data
-	if found && !shouldRefreshTransaction(cachedTx) {
-		// Use cached data - transaction is in final state
-		tx = cachedTx
-		tflog.Info(ctx, "Using cached transaction data", map[string]interface{}{
-			"transaction_id": transactionID,
-			"status":         tx.Status,
-		})
-	} else {
-		// Make API call to get fresh data
-		if found {
-			tflog.Info(ctx, "Refreshing transaction data (status is in process)", map[string]interface{}{
-				"transaction_id": transactionID,
-				"cached_status":  cachedTx.Status,
-			})
-		} else {
-			tflog.Info(ctx, "No cached data found, fetching transaction", map[string]interface{}{
-				"transaction_id": transactionID,
-			})
-		}
-
-		tx, err = r.providerData.Client.GetOrderTransaction(transactionID)
-		if client.IsNotFound(err) {
-			resp.State.RemoveResource(ctx)
-			return
-		}
-		if err != nil {
-			resp.Diagnostics.AddError("read transaction", err.Error())
-			return
-		}
-
-		// Update cache with fresh data
-		setCachedTransaction(transactionID, tx)
-		tflog.Info(ctx, "Updated transaction cache", map[string]interface{}{
-			"transaction_id": transactionID,
-			"status":         tx.Status,
-		})
+	tx, err := readTransaction(ctx, r.providerData, client.TransactionTypeOrder, state.ID.ValueString(), r.providerData.Client.GetOrderTransaction)
+	if client.IsNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("read transaction", robotErrorDetail(err, "read order transactions", "Ordering"))
+		return
 	}
 
 	state.Status = types.StringValue(tx.Status)
@@ -328,6 +297,12 @@ func (r *serverOrderResource) Read(ctx context.Context, req resource.ReadRequest
 		state.ServerNumber = types.Int64Null()
 	}
 	state.ServerIP = types.StringValue(tx.ServerIP)
+	effective, diags := effectiveAddons(ctx, tx.Addons)
+	resp.Diagnostics.Append(diags...)
+	state.EffectiveAddons = effective
+	state.CreatedAt = transactionCreatedAt(tx)
+	state.OrderedProductID = orderedProductID(tx)
+	state.Datacenter = transactionDatacenter(ctx, r.providerData, tx)
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
 }
@@ -349,23 +324,64 @@ func (r *serverOrderResource) Delete(ctx context.Context, req resource.DeleteReq
 	tflog.Info(ctx, "server order resource deleted from state")
 }
 
-// helpers
-func mustStringSliceCreate(ctx context.Context, resp *resource.CreateResponse, l types.List) []string {
-	if l.IsNull() || l.IsUnknown() {
-		return nil
+func (r *serverOrderResource) ConfigValidators(_ context.Context) []resource.ConfigValidator {
+	return []resource.ConfigValidator{orderCredentialsValidator{}}
+}
+
+// ValidateConfig checks addon_quantities and warns about fingerprints that can't be Robot key
+// fingerprints, which Robot only rejects once the order is placed
+func (r *serverOrderResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var config serverOrderModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	validateAddonQuantities(ctx, &resp.Diagnostics, config.AddonQty)
+	if config.Keys.IsUnknown() {
+		return
+	}
+	var keys []types.String
+	config.Keys.ElementsAs(ctx, &keys, false)
+	for i, k := range keys {
+		if k.IsNull() || k.IsUnknown() || md5Fingerprint.MatchString(k.ValueString()) {
+			continue
+		}
+		resp.Diagnostics.AddAttributeWarning(path.Root("authorized_key_fingerprints").AtListIndex(i), "Unexpected SSH key fingerprint",
+			fmt.Sprintf("%q is not an MD5 fingerprint like 56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10, which is how Robot identifies SSH keys; the order will likely fail with KEY_NOT_FOUND.", k.ValueString()))
 	}
-	var out []string
-	resp.Diagnostics.Append(l.ElementsAs(ctx, &out, false)...)
-	return out
 }
-func mustStringSliceUpdate(ctx context.Context, resp *resource.UpdateResponse, l types.List) []string {
-	if l.IsNull() || l.IsUnknown() {
-		return nil
+
+// md5Fingerprint matches the fingerprints Robot lists SSH keys by
+var md5Fingerprint = regexp.MustCompile(`^[0-9a-f]{2}(:[0-9a-f]{2}){15}$`)
+
+// unknownFingerprints returns the fingerprints that are not among the keys stored in Robot
+func unknownFingerprints(fingerprints []string, stored []client.SSHKey) []string {
+	known := make(map[string]bool, len(stored))
+	for _, k := range stored {
+		known[strings.ToLower(k.Fingerprint)] = true
 	}
-	var out []string
-	resp.Diagnostics.Append(l.ElementsAs(ctx, &out, false)...)
-	return out
+	var unknown []string
+	for _, fp := range fingerprints {
+		if !known[strings.ToLower(fp)] {
+			unknown = append(unknown, fp)
+		}
+	}
+	return unknown
 }
+
+// validateDist checks dist against the distributions Robot offers for the ordered product
+func validateDist(dist string, allowed []string) error {
+	if len(allowed) == 0 {
+		return fmt.Errorf("dist %q was requested but Robot offers no preinstall distributions for this product", dist)
+	}
+	for _, d := range allowed {
+		if d == dist {
+			return nil
+		}
+	}
+	return fmt.Errorf("dist %q is not offered for this product; allowed values: %s", dist, strings.Join(allowed, ", "))
+}
+
 func optString(v types.String) *string {
 	if v.IsNull() || v.IsUnknown() {
 		return nil
@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+func TestServerOrderVerifiesAuthorizedKeys(t *testing.T) {
+	ctx := context.Background()
+	var ordered int32
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/key":
+			_, _ = w.Write([]byte(`[{"key":{"name":"laptop","fingerprint":"56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10"}}]`))
+		case r.Method == http.MethodPost && r.URL.Path == "/order/server/transaction":
+			atomic.AddInt32(&ordered, 1)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"transaction":{"id":"B20250101-1","status":"in process"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	pd.TransactionCache = NewTransactionCache(filepath.Join(t.TempDir(), "transaction-cache.json"), "")
+	res := &serverOrderResource{providerData: pd}
+
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	objType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	raw := func(fingerprints ...string) tftypes.Value {
+		vals := map[string]tftypes.Value{}
+		for name, typ := range objType.AttributeTypes {
+			vals[name] = tftypes.NewValue(typ, nil)
+		}
+		vals["product_id"] = tftypes.NewValue(tftypes.String, "EX44")
+		var fps []tftypes.Value
+		for _, fp := range fingerprints {
+			fps = append(fps, tftypes.NewValue(tftypes.String, fp))
+		}
+		vals["authorized_key_fingerprints"] = tftypes.NewValue(tftypes.List{ElementType: tftypes.String}, fps)
+		return tftypes.NewValue(objType, vals)
+	}
+	create := func(v tftypes.Value) diag.Diagnostics {
+		req := resource.CreateRequest{Plan: tfsdk.Plan{Schema: schemaResp.Schema, Raw: v}}
+		resp := resource.CreateResponse{State: tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objType, nil)}}
+		res.Create(ctx, req, &resp)
+		return resp.Diagnostics
+	}
+
+	diags := create(raw("56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10", "aa:bb:cc:dd:ee:ff:00:11:22:33:44:55:66:77:88:99"))
+	if !diags.HasError() || !strings.Contains(diags.Errors()[0].Detail(), "aa:bb:cc:dd:ee:ff:00:11:22:33:44:55:66:77:88:99") {
+		t.Fatalf("expected the unknown fingerprint to be reported, got %v", diags)
+	}
+	if strings.Contains(diags.Errors()[0].Detail(), "56:29") {
+		t.Fatalf("known fingerprint reported as unknown: %v", diags)
+	}
+	if atomic.LoadInt32(&ordered) != 0 {
+		t.Fatal("the order was placed despite an unknown key")
+	}
+
+	if diags := create(raw("56:29:99:A4:5D:ED:AC:95:C1:F5:88:82:90:5D:DD:10")); diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+	if atomic.LoadInt32(&ordered) != 1 {
+		t.Fatal("the order was not placed")
+	}
+
+	var validateResp resource.ValidateConfigResponse
+	res.ValidateConfig(ctx, resource.ValidateConfigRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: raw("56:29:99:a4:5d:ed:ac:95:c1:f5:88:82:90:5d:dd:10", "SHA256:abc")}}, &validateResp)
+	if validateResp.Diagnostics.HasError() || len(validateResp.Diagnostics.Warnings()) != 1 {
+		t.Fatalf("expected one warning for the SHA256 fingerprint, got %v", validateResp.Diagnostics)
+	}
+}
+
+func TestShouldRefreshTransaction(t *testing.T) {
+	cases := map[string]bool{
+		"ready":           false,
+		"Ready":           false,
+		" READY ":         false,
+		"cancelled":       false,
+		"Cancelled":       false,
+		"canceled":        false,
+		"in process":      true,
+		"In Process":      true,
+		"in progress":     true,
+		"incomplete":      true,
+		"in Bearbeitung":  true,
+		"abgeschlossen":   true,
+		"":                true,
+		"ready for setup": true,
+	}
+	for status, want := range cases {
+		tx := &client.Transaction{Status: status}
+		if got := shouldRefreshTransaction(tx); got != want {
+			t.Errorf("shouldRefreshTransaction(%q) = %v, want %v", status, got, want)
+		}
+		if got := shouldRefreshMarketTransaction(tx); got != want {
+			t.Errorf("shouldRefreshMarketTransaction(%q) = %v, want %v", status, got, want)
+		}
+	}
+	if !shouldRefreshTransaction(nil) || !shouldRefreshMarketTransaction(nil) {
+		t.Fatal("a missing transaction must be refreshed")
+	}
+}
+
+func TestReadTransactionFetchesUntilFinal(t *testing.T) {
+	ctx := context.Background()
+	pd := &ProviderData{TransactionCache: NewTransactionCache(filepath.Join(t.TempDir(), "cache.json"), "test")}
+	status := "in process"
+	var fetches int
+	fetch := func(id string) (*client.Transaction, error) {
+		fetches++
+		return &client.Transaction{ID: id, Status: status}, nil
+	}
+
+	for i, want := range []int{1, 2, 3, 3} {
+		if i == 2 {
+			status = "ready"
+		}
+		tx, err := readTransaction(ctx, pd, client.TransactionTypeOrder, "B1", fetch)
+		if err != nil || fetches != want {
+			t.Fatalf("read %d: expected %d fetches, got %d (%v, %v)", i, want, fetches, tx, err)
+		}
+	}
+}
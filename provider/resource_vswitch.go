@@ -5,8 +5,15 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/identityschema"
 	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/boolplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/mokto/terraform-provider-hrobot/internal/client"
@@ -17,9 +24,17 @@ type vswitchResource struct {
 }
 
 type vswitchModel struct {
-	ID   types.Int64  `tfsdk:"id"`
-	VLAN types.Int64  `tfsdk:"vlan"`
-	Name types.String `tfsdk:"name"`
+	ID          types.Int64  `tfsdk:"id"`
+	VLAN        types.Int64  `tfsdk:"vlan"`
+	Name        types.String `tfsdk:"name"`
+	Description types.String `tfsdk:"description"`
+	Cancelled   types.Bool   `tfsdk:"cancelled"`
+}
+
+// vswitchIdentityModel is the resource identity: the vSwitch ID, which Robot never changes, so
+// Terraform 1.12+ can import by identity and tell a renamed vSwitch from a replaced one
+type vswitchIdentityModel struct {
+	ID types.Int64 `tfsdk:"id"`
 }
 
 func NewResourceVSwitch() resource.Resource {
@@ -35,9 +50,11 @@ func (r *vswitchResource) Schema(_ context.Context, _ resource.SchemaRequest, re
 		Description: "Manages a Hetzner Robot virtual switch (vSwitch).",
 		Attributes: map[string]rschema.Attribute{
 			"id": rschema.Int64Attribute{
-				Computed:    true,
-				Description: "The unique ID of the vSwitch.",
+				Computed:      true,
+				Description:   "The unique ID of the vSwitch.",
+				PlanModifiers: []planmodifier.Int64{int64planmodifier.UseStateForUnknown()},
 			},
+			// vlan and name are updated in place, and Read picks up changes made in Robot
 			"vlan": rschema.Int64Attribute{
 				Required:    true,
 				Description: "The VLAN ID for the vSwitch (e.g., 4000).",
@@ -46,10 +63,65 @@ func (r *vswitchResource) Schema(_ context.Context, _ resource.SchemaRequest, re
 				Required:    true,
 				Description: "The name of the vSwitch.",
 			},
+			"description": rschema.StringAttribute{
+				Optional:    true,
+				Description: "A description of the vSwitch. It is sent to Robot; when Robot doesn't return it, it is only kept in the Terraform state.",
+			},
+			// A vSwitch cancelled in Robot is removed from state on refresh, so this is false in state
+			"cancelled": rschema.BoolAttribute{
+				Computed:      true,
+				Description:   "Whether Robot reports the vSwitch as cancelled. A cancelled vSwitch is removed from the state on refresh, like a deleted one, so the next apply creates a new one.",
+				PlanModifiers: []planmodifier.Bool{boolplanmodifier.UseStateForUnknown()},
+			},
+		},
+	}
+}
+
+func (r *vswitchResource) IdentitySchema(_ context.Context, _ resource.IdentitySchemaRequest, resp *resource.IdentitySchemaResponse) {
+	resp.IdentitySchema = identityschema.Schema{
+		Attributes: map[string]identityschema.Attribute{
+			"id": identityschema.Int64Attribute{
+				RequiredForImport: true,
+				Description:       "The unique ID of the vSwitch.",
+			},
 		},
 	}
 }
 
+// setVSwitchIdentity records the identity; identity is nil when Terraform doesn't support it
+func setVSwitchIdentity(ctx context.Context, identity *tfsdk.ResourceIdentity, diags *diag.Diagnostics, id types.Int64) {
+	if identity == nil {
+		return
+	}
+	diags.Append(identity.Set(ctx, vswitchIdentityModel{ID: id})...)
+}
+
+// vswitchDescription returns the description to send to Robot, nil when none is set. Removing a
+// description sends an empty one so Robot clears it
+func vswitchDescription(plan, state types.String) *string {
+	if !plan.IsNull() && !plan.IsUnknown() {
+		description := plan.ValueString()
+		return &description
+	}
+	if !state.IsNull() && !state.IsUnknown() {
+		description := ""
+		return &description
+	}
+	return nil
+}
+
+// robotDescription returns the description Robot reports for the vSwitch, or local when Robot
+// doesn't return the field; an empty description counts as none
+func robotDescription(vswitch *client.VSwitch, local types.String) types.String {
+	if vswitch.Description == nil {
+		return local
+	}
+	if *vswitch.Description == "" {
+		return types.StringNull()
+	}
+	return types.StringValue(*vswitch.Description)
+}
+
 func (r *vswitchResource) Configure(_ context.Context, req resource.ConfigureRequest, _ *resource.ConfigureResponse) {
 	if req.ProviderData == nil {
 		return
@@ -64,16 +136,18 @@ func (r *vswitchResource) Create(ctx context.Context, req resource.CreateRequest
 		return
 	}
 
-	vswitch, err := r.providerData.Client.CreateVSwitch(int(plan.VLAN.ValueInt64()), plan.Name.ValueString())
+	vswitch, err := r.providerData.Client.CreateVSwitch(int(plan.VLAN.ValueInt64()), plan.Name.ValueString(), vswitchDescription(plan.Description, types.StringNull()))
 	if err != nil {
-		resp.Diagnostics.AddError("Failed to create vSwitch", err.Error())
+		resp.Diagnostics.AddError("Failed to create vSwitch", robotErrorDetail(err, "manage vSwitches", "vSwitch"))
 		return
 	}
 
 	state := vswitchModel{
-		ID:   types.Int64Value(int64(vswitch.ID)),
-		VLAN: types.Int64Value(int64(vswitch.VLAN)),
-		Name: types.StringValue(vswitch.Name),
+		ID:          types.Int64Value(int64(vswitch.ID)),
+		VLAN:        types.Int64Value(int64(vswitch.VLAN)),
+		Name:        types.StringValue(vswitch.Name),
+		Description: robotDescription(vswitch, plan.Description),
+		Cancelled:   types.BoolValue(vswitch.Cancelled),
 	}
 
 	tflog.Info(ctx, "Created vSwitch", map[string]interface{}{
@@ -83,6 +157,7 @@ func (r *vswitchResource) Create(ctx context.Context, req resource.CreateRequest
 	})
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+	setVSwitchIdentity(ctx, resp.Identity, &resp.Diagnostics, state.ID)
 }
 
 func (r *vswitchResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
@@ -103,12 +178,22 @@ func (r *vswitchResource) Read(ctx context.Context, req resource.ReadRequest, re
 		return
 	}
 	if err != nil {
-		resp.Diagnostics.AddError("Failed to read vSwitch", err.Error())
+		resp.Diagnostics.AddError("Failed to read vSwitch", robotErrorDetail(err, "manage vSwitches", "vSwitch"))
+		return
+	}
+	if vswitch.Cancelled {
+		// Servers can't be attached to a cancelled vSwitch, so it counts as deleted
+		tflog.Warn(ctx, "vSwitch was cancelled in Robot, removing it from state", map[string]interface{}{
+			"id": vswitch.ID,
+		})
+		resp.State.RemoveResource(ctx)
 		return
 	}
 
 	state.VLAN = types.Int64Value(int64(vswitch.VLAN))
 	state.Name = types.StringValue(vswitch.Name)
+	state.Description = robotDescription(vswitch, state.Description)
+	state.Cancelled = types.BoolValue(false)
 
 	tflog.Info(ctx, "Read vSwitch", map[string]interface{}{
 		"id":   vswitch.ID,
@@ -117,6 +202,7 @@ func (r *vswitchResource) Read(ctx context.Context, req resource.ReadRequest, re
 	})
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+	setVSwitchIdentity(ctx, resp.Identity, &resp.Diagnostics, state.ID)
 }
 
 func (r *vswitchResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
@@ -137,14 +223,16 @@ func (r *vswitchResource) Update(ctx context.Context, req resource.UpdateRequest
 		return
 	}
 
-	vswitch, err := r.providerData.Client.UpdateVSwitch(int(state.ID.ValueInt64()), int(plan.VLAN.ValueInt64()), plan.Name.ValueString())
+	vswitch, err := r.providerData.Client.UpdateVSwitch(int(state.ID.ValueInt64()), int(plan.VLAN.ValueInt64()), plan.Name.ValueString(), vswitchDescription(plan.Description, state.Description))
 	if err != nil {
-		resp.Diagnostics.AddError("Failed to update vSwitch", err.Error())
+		resp.Diagnostics.AddError("Failed to update vSwitch", robotErrorDetail(err, "manage vSwitches", "vSwitch"))
 		return
 	}
 
 	state.VLAN = types.Int64Value(int64(vswitch.VLAN))
 	state.Name = types.StringValue(vswitch.Name)
+	state.Description = robotDescription(vswitch, plan.Description)
+	state.Cancelled = types.BoolValue(vswitch.Cancelled)
 
 	tflog.Info(ctx, "Updated vSwitch", map[string]interface{}{
 		"id":   vswitch.ID,
@@ -153,6 +241,7 @@ func (r *vswitchResource) Update(ctx context.Context, req resource.UpdateRequest
 	})
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+	setVSwitchIdentity(ctx, resp.Identity, &resp.Diagnostics, state.ID)
 }
 
 func (r *vswitchResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
@@ -169,7 +258,7 @@ func (r *vswitchResource) Delete(ctx context.Context, req resource.DeleteRequest
 
 	err := r.providerData.Client.DeleteVSwitch(int(state.ID.ValueInt64()))
 	if err != nil {
-		resp.Diagnostics.AddError("Failed to delete vSwitch", err.Error())
+		resp.Diagnostics.AddError("Failed to delete vSwitch", robotErrorDetail(err, "manage vSwitches", "vSwitch"))
 		return
 	}
 
@@ -178,24 +267,44 @@ func (r *vswitchResource) Delete(ctx context.Context, req resource.DeleteRequest
 	})
 }
 
+// ImportState imports by ID (terraform import, or an import block with id) or, on Terraform
+// 1.12+, by an import block with identity
 func (r *vswitchResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	id, err := strconv.Atoi(req.ID)
-	if err != nil {
-		resp.Diagnostics.AddError("Invalid vSwitch ID", fmt.Sprintf("Expected integer, got: %s", req.ID))
-		return
+	var id int
+	if req.ID == "" && req.Identity != nil {
+		var identity types.Int64
+		resp.Diagnostics.Append(req.Identity.GetAttribute(ctx, path.Root("id"), &identity)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+		id = int(identity.ValueInt64())
+	} else {
+		var err error
+		id, err = strconv.Atoi(req.ID)
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid vSwitch ID", fmt.Sprintf("Expected integer, got: %s", req.ID))
+			return
+		}
 	}
 
 	vswitch, err := r.providerData.Client.GetVSwitch(id)
 	if err != nil {
-		resp.Diagnostics.AddError("Failed to import vSwitch", err.Error())
+		resp.Diagnostics.AddError("Failed to import vSwitch", robotErrorDetail(err, "manage vSwitches", "vSwitch"))
+		return
+	}
+	if vswitch.Cancelled {
+		resp.Diagnostics.AddError("vSwitch cancelled", fmt.Sprintf("vSwitch %d has been cancelled in Robot and can't be managed anymore.", id))
 		return
 	}
 
 	state := vswitchModel{
-		ID:   types.Int64Value(int64(vswitch.ID)),
-		VLAN: types.Int64Value(int64(vswitch.VLAN)),
-		Name: types.StringValue(vswitch.Name),
+		ID:          types.Int64Value(int64(vswitch.ID)),
+		VLAN:        types.Int64Value(int64(vswitch.VLAN)),
+		Name:        types.StringValue(vswitch.Name),
+		Description: robotDescription(vswitch, types.StringNull()),
+		Cancelled:   types.BoolValue(vswitch.Cancelled),
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+	setVSwitchIdentity(ctx, resp.Identity, &resp.Diagnostics, state.ID)
 }
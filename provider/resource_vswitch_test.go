@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+func TestVSwitchCancelled(t *testing.T) {
+	ctx := context.Background()
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":123,"vlan":4001,"name":"internal","cancelled":true,"server":[]}`))
+	})
+	res := &vswitchResource{providerData: pd}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	stateType := schemaResp.Schema.Type().TerraformType(ctx)
+
+	// Cancelled in Robot since the last apply: refresh treats it like deleted
+	state := tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(stateType, nil)}
+	state.Set(ctx, &vswitchModel{ID: types.Int64Value(123), VLAN: types.Int64Value(4001), Name: types.StringValue("internal"), Cancelled: types.BoolValue(false)})
+	read := resource.ReadResponse{State: state}
+	res.Read(ctx, resource.ReadRequest{State: state}, &read)
+	if read.Diagnostics.HasError() || !read.State.Raw.IsNull() {
+		t.Fatalf("expected the cancelled vSwitch to be removed from state, got %v %v", read.State.Raw, read.Diagnostics)
+	}
+
+	imported := resource.ImportStateResponse{State: tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(stateType, nil)}}
+	res.ImportState(ctx, resource.ImportStateRequest{ID: "123"}, &imported)
+	if !imported.Diagnostics.HasError() || imported.Diagnostics[0].Summary() != "vSwitch cancelled" {
+		t.Fatalf("expected importing a cancelled vSwitch to fail, got %v", imported.Diagnostics)
+	}
+}
+
+func TestVSwitchImportRenamedInRobot(t *testing.T) {
+	ctx := context.Background()
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/vswitch/123" {
+			http.NotFound(w, r)
+			return
+		}
+		// Renamed in the Robot UI since it was last applied
+		_, _ = w.Write([]byte(`{"id":123,"vlan":4001,"name":"renamed-in-robot","cancelled":false,"server":[]}`))
+	})
+	res := &vswitchResource{providerData: pd}
+
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	var identityResp resource.IdentitySchemaResponse
+	res.IdentitySchema(ctx, resource.IdentitySchemaRequest{}, &identityResp)
+	stateType := schemaResp.Schema.Type().TerraformType(ctx)
+	identityType := identityResp.IdentitySchema.Type().TerraformType(ctx)
+	emptyState := func() tfsdk.State {
+		return tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(stateType, nil)}
+	}
+	identity := func(id interface{}) *tfsdk.ResourceIdentity {
+		return &tfsdk.ResourceIdentity{Schema: identityResp.IdentitySchema, Raw: tftypes.NewValue(identityType, map[string]tftypes.Value{
+			"id": tftypes.NewValue(tftypes.Number, id),
+		})}
+	}
+	check := func(name string, state tfsdk.State, got *tfsdk.ResourceIdentity) {
+		t.Helper()
+		var m vswitchModel
+		if d := state.Get(ctx, &m); d.HasError() {
+			t.Fatalf("%s: %v", name, d)
+		}
+		if m.ID.ValueInt64() != 123 || m.VLAN.ValueInt64() != 4001 || m.Name.ValueString() != "renamed-in-robot" {
+			t.Errorf("%s: unexpected state %+v", name, m)
+		}
+		var id vswitchIdentityModel
+		if d := got.Get(ctx, &id); d.HasError() || id.ID.ValueInt64() != 123 {
+			t.Errorf("%s: unexpected identity %+v %v", name, id, d)
+		}
+	}
+
+	byID := resource.ImportStateResponse{State: emptyState(), Identity: identity(nil)}
+	res.ImportState(ctx, resource.ImportStateRequest{ID: "123"}, &byID)
+	if byID.Diagnostics.HasError() {
+		t.Fatal(byID.Diagnostics)
+	}
+	check("import by ID", byID.State, byID.Identity)
+
+	byIdentity := resource.ImportStateResponse{State: emptyState(), Identity: identity(123)}
+	res.ImportState(ctx, resource.ImportStateRequest{Identity: identity(123)}, &byIdentity)
+	if byIdentity.Diagnostics.HasError() {
+		t.Fatal(byIdentity.Diagnostics)
+	}
+	check("import by identity", byIdentity.State, byIdentity.Identity)
+
+	// A refresh of a state with the old name picks up the new one, so the plan renames it back
+	old := emptyState()
+	old.Set(ctx, &vswitchModel{ID: types.Int64Value(123), VLAN: types.Int64Value(4001), Name: types.StringValue("internal")})
+	read := resource.ReadResponse{State: old, Identity: identity(123)}
+	res.Read(ctx, resource.ReadRequest{State: old, Identity: identity(123)}, &read)
+	if read.Diagnostics.HasError() {
+		t.Fatal(read.Diagnostics)
+	}
+	check("read", read.State, read.Identity)
+
+	// id is kept across plans, vlan and name are updated in place
+	for name, a := range schemaResp.Schema.Attributes {
+		switch at := a.(type) {
+		case rschema.Int64Attribute:
+			if (name == "id") != (len(at.PlanModifiers) == 1) {
+				t.Errorf("unexpected plan modifiers on %s: %v", name, at.PlanModifiers)
+			}
+		case rschema.StringAttribute:
+			if len(at.PlanModifiers) != 0 {
+				t.Errorf("%s must be updated in place, got plan modifiers %v", name, at.PlanModifiers)
+			}
+		}
+	}
+}
+
+func TestVSwitchDescription(t *testing.T) {
+	desc := func(s string) *string { return &s }
+	if got := vswitchDescription(types.StringValue("k3s"), types.StringNull()); got == nil || *got != "k3s" {
+		t.Errorf("set description: %v", got)
+	}
+	if got := vswitchDescription(types.StringNull(), types.StringValue("k3s")); got == nil || *got != "" {
+		t.Errorf("removed description must be cleared, got %v", got)
+	}
+	if got := vswitchDescription(types.StringNull(), types.StringNull()); got != nil {
+		t.Errorf("no description must not be sent, got %q", *got)
+	}
+
+	local := types.StringValue("k3s")
+	for _, tc := range []struct {
+		robot *string
+		want  types.String
+	}{
+		{nil, local},
+		{desc(""), types.StringNull()},
+		{desc("changed in robot"), types.StringValue("changed in robot")},
+	} {
+		if got := robotDescription(&client.VSwitch{Description: tc.robot}, local); !got.Equal(tc.want) {
+			t.Errorf("robotDescription(%v) = %v, want %v", tc.robot, got, tc.want)
+		}
+	}
+
+	// Read keeps the description in state when Robot doesn't return the field
+	ctx := context.Background()
+	pd := testProviderData(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":123,"vlan":4001,"name":"internal","cancelled":false,"server":[]}`))
+	})
+	res := &vswitchResource{providerData: pd}
+	var schemaResp resource.SchemaResponse
+	res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	state := tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(schemaResp.Schema.Type().TerraformType(ctx), nil)}
+	state.Set(ctx, &vswitchModel{ID: types.Int64Value(123), VLAN: types.Int64Value(4001), Name: types.StringValue("internal"), Description: local})
+	read := resource.ReadResponse{State: state}
+	res.Read(ctx, resource.ReadRequest{State: state}, &read)
+	var m vswitchModel
+	if d := read.State.Get(ctx, &m); d.HasError() || read.Diagnostics.HasError() {
+		t.Fatal(d, read.Diagnostics)
+	}
+	if !m.Description.Equal(local) {
+		t.Errorf("description after read = %v, want %v", m.Description, local)
+	}
+}
@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+)
+
+// PostInstallTemplateData holds the values substituted into the post-install scripts
+type PostInstallTemplateData struct {
+	CryptPassword string // LUKS passphrase used to add the boot key file
+	UnusedDisks   string // space-separated devices to wipe (3 and 4 disk setups)
+	LocalIP       string // private network address configured on first run
+	Hostname      string // set with hostnamectl on first run, empty to keep the current one
+	ExtraScript   string // appended to the first-run script (Docker installation, extra_scripts)
+	APTProxy      string // apt proxy for HTTP repositories, empty for none
+	APTHTTPSProxy string // apt proxy for HTTPS repositories, empty for none
+
+	VLANMTU      int64               // MTU of the private VLAN interface
+	VLANName     string              // name of the private VLAN interface, empty for ${DEFAULT_IFACE}.4001
+	ParentMTU    int64               // MTU of the interface the VLANs are attached to
+	Routes       []RouteTemplateData // static routes on the private VLAN interface
+	ExtraVLANs   []VLANTemplateData  // VLAN interfaces besides the private one, from vswitches
+	ARPKeepalive string              // rendered arpKeepaliveScript
+
+	NetworkBackend string            // netplan, ifupdown or systemd-networkd; empty to auto-detect on the server
+	Bond           *BondTemplateData // bond the public NICs and hang the VLANs off bond0, nil to use the default interface
+
+	NetworkInterface string // interface the VLANs hang off, empty to detect the one with the default route
+}
+
+// scriptFuncs are the functions the script templates can call
+var scriptFuncs = template.FuncMap{"shellQuote": shellQuote}
+
+var (
+	postinstallTemplate         = template.Must(template.New("post-install.sh").Option("missingkey=error").Funcs(scriptFuncs).Parse(postinstallScript))
+	postinstallFirstRunTemplate = template.Must(template.Must(template.New("initialize.sh").Option("missingkey=error").Funcs(scriptFuncs).Parse(postinstallFirstRunScript)).Parse(networkBackendTemplates))
+)
+
+// shellQuote quotes s as a single shell word: inside single quotes nothing is special, and a
+// single quote is closed, escaped and reopened
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// NetworkVLANs returns the private VLAN followed by the extra VLANs, for backends that configure
+// every VLAN the same way
+func (d PostInstallTemplateData) NetworkVLANs() []VLANTemplateData {
+	vlans := []VLANTemplateData{{ID: privateVLAN, MTU: d.VLANMTU, Address: "${LOCAL_IP}/24", Routes: d.Routes, Name: d.VLANName}}
+	return append(vlans, d.ExtraVLANs...)
+}
+
+// PrivateVLANInterface returns the name of the private VLAN interface in the first-run script
+func (d PostInstallTemplateData) PrivateVLANInterface() string {
+	return VLANTemplateData{ID: privateVLAN, Name: d.VLANName}.Interface()
+}
+
+// renderScript executes a script template; nil data renders every value as empty
+func renderScript(tmpl *template.Template, data *PostInstallTemplateData) (string, error) {
+	if data == nil {
+		data = &PostInstallTemplateData{}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestRenderScriptZeroValues(t *testing.T) {
+	for name, tmpl := range map[string]*template.Template{
+		"post-install": postinstallTemplate,
+		"first-run":    postinstallFirstRunTemplate,
+	} {
+		for _, data := range []*PostInstallTemplateData{nil, {}} {
+			out, err := renderScript(tmpl, data)
+			if err != nil {
+				t.Fatalf("%s: render: %v", name, err)
+			}
+			if strings.Contains(out, "{{") || strings.Contains(out, "<no value>") {
+				t.Fatalf("%s: unrendered template action left in output", name)
+			}
+			if !strings.HasPrefix(out, "#!/bin/bash") {
+				t.Fatalf("%s: expected shebang, got %q", name, out[:20])
+			}
+		}
+	}
+}
+
+func TestRenderScriptSubstitutesValues(t *testing.T) {
+	out, err := renderScript(postinstallTemplate, &PostInstallTemplateData{
+		CryptPassword: "s3cret",
+		UnusedDisks:   "/dev/sdc /dev/sdd",
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	for _, want := range []string{`CRYPT_PASSWORD='s3cret'`, `UNUSED_DISKS="/dev/sdc /dev/sdd"`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("post-install script missing %q", want)
+		}
+	}
+
+	out, err = renderScript(postinstallFirstRunTemplate, &PostInstallTemplateData{
+		LocalIP:     "10.1.0.5",
+		ExtraScript: "echo extra",
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(out, `LOCAL_IP="10.1.0.5"`) {
+		t.Fatalf("first-run script missing local IP")
+	}
+	if !strings.HasSuffix(out, "echo extra\n") {
+		t.Fatalf("first-run script must end with the extra script")
+	}
+}
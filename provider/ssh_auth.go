@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	pschema "github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+
+	sshx "github.com/mokto/terraform-provider-hrobot/internal/ssh"
+)
+
+const (
+	sshAuthAgent      = "agent"
+	sshAuthPrivateKey = "private_key"
+	sshAuthPassword   = "password"
+)
+
+type sshAuthModel struct {
+	Method         types.String `tfsdk:"method"`
+	PrivateKeyPath types.String `tfsdk:"private_key_path"`
+	PrivateKey     types.String `tfsdk:"private_key"`
+	Password       types.String `tfsdk:"password"`
+}
+
+const (
+	sshAuthDescription         = "How SSH logs in to servers: only the chosen method is tried"
+	sshAuthMethodDescription   = "agent (keys in the local SSH agent), private_key or password"
+	sshAuthKeyPathDescription  = "File with the private key for method = private_key"
+	sshAuthKeyDescription      = "Private key (PEM/OpenSSH) for method = private_key, instead of private_key_path"
+	sshAuthPasswordDescription = "Root password for method = password; only reaches installed servers, not the rescue system"
+)
+
+func providerSSHAuthAttribute() pschema.SingleNestedAttribute {
+	return pschema.SingleNestedAttribute{
+		Optional:    true,
+		Description: sshAuthDescription + " (default: agent)",
+		Attributes: map[string]pschema.Attribute{
+			"method":           pschema.StringAttribute{Required: true, Description: sshAuthMethodDescription},
+			"private_key_path": pschema.StringAttribute{Optional: true, Description: sshAuthKeyPathDescription},
+			"private_key":      pschema.StringAttribute{Optional: true, Sensitive: true, Description: sshAuthKeyDescription},
+			"password":         pschema.StringAttribute{Optional: true, Sensitive: true, Description: sshAuthPasswordDescription},
+		},
+	}
+}
+
+func sshAuthAttribute() rschema.SingleNestedAttribute {
+	return rschema.SingleNestedAttribute{
+		Optional:    true,
+		Description: sshAuthDescription + ". Overrides the provider's ssh_auth for this resource",
+		Attributes: map[string]rschema.Attribute{
+			"method":           rschema.StringAttribute{Required: true, Description: sshAuthMethodDescription},
+			"private_key_path": rschema.StringAttribute{Optional: true, Description: sshAuthKeyPathDescription},
+			"private_key":      rschema.StringAttribute{Optional: true, Sensitive: true, Description: sshAuthKeyDescription},
+			"password":         rschema.StringAttribute{Optional: true, Sensitive: true, Description: sshAuthPasswordDescription},
+		},
+	}
+}
+
+// validateSSHAuth checks that the fields set match the method; unknown values are skipped
+func validateSSHAuth(diags *diag.Diagnostics, p path.Path, m sshAuthModel) {
+	if m.Method.IsUnknown() {
+		return
+	}
+	set := func(v types.String) bool { return !v.IsNull() }
+	switch method := m.Method.ValueString(); method {
+	case sshAuthAgent:
+		if set(m.PrivateKeyPath) || set(m.PrivateKey) || set(m.Password) {
+			diags.AddAttributeError(p, "Conflicting SSH auth", "method = agent takes no private_key_path, private_key or password.")
+		}
+	case sshAuthPrivateKey:
+		if set(m.PrivateKeyPath) == set(m.PrivateKey) {
+			diags.AddAttributeError(p.AtName("private_key"), "Invalid SSH auth", "method = private_key needs exactly one of private_key_path and private_key.")
+		}
+		if set(m.Password) {
+			diags.AddAttributeError(p.AtName("password"), "Conflicting SSH auth", "password cannot be combined with method = private_key.")
+		}
+	case sshAuthPassword:
+		if !set(m.Password) {
+			diags.AddAttributeError(p.AtName("password"), "Invalid SSH auth", "method = password needs password.")
+		}
+		if set(m.PrivateKeyPath) || set(m.PrivateKey) {
+			diags.AddAttributeError(p, "Conflicting SSH auth", "private_key_path and private_key cannot be combined with method = password.")
+		}
+	default:
+		diags.AddAttributeError(p.AtName("method"), "Invalid SSH auth method",
+			fmt.Sprintf("method must be %q, %q or %q, got %q.", sshAuthAgent, sshAuthPrivateKey, sshAuthPassword, method))
+	}
+}
+
+// buildSSHAuth turns a validated ssh_auth block into the single method SSH connections use,
+// reading private_key_path and checking that the agent on agentSocket is reachable for method = agent
+func buildSSHAuth(m sshAuthModel, agentSocket string) (sshx.Auth, error) {
+	switch m.Method.ValueString() {
+	case sshAuthPrivateKey:
+		key := []byte(m.PrivateKey.ValueString())
+		if !m.PrivateKeyPath.IsNull() {
+			var err error
+			if key, err = os.ReadFile(m.PrivateKeyPath.ValueString()); err != nil {
+				return sshx.Auth{}, fmt.Errorf("read private_key_path: %w", err)
+			}
+		}
+		auth := sshx.AuthPrivateKey(key)
+		if _, err := auth.Fingerprints(); err != nil {
+			return sshx.Auth{}, err
+		}
+		return auth, nil
+	case sshAuthPassword:
+		return sshx.AuthPassword(m.Password.ValueString()), nil
+	}
+	if err := sshx.CheckAgent(agentSocket); err != nil {
+		return sshx.Auth{}, err
+	}
+	return sshx.AuthFromAgent(agentSocket), nil
+}
+
+// sshAuth returns how this resource logs in over SSH: its own ssh_auth block, else the provider's
+func (r *configurationResource) sshAuth(ctx context.Context, plan configurationModel) (sshx.Auth, string, string) {
+	if !plan.SSHAuth.IsNull() && !plan.SSHAuth.IsUnknown() {
+		var m sshAuthModel
+		plan.SSHAuth.As(ctx, &m, basetypes.ObjectAsOptions{})
+		auth, err := buildSSHAuth(m, r.providerData.SSHAgentSocket)
+		if err != nil {
+			return sshx.Auth{}, "ssh auth", err.Error()
+		}
+		return auth, "", ""
+	}
+	if r.providerData.SSHAuth.Method() == "none" {
+		return sshx.AuthFromAgent(r.providerData.SSHAgentSocket), "", ""
+	}
+	return r.providerData.SSHAuth, "", ""
+}
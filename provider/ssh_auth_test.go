@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestSSHAgentSocket(t *testing.T) {
+	// An agent on a socket SSH_AUTH_SOCK doesn't point to, as CI runners set up
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "ssh-agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	keyring := agent.NewKeyring()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _ = agent.ServeAgent(keyring, conn); conn.Close() }()
+		}
+	}()
+	signer, _ := ssh.NewSignerFromKey(key)
+	want := ssh.FingerprintLegacyMD5(signer.PublicKey())
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("HROBOT_SSH_AGENT_SOCKET", "")
+	if got := resolveAgentSocket(sock); got != sock {
+		t.Fatalf("expected ssh_agent_socket, got %q", got)
+	}
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/default.sock")
+	if got := resolveAgentSocket(""); got != "/tmp/default.sock" {
+		t.Fatalf("expected the SSH_AUTH_SOCK fallback, got %q", got)
+	}
+	t.Setenv("HROBOT_SSH_AGENT_SOCKET", sock)
+	if got := resolveAgentSocket(""); got != sock {
+		t.Fatalf("expected HROBOT_SSH_AGENT_SOCKET over SSH_AUTH_SOCK, got %q", got)
+	}
+
+	agentAuth := sshAuthModel{Method: types.StringValue("agent"), PrivateKeyPath: types.StringNull(), PrivateKey: types.StringNull(), Password: types.StringNull()}
+	if _, err := buildSSHAuth(agentAuth, ""); err == nil {
+		t.Fatal("expected the agent on SSH_AUTH_SOCK to be unreachable")
+	}
+	auth, err := buildSSHAuth(agentAuth, sock)
+	if err != nil {
+		t.Fatalf("agent on ssh_agent_socket: %v", err)
+	}
+	if fps, err := auth.Fingerprints(); err != nil || len(fps) != 1 || fps[0] != want {
+		t.Fatalf("expected the key of the agent on %s, got %v %v", sock, fps, err)
+	}
+
+	// Resources without their own ssh_auth fall back to the provider's socket
+	r := &configurationResource{providerData: &ProviderData{SSHAgentSocket: sock}}
+	plan := configurationModel{SSHAuth: types.ObjectNull(sshAuthAttribute().GetType().(types.ObjectType).AttrTypes)}
+	got, summary, _ := r.sshAuth(context.Background(), plan)
+	if fps, err := got.Fingerprints(); summary != "" || err != nil || len(fps) != 1 || fps[0] != want {
+		t.Fatalf("expected the provider's agent socket, got %v %v (%s)", fps, err, summary)
+	}
+}
+
+func TestSSHAuth(t *testing.T) {
+	str := types.StringValue
+	null := types.StringNull()
+	cases := []struct {
+		name string
+		m    sshAuthModel
+		ok   bool
+	}{
+		{"agent", sshAuthModel{Method: str("agent"), PrivateKeyPath: null, PrivateKey: null, Password: null}, true},
+		{"agent with key", sshAuthModel{Method: str("agent"), PrivateKeyPath: str("/k"), PrivateKey: null, Password: null}, false},
+		{"key path", sshAuthModel{Method: str("private_key"), PrivateKeyPath: str("/k"), PrivateKey: null, Password: null}, true},
+		{"key missing", sshAuthModel{Method: str("private_key"), PrivateKeyPath: null, PrivateKey: null, Password: null}, false},
+		{"key twice", sshAuthModel{Method: str("private_key"), PrivateKeyPath: str("/k"), PrivateKey: str("x"), Password: null}, false},
+		{"password", sshAuthModel{Method: str("password"), PrivateKeyPath: null, PrivateKey: null, Password: str("pw")}, true},
+		{"password missing", sshAuthModel{Method: str("password"), PrivateKeyPath: null, PrivateKey: null, Password: null}, false},
+		{"unknown method", sshAuthModel{Method: str("kerberos"), PrivateKeyPath: null, PrivateKey: null, Password: null}, false},
+	}
+	for _, c := range cases {
+		var diags diag.Diagnostics
+		validateSSHAuth(&diags, path.Root("ssh_auth"), c.m)
+		if diags.HasError() == c.ok {
+			t.Errorf("%s: expected ok=%v, got %v", c.name, c.ok, diags)
+		}
+	}
+
+	pub, priv, err := generateEphemeralSSHKey()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, []byte(priv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := buildSSHAuth(sshAuthModel{Method: str("private_key"), PrivateKeyPath: str(keyPath), PrivateKey: null, Password: null}, "")
+	if err != nil {
+		t.Fatalf("build private_key auth: %v", err)
+	}
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fps, err := auth.Fingerprints()
+	if err != nil || len(fps) != 1 || fps[0] != ssh.FingerprintLegacyMD5(parsed) || auth.Method() != "private_key" {
+		t.Fatalf("unexpected key auth: %s %v %v", auth.Method(), fps, err)
+	}
+	if _, err := buildSSHAuth(sshAuthModel{Method: str("private_key"), PrivateKeyPath: null, PrivateKey: str("not a key"), Password: null}, ""); err == nil {
+		t.Fatal("expected an unparsable key to be rejected")
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, err := buildSSHAuth(sshAuthModel{Method: str("agent"), PrivateKeyPath: null, PrivateKey: null, Password: null}, ""); err == nil {
+		t.Fatal("expected agent auth to fail fast without SSH_AUTH_SOCK")
+	}
+
+	// The resource block overrides the provider's auth
+	r := &configurationResource{providerData: &ProviderData{SSHAuth: auth}}
+	plan := configurationModel{SSHAuth: types.ObjectNull(sshAuthAttribute().GetType().(types.ObjectType).AttrTypes)}
+	if got, summary, _ := r.sshAuth(context.Background(), plan); summary != "" || got.Method() != "private_key" {
+		t.Fatalf("expected the provider's key auth, got %s (%s)", got.Method(), summary)
+	}
+	plan.SSHAuth = types.ObjectValueMust(plan.SSHAuth.AttributeTypes(context.Background()), map[string]attr.Value{
+		"method": str("password"), "private_key_path": null, "private_key": null, "password": str("pw"),
+	})
+	if got, summary, _ := r.sshAuth(context.Background(), plan); summary != "" || got.Method() != "password" {
+		t.Fatalf("expected the resource's password auth, got %s (%s)", got.Method(), summary)
+	}
+}
@@ -0,0 +1,31 @@
+package provider
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// generateEphemeralSSHKey creates a throwaway Ed25519 key pair. The public key is returned in
+// authorized_keys format and the private key as an OpenSSH PEM block.
+func generateEphemeralSSHKey() (publicKey, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", err
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "terraform-provider-hrobot ephemeral")
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))), string(pem.EncodeToMemory(block)), nil
+}
@@ -0,0 +1,34 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateEphemeralSSHKey(t *testing.T) {
+	pub, priv, err := generateEphemeralSSHKey()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !strings.HasPrefix(pub, "ssh-ed25519 ") {
+		t.Fatalf("expected authorized_keys ed25519 key, got %q", pub)
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(priv))
+	if err != nil {
+		t.Fatalf("parse private key: %v", err)
+	}
+	if got := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))); got != pub {
+		t.Fatalf("private key does not match public key: %q vs %q", got, pub)
+	}
+
+	pub2, _, err := generateEphemeralSSHKey()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if pub2 == pub {
+		t.Fatalf("expected a fresh key on every call")
+	}
+}
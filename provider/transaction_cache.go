@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+// Cache entry for transaction data
+type transactionCacheEntry struct {
+	transaction *client.Transaction
+	lastUpdated time.Time
+}
+
+// JSON-serializable cache entry
+type jsonCacheEntry struct {
+	Transaction *client.Transaction `json:"transaction"`
+	LastUpdated string              `json:"last_updated"`
+	// Namespace is the Robot account the entry was read from, see transactionCacheNamespace
+	Namespace string `json:"namespace,omitempty"`
+}
+
+var (
+	cacheExpiry = 5 * time.Minute // Cache expires after 5 minutes
+	cacheFile   = getCacheFilePath()
+)
+
+// getCacheFilePath returns the path to the cache file in the .cache directory
+func getCacheFilePath() string {
+	// Get the current working directory (should be the repository root)
+	wd, err := os.Getwd()
+	if err != nil {
+		// Fallback to temp directory if we can't get working directory
+		return filepath.Join(os.TempDir(), "terraform-provider-hrobot-cache.json")
+	}
+
+	// Create .cache directory if it doesn't exist
+	cacheDir := filepath.Join(wd, ".cache")
+	os.MkdirAll(cacheDir, 0755)
+
+	return filepath.Join(cacheDir, "transaction-cache.json")
+}
+
+// TransactionCache keeps order and auction order transactions to avoid hitting API rate limits.
+// Entries are keyed by type:id since the two kinds of transactions have separate id sequences,
+// and are persisted to file so they outlive the provider process. Transaction ids are only
+// unique within a Robot account, so entries are tagged with a namespace for the base URL and
+// user, and entries from another namespace are not loaded
+type TransactionCache struct {
+	entries   map[string]*transactionCacheEntry
+	file      string
+	namespace string
+	disabled  bool
+	mutex     sync.RWMutex
+}
+
+// NewTransactionCache returns a cache backed by file, loaded with its non-expired entries of
+// namespace
+func NewTransactionCache(file, namespace string) *TransactionCache {
+	tc := &TransactionCache{entries: make(map[string]*transactionCacheEntry), file: file, namespace: namespace}
+	tc.load()
+	return tc
+}
+
+// NewDisabledTransactionCache returns a cache that keeps nothing, for disable_cache: every
+// lookup misses, so transactions are always read from Robot
+func NewDisabledTransactionCache() *TransactionCache {
+	return &TransactionCache{entries: make(map[string]*transactionCacheEntry), disabled: true}
+}
+
+// transactionCacheNamespace identifies the Robot account behind baseURL and username without
+// writing either to the cache file
+func transactionCacheNamespace(baseURL, username string) string {
+	sum := sha256.Sum256([]byte(strings.TrimRight(baseURL, "/") + "\n" + username))
+	return hex.EncodeToString(sum[:8])
+}
+
+func transactionCacheKey(txType, id string) string {
+	return txType + ":" + id
+}
+
+// load loads the cache from disk
+func (tc *TransactionCache) load() {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	data, err := os.ReadFile(tc.file)
+	if err != nil {
+		// Cache file doesn't exist or can't be read, start with empty cache
+		return
+	}
+
+	var diskCache map[string]*jsonCacheEntry
+	if err := json.Unmarshal(data, &diskCache); err != nil {
+		// Invalid cache file, start with empty cache
+		return
+	}
+
+	// Only load non-expired entries; keys without a type prefix are from before the caches were merged
+	now := time.Now()
+	for key, jsonEntry := range diskCache {
+		if !strings.HasPrefix(key, client.TransactionTypeOrder+":") && !strings.HasPrefix(key, client.TransactionTypeMarket+":") {
+			continue
+		}
+		if jsonEntry.Namespace != tc.namespace {
+			continue // Read from another Robot account or environment
+		}
+		lastUpdated, err := time.Parse(time.RFC3339, jsonEntry.LastUpdated)
+		if err != nil {
+			continue // Skip invalid timestamp
+		}
+
+		if now.Sub(lastUpdated) <= cacheExpiry {
+			tc.entries[key] = &transactionCacheEntry{
+				transaction: jsonEntry.Transaction,
+				lastUpdated: lastUpdated,
+			}
+		}
+	}
+}
+
+// save saves the cache to disk; the caller holds the lock
+func (tc *TransactionCache) save() {
+	// Convert to JSON-serializable format
+	jsonCache := make(map[string]*jsonCacheEntry)
+	for key, entry := range tc.entries {
+		jsonCache[key] = &jsonCacheEntry{
+			Transaction: entry.transaction,
+			LastUpdated: entry.lastUpdated.Format(time.RFC3339),
+			Namespace:   tc.namespace,
+		}
+	}
+
+	data, err := json.Marshal(jsonCache)
+	if err != nil {
+		return
+	}
+
+	os.WriteFile(tc.file, data, 0600)
+}
+
+// Get retrieves a transaction of type txType (client.TransactionTypeOrder or
+// client.TransactionTypeMarket) from cache if available and not expired
+func (tc *TransactionCache) Get(txType, id string) (*client.Transaction, bool) {
+	if tc.disabled {
+		return nil, false
+	}
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+
+	entry, exists := tc.entries[transactionCacheKey(txType, id)]
+	if !exists {
+		return nil, false
+	}
+
+	// Check if cache entry is expired
+	if time.Since(entry.lastUpdated) > cacheExpiry {
+		return nil, false
+	}
+
+	return entry.transaction, true
+}
+
+// Set stores a transaction of type txType in cache and on disk
+func (tc *TransactionCache) Set(txType, id string, transaction *client.Transaction) {
+	if tc.disabled {
+		return
+	}
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	tc.entries[transactionCacheKey(txType, id)] = &transactionCacheEntry{
+		transaction: transaction,
+		lastUpdated: time.Now(),
+	}
+	tc.save()
+}
@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+
+	"github.com/mokto/terraform-provider-hrobot/internal/client"
+)
+
+func TestTransactionCacheSeparatesTypes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "transaction-cache.json")
+	tc := NewTransactionCache(file, "")
+	tc.Set(client.TransactionTypeOrder, "B1", &client.Transaction{ID: "B1", Status: "ready", TransactionType: client.TransactionTypeOrder})
+	tc.Set(client.TransactionTypeMarket, "B1", &client.Transaction{ID: "B1", Status: "in process", TransactionType: client.TransactionTypeMarket})
+
+	for _, c := range []*TransactionCache{tc, NewTransactionCache(file, "")} {
+		order, ok := c.Get(client.TransactionTypeOrder, "B1")
+		if !ok || order.Status != "ready" {
+			t.Fatalf("unexpected order transaction: %+v, %v", order, ok)
+		}
+		market, ok := c.Get(client.TransactionTypeMarket, "B1")
+		if !ok || market.Status != "in process" || market.TransactionType != client.TransactionTypeMarket {
+			t.Fatalf("unexpected market transaction: %+v, %v", market, ok)
+		}
+		if _, ok := c.Get(client.TransactionTypeOrder, "B2"); ok {
+			t.Fatal("expected a miss for an unknown transaction")
+		}
+	}
+
+	// Entries written before the caches were merged have no type prefix and are ignored
+	if err := os.WriteFile(file, []byte(`{"B1":{"transaction":{"id":"B1","status":"ready"},"last_updated":"`+time.Now().Format(time.RFC3339)+`"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := NewTransactionCache(file, "").Get(client.TransactionTypeOrder, "B1"); ok {
+		t.Fatal("expected legacy unprefixed entries to be ignored")
+	}
+}
+
+func TestTransactionCacheNamespaces(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "transaction-cache.json")
+
+	// The same transaction id exists in a staging mock and in production
+	robot := func(status string, requests *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/order/server/transaction/B1" {
+				http.NotFound(w, r)
+				return
+			}
+			atomic.AddInt32(requests, 1)
+			_, _ = w.Write([]byte(`{"transaction":{"id":"B1","status":"` + status + `","server_number":null}}`))
+		}))
+	}
+	var stagingRequests, productionRequests int32
+	staging := robot("ready", &stagingRequests)
+	defer staging.Close()
+	production := robot("cancelled", &productionRequests)
+	defer production.Close()
+
+	read := func(ts *httptest.Server, cache *TransactionCache) serverOrderModel {
+		t.Helper()
+		res := &serverOrderResource{providerData: &ProviderData{
+			Client:           client.New(ts.URL, "user", "pass", ts.Client(), client.ClientConfig{}),
+			TransactionCache: cache,
+		}}
+		var schemaResp resource.SchemaResponse
+		res.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+		state := tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(schemaResp.Schema.Type().TerraformType(ctx), nil)}
+		state.Set(ctx, &serverOrderModel{
+			ID:              types.StringValue("B1"),
+			ProductID:       types.StringValue("EX44"),
+			TransactionID:   types.StringValue("B1"),
+			Keys:            types.ListNull(types.StringType),
+			Addons:          types.ListNull(types.StringType),
+			AddonQty:        types.ListNull(types.ObjectType{AttrTypes: map[string]attr.Type{"id": types.StringType, "count": types.Int64Type}}),
+			EffectiveAddons: types.ListNull(types.StringType),
+		})
+		resp := resource.ReadResponse{State: state}
+		res.Read(ctx, resource.ReadRequest{State: state}, &resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatal(resp.Diagnostics)
+		}
+		var m serverOrderModel
+		resp.State.Get(ctx, &m)
+		return m
+	}
+	namespace := func(ts *httptest.Server) string { return transactionCacheNamespace(ts.URL, "user") }
+
+	if m := read(staging, NewTransactionCache(file, namespace(staging))); m.Status.ValueString() != "ready" {
+		t.Fatalf("unexpected staging status %s", m.Status)
+	}
+	if m := read(staging, NewTransactionCache(file, namespace(staging))); m.Status.ValueString() != "ready" || stagingRequests != 1 {
+		t.Fatalf("expected the final staging transaction from the cache file, got %s after %d requests", m.Status, stagingRequests)
+	}
+	if m := read(production, NewTransactionCache(file, namespace(production))); m.Status.ValueString() != "cancelled" || productionRequests != 1 {
+		t.Fatalf("production read the staging cache: status %s after %d requests", m.Status, productionRequests)
+	}
+	if namespace(staging) == transactionCacheNamespace(staging.URL, "other-user") {
+		t.Fatal("expected the namespace to depend on the username")
+	}
+
+	// disable_cache reads from Robot every time, even with a final transaction
+	disabled := NewDisabledTransactionCache()
+	read(production, disabled)
+	read(production, disabled)
+	if productionRequests != 3 {
+		t.Fatalf("expected every read to reach Robot with the cache disabled, got %d requests", productionRequests)
+	}
+}